@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// openBrowser opens url in the user's browser, trying $BROWSER first and
+// then the platform's openers in turn. It fails without trying anything on
+// a Linux machine with no display (an SSH session), so the caller prints
+// the URL instead.
+func openBrowser(url string) error {
+	candidates := browserFromEnv(url)
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = append(candidates, []string{"open", url})
+	case "windows":
+		// rundll32 hands the URL to the default browser untouched, whereas
+		// "cmd /c start" would cut it at the first & in the query string.
+		candidates = append(candidates,
+			[]string{"rundll32", "url.dll,FileProtocolHandler", url},
+			[]string{"explorer", url},
+		)
+	default:
+		if isWSL() {
+			candidates = append(candidates, []string{"wslview", url}, []string{"rundll32.exe", "url.dll,FileProtocolHandler", url})
+		} else if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" && len(candidates) == 0 {
+			return errors.New("no display")
+		}
+		candidates = append(candidates,
+			[]string{"xdg-open", url},
+			[]string{"gio", "open", url},
+			[]string{"sensible-browser", url},
+			[]string{"x-www-browser", url},
+		)
+	}
+
+	err := errors.New("no browser opener found")
+	for _, c := range candidates {
+		path, lerr := exec.LookPath(c[0])
+		if lerr != nil {
+			continue
+		}
+		cmd := exec.Command(path, c[1:]...)
+		if err = cmd.Start(); err != nil {
+			continue
+		}
+		// Openers return at once; reap them in the background.
+		go cmd.Wait()
+		return nil
+	}
+	return err
+}
+
+// browserFromEnv returns the commands listed in $BROWSER, separated like
+// PATH. A command containing %s gets the URL there instead of at the end.
+func browserFromEnv(url string) [][]string {
+	var cmds [][]string
+	for _, entry := range filepath.SplitList(os.Getenv("BROWSER")) {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		substituted := false
+		for i, f := range fields {
+			if strings.Contains(f, "%s") {
+				fields[i] = strings.ReplaceAll(f, "%s", url)
+				substituted = true
+			}
+		}
+		if !substituted {
+			fields = append(fields, url)
+		}
+		cmds = append(cmds, fields)
+	}
+	return cmds
+}
+
+// isWSL reports whether this is Linux running under Windows Subsystem for
+// Linux, where the Windows browser is opened through interop.
+func isWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	return err == nil && strings.Contains(strings.ToLower(string(data)), "microsoft")
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// googleOAuthURL is Google's authorization endpoint, where the Partner
+// Connections page sends the user after device selection.
+const googleOAuthURL = "https://accounts.google.com/o/oauth2/v2/auth"
+
+var (
+	ErrUnknownClient    = errors.New("OAuth client ID not found")
+	ErrRedirectMismatch = errors.New("redirect URI is not registered for this OAuth client")
+)
+
+// CheckClient asks Google's authorization endpoint whether clientID exists
+// and accepts redirectURI, without signing in: a valid request redirects to
+// the sign-in page, an invalid one gets an error page naming the problem.
+func CheckClient(clientID, redirectURI string) error {
+	params := url.Values{
+		"client_id":     {clientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {SDMScope},
+	}
+	client := &http.Client{
+		Timeout: 15 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(googleOAuthURL + "?" + params.Encode())
+	if err != nil {
+		// Drop the request URL from the error; it's long and not useful.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	page := string(body)
+	switch {
+	case strings.Contains(page, "redirect_uri_mismatch"):
+		return ErrRedirectMismatch
+	case strings.Contains(page, "invalid_client"), strings.Contains(page, "deleted_client"):
+		return ErrUnknownClient
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("authorization endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
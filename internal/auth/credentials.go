@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataURL is the GCE metadata server's token endpoint for the
+// instance's default service account.
+const metadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// ADC selects Application Default Credentials in CredentialsTokenFn.
+const ADC = "adc"
+
+// credentialsFile is a Google credentials JSON file: a service-account key
+// or the authorized_user file written by gcloud auth application-default
+// login.
+type credentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// CredentialsTokenFn returns an access token source for scopes from source:
+// ADC for Application Default Credentials, or the path of a service-account
+// key file. Application Default Credentials are the file named by
+// GOOGLE_APPLICATION_CREDENTIALS, then gcloud's application default
+// credentials, then the GCE metadata server. The file is read and checked
+// now; tokens are fetched on first use and cached until shortly before they
+// expire. It also returns a description of the identity for messages.
+func CredentialsTokenFn(source string, scopes ...string) (fn func() (string, error), identity string, err error) {
+	path := source
+	if source == ADC {
+		if path, err = adcPath(); err != nil {
+			return nil, "", err
+		}
+		if path == "" {
+			c := &cachedToken{fetch: func() (*TokenResponse, error) { return metadataToken(scopes) }}
+			return c.get, "GCE metadata server", nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var f credentialsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	switch f.Type {
+	case "service_account":
+		key, err := parsePrivateKey(f.PrivateKey)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", path, err)
+		}
+		if f.ClientEmail == "" {
+			return nil, "", fmt.Errorf("%s: client_email missing", path)
+		}
+		if f.TokenURI == "" {
+			f.TokenURI = googleTokenURL
+		}
+		c := &cachedToken{fetch: func() (*TokenResponse, error) { return serviceAccountToken(f, key, scopes) }}
+		return c.get, "service account " + f.ClientEmail, nil
+	case "authorized_user":
+		if f.ClientID == "" || f.ClientSecret == "" || f.RefreshToken == "" {
+			return nil, "", fmt.Errorf("%s: client_id, client_secret or refresh_token missing", path)
+		}
+		tm := NewTokenManager(f.ClientID, f.ClientSecret)
+		return func() (string, error) { return tm.AccessTokenFor(f.RefreshToken, scopes...) }, "gcloud user credentials", nil
+	case "":
+		return nil, "", fmt.Errorf("%s is not a Google credentials file", path)
+	}
+	return nil, "", fmt.Errorf("%s: %s credentials aren't supported (use a service-account key)", path, f.Type)
+}
+
+// adcPath returns the Application Default Credentials file, or "" to use
+// the metadata server.
+func adcPath() (string, error) {
+	if p := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); p != "" {
+		if _, err := os.Stat(p); err != nil {
+			return "", fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+		}
+		return p, nil
+	}
+	var dir string
+	if runtime.GOOS == "windows" {
+		dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	if dir != "" {
+		p := filepath.Join(dir, "application_default_credentials.json")
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+func parsePrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("private_key is not PEM")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			return rsaKey, nil
+		}
+		return nil, errors.New("private_key is not an RSA key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private_key: %w", err)
+	}
+	return key, nil
+}
+
+// serviceAccountToken exchanges a signed JWT assertion for an access token.
+func serviceAccountToken(f credentialsFile, key *rsa.PrivateKey, scopes []string) (*TokenResponse, error) {
+	now := time.Now()
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if f.PrivateKeyID != "" {
+		header["kid"] = f.PrivateKeyID
+	}
+	claims := map[string]any{
+		"iss":   f.ClientEmail,
+		"scope": strings.Join(scopes, " "),
+		"aud":   f.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return nil, fmt.Errorf("signing token request: %w", err)
+	}
+	return postToken(f.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {signed + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	})
+}
+
+// metadataToken gets a token for the instance's service account from the
+// GCE metadata server.
+func metadataToken(scopes []string) (*TokenResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataURL+"?scopes="+url.QueryEscape(strings.Join(scopes, ",")), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("no Application Default Credentials found (set GOOGLE_APPLICATION_CREDENTIALS or run: gcloud auth application-default login): %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata server response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata server returned %d: %s", resp.StatusCode, string(body))
+	}
+	var tok TokenResponse
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return nil, fmt.Errorf("parsing metadata server response: %w", err)
+	}
+	return &tok, nil
+}
+
+// cachedToken caches the access token from fetch until a minute before it
+// expires.
+type cachedToken struct {
+	fetch func() (*TokenResponse, error)
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func (c *cachedToken) get() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken != "" && time.Now().Before(c.expiry.Add(-60*time.Second)) {
+		return c.accessToken, nil
+	}
+	resp, err := c.fetch()
+	if err != nil {
+		return "", err
+	}
+	c.accessToken = resp.AccessToken
+	c.expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	return c.accessToken, nil
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	var calls atomic.Int32
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		r.ParseForm()
+		if g := r.PostForm.Get("grant_type"); g != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("grant_type = %q", g)
+		}
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Fatalf("assertion has %d parts", len(parts))
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			t.Fatalf("signature: %v", err)
+		}
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			t.Errorf("signature doesn't verify: %v", err)
+		}
+
+		var header map[string]string
+		var claims map[string]any
+		decodeSegment(t, parts[0], &header)
+		decodeSegment(t, parts[1], &claims)
+		if header["alg"] != "RS256" || header["typ"] != "JWT" || header["kid"] != "key-1" {
+			t.Errorf("header = %v", header)
+		}
+		if claims["iss"] != "bot@example.iam.gserviceaccount.com" || claims["aud"] != srv.URL ||
+			claims["scope"] != "scope-a scope-b" {
+			t.Errorf("claims = %v", claims)
+		}
+		iat, exp := claims["iat"].(float64), claims["exp"].(float64)
+		if now := float64(time.Now().Unix()); iat < now-60 || iat > now+60 || exp-iat != 3600 {
+			t.Errorf("iat = %v, exp = %v", iat, exp)
+		}
+		fmt.Fprint(w, `{"access_token":"tok","expires_in":3600,"token_type":"Bearer"}`)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "sa.json")
+	data, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "bot@example.iam.gserviceaccount.com",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"private_key_id": "key-1",
+		"token_uri":      srv.URL,
+	})
+	os.WriteFile(path, data, 0o600)
+
+	fn, identity, err := CredentialsTokenFn(path, "scope-a", "scope-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "service account bot@example.iam.gserviceaccount.com" {
+		t.Errorf("identity = %q", identity)
+	}
+	for range 2 {
+		tok, err := fn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok != "tok" {
+			t.Errorf("token = %q, want tok", tok)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("token endpoint called %d times, want 1 (cached)", n)
+	}
+}
+
+func decodeSegment(t *testing.T, s string, v any) {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("segment %q: %v", s, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("segment %s: %v", b, err)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalPKCS8PrivateKey(ec)
+	encode := func(typ string, b []byte) string {
+		return string(pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}))
+	}
+
+	tests := []struct {
+		name, in, err string
+	}{
+		{"pkcs8", encode("PRIVATE KEY", pkcs8), ""},
+		{"pkcs1", encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)), ""},
+		{"not pem", "-----BEGIN nothing", "not PEM"},
+		{"ec key", encode("PRIVATE KEY", ecDER), "not an RSA key"},
+		{"garbage", encode("PRIVATE KEY", []byte("junk")), "private_key:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePrivateKey(tt.in)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("parsePrivateKey error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(key) {
+				t.Error("parsePrivateKey returned a different key")
+			}
+		})
+	}
+}
+
+func TestCredentialsTokenFnErrors(t *testing.T) {
+	tests := []struct {
+		name, file, err string
+	}{
+		{"not json", "nope", "invalid character"},
+		{"no type", `{}`, "is not a Google credentials file"},
+		{"external account", `{"type":"external_account"}`, "external_account credentials aren't supported"},
+		{"bad key", `{"type":"service_account","private_key":"x"}`, "not PEM"},
+		{"user without token", `{"type":"authorized_user","client_id":"a","client_secret":"b"}`, "refresh_token missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "creds.json")
+			os.WriteFile(path, []byte(tt.file), 0o600)
+			_, _, err := CredentialsTokenFn(path, "scope")
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("CredentialsTokenFn error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -6,17 +6,24 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"os/exec"
-	"runtime"
 	"strings"
 )
 
 const (
 	googleAuthURL    = "https://nestservices.google.com/partnerconnections"
 	googleTokenURL   = "https://oauth2.googleapis.com/token"
-	sdmScope         = "https://www.googleapis.com/auth/sdm.service https://www.googleapis.com/auth/pubsub"
+	SDMScope         = "https://www.googleapis.com/auth/sdm.service"
 	DefaultPort      = 9004
 	DefaultRedirect  = "http://localhost:9004/callback"
+
+	// PubSubScope is requested in addition to SDMScope unless Pub/Sub has
+	// its own credentials or isn't used.
+	PubSubScope = "https://www.googleapis.com/auth/pubsub"
+
+	// GCSScope and DriveScope are requested in addition to SDMScope when
+	// captures are uploaded to Google Cloud Storage or Drive.
+	GCSScope   = "https://www.googleapis.com/auth/devstorage.read_write"
+	DriveScope = "https://www.googleapis.com/auth/drive.file"
 )
 
 // AuthCodeResult is returned from the OAuth callback.
@@ -25,15 +32,17 @@ type AuthCodeResult struct {
 	Err  error
 }
 
-// BuildAuthURL constructs the Google OAuth authorization URL.
-func BuildAuthURL(clientID, redirectURI, projectID string) string {
+// BuildAuthURL constructs the Google OAuth authorization URL. extraScopes,
+// such as PubSubScope, are requested alongside SDMScope.
+func BuildAuthURL(clientID, redirectURI, projectID string, extraScopes ...string) string {
+	scope := strings.Join(append([]string{SDMScope}, extraScopes...), " ")
 	params := url.Values{
 		"redirect_uri":  {redirectURI},
 		"access_type":   {"offline"},
 		"prompt":        {"consent"},
 		"client_id":     {clientID},
 		"response_type": {"code"},
-		"scope":         {sdmScope},
+		"scope":         {scope},
 	}
 	return fmt.Sprintf("%s/%s/auth?%s", googleAuthURL, projectID, params.Encode())
 }
@@ -43,7 +52,7 @@ func BuildAuthURL(clientID, redirectURI, projectID string) string {
 //
 // The redirect URI http://localhost:9004/callback must be registered in your
 // Google Cloud Console under APIs & Services → Credentials → OAuth 2.0 Client.
-func BrowserFlow(ctx context.Context, clientID, projectID string) (code string, redirectURI string, err error) {
+func BrowserFlow(ctx context.Context, clientID, projectID string, extraScopes ...string) (code string, redirectURI string, err error) {
 	addr := fmt.Sprintf("localhost:%d", DefaultPort)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -52,7 +61,7 @@ func BrowserFlow(ctx context.Context, clientID, projectID string) (code string,
 	defer listener.Close()
 
 	redirectURI = DefaultRedirect
-	authURL := BuildAuthURL(clientID, redirectURI, projectID)
+	authURL := BuildAuthURL(clientID, redirectURI, projectID, extraScopes...)
 
 	resultCh := make(chan AuthCodeResult, 1)
 
@@ -78,7 +87,11 @@ func BrowserFlow(ctx context.Context, clientID, projectID string) (code string,
 
 	fmt.Printf("Opening browser for authentication...\n")
 	if err := openBrowser(authURL); err != nil {
-		fmt.Printf("Could not open browser. Please visit:\n%s\n", authURL)
+		fmt.Printf("Could not open browser (%v). Please visit:\n%s\n", err, authURL)
+	} else {
+		// Openers can succeed without showing anything (no default
+		// browser, a remote desktop), so the URL is always available.
+		fmt.Printf("If nothing opens, visit:\n%s\n", authURL)
 	}
 
 	select {
@@ -90,9 +103,9 @@ func BrowserFlow(ctx context.Context, clientID, projectID string) (code string,
 }
 
 // ManualFlow prints the auth URL and prompts the user to paste the redirect URL.
-func ManualFlow(clientID, projectID string) (code string, err error) {
+func ManualFlow(clientID, projectID string, extraScopes ...string) (code string, err error) {
 	redirectURI := "https://www.google.com"
-	authURL := BuildAuthURL(clientID, redirectURI, projectID)
+	authURL := BuildAuthURL(clientID, redirectURI, projectID, extraScopes...)
 
 	fmt.Printf("Visit this URL in your browser:\n\n%s\n\n", authURL)
 	fmt.Printf("After authorizing, paste the full redirect URL here: ")
@@ -113,18 +126,3 @@ func ManualFlow(clientID, projectID string) (code string, err error) {
 	}
 	return code, nil
 }
-
-func openBrowser(url string) error {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "darwin":
-		cmd = exec.Command("open", url)
-	case "linux":
-		cmd = exec.Command("xdg-open", url)
-	case "windows":
-		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
-	default:
-		return fmt.Errorf("unsupported platform")
-	}
-	return cmd.Start()
-}
@@ -19,14 +19,20 @@ type TokenResponse struct {
 	TokenType    string `json:"token_type"`
 }
 
-// TokenManager handles token caching and refresh.
+// TokenManager handles token caching and refresh. Tokens are cached per
+// scope set, so SDM and Pub/Sub calls can each use a token that covers only
+// their own API.
 type TokenManager struct {
 	clientID     string
 	clientSecret string
 
-	mu          sync.Mutex
-	accessToken string
-	expiry      time.Time
+	mu     sync.Mutex
+	tokens map[string]cachedAccess
+}
+
+type cachedAccess struct {
+	token  string
+	expiry time.Time
 }
 
 // NewTokenManager creates a new token manager.
@@ -34,6 +40,7 @@ func NewTokenManager(clientID, clientSecret string) *TokenManager {
 	return &TokenManager{
 		clientID:     clientID,
 		clientSecret: clientSecret,
+		tokens:       map[string]cachedAccess{},
 	}
 }
 
@@ -48,36 +55,59 @@ func (tm *TokenManager) ExchangeCode(code, redirectURI string) (*TokenResponse,
 	})
 }
 
-// AccessToken returns a valid access token, refreshing if needed.
+// AccessToken returns a valid access token for every scope the refresh
+// token was granted, refreshing if needed.
 func (tm *TokenManager) AccessToken(refreshToken string) (string, error) {
+	return tm.AccessTokenFor(refreshToken)
+}
+
+// AccessTokenFor returns a valid access token limited to scopes, which must
+// have been granted to the refresh token, refreshing if needed.
+func (tm *TokenManager) AccessTokenFor(refreshToken string, scopes ...string) (string, error) {
+	key := strings.Join(scopes, " ")
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
-	if tm.accessToken != "" && time.Now().Before(tm.expiry.Add(-60*time.Second)) {
-		return tm.accessToken, nil
+	if c := tm.tokens[key]; c.token != "" && time.Now().Before(c.expiry.Add(-60*time.Second)) {
+		return c.token, nil
 	}
 
-	resp, err := tm.refresh(refreshToken)
+	resp, err := tm.refresh(refreshToken, key)
 	if err != nil {
+		if key != "" && strings.Contains(err.Error(), "invalid_scope") {
+			return "", fmt.Errorf("%w (the authorization doesn't cover %s; re-run: gognestcli auth)", err, key)
+		}
 		return "", err
 	}
 
-	tm.accessToken = resp.AccessToken
-	tm.expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
-	return tm.accessToken, nil
+	tm.tokens[key] = cachedAccess{
+		token:  resp.AccessToken,
+		expiry: time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	}
+	return resp.AccessToken, nil
 }
 
-func (tm *TokenManager) refresh(refreshToken string) (*TokenResponse, error) {
-	return tm.tokenRequest(url.Values{
+// refresh gets a new access token, limited to scope if it's not empty.
+func (tm *TokenManager) refresh(refreshToken, scope string) (*TokenResponse, error) {
+	params := url.Values{
 		"client_id":     {tm.clientID},
 		"client_secret": {tm.clientSecret},
 		"refresh_token": {refreshToken},
 		"grant_type":    {"refresh_token"},
-	})
+	}
+	if scope != "" {
+		params.Set("scope", scope)
+	}
+	return tm.tokenRequest(params)
 }
 
 func (tm *TokenManager) tokenRequest(params url.Values) (*TokenResponse, error) {
-	resp, err := http.Post(googleTokenURL, "application/x-www-form-urlencoded", strings.NewReader(params.Encode()))
+	return postToken(googleTokenURL, params)
+}
+
+// postToken sends a token request to tokenURL.
+func postToken(tokenURL string, params url.Values) (*TokenResponse, error) {
+	resp, err := http.Post(tokenURL, "application/x-www-form-urlencoded", strings.NewReader(params.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("token request failed: %w", err)
 	}
@@ -0,0 +1,62 @@
+package capture
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// Automations matches events against the configured automations.
+type Automations struct {
+	rules []config.Automation
+}
+
+// NewAutomations checks the configured automations. Device references in
+// them should already be resolved to IDs. Unnamed automations are named by
+// their position in config ("#2") for messages.
+func NewAutomations(rules []config.Automation) (*Automations, error) {
+	rules = slices.Clone(rules)
+	for i := range rules {
+		r := &rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("#%d", i+1)
+		}
+		name := r.Name
+		if r.Event == "" {
+			return nil, fmt.Errorf("automation %s: event is required", name)
+		}
+		if len(r.Actions) == 0 {
+			return nil, fmt.Errorf("automation %s: no actions", name)
+		}
+		for j, a := range r.Actions {
+			switch {
+			case a.ClipSecs < 0:
+				return nil, fmt.Errorf("automation %s: action %d: clip_secs must not be negative", name, j+1)
+			case a.Command != "" && (a.Snapshot || a.Clip):
+				return nil, fmt.Errorf("automation %s: action %d: a command can't also capture; split it into two actions", name, j+1)
+			case a.Command == "" && a.Params != nil:
+				return nil, fmt.Errorf("automation %s: action %d: params without a command", name, j+1)
+			case a.Command == "" && !a.Snapshot && !a.Clip:
+				return nil, fmt.Errorf("automation %s: action %d does nothing (set snapshot, clip or command)", name, j+1)
+			}
+		}
+	}
+	return &Automations{rules: rules}, nil
+}
+
+// Match returns every automation that applies to an event of eventType from
+// deviceName, detected in zones, in config order.
+func (a *Automations) Match(deviceName, eventType string, zones []string) []config.Automation {
+	var matched []config.Automation
+	for _, r := range a.rules {
+		if !matchEvent(r.Event, eventType) || !MatchZone(r.Zone, zones) {
+			continue
+		}
+		if r.Device != "" && !MatchDevice(r.Device, deviceName) {
+			continue
+		}
+		matched = append(matched, r)
+	}
+	return matched
+}
@@ -0,0 +1,230 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultTemplate reproduces the original flat naming scheme,
+// e.g. "20240101-120000_person_001.jpg".
+const DefaultTemplate = "{{.Timestamp}}_{{.Type}}_{{.Seq}}.{{.Ext}}"
+
+// NameData is the data available to filename templates.
+type NameData struct {
+	Device    string // human-readable device name
+	DeviceID  string // device ID (last segment of the resource name)
+	Type      string // lowercase event type, e.g. "person"
+	Zone      string // activity zones joined with "+", or "none"
+	Date      string // 2006-01-02
+	Time      string // 150405
+	Timestamp string // 20060102-150405
+	Seq       string // zero-padded capture sequence number
+	Ext       string // file extension without the dot
+}
+
+// NewNameData fills NameData for a capture taken at t.
+func NewNameData(device, deviceID, eventType string, seq int64, ext string, t time.Time) NameData {
+	return NameData{
+		Device:    device,
+		DeviceID:  deviceID,
+		Type:      eventType,
+		Date:      t.Format("2006-01-02"),
+		Time:      t.Format("150405"),
+		Timestamp: t.Format("20060102-150405"),
+		Seq:       fmt.Sprintf("%03d", seq),
+		Ext:       strings.TrimPrefix(ext, "."),
+	}
+}
+
+// Namer renders capture paths from a filename template.
+type Namer struct {
+	tmpl *template.Template
+
+	parseOnce sync.Once
+	parseRe   *regexp.Regexp
+	parseKeys []string // NameData field for each capture group
+}
+
+// NewNamer parses a filename template such as
+// "{{.Device}}/{{.Date}}/{{.Time}}_{{.Type}}.{{.Ext}}". An empty template
+// selects DefaultTemplate.
+func NewNamer(text string) (*Namer, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename template: %w", err)
+	}
+	return &Namer{tmpl: tmpl}, nil
+}
+
+// Render returns the relative capture path for data. Slashes in the template
+// create subdirectories; values are sanitized so they can't escape them.
+func (n *Namer) Render(data NameData) (string, error) {
+	data.Device = sanitize(data.Device)
+	data.DeviceID = sanitize(data.DeviceID)
+	data.Type = sanitize(data.Type)
+	if data.Zone == "" {
+		data.Zone = "none"
+	}
+	data.Zone = sanitize(data.Zone)
+
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("rendering filename template: %w", err)
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(buf.String()))
+	if filepath.IsAbs(rel) || rel == "." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+		return "", fmt.Errorf("filename template produced invalid path %q", buf.String())
+	}
+	return rel, nil
+}
+
+// sanitize makes s safe for use as a single path segment.
+func sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '-'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" || s == "." || s == ".." {
+		return "unknown"
+	}
+	return s
+}
+
+type nameField struct{ name, pattern string }
+
+// nameFields are the NameData fields and the text each matches when parsing
+// a path back into NameData.
+var nameFields = []nameField{
+	{"Device", `[^/]+?`},
+	{"DeviceID", `[^/]+?`},
+	{"Type", `[^/]+?`},
+	{"Zone", `[^/]+?`},
+	{"Date", `\d{4}-\d{2}-\d{2}`},
+	{"Time", `\d{6}`},
+	{"Timestamp", `\d{8}-\d{6}`},
+	{"Seq", `\d+`},
+	{"Ext", `[A-Za-z0-9]+`},
+}
+
+// field returns a pointer to the named field, or nil.
+func (d *NameData) field(name string) *string {
+	switch name {
+	case "Device":
+		return &d.Device
+	case "DeviceID":
+		return &d.DeviceID
+	case "Type":
+		return &d.Type
+	case "Zone":
+		return &d.Zone
+	case "Date":
+		return &d.Date
+	case "Time":
+		return &d.Time
+	case "Timestamp":
+		return &d.Timestamp
+	case "Seq":
+		return &d.Seq
+	case "Ext":
+		return &d.Ext
+	}
+	return nil
+}
+
+// Parse is the inverse of Render: it matches a relative capture path against
+// the template and returns the fields it contains. It reports false if rel
+// doesn't fit the template.
+func (n *Namer) Parse(rel string) (NameData, bool) {
+	n.parseOnce.Do(n.compileParser)
+	if n.parseRe == nil {
+		return NameData{}, false
+	}
+	m := n.parseRe.FindStringSubmatch(filepath.ToSlash(rel))
+	if m == nil {
+		return NameData{}, false
+	}
+
+	var data NameData
+	for i, key := range n.parseKeys {
+		v := m[i+1]
+		if p := data.field(key); p != nil && *p == "" {
+			*p = v
+		}
+	}
+	return data, true
+}
+
+// compileParser renders the template with a marker in each field and turns
+// the result into a regular expression, so any layout Render can produce can
+// be read back.
+func (n *Namer) compileParser() {
+	marker := func(name string) string { return "\ue000" + name + "\ue001" }
+	data := NameData{}
+	for _, f := range nameFields {
+		*data.field(f.name) = marker(f.name)
+	}
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, data); err != nil {
+		return
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	rest := filepath.ToSlash(path.Clean(buf.String()))
+	for {
+		start := strings.Index(rest, "\ue000")
+		if start < 0 {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		end := strings.Index(rest[start:], "\ue001")
+		if end < 0 {
+			return
+		}
+		name := rest[start+len("\ue000") : start+end]
+		i := slices.IndexFunc(nameFields, func(f nameField) bool { return f.name == name })
+		if i < 0 {
+			return // a template function altered the marker
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:start]))
+		expr.WriteString("(" + nameFields[i].pattern + ")")
+		n.parseKeys = append(n.parseKeys, name)
+		rest = rest[start+end+len("\ue001"):]
+	}
+	expr.WriteString("$")
+	n.parseRe, _ = regexp.Compile(expr.String())
+}
+
+// CaptureTime returns the capture time encoded in the name, if it has one.
+func (d NameData) CaptureTime() (time.Time, bool) {
+	switch {
+	case d.Timestamp != "":
+		t, err := time.ParseInLocation("20060102-150405", d.Timestamp, time.Local)
+		return t, err == nil
+	case d.Date != "" && d.Time != "":
+		t, err := time.ParseInLocation("2006-01-02 150405", d.Date+" "+d.Time, time.Local)
+		return t, err == nil
+	case d.Date != "":
+		t, err := time.ParseInLocation("2006-01-02", d.Date, time.Local)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,93 @@
+package capture
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/history"
+)
+
+// settleTime is how long a file must be left untouched before Index picks
+// it up, so captures still being written or about to be logged aren't
+// indexed twice.
+const settleTime = time.Minute
+
+// captureExts are the file types Index treats as captures.
+var captureExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".mp4":  true,
+	".webm": true,
+	".mkv":  true,
+}
+
+// Index finds captures under dir that the history doesn't know about yet,
+// e.g. clips written by other tools or restored from a backup, and returns
+// history records for them. Device, type, event and time come from the
+// capture's sidecar when there is one, otherwise from its path via the
+// filename template, falling back to the file's modification time.
+// indexed reports whether a slash-separated path relative to dir is already
+// in the history.
+func Index(dir string, namer *Namer, indexed func(rel string) bool) ([]history.Record, error) {
+	cutoff := time.Now().Add(-settleTime)
+	var records []history.Record
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(name, ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") || strings.Contains(name, ".tmp.") || !captureExts[strings.ToLower(filepath.Ext(name))] {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if indexed(rel) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		r := history.Record{Kind: history.KindCapture, Time: info.ModTime(), Path: rel}
+		if s, err := ReadSidecar(path); err == nil {
+			r.Device, r.Type, r.EventID = s.Device, s.EventType, s.EventID
+			switch {
+			case !s.EventTime.IsZero():
+				r.Time = s.EventTime
+			case !s.Started.IsZero():
+				r.Time = s.Started
+			}
+		} else if data, ok := namer.Parse(rel); ok {
+			r.Device = data.DeviceID
+			if r.Device == "" {
+				r.Device = data.Device
+			}
+			if data.Type != "" {
+				r.Type = strings.ToUpper(data.Type[:1]) + data.Type[1:]
+			}
+			if t, ok := data.CaptureTime(); ok {
+				r.Time = t
+			}
+		}
+		records = append(records, r)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return records, err
+	}
+	return records, nil
+}
@@ -0,0 +1,95 @@
+package capture
+
+import (
+	"strings"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// Action describes what to capture for an event.
+type Action struct {
+	Snapshot bool
+	Clip     bool
+	ClipSecs int // 0 means the command's default clip length
+}
+
+// Policies resolves capture actions from the configured per-event-type rules.
+type Policies struct {
+	rules []config.CapturePolicy
+}
+
+// NewPolicies wraps the configured rules. An empty rule set means callers
+// should fall back to their command-line defaults.
+func NewPolicies(rules []config.CapturePolicy) *Policies {
+	return &Policies{rules: rules}
+}
+
+// Empty reports whether no rules are configured.
+func (p *Policies) Empty() bool {
+	return len(p.rules) == 0
+}
+
+// Match returns the action for an event of eventType (e.g.
+// "sdm.devices.events.CameraPerson.Person") from deviceName, detected in
+// zones. Rules naming the device win over device-agnostic ones; otherwise the
+// first match in config order applies. ok is false when no rule matches.
+func (p *Policies) Match(deviceName, eventType string, zones []string) (action Action, ok bool) {
+	var generic *config.CapturePolicy
+	for i := range p.rules {
+		r := &p.rules[i]
+		if !matchEvent(r.Event, eventType) || !MatchZone(r.Zone, zones) {
+			continue
+		}
+		if r.Device == "" {
+			if generic == nil {
+				generic = r
+			}
+			continue
+		}
+		if MatchDevice(r.Device, deviceName) {
+			return toAction(r), true
+		}
+	}
+	if generic != nil {
+		return toAction(generic), true
+	}
+	return Action{}, false
+}
+
+func toAction(r *config.CapturePolicy) Action {
+	return Action{Snapshot: r.Snapshot, Clip: r.Clip, ClipSecs: r.ClipSecs}
+}
+
+// matchEvent compares a rule's event name ("Person", "CameraPerson.Person",
+// or "*") against a full SDM event type, case-insensitively.
+func matchEvent(rule, eventType string) bool {
+	if rule == "*" {
+		return true
+	}
+	rule = strings.ToLower(rule)
+	eventType = strings.ToLower(eventType)
+	return eventType == rule || strings.HasSuffix(eventType, "."+rule)
+}
+
+// MatchZone reports whether an event detected in zones satisfies a rule's
+// zone, compared case-insensitively. An empty rule zone matches any event.
+func MatchZone(zone string, zones []string) bool {
+	if zone == "" {
+		return true
+	}
+	for _, z := range zones {
+		if strings.EqualFold(z, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchDevice reports whether a configured device reference (full resource
+// name or bare device ID) refers to deviceName.
+func MatchDevice(ref, deviceName string) bool {
+	if ref == deviceName {
+		return true
+	}
+	return strings.HasSuffix(deviceName, "/"+ref)
+}
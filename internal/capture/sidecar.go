@@ -0,0 +1,83 @@
+package capture
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SidecarSuffix is appended to a capture's path for its metadata sidecar,
+// e.g. "front.mp4.json". The full name is kept so a snapshot and clip sharing
+// a stem don't collide.
+const SidecarSuffix = ".json"
+
+// Sidecar is the metadata written next to a capture so archives stay
+// searchable and verifiable without the history log.
+type Sidecar struct {
+	File           string    `json:"file"`
+	Device         string    `json:"device"` // device ID
+	DeviceName     string    `json:"device_name,omitempty"`
+	Room           string    `json:"room,omitempty"`
+	EventType      string    `json:"event_type,omitempty"`
+	EventID        string    `json:"event_id,omitempty"`
+	EventSessionID string    `json:"event_session_id,omitempty"`
+	EventThreadID  string    `json:"event_thread_id,omitempty"`
+	EventTime      time.Time `json:"event_time,omitzero"`
+	Loudness       float64   `json:"loudness_db,omitempty"`
+	FamiliarFaces  []string  `json:"familiar_faces,omitempty"`
+	Zones          []string  `json:"zones,omitempty"`
+	Started        time.Time `json:"started"`
+	Finished       time.Time `json:"finished"`
+	Duration       float64   `json:"duration_seconds,omitempty"`
+	Width          int       `json:"width,omitempty"`
+	Height         int       `json:"height,omitempty"`
+	Profile        string    `json:"h264_profile,omitempty"` // as the camera sent it, e.g. "High"
+	Level          string    `json:"h264_level,omitempty"`
+	Size           int64     `json:"size"`
+	SHA256         string    `json:"sha256"`
+}
+
+// WriteSidecar fills in the file name, size and SHA256 of the capture at path
+// and writes s to path+SidecarSuffix.
+func WriteSidecar(path string, s Sidecar) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	s.File = filepath.Base(path)
+	s.Size = n
+	s.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + SidecarSuffix + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path+SidecarSuffix)
+}
+
+// ReadSidecar reads the sidecar for the capture at path.
+func ReadSidecar(path string) (*Sidecar, error) {
+	data, err := os.ReadFile(path + SidecarSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var s Sidecar
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,122 @@
+package capture
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// DefaultTriggers are the events that get default captures unless config or
+// flags say otherwise.
+var DefaultTriggers = []string{"Motion", "Person"}
+
+// EventMatcher matches SDM event types against event names ("Person",
+// "DoorbellChime.Chime", "*"), compared as in policies, and against regular
+// expressions written between slashes ("/Camera(Motion|Person)/"), which are
+// matched against the full type.
+type EventMatcher struct {
+	names []string
+	res   []*regexp.Regexp
+}
+
+// NewEventMatcher parses patterns.
+func NewEventMatcher(patterns []string) (*EventMatcher, error) {
+	m := &EventMatcher{}
+	for _, p := range patterns {
+		if len(p) >= 2 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid event pattern %q: %w", p, err)
+			}
+			m.res = append(m.res, re)
+			continue
+		}
+		if strings.TrimSpace(p) == "" {
+			return nil, fmt.Errorf("empty event pattern")
+		}
+		m.names = append(m.names, p)
+	}
+	return m, nil
+}
+
+// Match reports whether eventType matches any pattern.
+func (m *EventMatcher) Match(eventType string) bool {
+	for _, n := range m.names {
+		if matchEvent(n, eventType) {
+			return true
+		}
+	}
+	for _, re := range m.res {
+		if re.MatchString(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Triggers decides which events get the command's default captures when no
+// policies are configured: those matching an include pattern and no exclude
+// pattern. A device's own lists replace the global ones.
+type Triggers struct {
+	include, exclude *EventMatcher
+	devices          []deviceTriggers
+}
+
+type deviceTriggers struct {
+	ref              string
+	include, exclude *EventMatcher // nil keeps the global list
+}
+
+// NewTriggers builds triggers from the global filter and per-device filters
+// keyed by device reference (full resource name or device ID). An empty
+// global include list selects DefaultTriggers.
+func NewTriggers(global config.EventFilter, devices map[string]config.EventFilter) (*Triggers, error) {
+	include := global.Include
+	if len(include) == 0 {
+		include = DefaultTriggers
+	}
+	t := &Triggers{}
+	var err error
+	if t.include, err = NewEventMatcher(include); err != nil {
+		return nil, err
+	}
+	if t.exclude, err = NewEventMatcher(global.Exclude); err != nil {
+		return nil, err
+	}
+	for ref, f := range devices {
+		d := deviceTriggers{ref: ref}
+		if len(f.Include) > 0 {
+			if d.include, err = NewEventMatcher(f.Include); err != nil {
+				return nil, fmt.Errorf("device %s: %w", ref, err)
+			}
+		}
+		if len(f.Exclude) > 0 {
+			if d.exclude, err = NewEventMatcher(f.Exclude); err != nil {
+				return nil, fmt.Errorf("device %s: %w", ref, err)
+			}
+		}
+		t.devices = append(t.devices, d)
+	}
+	return t, nil
+}
+
+// Match reports whether an event of eventType from deviceName triggers the
+// default captures.
+func (t *Triggers) Match(deviceName, eventType string) bool {
+	include, exclude := t.include, t.exclude
+	for _, d := range t.devices {
+		if !MatchDevice(d.ref, deviceName) {
+			continue
+		}
+		if d.include != nil {
+			include = d.include
+		}
+		if d.exclude != nil {
+			exclude = d.exclude
+		}
+		break
+	}
+	return include.Match(eventType) && !exclude.Match(eventType)
+}
@@ -2,7 +2,7 @@ package cmd
 
 import (
 	"bufio"
-	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -10,13 +10,24 @@ import (
 	"github.com/brice/gognestcli/internal/auth"
 	"github.com/brice/gognestcli/internal/config"
 	"github.com/brice/gognestcli/internal/secrets"
+	"golang.org/x/term"
 )
 
 type AuthCmd struct {
-	Manual bool `help:"Use manual paste flow instead of browser callback" default:"false"`
+	Login  AuthLoginCmd  `cmd:"" default:"withargs" help:"Run the OAuth flow and store the refresh token (the default)"`
+	Export AuthExportCmd `cmd:"" help:"Write the config and refresh token to a passphrase-encrypted bundle for another machine"`
+	Import AuthImportCmd `cmd:"" help:"Restore the config and refresh token from a bundle made by auth export"`
+	List   AuthListCmd   `cmd:"" help:"List the refresh tokens and config keys stored in the OS keyring, for all profiles"`
+	Remove AuthRemoveCmd `cmd:"" help:"Remove stored credentials from the OS keyring (the current project's refresh token by default)"`
 }
 
-func (a *AuthCmd) Run() error {
+type AuthLoginCmd struct {
+	Manual   bool `help:"Use manual paste flow instead of browser callback" default:"false"`
+	Storage  bool `help:"Also request Cloud Storage and Drive scopes for capture uploads" default:"false"`
+	NoPubSub bool `name:"no-pubsub" help:"Don't request the Pub/Sub scope, if events and watch --pubsub aren't used" default:"false"`
+}
+
+func (a *AuthLoginCmd) Run() error {
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
@@ -43,29 +54,55 @@ func (a *AuthCmd) Run() error {
 		}
 	}
 
+	warnCommentsDropped()
 	if err := cfg.Save(); err != nil {
 		return fmt.Errorf("saving config: %w", err)
 	}
 	fmt.Println("Config saved.")
 
-	var code string
-	var redirectURI string
+	if err := authorize(cfg, a.Manual, consentScopes(cfg, !a.NoPubSub, a.Storage)); err != nil {
+		return err
+	}
+	fmt.Println("Authentication successful!")
+	return nil
+}
+
+// consentScopes returns the scopes to request besides the SDM scope. The
+// Pub/Sub scope is left out when pubsub_credentials authenticates Pub/Sub.
+func consentScopes(cfg *config.Config, pubsub, storage bool) []string {
+	var scopes []string
+	if pubsub && cfg.PubSubCredentials == "" {
+		scopes = append(scopes, auth.PubSubScope)
+	}
+	if storage {
+		scopes = append(scopes, auth.GCSScope, auth.DriveScope)
+	}
+	return scopes
+}
+
+// manualRedirect is the redirect URI used by auth.ManualFlow.
+const manualRedirect = "https://www.google.com"
+
+// authorize runs the OAuth flow for cfg's client and stores the refresh token
+// in the OS keyring.
+func authorize(cfg *config.Config, manual bool, extraScopes []string) error {
+	var code, redirectURI string
+	var err error
 
-	if !a.Manual {
+	if !manual {
 		fmt.Printf("\nMake sure this redirect URI is registered in Google Cloud Console:\n")
 		fmt.Printf("  %s\n", auth.DefaultRedirect)
 		fmt.Printf("  (APIs & Services → Credentials → OAuth 2.0 Client → Authorized redirect URIs)\n\n")
 	}
 
-	if a.Manual {
-		redirectURI = "https://www.google.com"
-		code, err = auth.ManualFlow(cfg.ClientID, cfg.ProjectID)
+	if manual {
+		redirectURI = manualRedirect
+		code, err = auth.ManualFlow(cfg.ClientID, cfg.ProjectID, extraScopes...)
 		if err != nil {
 			return fmt.Errorf("manual auth flow: %w", err)
 		}
 	} else {
-		ctx := context.Background()
-		code, redirectURI, err = auth.BrowserFlow(ctx, cfg.ClientID, cfg.ProjectID)
+		code, redirectURI, err = auth.BrowserFlow(commandCtx, cfg.ClientID, cfg.ProjectID, extraScopes...)
 		if err != nil {
 			return fmt.Errorf("browser auth flow: %w", err)
 		}
@@ -77,22 +114,26 @@ func (a *AuthCmd) Run() error {
 		return fmt.Errorf("exchanging auth code: %w", err)
 	}
 
-	store, err := secrets.NewStore()
+	store, err := openStore()
 	if err != nil {
 		return fmt.Errorf("opening keyring: %w", err)
 	}
 
 	if tok.RefreshToken != "" {
-		if err := store.SaveRefreshToken(tok.RefreshToken); err != nil {
+		if err := store.SaveRefreshToken(cfg.ProjectID, tok.RefreshToken); err != nil {
 			return fmt.Errorf("saving refresh token: %w", err)
 		}
 		fmt.Println("Refresh token saved to OS keyring.")
 	}
 
-	fmt.Println("Authentication successful!")
 	return nil
 }
 
+// openStore opens the keyring items of the profile selected with --profile.
+func openStore() (*secrets.Store, error) {
+	return secrets.NewStore(config.Profile())
+}
+
 func prompt(reader *bufio.Reader, label string) (string, error) {
 	fmt.Printf("%s: ", label)
 	val, err := reader.ReadString('\n')
@@ -105,3 +146,267 @@ func prompt(reader *bufio.Reader, label string) (string, error) {
 	}
 	return val, nil
 }
+
+// authBundle is what auth export encrypts: the config with its secrets in
+// plain text, so the bundle doesn't depend on this machine's keyring.
+type authBundle struct {
+	Config       json.RawMessage `json:"config"`
+	RefreshToken string          `json:"refresh_token"`
+}
+
+// minPassphrase is the shortest passphrase auth export accepts.
+const minPassphrase = 8
+
+type AuthExportCmd struct {
+	Out   string `short:"o" required:"" help:"Bundle file to write" type:"path"`
+	Force bool   `help:"Overwrite the bundle file if it exists" default:"false"`
+}
+
+func (a *AuthExportCmd) Run() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("loading config: %w", err))
+	}
+	if err := cfg.Validate(); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	store, err := openStore()
+	if err != nil {
+		return fmt.Errorf("opening keyring: %w", err)
+	}
+	token, err := store.LoadRefreshToken(cfg.ProjectID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(a.Out); err == nil && !a.Force {
+		return fmt.Errorf("%s already exists (use --force to overwrite)", a.Out)
+	}
+
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(authBundle{Config: cfgJSON, RefreshToken: token})
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase("Bundle passphrase", true)
+	if err != nil {
+		return err
+	}
+	data, err := secrets.SealBundle(payload, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.Out, data, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s.\n", a.Out)
+	fmt.Println("It holds your client secret and refresh token: copy it to the new machine, run `gognestcli auth import` there, then delete it.")
+	return nil
+}
+
+type AuthImportCmd struct {
+	File  string `arg:"" help:"Bundle file written by auth export" type:"existingfile"`
+	Force bool   `help:"Replace an existing config and refresh token" default:"false"`
+}
+
+func (a *AuthImportCmd) Run() error {
+	data, err := os.ReadFile(a.File)
+	if err != nil {
+		return err
+	}
+
+	// Check for existing credentials before asking for the passphrase.
+	existing, err := config.Load()
+	if err != nil && !a.Force {
+		return withExitCode(ExitConfig, fmt.Errorf("loading config: %w (use --force to replace it)", err))
+	}
+	if !a.Force && existing.ClientID != "" {
+		return fmt.Errorf("a config with a client_id already exists (use --force to replace it)")
+	}
+	store, err := openStore()
+	if err != nil {
+		return fmt.Errorf("opening keyring: %w", err)
+	}
+
+	passphrase, err := readPassphrase("Bundle passphrase", false)
+	if err != nil {
+		return err
+	}
+	payload, err := secrets.OpenBundle(data, passphrase)
+	if err != nil {
+		return err
+	}
+	var bundle authBundle
+	if err := json.Unmarshal(payload, &bundle); err != nil {
+		return fmt.Errorf("reading bundle: %w", err)
+	}
+	cfg, err := config.Parse("config.json", bundle.Config)
+	if err != nil {
+		return fmt.Errorf("bundled config: %w", err)
+	}
+	if bundle.RefreshToken == "" {
+		return fmt.Errorf("bundle has no refresh token")
+	}
+	if _, err := store.LoadRefreshToken(cfg.ProjectID); err == nil && !a.Force {
+		return fmt.Errorf("the keyring already holds a refresh token for project %s (use --force to replace it)", cfg.ProjectID)
+	}
+
+	// Saving re-encrypts the secrets with this machine's key when
+	// encrypt_secrets is set.
+	warnCommentsDropped()
+	if err := cfg.Save(); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	path, _ := config.Path()
+	fmt.Printf("Config saved to %s.\n", path)
+	if err := store.SaveRefreshToken(cfg.ProjectID, bundle.RefreshToken); err != nil {
+		return fmt.Errorf("saving refresh token: %w", err)
+	}
+	fmt.Println("Refresh token saved to OS keyring.")
+	fmt.Printf("Delete %s now that it's imported.\n", a.File)
+	return nil
+}
+
+// readPassphrase reads a passphrase without echo from the terminal, asking
+// twice if confirm is set. When stdin isn't a terminal it's read as one line,
+// for scripts.
+func readPassphrase(label string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading passphrase: %w", err)
+		}
+		pass := strings.TrimRight(line, "\r\n")
+		if confirm && len(pass) < minPassphrase {
+			return "", fmt.Errorf("passphrase must be at least %d characters", minPassphrase)
+		}
+		return pass, nil
+	}
+
+	read := func(label string) (string, error) {
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	pass, err := read(label)
+	if err != nil {
+		return "", err
+	}
+	if !confirm {
+		return pass, nil
+	}
+	if len(pass) < minPassphrase {
+		return "", fmt.Errorf("passphrase must be at least %d characters", minPassphrase)
+	}
+	again, err := read("Repeat " + strings.ToLower(label))
+	if err != nil {
+		return "", err
+	}
+	if again != pass {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return pass, nil
+}
+
+type AuthListCmd struct{}
+
+func (a *AuthListCmd) Run() error {
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	items, err := store.Items()
+	if err != nil {
+		return fmt.Errorf("listing keyring: %w", err)
+	}
+	if len(items) == 0 {
+		fmt.Println("No credentials stored (run: gognestcli auth).")
+		return nil
+	}
+
+	// Mark the items the current profile and config use.
+	profile := config.Profile()
+	if profile == "" {
+		profile = secrets.DefaultProfile
+	}
+	project := ""
+	if cfg, err := config.Load(); err == nil {
+		project = cfg.ProjectID
+	}
+	fmt.Printf("%-12s  %-13s  %-36s  %s\n", "PROFILE", "KIND", "PROJECT", "ITEM")
+	for _, it := range items {
+		proj := it.Project
+		if proj == "" && it.Kind == "refresh token" {
+			proj = "(not namespaced yet)"
+		}
+		inUse := ""
+		if it.Profile == profile && (it.Kind == "config key" || it.Project == project) {
+			inUse = "  (in use)"
+		}
+		fmt.Printf("%-12s  %-13s  %-36s  %s%s\n", it.Profile, it.Kind, proj, it.Key, inUse)
+	}
+	return nil
+}
+
+type AuthRemoveCmd struct {
+	Items   []string `arg:"" optional:"" help:"Keyring items to remove, as listed by auth list"`
+	Project string   `help:"Remove the refresh token for this SDM project in the current profile instead of the config's"`
+	Force   bool     `help:"Allow removing a config key; configs encrypted with it can no longer be loaded" default:"false"`
+}
+
+func (a *AuthRemoveCmd) Run() error {
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	if len(a.Items) == 0 {
+		project := a.Project
+		if project == "" {
+			cfg, err := config.Load()
+			if err != nil {
+				return withExitCode(ExitConfig, fmt.Errorf("loading config: %w (pass --project or an item from auth list)", err))
+			}
+			if project = cfg.ProjectID; project == "" {
+				return withExitCode(ExitConfig, fmt.Errorf("project_id not configured (pass --project or an item from auth list)"))
+			}
+		}
+		if _, err := store.LoadRefreshToken(project); err != nil {
+			return err
+		}
+		if err := store.DeleteRefreshToken(project); err != nil {
+			return err
+		}
+		fmt.Printf("Removed the refresh token for project %s.\n", project)
+		return nil
+	}
+
+	items, err := store.Items()
+	if err != nil {
+		return fmt.Errorf("listing keyring: %w", err)
+	}
+	kinds := map[string]string{}
+	for _, it := range items {
+		kinds[it.Key] = it.Kind
+	}
+	for _, key := range a.Items {
+		kind, ok := kinds[key]
+		if !ok {
+			return fmt.Errorf("no gognestcli keyring item %q (see: gognestcli auth list)", key)
+		}
+		if kind == "config key" && !a.Force {
+			return fmt.Errorf("%s is a config encryption key; configs encrypted with it can't be loaded without it (use --force, after config decrypt)", key)
+		}
+	}
+	for _, key := range a.Items {
+		if err := store.Remove(key); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s.\n", key)
+	}
+	return nil
+}
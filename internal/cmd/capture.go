@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/crash"
+	"github.com/brice/gognestcli/internal/fanout"
+	"github.com/brice/gognestcli/internal/history"
+	"github.com/brice/gognestcli/internal/pubsub"
+	"github.com/brice/gognestcli/internal/recorder"
+	"github.com/brice/gognestcli/internal/retry"
+	"github.com/brice/gognestcli/internal/sdm"
+	"github.com/brice/gognestcli/internal/trigger"
+)
+
+type CaptureCmd struct {
+	DeviceID   string `short:"d" help:"Device ID (uses config default if omitted)"`
+	Clip       int    `help:"Also record a clip this many seconds long (0 for none)" default:"0"`
+	NoSnapshot bool   `help:"Don't take a snapshot; with --clip, only record the clip" default:"false"`
+	OutputDir  string `short:"o" help:"Events output dir to save captures into" default:"events"`
+
+	NoUpload         bool   `help:"Keep captures local even if upload targets are configured" default:"false"`
+	NoFanout         bool   `help:"Don't publish the capture to the NATS/Kafka targets in config" default:"false"`
+	FilenameTemplate string `help:"Capture path template relative to the output dir (overrides filename_template in config)"`
+	Overlay          bool   `help:"Burn the wall-clock time and camera name into the captures" default:"false"`
+}
+
+// Run captures now through the events pipeline: the captures are named,
+// logged in the history, published and uploaded as if an event had asked for
+// them.
+func (c *CaptureCmd) Run(g *Globals) error {
+	snapshot := !c.NoSnapshot
+	req := trigger.Request{Snapshot: &snapshot, Clip: c.Clip, Source: "cli"}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	tokenFn, err := newTokenFn(cfg)
+	if err != nil {
+		return err
+	}
+	client := newClient(cfg, tokenFn)
+	if req.Device, err = resolveDevice(client, cfg, c.DeviceID); err != nil {
+		return err
+	}
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	e := &EventsCmd{
+		OutputDir:        c.OutputDir,
+		NoUpload:         c.NoUpload,
+		NoFanout:         c.NoFanout,
+		FilenameTemplate: c.FilenameTemplate,
+		Overlay:          c.Overlay,
+	}
+	cleanup, err := e.setup(g, cfg, true)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	var seq atomic.Int64
+	wait, err := e.startManual(commandCtx, client, &seq, req)
+	if err != nil {
+		return err
+	}
+	return wait()
+}
+
+// manualEvent is the event type recorded for manual captures.
+const manualEvent = "Manual"
+
+// startTriggers starts the HTTP and MQTT capture triggers configured under
+// triggers, or --trigger-listen, until ctx is done. Captures they start are
+// bound to work, like event captures. The HTTP listener's /healthz reports
+// health.
+func (e *EventsCmd) startTriggers(ctx, work context.Context, client sdm.API, cfg *config.Config, seq *atomic.Int64, health trigger.HealthFunc) error {
+	tc := cfg.Triggers
+	if tc == nil {
+		tc = &config.TriggersConfig{}
+	}
+	handle := func(req trigger.Request) error {
+		_, err := e.startManual(work, client, seq, req)
+		return err
+	}
+
+	listen := e.TriggerListen
+	if listen == "" {
+		listen = tc.HTTPListen
+	}
+	if listen != "" {
+		err := trigger.ServeHTTP(ctx, listen, tc.HTTPToken, handle, health, func(err error) {
+			fmt.Printf("Warning: trigger listener: %v\n", err)
+		})
+		if err != nil {
+			return err
+		}
+		auth := "no token"
+		if tc.HTTPToken != "" {
+			auth = "bearer token required"
+		}
+		fmt.Printf("Accepting capture requests at http://%s/capture (%s); health at /healthz\n", listen, auth)
+	}
+
+	if tc.MQTT != nil {
+		m, err := trigger.NewMQTT(*tc.MQTT)
+		if err != nil {
+			return fmt.Errorf("triggers.mqtt: %w", err)
+		}
+		fmt.Printf("Accepting capture requests on MQTT topic %s\n", m.Topic())
+		go m.Run(ctx, handle, func(err error) {
+			fmt.Printf("Warning: mqtt trigger: %v\n", err)
+		})
+	}
+	return nil
+}
+
+// startManual starts the captures req asks for and returns once they're
+// under way. wait blocks until they finish and returns their errors. The
+// request is logged, recorded and published as a Manual event; captures
+// that fail on transient errors are queued for retry like event captures.
+func (e *EventsCmd) startManual(work context.Context, client sdm.API, seq *atomic.Int64, req trigger.Request) (wait func() error, err error) {
+	deviceName, err := resolveDevice(client, e.cfg, req.Device)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", trigger.ErrInvalid, err)
+	}
+	if err := ensureWebRTC(client, deviceName); err != nil {
+		return nil, fmt.Errorf("%w: %w", trigger.ErrInvalid, err)
+	}
+	if !e.begin() {
+		return nil, trigger.ErrUnavailable
+	}
+	defer e.done()
+
+	event := pubsub.Event{DeviceName: deviceName, EventType: manualEvent, Timestamp: time.Now()}
+	deviceShort := deviceDisplayNameFromFull(deviceName)
+	fmt.Printf("[%s] %s: %s (%s)\n", event.Timestamp.Format("15:04:05"), e.friendly(deviceName), manualEvent, req.Source)
+	e.record(history.Record{
+		Kind:   history.KindEvent,
+		Time:   event.Timestamp,
+		Device: deviceShort,
+		Type:   manualEvent,
+	})
+	e.publish(fanout.KindEvent, event, "")
+	return e.startCaptures(work, client, event, seq.Add(1), req.WantSnapshot(), req.Clip > 0, req.ClipDuration()), nil
+}
+
+// startCaptures starts a snapshot and/or a clip of length (the default when
+// 0) for event without waiting for a free slot, and handles the results like
+// event captures. The snapshot is the event image when the event has one,
+// else taken from the live stream. wait blocks until both finish and returns
+// their errors.
+func (e *EventsCmd) startCaptures(work context.Context, client sdm.API, event pubsub.Event, seq int64, snapshot, clip bool, length time.Duration) (wait func() error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	run := func(item retry.Item, capture func() (string, error)) {
+		if !e.begin() {
+			return
+		}
+		wg.Add(1)
+		crash.Go(item.Kind, func() {
+			defer wg.Done()
+			defer e.done()
+			path, err := capture()
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", item.Kind, err))
+				mu.Unlock()
+				e.retryLater(item, err)
+				return
+			}
+			e.recordCapture(event, path)
+			e.refreshGallery()
+			e.upload(work, event, path)
+		})
+	}
+	if snapshot {
+		run(retry.Item{Kind: retry.KindSnapshot, Event: event, Seq: seq}, func() (string, error) {
+			if event.EventID != "" {
+				return e.captureEventImage(client, event, seq)
+			}
+			return e.captureLiveSnapshot(client, event, seq)
+		})
+	}
+	if clip {
+		run(retry.Item{Kind: retry.KindClip, Event: event, Seq: seq, Duration: length}, func() (string, error) {
+			return e.captureClip(client, event, seq, length, false)
+		})
+	}
+
+	return func() error {
+		wg.Wait()
+		return errors.Join(errs...)
+	}
+}
+
+// captureLiveSnapshot takes a snapshot from the live stream, for events
+// without an event image, and returns the saved path. Failures are logged
+// before they're returned.
+func (e *EventsCmd) captureLiveSnapshot(client sdm.API, event pubsub.Event, seq int64) (string, error) {
+	outputPath, err := e.capturePath(event, strings.ToLower(shortType(event.EventType)), seq, "jpg")
+	if err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+		return "", err
+	}
+	defer e.releasePath(outputPath)
+
+	fmt.Printf("  Taking snapshot: %s\n", filepath.Base(outputPath))
+	started := time.Now()
+	stream := newStreamCheck(client, event.DeviceName, warnStream)
+	opts := append(slices.Clip(e.recOpts), stream.option())
+	if e.Overlay {
+		opts = append(opts, recorder.WithOverlay(deviceLabel(client, event.DeviceName)))
+	}
+	if err := recorder.TakeSnapshot(outputPath, e.stream(client, event.DeviceName), opts...); err != nil {
+		fmt.Printf("  Warning: snapshot failed: %v\n", err)
+		return "", err
+	}
+
+	fmt.Printf("  Saved: %s\n", outputPath)
+	e.writeSidecar(client, event, outputPath, started, stream)
+	return outputPath, nil
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type CommandCmd struct {
+	DeviceID string            `arg:"" help:"Device ID or full resource name"`
+	Command  string            `arg:"" help:"SDM command, e.g. ThermostatMode.SetMode (the sdm.devices.commands. prefix is optional)"`
+	Param    map[string]string `short:"p" help:"Command parameter as key=value; values are parsed as JSON when possible (repeatable)"`
+	JSON     string            `name:"json" help:"Command parameters as a JSON object, merged under --param values"`
+}
+
+func (c *CommandCmd) Run() error {
+	client, cfg, err := newSDMClient()
+	if err != nil {
+		return err
+	}
+	// Commands change traits, so the cached device is dropped.
+	client = cachedClient(client, cfg, false)
+
+	deviceName, err := resolveDevice(client, cfg, c.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]interface{}{}
+	if c.JSON != "" {
+		if err := json.Unmarshal([]byte(c.JSON), &params); err != nil {
+			return fmt.Errorf("parsing --json: %w", err)
+		}
+	}
+	for k, v := range c.Param {
+		// Accept numbers, booleans and objects as-is; anything else is a string.
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(v), &parsed); err == nil {
+			params[k] = parsed
+		} else {
+			params[k] = v
+		}
+	}
+
+	command := c.Command
+	if !strings.HasPrefix(command, "sdm.") {
+		command = "sdm.devices.commands." + command
+	}
+
+	results, err := client.ExecuteCommand(deviceName, command, params)
+	if err != nil {
+		return fmt.Errorf("executing %s: %w", command, err)
+	}
+
+	if len(results) == 0 {
+		fmt.Println("{}")
+		return nil
+	}
+	var pretty interface{}
+	if err := json.Unmarshal(results, &pretty); err != nil {
+		fmt.Println(string(results))
+		return nil
+	}
+	data, _ := json.MarshalIndent(pretty, "", "  ")
+	fmt.Println(string(data))
+	return nil
+}
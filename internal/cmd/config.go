@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/auth"
+	"github.com/brice/gognestcli/internal/capture"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/crash"
+	"github.com/brice/gognestcli/internal/fanout"
+	"github.com/brice/gognestcli/internal/recorder"
+	"github.com/brice/gognestcli/internal/schedule"
+	"github.com/brice/gognestcli/internal/trigger"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+)
+
+type ConfigCmd struct {
+	Validate ConfigValidateCmd `cmd:"" help:"Check the config file for unknown keys, type errors and invalid values"`
+	Schema   ConfigSchemaCmd   `cmd:"" help:"Print the JSON Schema of the config file (for editor completion of config.yaml or config.json)"`
+	Encrypt  ConfigEncryptCmd  `cmd:"" help:"Encrypt client_secret, passwords and tokens in the config file with a key kept in the OS keyring"`
+	Decrypt  ConfigDecryptCmd  `cmd:"" help:"Store the config file's secrets in plain text again"`
+}
+
+type ConfigValidateCmd struct {
+	File string `arg:"" optional:"" type:"path" help:"Config file to check (default: the one in use)"`
+}
+
+func (c *ConfigValidateCmd) Run() error {
+	path := c.File
+	if path == "" {
+		var err error
+		if path, err = config.Path(); err != nil {
+			return err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	issues, err := config.Check(path, data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	// Values the schema can't express, once the file decodes.
+	if cfg, err := config.Parse(path, data); err == nil {
+		issues = append(issues, checkSettings(cfg)...)
+	}
+
+	if len(issues) == 0 {
+		fmt.Printf("%s is valid (schema version %d)\n", path, config.SchemaVersion)
+		return nil
+	}
+	for _, issue := range issues {
+		fmt.Printf("  %s\n", issue)
+	}
+	return fmt.Errorf("%d problem(s) in %s", len(issues), path)
+}
+
+// checkSettings reports config values that parse but would be rejected when
+// used: durations, cron expressions, templates and names of options.
+func checkSettings(cfg *config.Config) []config.Issue {
+	var issues []config.Issue
+	add := func(path string, err error) {
+		issues = append(issues, config.Issue{Path: path, Message: err.Error()})
+	}
+
+	if p := cfg.VideoProfile; p != "" && !slices.Contains([]string{"baseline", "main", "high", "any"}, p) {
+		add("video_profile", fmt.Errorf("unknown profile %q (baseline, main, high or any)", p))
+	}
+	if n := cfg.ICENetwork; n != "" && n != "ipv4" && n != "ipv6" {
+		add("ice_network", fmt.Errorf("unknown network %q (ipv4 or ipv6)", n))
+	}
+	for i, name := range cfg.ICEInterfaces {
+		if _, err := path.Match(name, ""); err != nil {
+			add(fmt.Sprintf("ice_interfaces[%d]", i), fmt.Errorf("bad pattern %q", name))
+		}
+	}
+	if p := cfg.ICEUDPPorts; p != "" {
+		if _, _, err := nestwebrtc.ParsePortRange(p); err != nil {
+			add("ice_udp_ports", err)
+		}
+	}
+	if _, err := recorder.PlayerHWAccelArgs(cfg.HWAccel); err != nil {
+		add("hwaccel", err)
+	}
+	if err := recorder.ValidateAudio(cfg.ClipAudio); err != nil {
+		add("clip_audio", err)
+	}
+	if f := cfg.ClipFormat; f != "" && f != "mp4" && f != "mkv" {
+		add("clip_format", fmt.Errorf("unknown clip format %q (mp4 or mkv)", f))
+	}
+	if _, err := capture.NewNamer(cfg.FilenameTemplate); err != nil {
+		add("filename_template", err)
+	}
+	if p := cfg.Presence; p != nil && p.CaptureWhen != "" && !strings.EqualFold(p.CaptureWhen, "home") && !strings.EqualFold(p.CaptureWhen, "away") {
+		add("presence.capture_when", fmt.Errorf("must be home or away, not %q", p.CaptureWhen))
+	}
+	for i, sc := range cfg.Schedules {
+		path := fmt.Sprintf("schedules[%d]", i)
+		// Missing keys are already reported by config.Check.
+		if _, err := schedule.Parse(sc.Cron); err != nil && sc.Cron != "" {
+			add(path+".cron", err)
+		}
+		if d, err := time.ParseDuration(sc.Duration); (err != nil || d <= 0) && sc.Duration != "" {
+			add(path+".duration", fmt.Errorf("invalid duration %q", sc.Duration))
+		}
+	}
+	for i, a := range cfg.Automations {
+		if a.Name == "" {
+			a.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if _, err := capture.NewAutomations([]config.Automation{a}); err != nil && a.Event != "" {
+			add(fmt.Sprintf("automations[%d]", i), err)
+		}
+	}
+	checkFilter := func(path string, f *config.EventFilter) {
+		if f == nil {
+			return
+		}
+		if _, err := capture.NewEventMatcher(f.Include); err != nil {
+			add(path+".include", err)
+		}
+		if _, err := capture.NewEventMatcher(f.Exclude); err != nil {
+			add(path+".exclude", err)
+		}
+	}
+	checkFilter("capture_events", cfg.CaptureEvents)
+	for _, key := range slices.Sorted(maps.Keys(cfg.Devices)) {
+		if _, err := cfg.Devices[key].RetentionPeriod(); err != nil {
+			add("devices."+key+".retention", err)
+		}
+		checkFilter("devices."+key+".capture_events", cfg.Devices[key].CaptureEvents)
+	}
+	if d, err := time.ParseDuration(cfg.DeviceCacheTTL); (err != nil || d < 0) && cfg.DeviceCacheTTL != "" {
+		add("device_cache_ttl", fmt.Errorf("invalid duration %q", cfg.DeviceCacheTTL))
+	}
+	if q := cfg.Quota; q != nil {
+		if _, err := q.WindowPeriod(); err != nil {
+			add("quota.window", err)
+		}
+		if q.PerDevice < -1 {
+			add("quota.per_device", fmt.Errorf("must be -1 (unlimited) or more, not %d", q.PerDevice))
+		}
+		if q.PerProject < -1 {
+			add("quota.per_project", fmt.Errorf("must be -1 (unlimited) or more, not %d", q.PerProject))
+		}
+	}
+	if t := cfg.PubSubForwardTopic; t != "" {
+		if parts := strings.Split(t, "/"); len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != "topics" || parts[3] == "" {
+			add("pubsub_forward_topic", fmt.Errorf("must be projects/<project>/topics/<topic>, not %q", t))
+		}
+	}
+	if c := cfg.PubSubCredentials; c != "" {
+		if _, _, err := auth.CredentialsTokenFn(c, auth.PubSubScope); err != nil {
+			add("pubsub_credentials", err)
+		}
+	}
+	labels := map[string]bool{config.DefaultSubscriptionLabel: true}
+	for i, s := range cfg.PubSubSubscriptions {
+		path := fmt.Sprintf("pubsub_subscriptions[%d]", i)
+		// Missing keys are already reported by config.Check.
+		if s.Label != "" && labels[s.Label] {
+			add(path+".label", fmt.Errorf("%q is already used (%q is pubsub_subscription's)", s.Label, config.DefaultSubscriptionLabel))
+		}
+		labels[s.Label] = true
+		if parts := strings.Split(s.Subscription, "/"); s.Subscription != "" && (len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != "subscriptions" || parts[3] == "") {
+			add(path+".subscription", fmt.Errorf("must be projects/<project>/subscriptions/<name>, not %q", s.Subscription))
+		}
+		if s.Credentials != "" {
+			if _, _, err := auth.CredentialsTokenFn(s.Credentials, auth.PubSubScope); err != nil {
+				add(path+".credentials", err)
+			}
+		}
+	}
+	if f := cfg.Fanout; f != nil {
+		if f.NATS != nil {
+			if _, err := fanout.NewNATS(*f.NATS); err != nil {
+				add("fanout.nats", err)
+			}
+		}
+		if f.Kafka != nil {
+			if _, err := fanout.NewKafka(*f.Kafka); err != nil {
+				add("fanout.kafka", err)
+			}
+		}
+		for i, h := range f.Handlers {
+			if _, err := fanout.NewHandler(h, ""); err != nil {
+				add(fmt.Sprintf("fanout.handlers[%d]", i), err)
+			}
+		}
+	}
+	if lc := cfg.EventLog; lc != nil {
+		if lc.Path == "" {
+			add("event_log.path", fmt.Errorf("is required"))
+		}
+		if lc.MaxSizeMB < 0 || lc.MaxBackups < 0 {
+			add("event_log", fmt.Errorf("max_size_mb and max_backups can't be negative"))
+		}
+		if _, _, err := lc.Periods(); err != nil {
+			add("event_log", err)
+		}
+	}
+	if c := cfg.CrashReport; c != nil {
+		if _, err := crash.NewReporter(c.DSN, c.URL, c.Token, version); err != nil {
+			add("crash_report", err)
+		}
+	}
+	if t := cfg.Triggers; t != nil {
+		if t.HTTPListen != "" {
+			if _, _, err := net.SplitHostPort(t.HTTPListen); err != nil {
+				add("triggers.http_listen", fmt.Errorf("must be host:port or :port, not %q", t.HTTPListen))
+			}
+		}
+		if t.MQTT != nil {
+			if _, err := trigger.NewMQTT(*t.MQTT); err != nil {
+				add("triggers.mqtt", err)
+			}
+		}
+	}
+	return issues
+}
+
+type ConfigEncryptCmd struct{}
+
+func (c *ConfigEncryptCmd) Run() error {
+	return setSecretEncryption(true)
+}
+
+type ConfigDecryptCmd struct{}
+
+func (c *ConfigDecryptCmd) Run() error {
+	return setSecretEncryption(false)
+}
+
+// setSecretEncryption turns encrypt_secrets on or off and rewrites the
+// config file accordingly.
+func setSecretEncryption(on bool) error {
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("no config file at %s (run: gognestcli init)", path))
+	}
+	warnCommentsDropped()
+	var set int
+	err = config.Update(func(cfg *config.Config) error {
+		cfg.EncryptSecrets = on
+		set, _ = cfg.Secrets()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	if on {
+		fmt.Printf("Encrypted %d secret value(s) in %s; the key is in the OS keyring.\n", set, path)
+		fmt.Println("Secrets added to the file later are encrypted the next time it's loaded (JSON) or by running this again (YAML).")
+	} else {
+		fmt.Printf("%d secret value(s) in %s are in plain text again.\n", set, path)
+	}
+	return nil
+}
+
+// warnCommentsDropped warns before a save that rewrites a commented YAML
+// config without its comments.
+func warnCommentsDropped() {
+	if path, drops := config.SaveDropsComments(); drops {
+		fmt.Printf("Warning: saving rewrites %s without its comments or key order; the current file is kept as %s.bak\n",
+			path, filepath.Base(path))
+	}
+}
+
+// configKey is config.KeyFunc: the config encryption key from the OS
+// keyring.
+func configKey(create bool) ([]byte, error) {
+	store, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ConfigKey(create)
+}
+
+type ConfigSchemaCmd struct{}
+
+func (c *ConfigSchemaCmd) Run() error {
+	data, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -1,67 +1,167 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/brice/gognestcli/internal/auth"
 	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/devcache"
+	"github.com/brice/gognestcli/internal/mock"
+	"github.com/brice/gognestcli/internal/proc"
 	"github.com/brice/gognestcli/internal/sdm"
-	"github.com/brice/gognestcli/internal/secrets"
 )
 
-type DevicesCmd struct{}
+type DevicesCmd struct {
+	Type     string        `help:"Only show devices of this type" enum:",camera,doorbell,thermostat,display" default:""`
+	Room     string        `help:"Only show devices in this room (case-insensitive)"`
+	Watch    bool          `help:"Keep refreshing the table with connectivity status" default:"false"`
+	Interval time.Duration `help:"Refresh interval for --watch" default:"30s"`
+	Refresh  bool          `help:"Fetch devices from the API instead of the device cache" default:"false"`
+}
 
 func (d *DevicesCmd) Run() error {
-	client, _, err := newSDMClient()
+	client, cfg, err := newSDMClient()
 	if err != nil {
 		return err
 	}
+	// --watch always fetches live status, refreshing the cache for info.
+	client = cachedClient(client, cfg, d.Refresh || d.Watch)
 
-	devices, err := client.ListDevices()
-	if err != nil {
-		return fmt.Errorf("listing devices: %w", err)
-	}
-
-	if len(devices) == 0 {
-		fmt.Println("No devices found.")
+	if !d.Watch {
+		devices, err := d.list(client)
+		if err != nil {
+			return err
+		}
+		if len(devices) == 0 {
+			fmt.Println("No devices found.")
+			return nil
+		}
+		for _, dev := range devices {
+			displayName := deviceDisplayName(dev)
+			deviceType := shortType(dev.Type)
+			fmt.Printf("%-40s  %-20s  %-16s  %s\n", displayName, deviceType, streamSummary(dev), dev.Name)
+		}
 		return nil
 	}
 
-	for _, dev := range devices {
-		displayName := deviceDisplayName(dev)
-		deviceType := shortType(dev.Type)
-		fmt.Printf("%-40s  %-20s  %s\n", displayName, deviceType, dev.Name)
+	ctx, cancel := context.WithCancel(commandCtx)
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	proc.NotifyInterrupt(sigCh)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		devices, err := d.list(client)
+
+		// Clear the screen and redraw from the top-left corner.
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("gognestcli devices — %s (every %s, Ctrl-C to quit)\n\n", time.Now().Format("15:04:05"), d.Interval)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+		} else {
+			fmt.Printf("%-32s  %-12s  %-8s  %s\n", "NAME", "TYPE", "STATUS", "STREAM")
+			for _, dev := range devices {
+				status := dev.Connectivity()
+				if status == "" {
+					status = "-"
+				}
+				fmt.Printf("%-32s  %-12s  %-8s  %s\n", deviceDisplayName(dev), shortType(dev.Type), status, streamSummary(dev))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
 	}
-	return nil
 }
 
-// newSDMClient creates an authenticated SDM client from stored config and secrets.
-func newSDMClient() (*sdm.Client, *config.Config, error) {
-	cfg, err := config.Load()
+// list fetches devices and applies the --type and --room filters.
+func (d *DevicesCmd) list(client sdm.DeviceAPI) ([]sdm.Device, error) {
+	devices, err := client.ListDevices()
 	if err != nil {
-		return nil, nil, fmt.Errorf("loading config: %w", err)
+		return nil, fmt.Errorf("listing devices: %w", err)
 	}
-	if err := cfg.Validate(); err != nil {
-		return nil, nil, err
+
+	var out []sdm.Device
+	for _, dev := range devices {
+		if d.Type != "" && !strings.EqualFold(shortType(dev.Type), d.Type) {
+			continue
+		}
+		if d.Room != "" && !strings.EqualFold(deviceDisplayName(dev), d.Room) {
+			continue
+		}
+		out = append(out, dev)
 	}
+	return out, nil
+}
 
-	store, err := secrets.NewStore()
+// newSDMClient creates an authenticated SDM client from stored config and secrets.
+func newSDMClient() (sdm.API, *config.Config, error) {
+	cfg, err := loadConfig()
 	if err != nil {
-		return nil, nil, fmt.Errorf("opening keyring: %w", err)
+		return nil, nil, fmt.Errorf("loading config: %w", err)
 	}
 
-	refreshToken, err := store.LoadRefreshToken()
+	tokenFn, err := newTokenFn(cfg)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	tm := auth.NewTokenManager(cfg.ClientID, cfg.ClientSecret)
-	tokenFn := func() (string, error) {
-		return tm.AccessToken(refreshToken)
-	}
+	return newClient(cfg, tokenFn), cfg, nil
+}
+
+// newTokenFn returns an access token source for SDM API calls, limited to
+// the SDM scope (see userTokenFn).
+func newTokenFn(cfg *config.Config) (func() (string, error), error) {
+	return userTokenFn(cfg, auth.SDMScope)
+}
+
+// userTokens is the token manager shared by userTokenFn's sources, and the
+// refresh token it uses, loaded on first use.
+var userTokens struct {
+	once         sync.Once
+	tm           *auth.TokenManager
+	refreshToken string
+	err          error
+}
 
-	return sdm.NewClient(cfg.ProjectID, tokenFn), cfg, nil
+// userTokenFn returns an access token source limited to scopes (all granted
+// scopes if none), backed by the refresh token in the OS keyring, or a fixed
+// token with --mock. Sources share one token manager, which keeps a token
+// per scope set.
+func userTokenFn(cfg *config.Config, scopes ...string) (func() (string, error), error) {
+	if mockServer != nil {
+		return func() (string, error) { return mock.AccessToken, nil }, nil
+	}
+	userTokens.once.Do(func() {
+		store, err := openStore()
+		if err != nil {
+			userTokens.err = fmt.Errorf("opening keyring: %w", err)
+			return
+		}
+		userTokens.refreshToken, userTokens.err = store.LoadRefreshToken(cfg.ProjectID)
+		userTokens.tm = auth.NewTokenManager(cfg.ClientID, cfg.ClientSecret)
+	})
+	if userTokens.err != nil {
+		return nil, userTokens.err
+	}
+	tm, refreshToken := userTokens.tm, userTokens.refreshToken
+	return func() (string, error) {
+		return tm.AccessTokenFor(refreshToken, scopes...)
+	}, nil
 }
 
 func deviceDisplayName(dev sdm.Device) string {
@@ -74,8 +174,99 @@ func deviceDisplayName(dev sdm.Device) string {
 	return parts[len(parts)-1]
 }
 
+// deviceLabel returns the room name for a device resource name, falling back
+// to its ID when the device can't be fetched.
+func deviceLabel(client sdm.DeviceAPI, deviceName string) string {
+	dev, err := client.GetDevice(deviceName)
+	if err != nil {
+		return deviceDisplayNameFromFull(deviceName)
+	}
+	return deviceDisplayName(*dev)
+}
+
+// deviceRoom returns the display name of the room the device is in, or "".
+func deviceRoom(dev sdm.Device) string {
+	for _, rel := range dev.ParentRelations {
+		if rel.DisplayName != "" {
+			return rel.DisplayName
+		}
+	}
+	return ""
+}
+
+// shortType returns the last part of a device or event type, e.g.
+// "sdm.devices.types.CAMERA" → "CAMERA" or
+// "sdm.devices.events.CameraPerson.Person" → "Person". Capture filenames
+// use it lowercased.
 func shortType(t string) string {
-	// e.g. "sdm.devices.types.CAMERA" → "CAMERA"
 	parts := strings.Split(t, ".")
 	return parts[len(parts)-1]
 }
+
+// streamSummary describes a device's streaming protocols, e.g. "WEB_RTC",
+// or "-" for devices without a camera.
+func streamSummary(dev sdm.Device) string {
+	ls := dev.LiveStream()
+	if ls == nil {
+		return "-"
+	}
+	if len(ls.SupportedProtocols) == 0 {
+		return "no stream"
+	}
+	return strings.Join(ls.SupportedProtocols, ",")
+}
+
+// ensureWebRTC fails early with a clear error when deviceName can't be
+// streamed over WebRTC (e.g. not a camera, or an RTSP-only legacy model).
+func ensureWebRTC(client sdm.DeviceAPI, deviceName string) error {
+	dev, err := client.GetDevice(deviceName)
+	if err != nil {
+		return fmt.Errorf("getting device: %w", err)
+	}
+	ls := dev.LiveStream()
+	if ls == nil {
+		return withExitCode(ExitUnsupported, fmt.Errorf("%s (%s) does not support live streaming", deviceDisplayName(*dev), shortType(dev.Type)))
+	}
+	if !ls.SupportsWebRTC() {
+		return withExitCode(ExitUnsupported, fmt.Errorf("%s only supports %s streaming; gognestcli requires WEB_RTC",
+			deviceDisplayName(*dev), strings.Join(ls.SupportedProtocols, ", ")))
+	}
+	return nil
+}
+
+// cachedClient returns client with device reads cached on disk for
+// device_cache_ttl (see devcache). With refresh, the cache is bypassed but
+// still updated; --mock keeps it in memory.
+func cachedClient(client sdm.API, cfg *config.Config, refresh bool) sdm.API {
+	ttl := devcache.DefaultTTL
+	if d, err := time.ParseDuration(cfg.DeviceCacheTTL); err == nil && d >= 0 {
+		ttl = d
+	}
+	path := ""
+	if mockServer == nil {
+		if p, err := config.StatePath("devices-cache.json"); err == nil {
+			path = p
+		}
+	}
+	return devcache.Wrap(client, path, cfg.ProjectID, ttl, refresh)
+}
+
+// fetchDevices gets each device in names with a few requests in flight at
+// once. Results and errors are in the order of names.
+func fetchDevices(client sdm.DeviceAPI, names []string) ([]*sdm.Device, []error) {
+	devices := make([]*sdm.Device, len(names))
+	errs := make([]error, len(names))
+	sem := make(chan struct{}, 4)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			devices[i], errs[i] = client.GetDevice(name)
+		}()
+	}
+	wg.Wait()
+	return devices, errs
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+// fakeAPI is an in-memory sdm.API. Devices not in devices aren't found;
+// while listErr is set, ListDevices fails with it.
+type fakeAPI struct {
+	mu      sync.Mutex
+	devices map[string]*sdm.Device
+	listErr error
+	images  int // event images generated
+}
+
+var _ sdm.API = (*fakeAPI)(nil)
+
+func newFakeAPI(devices ...sdm.Device) *fakeAPI {
+	f := &fakeAPI{devices: map[string]*sdm.Device{}}
+	for i := range devices {
+		f.devices[devices[i].Name] = &devices[i]
+	}
+	return f
+}
+
+func (f *fakeAPI) ListDevices() ([]sdm.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	var out []sdm.Device
+	for _, d := range f.devices {
+		out = append(out, *d)
+	}
+	return out, nil
+}
+
+func (f *fakeAPI) GetDevice(name string) (*sdm.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.devices[name]
+	if !ok {
+		return nil, fmt.Errorf("API returned 404: device %s not found", name)
+	}
+	return d, nil
+}
+
+func (f *fakeAPI) ExecuteCommand(deviceName, command string, params map[string]interface{}) (json.RawMessage, error) {
+	return json.RawMessage(`{}`), nil
+}
+
+func (f *fakeAPI) GenerateWebRTCStream(deviceName, offerSDP string) (*sdm.WebRTCStream, error) {
+	return nil, errors.New("fakeAPI doesn't stream")
+}
+
+func (f *fakeAPI) ExtendWebRTCStream(deviceName, mediaSessionID string) (*sdm.WebRTCStream, error) {
+	return nil, errors.New("fakeAPI doesn't stream")
+}
+
+func (f *fakeAPI) StopWebRTCStream(deviceName, mediaSessionID string) error { return nil }
+
+func (f *fakeAPI) GenerateEventImage(deviceName, eventID string) (*sdm.EventImage, error) {
+	if _, err := f.GetDevice(deviceName); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images++
+	return &sdm.EventImage{URL: "fake://" + eventID, Token: "t"}, nil
+}
+
+func (f *fakeAPI) DownloadEventImage(img *sdm.EventImage, outputPath string) error {
+	return os.WriteFile(outputPath, []byte("jpeg "+img.URL), 0o644)
+}
+
+// camera returns a device of type t streaming over protocols, with an
+// optional custom name and room.
+func camera(id, t, customName, room string, protocols ...string) sdm.Device {
+	traits := map[string]json.RawMessage{}
+	if customName != "" {
+		traits["sdm.devices.traits.Info"], _ = json.Marshal(map[string]any{"customName": customName})
+	}
+	if protocols != nil {
+		traits["sdm.devices.traits.CameraLiveStream"], _ = json.Marshal(map[string]any{"supportedProtocols": protocols})
+	}
+	d := sdm.Device{Name: "enterprises/p/devices/" + id, Type: "sdm.devices.types." + t, Traits: traits}
+	if room != "" {
+		d.ParentRelations = []sdm.ParentRelation{{DisplayName: room}}
+	}
+	return d
+}
+
+func TestEnsureWebRTC(t *testing.T) {
+	client := newFakeAPI(
+		camera("doorbell", "DOORBELL", "", "Porch", "WEB_RTC"),
+		camera("legacy", "CAMERA", "", "Garage", "RTSP"),
+		camera("thermo", "THERMOSTAT", "", "Hall"),
+	)
+	tests := []struct {
+		device   string
+		wantErr  string
+		wantCode int
+	}{
+		{"doorbell", "", 0},
+		{"legacy", "Garage only supports RTSP streaming", ExitUnsupported},
+		{"thermo", "Hall (THERMOSTAT) does not support live streaming", ExitUnsupported},
+		{"gone", "getting device", ExitNotFound},
+	}
+	for _, tt := range tests {
+		err := ensureWebRTC(client, "enterprises/p/devices/"+tt.device)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.device, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tt.device, err, tt.wantErr)
+		}
+		if code := exitCode(err); code != tt.wantCode {
+			t.Errorf("%s: exit code %d, want %d", tt.device, code, tt.wantCode)
+		}
+	}
+}
+
+func TestFetchDevices(t *testing.T) {
+	client := newFakeAPI(camera("a", "CAMERA", "", ""), camera("c", "CAMERA", "", ""))
+	names := []string{"enterprises/p/devices/a", "enterprises/p/devices/b", "enterprises/p/devices/c"}
+	devices, errs := fetchDevices(client, names)
+	for i, name := range names {
+		if i == 1 {
+			if errs[i] == nil || devices[i] != nil {
+				t.Errorf("%s: got %v, %v, want an error", name, devices[i], errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil || devices[i] == nil || devices[i].Name != name {
+			t.Errorf("%s: got %v, %v", name, devices[i], errs[i])
+		}
+	}
+}
+
+func TestDeviceNames(t *testing.T) {
+	client := newFakeAPI(
+		camera("front", "DOORBELL", "Front door", "Porch"),
+		camera("back", "CAMERA", "", "Garden"),
+		camera("side", "CAMERA", "", ""),
+	)
+	n := newDeviceNames(client)
+	for id, want := range map[string]string{"front": "Front door", "back": "Garden", "side": "side"} {
+		if got := friendlyName(*client.devices["enterprises/p/devices/"+id]); got != want {
+			t.Errorf("friendlyName(%s) = %q, want %q", id, got, want)
+		}
+		if got := n.names["enterprises/p/devices/"+id]; got != want {
+			t.Errorf("names[%s] = %q, want %q", id, got, want)
+		}
+	}
+
+	// A failed refresh keeps the names already known.
+	client.listErr = errors.New("API returned 503")
+	n.refresh()
+	if got := n.names["enterprises/p/devices/front"]; got != "Front door" {
+		t.Errorf("after a failed refresh names[front] = %q", got)
+	}
+
+	if got := deviceLabel(client, "enterprises/p/devices/back"); got != "Garden" {
+		t.Errorf("deviceLabel = %q", got)
+	}
+	if got := deviceLabel(client, "enterprises/p/devices/gone"); got != "gone" {
+		t.Errorf("deviceLabel of a missing device = %q", got)
+	}
+}
+
+func TestShortType(t *testing.T) {
+	for in, want := range map[string]string{
+		"sdm.devices.types.CAMERA":               "CAMERA",
+		"sdm.devices.events.CameraPerson.Person": "Person",
+		"Person":                                 "Person",
+		"":                                       "",
+	} {
+		if got := shortType(in); got != want {
+			t.Errorf("shortType(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
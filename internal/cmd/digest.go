@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/digest"
+	"github.com/brice/gognestcli/internal/history"
+)
+
+type DigestCmd struct {
+	Dir    string `help:"Directory containing event captures and history" default:"events"`
+	Period string `help:"Digest period" enum:"daily,weekly" default:"daily"`
+	Out    string `short:"o" help:"Write the digest to a file (.html for a contact sheet, otherwise text); prints to stdout if omitted"`
+}
+
+func (d *DigestCmd) Run() error {
+	from, to, err := digest.Period(d.Period, time.Now())
+	if err != nil {
+		return err
+	}
+	return writeDigest(d.Dir, d.Out, from, to)
+}
+
+// writeDigest summarizes the history in dir over [from, to) and writes it to
+// out, or to stdout as text when out is empty.
+func writeDigest(dir, out string, from, to time.Time) error {
+	records, err := history.Read(dir)
+	if err != nil {
+		return fmt.Errorf("reading history: %w", err)
+	}
+	summary := digest.Build(records, from, to)
+
+	if out == "" {
+		return summary.WriteText(os.Stdout)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(out), ".html") {
+		prefix, err := filepath.Rel(filepath.Dir(out), dir)
+		if err != nil {
+			prefix = dir
+		}
+		return summary.WriteHTML(f, filepath.ToSlash(prefix))
+	}
+	return summary.WriteText(f)
+}
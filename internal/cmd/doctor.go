@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/auth"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/proc"
+	"github.com/brice/gognestcli/internal/recorder"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+)
+
+type DoctorCmd struct {
+	CheckTimeout time.Duration `help:"Timeout for each network check (--timeout bounds the whole run)" default:"10s"`
+}
+
+// doctor collects check results and prints them as they complete.
+type doctor struct {
+	failed int
+}
+
+func (d *doctor) ok(name, detail string) {
+	fmt.Printf("  ok    %-14s %s\n", name, detail)
+}
+
+func (d *doctor) warn(name, detail, fix string) {
+	fmt.Printf("  warn  %-14s %s\n", name, detail)
+	if fix != "" {
+		fmt.Printf("        %-14s → %s\n", "", fix)
+	}
+}
+
+func (d *doctor) fail(name string, err error, fix string) {
+	d.failed++
+	fmt.Printf("  FAIL  %-14s %v\n", name, err)
+	if fix != "" {
+		fmt.Printf("        %-14s → %s\n", "", fix)
+	}
+}
+
+func (d *doctor) skip(name, why string) {
+	fmt.Printf("  skip  %-14s %s\n", name, why)
+}
+
+func (c *DoctorCmd) Run(g *Globals) error {
+	d := &doctor{}
+	fmt.Println("Checking gognestcli setup...")
+
+	cfg := c.checkConfig(d)
+	tokenFn := c.checkAuth(d, cfg)
+	c.checkSDM(d, cfg, tokenFn)
+	c.checkPubSub(d, cfg, tokenFn)
+	c.checkTools(d, cfg)
+	c.checkUDP(d, cfg)
+
+	if d.failed > 0 {
+		return fmt.Errorf("%d check(s) failed", d.failed)
+	}
+	fmt.Println("All checks passed.")
+	return nil
+}
+
+func (c *DoctorCmd) checkConfig(d *doctor) *config.Config {
+	path, err := config.Path()
+	if err != nil {
+		d.fail("config", err, "")
+		return nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		fix := ""
+		if !strings.Contains(err.Error(), "gognestcli auth") {
+			fix = "run: gognestcli config validate " + path
+		}
+		d.fail("config", err, fix)
+		return nil
+	}
+	d.ok("config", path)
+	if state, err := config.StatePath(""); err != nil {
+		d.fail("state dir", err, "pass a writable directory with --state-dir")
+	} else {
+		d.ok("state dir", state)
+	}
+	return cfg
+}
+
+func (c *DoctorCmd) checkAuth(d *doctor, cfg *config.Config) func() (string, error) {
+	if cfg == nil {
+		d.skip("keyring", "needs a valid config")
+		d.skip("token refresh", "needs a valid config")
+		return nil
+	}
+	if mockServer != nil {
+		d.skip("keyring", "--mock")
+		d.skip("token refresh", "--mock")
+		tokenFn, _ := newTokenFn(cfg)
+		return tokenFn
+	}
+
+	store, err := openStore()
+	if err != nil {
+		d.fail("keyring", err, "make sure the OS keyring is available (Keychain on macOS, GNOME Keyring or KWallet on Linux)")
+		d.skip("token refresh", "needs the keyring")
+		return nil
+	}
+	refreshToken, err := store.LoadRefreshToken(cfg.ProjectID)
+	if err != nil {
+		d.fail("keyring", err, "run: gognestcli auth")
+		d.skip("token refresh", "needs a refresh token")
+		return nil
+	}
+	d.ok("keyring", "refresh token found")
+
+	tm := auth.NewTokenManager(cfg.ClientID, cfg.ClientSecret)
+	if _, err := tm.AccessTokenFor(refreshToken, auth.SDMScope); err != nil {
+		d.fail("token refresh", err, "the refresh token may be revoked or expired (apps in Testing mode expire them after 7 days); run: gognestcli auth")
+		return nil
+	}
+	d.ok("token refresh", "access token issued")
+	return func() (string, error) { return tm.AccessTokenFor(refreshToken, auth.SDMScope) }
+}
+
+func (c *DoctorCmd) checkSDM(d *doctor, cfg *config.Config, tokenFn func() (string, error)) {
+	if tokenFn == nil {
+		d.skip("SDM API", "needs an access token")
+		return
+	}
+	devices, err := newClient(cfg, tokenFn).ListDevices()
+	switch {
+	case err != nil && strings.Contains(err.Error(), "returned 403"):
+		d.fail("SDM API", err, "authorize your Nest account for this project in the Partner Connections Manager, then run: gognestcli auth")
+	case err != nil && strings.Contains(err.Error(), "returned 404"):
+		d.fail("SDM API", err, "project_id must be the Device Access project ID, not the Google Cloud project ID")
+	case err != nil:
+		d.fail("SDM API", err, "check network access to smartdevicemanagement.googleapis.com")
+	case len(devices) == 0:
+		d.warn("SDM API", "reachable, but no devices are shared with this project",
+			"share devices when authorizing, or re-run: gognestcli auth")
+	default:
+		d.ok("SDM API", fmt.Sprintf("%d device(s)", len(devices)))
+	}
+}
+
+func (c *DoctorCmd) checkPubSub(d *doctor, cfg *config.Config, tokenFn func() (string, error)) {
+	switch {
+	case cfg == nil || (tokenFn == nil && cfg.PubSubCredentials == ""):
+		d.skip("Pub/Sub", "needs an access token")
+		return
+	case cfg.PubSubSub == "":
+		d.warn("Pub/Sub", "pubsub_subscription not configured",
+			"needed for events and watch --pubsub; create a pull subscription on the topic from the Device Access Console")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(commandCtx, c.CheckTimeout)
+	defer cancel()
+	_, identity := pubsubTokenFn(cfg)
+	err := newListener(cfg).CheckSubscription(ctx)
+	switch {
+	case err != nil && strings.Contains(err.Error(), "returned 404"):
+		d.fail("Pub/Sub", err, "pubsub_subscription must be projects/<gcp-project>/subscriptions/<name> for a pull subscription on the Device Access topic")
+	case err != nil && (strings.Contains(err.Error(), "returned 403") || strings.Contains(err.Error(), "missing")):
+		d.fail("Pub/Sub", err, "grant "+identity+" the Pub/Sub Subscriber role on the subscription")
+	case err != nil && strings.Contains(err.Error(), "pubsub_credentials"):
+		d.fail("Pub/Sub", err, "set pubsub_credentials to \"adc\" or the path of a service-account key file")
+	case err != nil:
+		d.fail("Pub/Sub", err, "check network access to pubsub.googleapis.com")
+	case cfg.PubSubCredentials != "":
+		d.ok("Pub/Sub", cfg.PubSubSub+" as "+identity)
+	default:
+		d.ok("Pub/Sub", cfg.PubSubSub)
+	}
+
+	listeners, err := newListeners(cfg, nil, false)
+	if err != nil {
+		return
+	}
+	for _, l := range listeners[1:] {
+		name := "Pub/Sub (" + l.Label() + ")"
+		if err := l.CheckSubscription(ctx); err != nil {
+			d.fail(name, err, "check the pubsub_subscriptions entry, and that its identity has the Pub/Sub Subscriber role on it")
+			continue
+		}
+		d.ok(name, l.Subscription())
+	}
+}
+
+func (c *DoctorCmd) checkTools(d *doctor, cfg *config.Config) {
+	var ffmpegPath, ffplayPath string
+	if cfg != nil {
+		ffmpegPath, ffplayPath = cfg.FFmpegPath, cfg.FFplayPath
+	}
+	for _, tool := range []struct{ name, configured, needed string }{
+		{"ffmpeg", ffmpegPath, "snapshots, recording and talk"},
+		{"ffplay", ffplayPath, "live view"},
+	} {
+		path, err := recorder.FindTool(tool.name, tool.configured)
+		if err != nil {
+			if tool.name == "ffmpeg" {
+				d.fail(tool.name, err, "needed for "+tool.needed)
+			} else {
+				d.warn(tool.name, err.Error(), "needed for "+tool.needed)
+			}
+			continue
+		}
+		d.ok(tool.name, toolVersion(path)+" ("+path+")")
+	}
+}
+
+// toolVersion returns the version from the first line of `<tool> -version`.
+func toolVersion(path string) string {
+	ctx, cancel := context.WithTimeout(commandCtx, 10*time.Second)
+	defer cancel()
+	out, err := proc.Command(ctx, path, "-version").Output()
+	if err != nil {
+		return "unknown version"
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	// "ffmpeg version 7.1 Copyright (c) ..."
+	if fields := strings.Fields(line); len(fields) >= 3 && fields[1] == "version" {
+		return fields[2]
+	}
+	return strings.TrimSpace(line)
+}
+
+func (c *DoctorCmd) checkUDP(d *doctor, cfg *config.Config) {
+	addr, err := nestwebrtc.ProbeUDP(c.CheckTimeout)
+	if err != nil && cfg != nil && len(cfg.TURN) > 0 {
+		d.warn("UDP (WebRTC)", err.Error(), "streams will need the configured TURN relay")
+		return
+	}
+	if err != nil {
+		d.fail("UDP (WebRTC)", err, "allow DNS and outbound UDP, or configure a TURN relay over TCP/TLS; without either, live view, recording and clips can't receive WebRTC media")
+		return
+	}
+	d.ok("UDP (WebRTC)", "STUN reachable, public address "+addr)
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// Environment variables that override the API roots. PUBSUB_EMULATOR_HOST
+// follows the Google client libraries: a bare host:port served over plain
+// HTTP.
+const (
+	sdmEndpointEnv    = "GOGNESTCLI_SDM_ENDPOINT"
+	pubsubEndpointEnv = "GOGNESTCLI_PUBSUB_ENDPOINT"
+	pubsubEmulatorEnv = "PUBSUB_EMULATOR_HOST"
+)
+
+// sdmEndpoint returns the SDM API root to use instead of Google's, or "" for
+// the default. --mock wins, then the environment, then config.
+func sdmEndpoint(cfg *config.Config) string {
+	if mockServer != nil {
+		return mockServer.URL
+	}
+	if url := os.Getenv(sdmEndpointEnv); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return strings.TrimSuffix(cfg.SDMEndpoint, "/")
+}
+
+// pubsubEndpoint returns the Pub/Sub API root to use instead of Google's, or
+// "" for the default. --mock wins, then the environment, then config.
+func pubsubEndpoint(cfg *config.Config) string {
+	if mockServer != nil {
+		return mockServer.URL
+	}
+	if url := os.Getenv(pubsubEndpointEnv); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	if host := os.Getenv(pubsubEmulatorEnv); host != "" {
+		return "http://" + host + "/v1"
+	}
+	return strings.TrimSuffix(cfg.PubSubEndpoint, "/")
+}
@@ -1,94 +1,282 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/brice/gognestcli/internal/auth"
+	"github.com/brice/gognestcli/internal/capture"
 	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/crash"
+	"github.com/brice/gognestcli/internal/digest"
+	"github.com/brice/gognestcli/internal/eventlog"
+	"github.com/brice/gognestcli/internal/fanout"
+	"github.com/brice/gognestcli/internal/gallery"
+	"github.com/brice/gognestcli/internal/history"
+	"github.com/brice/gognestcli/internal/pool"
+	"github.com/brice/gognestcli/internal/presence"
+	"github.com/brice/gognestcli/internal/proc"
 	"github.com/brice/gognestcli/internal/pubsub"
 	"github.com/brice/gognestcli/internal/recorder"
+	"github.com/brice/gognestcli/internal/retry"
+	"github.com/brice/gognestcli/internal/schedule"
 	"github.com/brice/gognestcli/internal/sdm"
-	"github.com/brice/gognestcli/internal/secrets"
+	"github.com/brice/gognestcli/internal/seen"
+	"github.com/brice/gognestcli/internal/upload"
 	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
-	"github.com/pion/webrtc/v4"
 )
 
 type EventsCmd struct {
-	OutputDir string `short:"o" help:"Directory to save event captures" default:"events"`
-	Capture   bool   `help:"Auto-capture snapshot on events" default:"true"`
-	Clip      bool   `help:"Also record a short video clip on events" default:"false"`
-	ClipSecs  int    `help:"Clip duration in seconds" default:"10"`
+	OutputDir  string `short:"o" help:"Directory to save event captures" default:"events"`
+	Capture    bool   `help:"Auto-capture snapshot on events" default:"true"`
+	Clip       bool   `help:"Also record a short video clip on events" default:"false"`
+	ClipSecs   int    `help:"Clip duration in seconds" default:"10"`
+	ClipFormat string `help:"Container for clips: mp4, or mkv to keep Opus audio and capture timestamps as is, muxed without ffmpeg (overrides clip_format in config)" enum:",mp4,mkv" default:""`
+	Sound      string `help:"What to capture on Sound events when no policies are configured: none, snapshot, clip or both" enum:"none,snapshot,clip,both" default:"snapshot"`
+
+	Trigger []string `help:"Event types that get the --capture/--clip defaults, e.g. Person, DoorbellChime.Chime or /regex/ (repeatable; replaces capture_events.include, default Motion and Person)"`
+	Ignore  []string `help:"Event types never captured by the --capture/--clip defaults (repeatable; replaces capture_events.exclude)"`
+
+	OnlyUnfamiliar bool     `help:"Skip captures for Person events where only familiar faces were recognized" default:"false"`
+	Zone           []string `help:"Only capture events detected in these activity zones (repeatable; events without zone information are skipped)"`
+
+	ClipUntilQuiet bool `help:"Keep recording clips while Motion/Person events for the same device keep arriving" default:"false"`
+	ClipQuiet      int  `help:"With --clip-until-quiet, stop after this many seconds without events" default:"5"`
+	ClipMax        int  `help:"With --clip-until-quiet, maximum clip duration in seconds" default:"60"`
+
+	PreRoll       time.Duration `help:"Keep the last DURATION of video in memory from an always-open stream per camera, and start clips that long before the event (0 disables; costs a stream per camera around the clock)" default:"0"`
+	PreRollDevice []string      `help:"Cameras to keep a pre-roll buffer for (repeatable; default all WebRTC cameras)"`
+
+	NoUpload bool   `help:"Keep captures local even if upload targets are configured" default:"false"`
+	NoFanout bool   `help:"Don't forward events to the NATS/Kafka targets in config" default:"false"`
+	EventLog string `help:"Append events and capture results as JSON lines to FILE, rotated as set under event_log (overrides event_log.path)" type:"path" placeholder:"FILE"`
+
+	TriggerListen string `help:"Serve POST /capture on ADDR for manual captures, e.g. :8787 (overrides triggers.http_listen)"`
+
+	RecreateSubscription bool     `help:"Recreate the Pub/Sub subscription on the Device Access topic (or pubsub_topic) if it was deleted or detached, instead of exiting" default:"false"`
+	Subscription         []string `help:"Only listen to the subscriptions with these labels (default for pubsub_subscription, or a label from pubsub_subscriptions)" placeholder:"LABEL"`
+
+	FilenameTemplate string `help:"Capture path template relative to the output dir, e.g. '{{.Device}}/{{.Date}}/{{.Time}}_{{.Type}}.{{.Ext}}' (overrides filename_template in config)"`
+	Gallery          bool   `help:"Regenerate index.html in the output dir after each capture" default:"false"`
+	Digest           string `help:"Write a digest-<date>.html report into the output dir every day or week" enum:",daily,weekly" default:""`
+	Overlay          bool   `help:"Burn the wall-clock time and camera name into clips (re-encodes)" default:"false"`
+	Replay           string `help:"Feed raw Pub/Sub messages from an NDJSON file through the event pipeline instead of listening, for testing policies and captures offline"`
+
+	IndexInterval time.Duration `help:"Index captures added to the output dir by other tools into the history this often (0 disables)" default:"5m"`
+	RetryMaxAge   time.Duration `help:"Retry snapshots and clips that failed on network, server or quota errors, with backoff, for up to this long (0 disables)" default:"5m"`
+	DedupWindow   time.Duration `help:"Remember handled events this long, across restarts, so messages Pub/Sub redelivers aren't captured twice (0 disables)" default:"24h"`
+	DrainTimeout  time.Duration `help:"On Ctrl-C, wait this long for running captures and uploads to finish before exiting (0 exits at once)" default:"60s"`
+	QueueDepth    int           `help:"Captures of each kind that can wait per camera behind the one running; events beyond that are dropped and counted (0 drops them while one runs)" default:"4"`
+
+	uploader  *upload.Manager
+	retries   *retry.Queue
+	seen      *seen.Set
+	names     *deviceNames
+	fanout    *fanout.Manager
+	eventLog  *eventlog.Log
+	pool      *pool.Pool
+	namer     *capture.Namer
+	history   *history.Log
+	opts      []nestwebrtc.Option
+	recOpts   []recorder.Option
+	marker    bool
+	sidecar   bool
+	cfg       *config.Config
+	policies  *capture.Policies
+	triggers  *capture.Triggers
+	automate  *capture.Automations
+	galleryMu sync.Mutex
+
+	// inflight tracks running captures and their uploads; once draining is
+	// set no new ones start.
+	inflight   sync.WaitGroup
+	inflightMu sync.Mutex
+	running    int
+	draining   bool
+
+	// activeClips maps device name → activity channel of the clip currently
+	// recording for it (--clip-until-quiet only).
+	activeClips sync.Map
+
+	// buffers holds the --pre-roll buffer of each camera by device name.
+	buffers map[string]*recorder.Buffer
+	// presignaled holds the WebRTC cameras without a buffer, whose stream
+	// is opened as soon as one of their events arrives; see presignal.
+	presignaled map[string]bool
+	warming     sync.Map // device name → true while presignal holds its stream
+
+	// claimed holds the output paths of captures in progress; see capturePath.
+	claimed sync.Map
+
+	// hubs holds each camera's shared stream by device name; see stream.
+	hubsMu sync.Mutex
+	hubs   map[string]*nestwebrtc.StreamHub
 }
 
-func (e *EventsCmd) Run() error {
-	cfg, err := config.Load()
+func (e *EventsCmd) Run(g *Globals) error {
+	// Long-running: never write the config, so edits made while events runs
+	// aren't overwritten.
+	config.SetReadOnly(true)
+	cfg, err := loadConfig()
 	if err != nil {
 		return fmt.Errorf("loading config: %w", err)
 	}
-	if err := cfg.Validate(); err != nil {
-		return err
-	}
 
-	if cfg.PubSubSub == "" {
+	if cfg.PubSubSub == "" && e.Replay == "" {
 		return fmt.Errorf("pubsub_subscription not configured in config.json")
 	}
 
-	store, err := secrets.NewStore()
+	tokenFn, err := newTokenFn(cfg)
 	if err != nil {
-		return fmt.Errorf("opening keyring: %w", err)
+		return err
 	}
 
-	refreshToken, err := store.LoadRefreshToken()
+	sdmClient := newClient(cfg, tokenFn)
+
+	e.policies = capture.NewPolicies(policyRules(cfg))
+	e.triggers, err = eventTriggers(cfg, e.Trigger, e.Ignore)
+	if err != nil {
+		return err
+	}
+	e.automate, err = capture.NewAutomations(automationRules(cfg))
 	if err != nil {
 		return err
 	}
+	manual := e.TriggerListen != "" || cfg.Triggers != nil
+	if c := cfg.CrashReport; c != nil {
+		report, err := crash.NewReporter(c.DSN, c.URL, c.Token, version)
+		if err != nil {
+			return withExitCode(ExitConfig, fmt.Errorf("crash_report: %w", err))
+		}
+		crash.SetReporter(report)
+	}
 
-	tm := auth.NewTokenManager(cfg.ClientID, cfg.ClientSecret)
-	tokenFn := func() (string, error) {
-		return tm.AccessToken(refreshToken)
+	cleanup, err := e.setup(g, cfg, e.Capture || e.Clip || e.Digest != "" || !e.policies.Empty() || len(cfg.Schedules) > 0 || len(cfg.Automations) > 0 || manual)
+	if err != nil {
+		return err
 	}
+	e.pool = pool.New(e.QueueDepth)
 
-	sdmClient := sdm.NewClient(cfg.ProjectID, tokenFn)
+	// The event log and fan-out queue are flushed even if a panic ends the
+	// process.
+	cleanup = sync.OnceFunc(cleanup)
+	crash.OnFatal(cleanup)
+	defer cleanup()
 
-	if e.Capture || e.Clip {
-		if err := os.MkdirAll(e.OutputDir, 0755); err != nil {
-			return fmt.Errorf("creating output dir: %w", err)
+	// Replays run once and exit, so they don't queue retries.
+	if e.RetryMaxAge > 0 && e.Replay == "" {
+		path := ""
+		if mockServer == nil {
+			if p, err := config.StatePath("retry-queue.json"); err == nil {
+				path = p
+			}
+		}
+		e.retries = retry.Open(path, e.OutputDir, e.RetryMaxAge)
+		if n := e.retries.Len(); n > 0 {
+			fmt.Printf("Resuming %d queued capture retries\n", n)
 		}
 	}
 
-	listener := pubsub.NewListener(cfg.PubSubSub, tokenFn)
+	// Replays are meant to be run again, so they aren't deduplicated.
+	if e.DedupWindow > 0 && e.Replay == "" {
+		path := ""
+		if mockServer == nil {
+			if p, err := config.StatePath("handled-events.json"); err == nil {
+				path = p
+			}
+		}
+		e.seen = seen.Open(path, e.DedupWindow)
+	}
+
+	var source pubsub.EventSource
+	var health func() (bool, any)
+	if e.Replay == "" {
+		listeners, err := newListeners(cfg, e.Subscription, e.RecreateSubscription)
+		if err != nil {
+			return err
+		}
+		source, health = eventSource(listeners)
+		health = e.withCaptureStats(health)
+	} else {
+		f, err := os.Open(e.Replay)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		source = pubsub.NewReplayer(e.Replay, f)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(commandCtx)
 	defer cancel()
 
+	// Captures and uploads get their own context so Ctrl-C stops listening
+	// without cutting them short; it's cancelled if the drain times out.
+	work, cancelWork := context.WithCancel(commandCtx)
+	defer cancelWork()
+
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
+	proc.NotifyInterrupt(sigCh)
 	go func() {
 		<-sigCh
 		fmt.Println("\nShutting down...")
 		cancel()
 	}()
 
+	e.names = newDeviceNames(sdmClient)
+	crash.Go("device names", func() { e.names.loop(ctx) })
+
+	if e.Digest != "" {
+		crash.Go("digest", func() { e.digestLoop(ctx) })
+	}
+	if e.history != nil && e.IndexInterval > 0 && e.Replay == "" {
+		crash.Go("index", func() { e.indexLoop(ctx) })
+	}
+	if e.history != nil && e.Replay == "" {
+		retention, err := retentionPeriods(cfg)
+		if err != nil {
+			return err
+		}
+		if len(retention) > 0 {
+			crash.Go("retention", func() { e.retentionLoop(ctx, retention) })
+		}
+	}
+
 	var dedup sync.Map
 	var captureSeq atomic.Int64
 
-	// Semaphore: one snapshot + one clip can run concurrently
-	snapSem := make(chan struct{}, 1)
-	clipSem := make(chan struct{}, 1)
-
-	return listener.Listen(ctx, func(event pubsub.Event) {
-		shortType := event.EventType
-		if parts := strings.Split(event.EventType, "."); len(parts) > 0 {
-			shortType = parts[len(parts)-1]
+	if e.Replay == "" {
+		if err := e.startSchedules(ctx, work, sdmClient, cfg, &captureSeq); err != nil {
+			return err
 		}
+		if e.PreRoll > 0 {
+			if err := e.startBuffers(work, sdmClient, cfg); err != nil {
+				return err
+			}
+			defer e.closeBuffers()
+		}
+		if manual {
+			if err := e.startTriggers(ctx, work, sdmClient, cfg, &captureSeq, health); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.retries != nil {
+		crash.Go("retries", func() { e.retryLoop(ctx, work, sdmClient) })
+	}
+
+	handler := func(event pubsub.Event) {
+		defer crash.Recover("event handler")
+		e.presignal(work, sdmClient, event.DeviceName)
+		eventType := shortType(event.EventType)
 
 		// Dedup by event timestamp + type
 		dedupKey := event.Timestamp.String() + event.EventType
@@ -102,123 +290,1153 @@ func (e *EventsCmd) Run() error {
 
 		ts := event.Timestamp.Format("15:04:05")
 		deviceShort := deviceDisplayNameFromFull(event.DeviceName)
-		fmt.Printf("[%s] %s: %s\n", ts, deviceShort, shortType)
+		label := e.friendly(event.DeviceName)
+		if event.Subscription != "" {
+			label = event.Subscription + "/" + label
+		}
+		key := seen.Key(event)
+		if e.seen != nil && !e.seen.Begin(key) {
+			fmt.Printf("[%s] %s: %s (redelivered, already handled)\n", ts, label, eventType)
+			return
+		}
+		// The event counts as handled once its captures are saved and
+		// uploaded, or queued for retry; until then a redelivery is
+		// captured again. Its message is only acknowledged after that, so
+		// a crash mid-capture leaves it to be redelivered.
+		var captures sync.WaitGroup
+		var failed atomic.Bool
+		release := event.Hold()
+		defer func() {
+			go func() {
+				captures.Wait()
+				if e.seen != nil {
+					if failed.Load() {
+						e.seen.Release(key)
+					} else if err := e.seen.Done(key); err != nil {
+						fmt.Printf("  Warning: %v\n", err)
+					}
+				}
+				release()
+			}()
+		}()
+		// enqueue queues a capture and counts it toward the event.
+		enqueue := func(item retry.Item, capture func() bool) {
+			captures.Add(1)
+			ok := e.enqueue(item, func() {
+				defer captures.Done()
+				if !capture() {
+					failed.Store(true)
+				}
+			})
+			if !ok {
+				failed.Store(true)
+				captures.Done()
+			}
+		}
+		fmt.Printf("[%s] %s: %s%s\n", ts, label, eventType, eventDetails(event))
+		e.record(history.Record{
+			Kind:         history.KindEvent,
+			Time:         event.Timestamp,
+			Device:       deviceShort,
+			Type:         eventType,
+			EventID:      event.EventID,
+			Subscription: event.Subscription,
+		})
+		e.publish(fanout.KindEvent, event, "")
+		e.runAutomations(work, sdmClient, event, &captureSeq)
 
-		if !isActionableEvent(event.EventType) {
+		action, ok := e.actionFor(event)
+		if !ok {
 			return
 		}
+		if e.OnlyUnfamiliar && len(event.FamiliarFaces) > 0 {
+			fmt.Println("  Skipping capture (familiar face)")
+			return
+		}
+		if len(e.Zone) > 0 && !slices.ContainsFunc(e.Zone, func(z string) bool { return capture.MatchZone(z, event.Zones) }) {
+			fmt.Println("  Skipping capture (outside --zone)")
+			return
+		}
+
+		if cfg.Presence != nil {
+			st, err := presence.Get()
+			if err != nil {
+				fmt.Printf("  Warning: reading presence: %v\n", err)
+			}
+			if !presence.Allows(cfg.Presence, st, event.DeviceName) {
+				fmt.Printf("  Skipping capture (%s)\n", st)
+				return
+			}
+		}
 
 		seq := captureSeq.Add(1)
 
 		// Snapshot via event image API (fast, no WebRTC needed)
-		if e.Capture && event.EventID != "" {
-			select {
-			case snapSem <- struct{}{}:
-				go func() {
-					defer func() { <-snapSem }()
-					e.captureEventImage(sdmClient, event, seq)
-				}()
-			default:
-				fmt.Println("  Skipping snapshot (previous still in progress)")
-			}
+		if action.Snapshot && event.EventID != "" {
+			item := retry.Item{Kind: retry.KindSnapshot, Event: event, Seq: seq}
+			enqueue(item, func() bool {
+				path, err := e.captureEventImage(sdmClient, event, seq)
+				if err != nil {
+					return e.retryLater(item, err)
+				}
+				e.recordCapture(event, path)
+				e.refreshGallery()
+				return e.upload(work, event, path) == nil
+			})
 		}
 
 		// Clip via WebRTC
-		if e.Clip {
-			select {
-			case clipSem <- struct{}{}:
-				go func() {
-					defer func() { <-clipSem }()
-					e.captureClip(sdmClient, cfg, event, seq)
-				}()
-			default:
-				fmt.Println("  Skipping clip (previous still recording)")
+		if action.Clip {
+			if e.ClipUntilQuiet {
+				if ch, ok := e.activeClips.Load(event.DeviceName); ok {
+					select {
+					case ch.(chan struct{}) <- struct{}{}:
+					default:
+					}
+					fmt.Println("  Activity continues, extending clip")
+					return
+				}
 			}
+			duration := time.Duration(action.ClipSecs) * time.Second
+			item := retry.Item{Kind: retry.KindClip, Event: event, Seq: seq, Duration: duration}
+			enqueue(item, func() bool {
+				path, err := e.captureClip(sdmClient, event, seq, duration, e.ClipUntilQuiet)
+				if err != nil {
+					return e.retryLater(item, err)
+				}
+				e.recordCapture(event, path)
+				e.refreshGallery()
+				return e.upload(work, event, path) == nil
+			})
 		}
-	})
+	}
+
+	err = source.Listen(ctx, handler)
+	if e.Replay != "" {
+		// A replay ends on its own; let the captures it started finish.
+		e.inflight.Wait()
+		return err
+	}
+	e.drain(cancelWork)
+	if n := e.pool.Dropped(); n > 0 {
+		fmt.Printf("Dropped %d capture(s) while their camera's queue was full\n", n)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// Interrupted; a --timeout deadline is still reported.
+		return nil
+	}
+	return err
+}
+
+// setup prepares what captures need: stream and ffmpeg options, naming,
+// upload and fanout targets and, with captures, the output dir and its
+// history. cleanup closes the history and flushes fanout.
+func (e *EventsCmd) setup(g *Globals, cfg *config.Config, captures bool) (cleanup func(), err error) {
+	e.opts = g.sessionOptions(cfg)
+	// Recorder messages, like pre-roll reconnects, belong in the event
+	// log on stdout.
+	e.recOpts = append(g.ffmpegOptions(cfg, "events"), recorder.WithLogger(newConsoleLogger(os.Stdout)), recorder.WithoutReplace())
+	e.marker = g.doneMarker(cfg)
+	e.sidecar = g.sidecar(cfg)
+	e.cfg = cfg
+
+	var closers []func()
+	cleanup = func() {
+		for _, c := range slices.Backward(closers) {
+			c()
+		}
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+
+	if captures {
+		if err := os.MkdirAll(e.OutputDir, 0755); err != nil {
+			return nil, fmt.Errorf("creating output dir: %w", err)
+		}
+		proc.SweepTemps(e.OutputDir, 10*time.Minute)
+		for _, d := range cfg.Devices {
+			if filepath.IsAbs(d.OutputDir) {
+				proc.SweepTemps(d.OutputDir, 10*time.Minute)
+			}
+		}
+		e.history, err = history.Open(e.OutputDir)
+		if err != nil {
+			return nil, fmt.Errorf("opening history: %w", err)
+		}
+		closers = append(closers, func() { e.history.Close() })
+	}
+
+	if !e.NoUpload {
+		// GCS and Drive use the user's token with all its scopes, including
+		// those added by auth --storage.
+		tokenFn, err := userTokenFn(cfg)
+		if err != nil {
+			return nil, err
+		}
+		e.uploader, err = upload.NewManager(cfg.Upload, tokenFn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !e.NoFanout {
+		e.fanout, err = fanout.NewManager(cfg.Fanout, e.OutputDir)
+		if err != nil {
+			return nil, err
+		}
+		if e.fanout != nil {
+			closers = append(closers, func() { e.fanout.Close(5 * time.Second) })
+		}
+	}
+
+	if lc := cfg.EventLog; lc != nil || e.EventLog != "" {
+		if lc == nil {
+			lc = &config.EventLogConfig{}
+		}
+		path := cmp.Or(e.EventLog, lc.Path)
+		if path == "" {
+			return nil, withExitCode(ExitConfig, errors.New("event_log: path is required"))
+		}
+		rotate, maxAge, err := lc.Periods()
+		if err != nil {
+			return nil, withExitCode(ExitConfig, fmt.Errorf("event_log: %w", err))
+		}
+		e.eventLog, err = eventlog.Open(path, eventlog.Options{
+			MaxSize:    int64(lc.MaxSizeMB) << 20,
+			Every:      rotate,
+			MaxBackups: lc.MaxBackups,
+			MaxAge:     maxAge,
+			Compress:   lc.Compress,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("opening event log: %w", err)
+		}
+		closers = append(closers, func() { e.eventLog.Close() })
+	}
+
+	tmpl := e.FilenameTemplate
+	if tmpl == "" {
+		tmpl = cfg.FilenameTemplate
+	}
+	e.namer, err = capture.NewNamer(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return cleanup, nil
+}
+
+// begin registers a capture about to start, or reports false once the
+// command is shutting down.
+func (e *EventsCmd) begin() bool {
+	e.inflightMu.Lock()
+	defer e.inflightMu.Unlock()
+	if e.draining {
+		return false
+	}
+	e.running++
+	e.inflight.Add(1)
+	return true
 }
 
-func isActionableEvent(eventType string) bool {
-	return strings.Contains(eventType, "Motion") || strings.Contains(eventType, "Person")
+// done marks a capture started with begin as finished.
+func (e *EventsCmd) done() {
+	e.inflightMu.Lock()
+	e.running--
+	e.inflightMu.Unlock()
+	e.inflight.Done()
 }
 
-func (e *EventsCmd) captureEventImage(client *sdm.Client, event pubsub.Event, seq int64) {
-	shortType := "event"
-	if parts := strings.Split(event.EventType, "."); len(parts) > 0 {
-		shortType = strings.ToLower(parts[len(parts)-1])
+// drain stops new captures and waits up to --drain-timeout for running ones
+// and their uploads. If they don't finish in time, uploads are cancelled and
+// recordings killed, removing their temp files.
+func (e *EventsCmd) drain(cancelWork context.CancelFunc) {
+	e.inflightMu.Lock()
+	e.draining = true
+	n := e.running
+	e.inflightMu.Unlock()
+	if n == 0 {
+		return
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		e.inflight.Wait()
+		close(finished)
+	}()
+	if e.DrainTimeout > 0 {
+		fmt.Printf("Waiting up to %s for %d capture(s) to finish (Ctrl-C again to quit now)...\n", e.DrainTimeout, n)
+		select {
+		case <-finished:
+			return
+		case <-time.After(e.DrainTimeout):
+		}
 	}
 
-	filename := fmt.Sprintf("%s_%s_%03d.jpg", time.Now().Format("20060102-150405"), shortType, seq)
-	outputPath := filepath.Join(e.OutputDir, filename)
+	e.inflightMu.Lock()
+	n = e.running
+	e.inflightMu.Unlock()
+	fmt.Printf("Abandoning %d unfinished capture(s)\n", n)
+	cancelWork()
+	proc.Cleanup()
+}
+
+// errQueueFull is logged for captures dropped because their camera's queue
+// was full.
+var errQueueFull = errors.New("capture queue full")
 
-	fmt.Printf("  Downloading event image: %s\n", filename)
+// poolKey is the worker queue of item's camera and kind, e.g.
+// "front-door/clip".
+func poolKey(item retry.Item) string {
+	return deviceDisplayNameFromFull(item.Event.DeviceName) + "/" + item.Kind
+}
 
-	img, err := client.GenerateEventImage(event.DeviceName, event.EventID)
+// enqueue queues capture on the worker for item's camera and kind. If the
+// queue is full (--queue-depth captures already wait behind a running one)
+// the capture is dropped, which is logged and counted. When replaying it
+// waits for room instead, so every replayed event gets its captures. It
+// reports false if the capture won't run.
+func (e *EventsCmd) enqueue(item retry.Item, capture func()) bool {
+	if !e.begin() {
+		return false
+	}
+	job := func() {
+		defer e.done()
+		capture()
+	}
+	if e.Replay != "" {
+		e.pool.SubmitWait(poolKey(item), job)
+		return true
+	}
+	if !e.pool.Submit(poolKey(item), job) {
+		e.done()
+		fmt.Printf("  Skipping %s (%s's queue is full)\n", item.Kind, e.friendly(item.Event.DeviceName))
+		e.logFailure(item, errQueueFull, false)
+		return false
+	}
+	return true
+}
+
+// retryLater queues a failed capture for another attempt if retries are
+// enabled and err looks transient. It reports whether it was queued.
+func (e *EventsCmd) retryLater(item retry.Item, err error) bool {
+	queue := e.retries != nil && retry.Transient(err)
+	e.logFailure(item, err, queue)
+	if !queue {
+		return false
+	}
+	e.retries.Add(item, err)
+	fmt.Printf("  Queued %s for retry\n", item.Kind)
+	return true
+}
+
+// retryLoop retries queued captures as they come due, one at a time. Each
+// waits in the queue of its camera and kind like an event capture, so a
+// retry doesn't overlap a running clip.
+func (e *EventsCmd) retryLoop(ctx, work context.Context, client sdm.API) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		due, expired := e.retries.Due(time.Now())
+		for _, it := range expired {
+			fmt.Printf("Giving up on %s for %s: retries expired (last error: %s)\n",
+				it.Kind, e.friendly(it.Event.DeviceName), it.LastError)
+			e.logFailure(it, fmt.Errorf("retries expired (last error: %s)", it.LastError), false)
+		}
+		for _, it := range due {
+			if ctx.Err() != nil || !e.begin() {
+				break // still due on the next run
+			}
+			finished := make(chan struct{})
+			e.pool.SubmitWait(poolKey(it), func() {
+				defer close(finished)
+				defer e.done()
+				e.retryCapture(work, client, it)
+			})
+			<-finished
+		}
+	}
+}
+
+// retryCapture makes one more attempt at a queued capture and, if it's
+// saved, records and uploads it like any other.
+func (e *EventsCmd) retryCapture(work context.Context, client sdm.API, it retry.Item) {
+	event := it.Event
+	fmt.Printf("[%s] %s: retrying %s for %s (%d/%d)\n", time.Now().Format("15:04:05"),
+		e.friendly(event.DeviceName), it.Kind, shortType(event.EventType), it.Attempts+1, retry.MaxAttempts)
+
+	var path string
+	var err error
+	switch {
+	case it.Kind == retry.KindClip:
+		path, err = e.captureClip(client, event, it.Seq, it.Duration, false)
+	case event.EventID == "":
+		path, err = e.captureLiveSnapshot(client, event, it.Seq)
+	default:
+		path, err = e.captureEventImage(client, event, it.Seq)
+	}
 	if err != nil {
-		fmt.Printf("  Warning: event image failed: %v\n", err)
+		next, ok := e.retries.Failed(it.ID, err)
+		if ok {
+			fmt.Printf("  Will retry at %s\n", next.Format("15:04:05"))
+		} else {
+			fmt.Printf("  Giving up on %s\n", it.Kind)
+		}
+		e.logFailure(it, err, ok)
 		return
 	}
+	e.retries.Done(it.ID)
+	e.recordCapture(event, path)
+	e.refreshGallery()
+	e.upload(work, event, path)
+}
 
-	if err := client.DownloadEventImage(img, outputPath); err != nil {
-		fmt.Printf("  Warning: image download failed: %v\n", err)
+// scheduledEvent is the event type recorded for scheduled clips.
+const scheduledEvent = "Scheduled"
+
+// startSchedules validates the recording schedules in config and runs each
+// in the background until ctx is done. Scheduled clips wait in their
+// camera's clip queue like event clips, so they never stream alongside
+// one, and go through the same naming, history, gallery and upload steps,
+// with uploads bound to work.
+func (e *EventsCmd) startSchedules(ctx, work context.Context, client sdm.API, cfg *config.Config, seq *atomic.Int64) error {
+	for _, sc := range cfg.Schedules {
+		sched, err := schedule.Parse(sc.Cron)
+		if err != nil {
+			return err
+		}
+		length, err := time.ParseDuration(sc.Duration)
+		if err != nil || length <= 0 {
+			return fmt.Errorf("schedule %q: invalid duration %q", sc.Cron, sc.Duration)
+		}
+		deviceName, err := resolveDevice(client, cfg, sc.Device)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Scheduled %s recording of %s at %q (next: %s)\n", length, e.friendly(deviceName),
+			sched, sched.Next(time.Now()).Format("Mon Jan 2 15:04"))
+		go schedule.Loop(ctx, sched, length, sc.CatchUp, func(d time.Duration) {
+			defer crash.Recover("scheduled recording")
+			if !e.begin() {
+				return
+			}
+			event := pubsub.Event{DeviceName: deviceName, EventType: scheduledEvent, Timestamp: time.Now()}
+			fmt.Printf("[%s] %s: %s\n", event.Timestamp.Format("15:04:05"), e.friendly(deviceName), scheduledEvent)
+			item := retry.Item{Kind: retry.KindClip, Event: event, Seq: seq.Add(1), Duration: d}
+			// Wait for the clip, so the schedule's runs don't overlap.
+			finished := make(chan struct{})
+			e.pool.SubmitWait(poolKey(item), func() {
+				defer close(finished)
+				defer e.done()
+				path, err := e.captureClip(client, event, item.Seq, d, false)
+				if err != nil {
+					e.retryLater(item, err)
+					return
+				}
+				e.recordCapture(event, path)
+				e.refreshGallery()
+				e.upload(work, event, path)
+			})
+			<-finished
+		})
+	}
+	return nil
+}
+
+// runAutomations runs the actions of the automations event matches. Captures
+// on other devices are made from their live streams, named and recorded
+// under the event's type; like event captures, they're skipped while
+// presence rules don't allow them. Commands are sent in the background and
+// only logged.
+func (e *EventsCmd) runAutomations(work context.Context, client sdm.API, event pubsub.Event, seq *atomic.Int64) {
+	for _, a := range e.automate.Match(event.DeviceName, event.EventType, event.Zones) {
+		for _, act := range a.Actions {
+			target := event.DeviceName
+			if act.Device != "" {
+				var err error
+				if target, err = resolveDevice(client, e.cfg, act.Device); err != nil {
+					fmt.Printf("  Warning: automation %s: %v\n", a.Name, err)
+					continue
+				}
+			}
+			targetShort := e.friendly(target)
+
+			if act.Command != "" {
+				if !e.begin() {
+					return
+				}
+				fmt.Printf("  Automation %s: %s on %s\n", a.Name, act.Command[strings.LastIndex(act.Command, ".")+1:], targetShort)
+				crash.Go("automation", func() {
+					defer e.done()
+					if _, err := client.ExecuteCommand(target, act.Command, act.Params); err != nil {
+						fmt.Printf("  Warning: automation %s: %s on %s: %v\n", a.Name, act.Command, targetShort, err)
+					}
+				})
+				continue
+			}
+
+			if e.cfg.Presence != nil {
+				st, err := presence.Get()
+				if err != nil {
+					fmt.Printf("  Warning: reading presence: %v\n", err)
+				}
+				if !presence.Allows(e.cfg.Presence, st, target) {
+					fmt.Printf("  Automation %s: skipping %s (%s)\n", a.Name, targetShort, st)
+					continue
+				}
+			}
+			ev := event
+			if target != event.DeviceName {
+				// The event's image and zones belong to its own device.
+				ev = pubsub.Event{DeviceName: target, EventType: event.EventType, Timestamp: event.Timestamp}
+			}
+			length := time.Duration(act.ClipSecs) * time.Second
+			if length == 0 {
+				_, dev, _ := e.cfg.DeviceSettings(target)
+				length = time.Duration(dev.ClipSecs) * time.Second
+			}
+			fmt.Printf("  Automation %s: capturing on %s\n", a.Name, targetShort)
+			e.startCaptures(work, client, ev, seq.Add(1), act.Snapshot, act.Clip, length)
+		}
+	}
+}
+
+// startBuffers opens the --pre-roll buffers: one always-open stream per
+// camera in --pre-roll-device, or per WebRTC camera. A camera whose stream
+// won't start is recorded without pre-roll.
+func (e *EventsCmd) startBuffers(ctx context.Context, client sdm.API, cfg *config.Config) error {
+	var names []string
+	if len(e.PreRollDevice) > 0 {
+		for _, ref := range e.PreRollDevice {
+			name, err := resolveDevice(client, cfg, ref)
+			if err != nil {
+				return err
+			}
+			if err := ensureWebRTC(client, name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+	} else {
+		devices, err := client.ListDevices()
+		if err != nil {
+			return fmt.Errorf("listing devices: %w", err)
+		}
+		for _, d := range devices {
+			if ls := d.LiveStream(); ls != nil && ls.SupportsWebRTC() {
+				names = append(names, d.Name)
+			}
+		}
+	}
+
+	e.buffers = map[string]*recorder.Buffer{}
+	for _, name := range names {
+		fmt.Printf("Buffering %s of %s for pre-roll...\n", e.PreRoll, e.friendly(name))
+		buf, err := recorder.StartBuffered(ctx, e.PreRoll, e.stream(client, name), e.recOpts...)
+		if err != nil {
+			fmt.Printf("  Warning: no pre-roll for %s: %v\n", e.friendly(name), err)
+			continue
+		}
+		e.buffers[name] = buf
+	}
+
+	// The other WebRTC cameras get their stream opened early instead.
+	devices, err := client.ListDevices()
+	if err != nil {
+		return fmt.Errorf("listing devices: %w", err)
+	}
+	e.presignaled = map[string]bool{}
+	for _, d := range devices {
+		if ls := d.LiveStream(); ls != nil && ls.SupportsWebRTC() && e.buffers[d.Name] == nil {
+			e.presignaled[d.Name] = true
+		}
+	}
+	return nil
+}
+
+// presignalHold is how long presignal keeps a stream open for a clip to
+// join.
+const presignalHold = 20 * time.Second
+
+// presignal opens the shared stream of a camera without a pre-roll buffer
+// as soon as any of its events arrives, before dedup and policies decide
+// whether it's captured, so a clip joins a session whose signaling is
+// already under way instead of starting its own. The stream is held for
+// presignalHold and closes then unless a capture subscribed. Each event
+// that isn't captured costs a stream session.
+func (e *EventsCmd) presignal(ctx context.Context, client sdm.StreamAPI, deviceName string) {
+	if !e.presignaled[deviceName] {
+		return
+	}
+	if _, busy := e.warming.LoadOrStore(deviceName, true); busy {
 		return
 	}
+	subscribe := e.stream(client, deviceName)
+	crash.Go("presignal", func() {
+		defer e.warming.Delete(deviceName)
+		ctx, cancel := context.WithTimeout(ctx, presignalHold)
+		defer cancel()
+		if err := subscribe(ctx, func(nestwebrtc.Track) {}); err != nil {
+			fmt.Printf("  Warning: pre-signaling %s: %v\n", e.friendly(deviceName), err)
+			return
+		}
+		<-ctx.Done()
+	})
+}
+
+// stream returns the recorder's startStream function for captures from
+// deviceName. Captures and the pre-roll buffer of a camera all subscribe
+// to one shared stream, so overlapping ones don't each open a Nest session.
+func (e *EventsCmd) stream(client sdm.StreamAPI, deviceName string) func(ctx context.Context, handler func(nestwebrtc.Track)) error {
+	e.hubsMu.Lock()
+	defer e.hubsMu.Unlock()
+	hub := e.hubs[deviceName]
+	if hub == nil {
+		if e.hubs == nil {
+			e.hubs = map[string]*nestwebrtc.StreamHub{}
+		}
+		hub = nestwebrtc.NewStreamHub(streamStarter(client, deviceName, e.opts))
+		e.hubs[deviceName] = hub
+	}
+	return hub.Subscribe
+}
 
-	fmt.Printf("  Saved: %s\n", outputPath)
+// closeBuffers stops the pre-roll streams.
+func (e *EventsCmd) closeBuffers() {
+	for _, buf := range e.buffers {
+		buf.Close()
+	}
 }
 
-func (e *EventsCmd) captureClip(client *sdm.Client, cfg *config.Config, event pubsub.Event, seq int64) {
-	deviceName := event.DeviceName
-	if deviceName == "" {
+// actionFor decides what to capture for an event: configured policies take
+// precedence, otherwise the --capture/--clip flags apply to the events picked
+// by capture_events or --trigger/--ignore (Motion/Person by default) and
+// --sound to Sound.
+// A device's clip_secs fills in clip lengths its matching rule leaves unset.
+func (e *EventsCmd) actionFor(event pubsub.Event) (capture.Action, bool) {
+	_, dev, _ := e.cfg.DeviceSettings(event.DeviceName)
+	var action capture.Action
+	var ok bool
+	switch {
+	case len(e.cfg.Policies) > 0 || len(dev.Policies) > 0:
+		action, ok = e.policies.Match(event.DeviceName, event.EventType, event.Zones)
+	case isSoundEvent(event.EventType):
+		action = capture.Action{
+			Snapshot: e.Sound == "snapshot" || e.Sound == "both",
+			Clip:     e.Sound == "clip" || e.Sound == "both",
+		}
+		ok = action.Snapshot || action.Clip
+	case e.triggers.Match(event.DeviceName, event.EventType):
+		action, ok = capture.Action{Snapshot: e.Capture, Clip: e.Clip}, true
+	}
+	if ok && action.ClipSecs == 0 {
+		action.ClipSecs = dev.ClipSecs
+	}
+	return action, ok
+}
+
+// policyRules returns the capture rules from config: each device's own
+// rules, bound to that device, followed by the top-level ones. Device
+// aliases in top-level rules are resolved to IDs.
+func policyRules(cfg *config.Config) []config.CapturePolicy {
+	var rules []config.CapturePolicy
+	for key, d := range cfg.Devices {
+		for _, r := range d.Policies {
+			r.Device = cfg.DeviceRef(key)
+			rules = append(rules, r)
+		}
+	}
+	for _, r := range cfg.Policies {
+		r.Device = cfg.DeviceRef(r.Device)
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// automationRules returns the automations from config with device aliases
+// in their event filters resolved to IDs. Action targets are resolved when
+// they run.
+func automationRules(cfg *config.Config) []config.Automation {
+	rules := slices.Clone(cfg.Automations)
+	for i := range rules {
+		rules[i].Device = cfg.DeviceRef(rules[i].Device)
+	}
+	return rules
+}
+
+// eventTriggers builds the capture_events filters from config, with the
+// --trigger and --ignore flags replacing the top-level lists.
+func eventTriggers(cfg *config.Config, include, exclude []string) (*capture.Triggers, error) {
+	var global config.EventFilter
+	if cfg.CaptureEvents != nil {
+		global = *cfg.CaptureEvents
+	}
+	if len(include) > 0 {
+		global.Include = include
+	}
+	if len(exclude) > 0 {
+		global.Exclude = exclude
+	}
+	devices := map[string]config.EventFilter{}
+	for key, d := range cfg.Devices {
+		if d.CaptureEvents != nil {
+			devices[cfg.DeviceRef(key)] = *d.CaptureEvents
+		}
+	}
+	return capture.NewTriggers(global, devices)
+}
+
+// deviceDir returns the directory for deviceName's captures: its output_dir
+// from config, relative to the output dir, or the output dir itself.
+func (e *EventsCmd) deviceDir(deviceName string) string {
+	_, dev, _ := e.cfg.DeviceSettings(deviceName)
+	if filepath.IsAbs(dev.OutputDir) {
+		return dev.OutputDir
+	}
+	return filepath.Join(e.OutputDir, dev.OutputDir)
+}
+
+func isSoundEvent(eventType string) bool {
+	return strings.HasSuffix(eventType, "CameraSound.Sound")
+}
+
+// eventDetails describes what an event's payload says beyond its type, e.g.
+// " (Driveway zone)", " (familiar: Alice)" or " (72 dB, session 3f9a1c20)",
+// for the events log.
+func eventDetails(event pubsub.Event) string {
+	var parts []string
+	switch len(event.Zones) {
+	case 0:
+	case 1:
+		parts = append(parts, event.Zones[0]+" zone")
+	default:
+		parts = append(parts, strings.Join(event.Zones, ", ")+" zones")
+	}
+	if len(event.FamiliarFaces) > 0 {
+		parts = append(parts, "familiar: "+strings.Join(event.FamiliarFaces, ", "))
+	}
+	if isSoundEvent(event.EventType) {
+		if event.Loudness != 0 {
+			parts = append(parts, fmt.Sprintf("%.0f dB", event.Loudness))
+		}
+		if id := event.SessionID; id != "" {
+			parts = append(parts, "session "+id[:min(len(id), 8)])
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// record appends r to the history log, if one is open.
+func (e *EventsCmd) record(r history.Record) {
+	if e.history == nil {
 		return
 	}
+	if err := e.history.Append(r); err != nil {
+		fmt.Printf("  Warning: history write failed: %v\n", err)
+	}
+}
 
-	shortType := "event"
-	if parts := strings.Split(event.EventType, "."); len(parts) > 0 {
-		shortType = strings.ToLower(parts[len(parts)-1])
+// recordCapture logs a saved capture in the history for digests and lookups.
+func (e *EventsCmd) recordCapture(event pubsub.Event, path string) {
+	rel, err := filepath.Rel(e.OutputDir, path)
+	if err != nil {
+		rel = path
 	}
+	e.record(history.Record{
+		Kind:         history.KindCapture,
+		Time:         time.Now(),
+		Device:       deviceDisplayNameFromFull(event.DeviceName),
+		Type:         shortType(event.EventType),
+		EventID:      event.EventID,
+		Path:         filepath.ToSlash(rel),
+		Subscription: event.Subscription,
+	})
+	e.publish(fanout.KindCapture, event, filepath.ToSlash(rel))
+}
 
-	filename := fmt.Sprintf("%s_%s_%03d.mp4", time.Now().Format("20060102-150405"), shortType, seq)
-	outputPath := filepath.Join(e.OutputDir, filename)
-	duration := time.Duration(e.ClipSecs) * time.Second
+// publish forwards an event, or a saved capture at rel, to the fanout
+// targets and the event log, if any.
+func (e *EventsCmd) publish(kind string, event pubsub.Event, rel string) {
+	if e.fanout == nil && e.eventLog == nil {
+		return
+	}
+	msg := e.message(kind, event, rel)
+	if e.fanout != nil {
+		e.fanout.Publish(msg)
+	}
+	e.logEntry(eventLogEntry{Message: msg})
+}
 
-	fmt.Printf("  Recording %s clip: %s\n", duration, filename)
+// kindCaptureFailed marks event log entries for captures that failed.
+const kindCaptureFailed = "capture_failed"
 
-	err := recorder.RecordClip(outputPath, duration, func(ctx context.Context, handler func(*webrtc.TrackRemote, *webrtc.RTPReceiver)) error {
-		session, offerSDP, err := nestwebrtc.NewSession(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-			handler(track, receiver)
-		})
-		if err != nil {
-			return err
+// eventLogEntry is a line of the event log: a fan-out message, plus for
+// failed captures the error and whether it'll be retried.
+type eventLogEntry struct {
+	fanout.Message
+	Capture string `json:"capture,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Retry   bool   `json:"retry,omitempty"`
+}
+
+// logEntry appends entry to the event log, if any.
+func (e *EventsCmd) logEntry(entry eventLogEntry) {
+	if e.eventLog == nil {
+		return
+	}
+	if err := e.eventLog.Write(entry); err != nil {
+		fmt.Printf("Warning: writing event log: %v\n", err)
+	}
+}
+
+// logFailure records a failed capture of item in the event log; queued says
+// whether it's queued to be tried again.
+func (e *EventsCmd) logFailure(item retry.Item, err error, queued bool) {
+	if e.eventLog == nil {
+		return
+	}
+	e.logEntry(eventLogEntry{
+		Message: e.message(kindCaptureFailed, item.Event, ""),
+		Capture: item.Kind,
+		Error:   err.Error(),
+		Retry:   queued,
+	})
+}
+
+// message describes an event, or a saved capture at rel, for the fanout
+// targets and the event log.
+func (e *EventsCmd) message(kind string, event pubsub.Event, rel string) fanout.Message {
+	t := event.Timestamp
+	if kind == fanout.KindCapture {
+		t = time.Now()
+	}
+	return fanout.Message{
+		Kind:          kind,
+		Time:          t,
+		Device:        deviceDisplayNameFromFull(event.DeviceName),
+		DeviceName:    event.DeviceName,
+		DisplayName:   e.friendly(event.DeviceName),
+		Type:          shortType(event.EventType),
+		EventID:       event.EventID,
+		SessionID:     event.SessionID,
+		ThreadID:      event.ThreadID,
+		ThreadState:   event.ThreadState,
+		ResourceGroup: event.ResourceGroup,
+		UserID:        event.UserID,
+		Zones:         event.Zones,
+		FamiliarFaces: event.FamiliarFaces,
+		Loudness:      event.Loudness,
+		Path:          rel,
+		Subscription:  event.Subscription,
+	}
+}
+
+// eventSource returns the source events listens to, merging listeners if
+// there are several, and its health for /healthz: unhealthy while any
+// listener's circuit is open.
+func eventSource(listeners []*pubsub.Listener) (pubsub.EventSource, func() (bool, any)) {
+	if len(listeners) == 1 {
+		l := listeners[0]
+		return l, func() (bool, any) {
+			h := l.Health()
+			return h.Healthy(), map[string]any{"pubsub": h}
+		}
+	}
+	m := pubsub.NewMulti(listeners...)
+	return m, func() (bool, any) {
+		all := m.Health()
+		healthy := true
+		for _, h := range all {
+			healthy = healthy && h.Healthy()
+		}
+		return healthy, map[string]any{"pubsub": all}
+	}
+}
+
+// withCaptureStats adds the capture queues' counts to health's status.
+func (e *EventsCmd) withCaptureStats(health func() (bool, any)) func() (bool, any) {
+	return func() (bool, any) {
+		healthy, status := health()
+		if m, ok := status.(map[string]any); ok {
+			m["captures"] = e.pool.Stats()
+		}
+		return healthy, status
+	}
+}
+
+// digestLoop writes a digest report into the output dir after each period
+// boundary (midnight for daily, Monday midnight for weekly).
+func (e *EventsCmd) digestLoop(ctx context.Context) {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		if e.Digest == "weekly" {
+			for next.Weekday() != time.Monday {
+				next = next.AddDate(0, 0, 1)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
 		}
 
-		answerSDP, mediaSessionID, err := client.GenerateWebRTCStream(deviceName, offerSDP)
+		from, to, err := digest.Period(e.Digest, time.Now())
 		if err != nil {
-			session.Close()
-			return err
+			return
+		}
+		out := filepath.Join(e.OutputDir, fmt.Sprintf("digest-%s.html", from.Format("2006-01-02")))
+		if err := writeDigest(e.OutputDir, out, from, to); err != nil {
+			fmt.Printf("Warning: digest failed: %v\n", err)
+			continue
 		}
+		fmt.Printf("Digest written: %s\n", out)
+	}
+}
 
-		err = session.SetAnswer(answerSDP, mediaSessionID,
-			func(msid string) error { return client.ExtendWebRTCStream(deviceName, msid) },
-			func(msid string) error { return client.StopWebRTCStream(deviceName, msid) },
-		)
+// indexLoop indexes captures that appear in the output dir without going
+// through this process, at startup and then every --index-interval.
+func (e *EventsCmd) indexLoop(ctx context.Context) {
+	ticker := time.NewTicker(e.IndexInterval)
+	defer ticker.Stop()
+	for {
+		n, err := indexCaptures(e.OutputDir, e.namer, e.history)
 		if err != nil {
-			session.Close()
-			return err
+			fmt.Printf("Warning: indexing captures failed: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("Indexed %d capture(s) added to %s\n", n, e.OutputDir)
+			e.refreshGallery()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+	}
+}
 
-		go func() {
-			<-ctx.Done()
-			time.Sleep(500 * time.Millisecond)
-			session.Close()
-		}()
+// refreshGallery rebuilds the output dir's index.html when --gallery is set.
+func (e *EventsCmd) refreshGallery() {
+	if !e.Gallery {
+		return
+	}
+	e.galleryMu.Lock()
+	defer e.galleryMu.Unlock()
+	if _, err := gallery.Generate(e.OutputDir, filepath.Join(e.OutputDir, "index.html")); err != nil {
+		fmt.Printf("  Warning: gallery update failed: %v\n", err)
+	}
+}
 
+// upload archives a saved capture to the configured targets, if any. Remote
+// keys mirror the local layout; flat layouts are grouped into one folder per
+// device. Failures are logged and returned.
+func (e *EventsCmd) upload(ctx context.Context, event pubsub.Event, path string) error {
+	if e.uploader == nil {
 		return nil
-	})
+	}
+	rel, err := filepath.Rel(e.OutputDir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.Contains(rel, "/") {
+		rel = deviceDisplayNameFromFull(event.DeviceName) + "/" + rel
+	}
+	if err := e.uploader.Upload(ctx, path, rel); err != nil {
+		fmt.Printf("  Warning: upload failed: %v\n", err)
+		return err
+	}
+	fmt.Printf("  Uploaded: %s\n", rel)
+	return nil
+}
 
+// capturePath renders the filename template for a capture and creates any
+// subdirectories it needs under the device's directory (see deviceDir). If
+// the path is taken, on disk or by another capture in progress, "-2", "-3"
+// and so on is added before the extension. The path stays claimed until the
+// caller passes it to releasePath.
+func (e *EventsCmd) capturePath(event pubsub.Event, eventType string, seq int64, ext string) (string, error) {
+	deviceID := deviceDisplayNameFromFull(event.DeviceName)
+	data := capture.NewNameData(e.friendly(event.DeviceName), deviceID, eventType, seq, ext, time.Now())
+	data.Zone = strings.Join(event.Zones, "+")
+	rel, err := e.namer.Render(data)
 	if err != nil {
-		fmt.Printf("  Warning: clip failed: %v\n", err)
+		return "", err
+	}
+	base := filepath.Join(e.deviceDir(event.DeviceName), rel)
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return "", fmt.Errorf("creating capture dir: %w", err)
+	}
+	dot := filepath.Ext(base)
+	for n := 1; ; n++ {
+		outputPath := base
+		if n > 1 {
+			outputPath = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, dot), n, dot)
+		}
+		if _, err := os.Lstat(outputPath); err == nil {
+			continue
+		}
+		if _, taken := e.claimed.LoadOrStore(outputPath, true); !taken {
+			return outputPath, nil
+		}
+	}
+}
+
+// releasePath ends a claim taken by capturePath.
+func (e *EventsCmd) releasePath(path string) { e.claimed.Delete(path) }
+
+// captureEventImage downloads the event image and returns the saved path.
+// Failures are logged before they're returned.
+func (e *EventsCmd) captureEventImage(client sdm.API, event pubsub.Event, seq int64) (string, error) {
+	outputPath, err := e.capturePath(event, strings.ToLower(shortType(event.EventType)), seq, "jpg")
+	if err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+		return "", err
+	}
+	defer e.releasePath(outputPath)
+
+	fmt.Printf("  Downloading event image: %s\n", filepath.Base(outputPath))
+	started := time.Now()
+
+	img, err := client.GenerateEventImage(event.DeviceName, event.EventID)
+	if err != nil {
+		fmt.Printf("  Warning: event image failed: %v\n", err)
+		return "", err
+	}
+
+	tmp := recorder.PartialPath(outputPath)
+	proc.AddTemp(tmp)
+	defer proc.RemoveTemp(tmp)
+	if err := client.DownloadEventImage(img, tmp); err != nil {
+		fmt.Printf("  Warning: image download failed: %v\n", err)
+		return "", err
+	}
+	if err := recorder.CommitNew(tmp, outputPath, e.marker); err != nil {
+		fmt.Printf("  Warning: saving image failed: %v\n", err)
+		return "", err
+	}
+	logLatency(event, "image saved")
+
+	fmt.Printf("  Saved: %s\n", outputPath)
+	e.writeSidecar(client, event, outputPath, started, nil)
+	return outputPath, nil
+}
+
+// writeSidecar writes a capture's metadata sidecar when enabled, with the
+// stream's resolution and profile from stream unless it's nil.
+func (e *EventsCmd) writeSidecar(client sdm.DeviceAPI, event pubsub.Event, path string, started time.Time, stream *streamCheck) {
+	if !e.sidecar {
+		return
+	}
+	s := capture.Sidecar{
+		EventType:      shortType(event.EventType),
+		EventID:        event.EventID,
+		EventSessionID: event.SessionID,
+		EventThreadID:  event.ThreadID,
+		EventTime:      event.Timestamp,
+		Loudness:       event.Loudness,
+		FamiliarFaces:  event.FamiliarFaces,
+		Zones:          event.Zones,
+	}
+	if stream != nil {
+		s = stream.fill(s)
+	}
+	if err := writeSidecar(client, e.cfg, event.DeviceName, path, started, s); err != nil {
+		fmt.Printf("  Warning: writing sidecar: %v\n", err)
+	}
+}
+
+// logLatency logs how long after Pub/Sub published the event a capture
+// reached disk, and how much of that was delivery to this process. Replayed
+// and scheduled events have no publish time and are skipped.
+func logLatency(event pubsub.Event, what string) {
+	if event.PublishTime.IsZero() {
+		return
+	}
+	now := time.Now()
+	fmt.Printf("  Latency: %s %s after publish (delivery %s, capture %s)\n", what,
+		now.Sub(event.PublishTime).Round(10*time.Millisecond),
+		event.Received.Sub(event.PublishTime).Round(10*time.Millisecond),
+		now.Sub(event.Received).Round(10*time.Millisecond))
+}
+
+// clipFormat returns the clip file extension from --clip-format or config.
+func (e *EventsCmd) clipFormat() string {
+	return cmp.Or(e.ClipFormat, e.cfg.ClipFormat, "mp4")
+}
+
+// captureClip records a clip over WebRTC and returns the saved path.
+// Failures are logged before they're returned.
+func (e *EventsCmd) captureClip(client sdm.API, event pubsub.Event, seq int64, duration time.Duration, untilQuiet bool) (string, error) {
+	deviceName := event.DeviceName
+	if deviceName == "" {
+		return "", fmt.Errorf("event has no device")
+	}
+
+	outputPath, err := e.capturePath(event, strings.ToLower(shortType(event.EventType)), seq, e.clipFormat())
+	if err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+		return "", err
+	}
+	defer e.releasePath(outputPath)
+	startStream := e.stream(client, deviceName)
+
+	started := time.Now()
+	eventType := shortType(event.EventType)
+	stream := newStreamCheck(client, deviceName, warnStream)
+	opts := append(slices.Clip(e.recOpts), recorder.WithMetadata(clipMetadata(client, deviceName, eventType)),
+		recorder.WithFirstFrame(func() { logLatency(event, "first clip frame on disk") }), stream.option())
+	if e.Overlay {
+		opts = append(opts, recorder.WithOverlay(deviceLabel(client, deviceName)))
+	}
+
+	if buf := e.buffers[deviceName]; buf != nil && !untilQuiet {
+		if duration <= 0 {
+			duration = time.Duration(e.ClipSecs) * time.Second
+		}
+		// Anchor the pre-roll on the event unless it's older than the
+		// buffer reaches (a retry): then the clip starts from now.
+		at := event.Timestamp
+		if time.Since(at) > buf.Window() {
+			at = time.Now()
+		}
+		fmt.Printf("  Recording %s clip with %s pre-roll: %s\n", duration, e.PreRoll, filepath.Base(outputPath))
+		err = buf.TriggerClip(outputPath, at, e.PreRoll, duration, opts...)
+	} else if untilQuiet {
+		activity := make(chan struct{}, 1)
+		e.activeClips.Store(deviceName, activity)
+		defer e.activeClips.Delete(deviceName)
+
+		quiet := time.Duration(e.ClipQuiet) * time.Second
+		maxDuration := time.Duration(e.ClipMax) * time.Second
+		fmt.Printf("  Recording clip until %s of quiet (max %s): %s\n", quiet, maxDuration, filepath.Base(outputPath))
+		err = recorder.RecordUntilQuiet(outputPath, quiet, maxDuration, activity, startStream, opts...)
 	} else {
-		fmt.Printf("  Saved: %s\n", outputPath)
+		if duration <= 0 {
+			duration = time.Duration(e.ClipSecs) * time.Second
+		}
+		fmt.Printf("  Recording %s clip: %s\n", duration, filepath.Base(outputPath))
+		err = recorder.RecordClip(outputPath, duration, startStream, opts...)
 	}
+
+	if err != nil {
+		fmt.Printf("  Warning: clip failed: %v\n", err)
+		return "", err
+	}
+	fmt.Printf("  Saved: %s\n", outputPath)
+	e.writeSidecar(client, event, outputPath, started, stream)
+	return outputPath, nil
 }
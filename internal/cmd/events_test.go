@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brice/gognestcli/internal/capture"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/pubsub"
+)
+
+func TestCapturePathCollision(t *testing.T) {
+	namer, err := capture.NewNamer("{{.Device}}/{{.Type}}.{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	e := &EventsCmd{OutputDir: dir, namer: namer, cfg: &config.Config{}}
+	event := pubsub.Event{DeviceName: "enterprises/p/devices/front", Timestamp: time.Now()}
+	want := func(name string) string { return filepath.Join(dir, "front", name) }
+
+	first, err := e.capturePath(event, "person", 1, "jpg")
+	if err != nil || first != want("person.jpg") {
+		t.Fatalf("capturePath = %q, %v", first, err)
+	}
+	// A capture in progress holds its path even before the file exists.
+	second, _ := e.capturePath(event, "person", 2, "jpg")
+	if second != want("person-2.jpg") {
+		t.Errorf("path claimed by a running capture reused: %q", second)
+	}
+
+	// Once a capture is saved, its file keeps later ones off the path.
+	if err := os.WriteFile(first, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e.releasePath(first)
+	e.releasePath(second)
+	third, _ := e.capturePath(event, "person", 3, "jpg")
+	if third != want("person-2.jpg") {
+		t.Errorf("capturePath after the first was saved = %q, want person-2.jpg", third)
+	}
+}
+
+func TestCaptureEventImage(t *testing.T) {
+	namer, err := capture.NewNamer("{{.Device}}/{{.Type}}.{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	client := newFakeAPI(camera("front", "DOORBELL", "", "Porch", "WEB_RTC"))
+	e := &EventsCmd{OutputDir: dir, namer: namer, cfg: &config.Config{}}
+	event := pubsub.Event{
+		DeviceName: "enterprises/p/devices/front",
+		EventType:  "sdm.devices.events.CameraPerson.Person",
+		EventID:    "ev1",
+		Timestamp:  time.Now(),
+	}
+
+	path, err := e.captureEventImage(client, event, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "front", "person.jpg"); path != want {
+		t.Errorf("saved to %q, want %q", path, want)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "jpeg fake://ev1" {
+		t.Errorf("image = %q", data)
+	}
+
+	// A second image of the same event type gets its own file.
+	event.EventID = "ev2"
+	second, err := e.captureEventImage(client, event, 2)
+	if err != nil || second != filepath.Join(dir, "front", "person-2.jpg") {
+		t.Errorf("second capture = %q, %v", second, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "jpeg fake://ev1" {
+		t.Errorf("first image overwritten: %q", data)
+	}
+
+	// A failed request leaves nothing behind.
+	event.DeviceName = "enterprises/p/devices/gone"
+	if _, err := e.captureEventImage(client, event, 3); err == nil {
+		t.Error("capture of an unknown device succeeded")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "gone")); len(entries) != 0 {
+		t.Errorf("files left after a failed capture: %v", entries)
+	}
+	if client.images != 2 {
+		t.Errorf("generated %d images, want 2", client.images)
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/brice/gognestcli/internal/pubsub"
+	"github.com/brice/gognestcli/internal/quota"
+	"github.com/brice/gognestcli/internal/recorder"
+	"github.com/brice/gognestcli/internal/secrets"
+)
+
+// Exit codes returned by Execute, so scripts and service managers can react
+// to a failure without parsing its message. Command-line usage errors exit
+// with 80 (kong), and SIGINT/SIGTERM with 130/143 (see proc.HandleSignals).
+const (
+	ExitError       = 1 // any other failure
+	ExitConfig      = 2 // config missing, unreadable or incomplete
+	ExitAuth        = 3 // not authorized yet, or credentials rejected
+	ExitNotFound    = 4 // device not found
+	ExitUnsupported = 5 // device can't stream over WebRTC
+	ExitFFmpeg      = 6 // ffmpeg or ffplay not installed
+	ExitTimeout     = 7 // no video in time, a stuck conversion or deadline
+	ExitQuota       = 8 // SDM rate limit or the local call budget
+)
+
+// codedError attaches an exit code to an error (a kong.ExitCoder).
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+func (e *codedError) ExitCode() int { return e.code }
+
+var _ kong.ExitCoder = (*codedError)(nil)
+
+// withExitCode makes Execute exit with code if err ends the command.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// exitCode picks the exit code for a command's error: a code attached with
+// withExitCode wins, then known error types, then the API status in the
+// message.
+func exitCode(err error) int {
+	// Only our own codes: *exec.ExitError also has an ExitCode method, and
+	// ffmpeg's status isn't ours to return.
+	var coded *codedError
+	var exceeded *quota.ExceededError
+	var subProblem *pubsub.SubscriptionProblem
+	switch {
+	case errors.As(err, &coded):
+		return coded.ExitCode()
+	case errors.As(err, &exceeded):
+		return ExitQuota
+	case errors.Is(err, recorder.ErrToolNotFound):
+		return ExitFFmpeg
+	case errors.Is(err, secrets.ErrNoRefreshToken):
+		return ExitAuth
+	case errors.As(err, &subProblem):
+		return ExitConfig
+	case errors.Is(err, context.DeadlineExceeded), commandCtx.Err() != nil:
+		// Whatever a command reports after --timeout passes, it's because
+		// of the deadline.
+		return ExitTimeout
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "returned 429"):
+		return ExitQuota
+	case strings.Contains(msg, "returned 401"), strings.Contains(msg, "returned 403"),
+		strings.Contains(msg, "token endpoint returned 4"):
+		return ExitAuth
+	case strings.Contains(msg, "returned 404"):
+		return ExitNotFound
+	case strings.Contains(msg, "timed out"):
+		return ExitTimeout
+	}
+	return ExitError
+}
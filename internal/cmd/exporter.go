@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/metrics"
+	"github.com/brice/gognestcli/internal/proc"
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+type ExporterCmd struct {
+	Interval time.Duration `help:"Polling interval" default:"60s"`
+	Listen   string        `help:"Serve Prometheus metrics on this address (e.g. :9102); empty disables" default:":9102"`
+	CSV      string        `name:"csv" help:"Append samples to this CSV file"`
+	Influx   string        `help:"Append samples to this file as InfluxDB line protocol"`
+}
+
+func (x *ExporterCmd) Run() error {
+	config.SetReadOnly(true)
+	client, _, err := newSDMClient()
+	if err != nil {
+		return err
+	}
+
+	if x.Listen == "" && x.CSV == "" && x.Influx == "" {
+		return errors.New("nothing to export: set --listen, --csv or --influx")
+	}
+
+	var csvFile, influxFile *os.File
+	if x.CSV != "" {
+		_, statErr := os.Stat(x.CSV)
+		csvFile, err = os.OpenFile(x.CSV, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer csvFile.Close()
+		if errors.Is(statErr, os.ErrNotExist) {
+			csvFile.WriteString(metrics.CSVHeader)
+		}
+	}
+	if x.Influx != "" {
+		influxFile, err = os.OpenFile(x.Influx, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer influxFile.Close()
+	}
+
+	ctx, cancel := context.WithCancel(commandCtx)
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	proc.NotifyInterrupt(sigCh)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	var mu sync.Mutex
+	var latest []metrics.Sample
+
+	if x.Listen != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			samples := latest
+			mu.Unlock()
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			metrics.WritePrometheus(w, samples)
+		})
+		server := &http.Server{Addr: x.Listen, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintf(os.Stderr, "Warning: metrics server: %v\n", err)
+			}
+		}()
+		defer server.Shutdown(context.Background())
+		fmt.Fprintf(os.Stderr, "Serving Prometheus metrics on %s/metrics\n", x.Listen)
+	}
+
+	ticker := time.NewTicker(x.Interval)
+	defer ticker.Stop()
+	for {
+		samples, err := collectSamples(client)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			mu.Lock()
+			latest = samples
+			mu.Unlock()
+
+			if csvFile != nil {
+				metrics.WriteCSV(csvFile, samples)
+			}
+			if influxFile != nil {
+				var buf bytes.Buffer
+				metrics.WriteInflux(&buf, samples)
+				influxFile.Write(buf.Bytes())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func collectSamples(client sdm.DeviceAPI) ([]metrics.Sample, error) {
+	devices, err := client.ListDevices()
+	if err != nil {
+		return nil, fmt.Errorf("listing devices: %w", err)
+	}
+	now := time.Now()
+	var samples []metrics.Sample
+	for _, dev := range devices {
+		samples = append(samples, metrics.Extract(dev, deviceDisplayName(dev), now)...)
+	}
+	return samples, nil
+}
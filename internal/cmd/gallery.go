@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/brice/gognestcli/internal/gallery"
+)
+
+type GalleryCmd struct {
+	Dir string `help:"Directory containing event captures" default:"events"`
+	Out string `help:"Output HTML file" default:"index.html"`
+}
+
+func (g *GalleryCmd) Run() error {
+	n, err := gallery.Generate(g.Dir, g.Out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Gallery with %d captures written to %s\n", n, g.Out)
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/brice/gognestcli/internal/capture"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/history"
+	"github.com/brice/gognestcli/internal/proc"
+)
+
+type ImportCmd struct {
+	Dir              string        `help:"Directory containing event captures" default:"events"`
+	FilenameTemplate string        `help:"Template the captures were named with (overrides filename_template in config)"`
+	Watch            bool          `help:"Keep watching the directory and index new captures as they appear" default:"false"`
+	Interval         time.Duration `help:"Scan interval for --watch" default:"1m"`
+}
+
+func (c *ImportCmd) Run() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	tmpl := c.FilenameTemplate
+	if tmpl == "" {
+		tmpl = cfg.FilenameTemplate
+	}
+	namer, err := capture.NewNamer(tmpl)
+	if err != nil {
+		return err
+	}
+
+	log, err := history.Open(c.Dir)
+	if err != nil {
+		return fmt.Errorf("opening history: %w", err)
+	}
+	defer log.Close()
+
+	if !c.Watch {
+		n, err := indexCaptures(c.Dir, namer, log)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Indexed %d capture(s) into %s\n", n, history.FileName)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(commandCtx)
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	proc.NotifyInterrupt(sigCh)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	fmt.Printf("Watching %s for new captures every %s...\n", c.Dir, c.Interval)
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := indexCaptures(c.Dir, namer, log)
+		if err != nil {
+			fmt.Printf("Warning: indexing failed: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("[%s] Indexed %d capture(s)\n", time.Now().Format("15:04:05"), n)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// indexCaptures appends history records for the captures in dir that the
+// history doesn't list yet, oldest first, and returns how many were added.
+func indexCaptures(dir string, namer *capture.Namer, log *history.Log) (int, error) {
+	existing, err := history.Read(dir)
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[string]bool)
+	for _, r := range existing {
+		if r.Kind == history.KindCapture && r.Path != "" {
+			known[r.Path] = true
+		}
+	}
+
+	records, err := capture.Index(dir, namer, func(rel string) bool { return known[rel] })
+	slices.SortFunc(records, func(a, b history.Record) int { return a.Time.Compare(b.Time) })
+	for i, r := range records {
+		if err := log.Append(r); err != nil {
+			return i, err
+		}
+	}
+	return len(records), err
+}
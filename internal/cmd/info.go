@@ -3,11 +3,16 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
+
+	"github.com/brice/gognestcli/internal/sdm"
 )
 
 type InfoCmd struct {
-	DeviceID string `arg:"" optional:"" help:"Device ID or full resource name (uses config default if omitted)"`
+	DeviceIDs []string `arg:"" optional:"" name:"device-id" help:"Device IDs, aliases or full resource names (uses config default if omitted)"`
+	All       bool     `help:"Show every device" default:"false"`
+	Refresh   bool     `help:"Fetch devices from the API instead of the device cache" default:"false"`
 }
 
 func (i *InfoCmd) Run() error {
@@ -15,43 +20,85 @@ func (i *InfoCmd) Run() error {
 	if err != nil {
 		return err
 	}
+	client = cachedClient(client, cfg, i.Refresh)
 
-	deviceName := i.DeviceID
-	if deviceName == "" {
-		deviceName = cfg.DeviceID
-	}
-	if deviceName == "" {
-		// Try to find the first camera device
-		devices, err := client.ListDevices()
+	var devices []*sdm.Device
+	var errs []error
+	var names []string
+	if i.All {
+		// The listing already carries every device's traits.
+		list, err := client.ListDevices()
 		if err != nil {
 			return fmt.Errorf("listing devices: %w", err)
 		}
-		for _, dev := range devices {
-			if strings.Contains(dev.Type, "CAMERA") {
-				deviceName = dev.Name
-				break
-			}
+		for _, dev := range list {
+			devices = append(devices, &dev)
+			errs = append(errs, nil)
+			names = append(names, dev.Name)
 		}
-		if deviceName == "" {
-			return fmt.Errorf("no camera device found; specify a device ID or set device_id in config")
+	} else {
+		ids := i.DeviceIDs
+		if len(ids) == 0 {
+			ids = []string{""}
+		}
+		for _, id := range ids {
+			name, err := resolveDevice(client, cfg, id)
+			if err != nil {
+				return err
+			}
+			names = append(names, name)
 		}
+		devices, errs = fetchDevices(client, names)
 	}
 
-	// Ensure full resource name
-	if !strings.HasPrefix(deviceName, "enterprises/") {
-		deviceName = fmt.Sprintf("enterprises/%s/devices/%s", cfg.ProjectID, deviceName)
+	failed := 0
+	for n, dev := range devices {
+		if errs[n] != nil {
+			if len(devices) == 1 {
+				return fmt.Errorf("getting device: %w", errs[n])
+			}
+			fmt.Fprintf(os.Stderr, "Error: getting %s: %v\n", names[n], errs[n])
+			failed++
+			continue
+		}
+		if n > 0 {
+			fmt.Println()
+		}
+		printDeviceInfo(dev)
 	}
-
-	dev, err := client.GetDevice(deviceName)
-	if err != nil {
-		return fmt.Errorf("getting device: %w", err)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d devices could not be fetched", failed, len(devices))
 	}
+	return nil
+}
 
+// printDeviceInfo prints a device's summary and traits.
+func printDeviceInfo(dev *sdm.Device) {
 	fmt.Printf("Name:  %s\n", dev.Name)
 	fmt.Printf("Type:  %s\n", dev.Type)
 	if dn := deviceDisplayName(*dev); dn != "" {
 		fmt.Printf("Room:  %s\n", dn)
 	}
+	if cn := dev.CustomName(); cn != "" {
+		fmt.Printf("Label: %s\n", cn)
+	}
+	if status := dev.Connectivity(); status != "" {
+		fmt.Printf("Status: %s\n", status)
+	}
+	if ls := dev.LiveStream(); ls != nil {
+		if len(ls.SupportedProtocols) == 0 {
+			fmt.Println("Stream: not available")
+		} else {
+			fmt.Printf("Stream: %s", strings.Join(ls.SupportedProtocols, ", "))
+			if ls.MaxVideoResolution.Width > 0 {
+				fmt.Printf(" (max %dx%d)", ls.MaxVideoResolution.Width, ls.MaxVideoResolution.Height)
+			}
+			fmt.Println()
+			if !ls.SupportsWebRTC() {
+				fmt.Println("        WEB_RTC not supported — snapshot/record/live are unavailable")
+			}
+		}
+	}
 	fmt.Println()
 
 	fmt.Println("Traits:")
@@ -68,5 +115,4 @@ func (i *InfoCmd) Run() error {
 			fmt.Printf("  %s: %s\n", shortName, string(raw))
 		}
 	}
-	return nil
 }
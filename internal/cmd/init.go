@@ -0,0 +1,353 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/auth"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+type InitCmd struct {
+	Manual bool `help:"Paste the OAuth redirect URL instead of using a local callback (for SSH/headless)" default:"false"`
+}
+
+// uuidPattern matches Device Access project IDs.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// wizard holds the state shared by the init steps.
+type wizard struct {
+	in   *bufio.Reader
+	cfg  *config.Config
+	step int
+}
+
+const initSteps = 7
+
+func (w *wizard) heading(title string) {
+	w.step++
+	fmt.Printf("\n[%d/%d] %s\n", w.step, initSteps, title)
+}
+
+// ask prompts for a value, offering def when the answer is empty.
+func (w *wizard) ask(label, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	val, err := w.in.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if val = strings.TrimSpace(val); val == "" {
+		return def, nil
+	}
+	return val, nil
+}
+
+// confirm asks a yes/no question.
+func (w *wizard) confirm(question string, def bool) (bool, error) {
+	hint := "Y/n"
+	if !def {
+		hint = "y/N"
+	}
+	val, err := w.ask(question+" ("+hint+")", "")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(val) {
+	case "":
+		return def, nil
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+func (w *wizard) save() error {
+	warnCommentsDropped()
+	if err := w.cfg.Save(); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+	return nil
+}
+
+func (c *InitCmd) Run(g *Globals) error {
+	if mockServer != nil {
+		return errors.New("init sets up a Google account; --mock needs no setup")
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	w := &wizard{in: bufio.NewReader(os.Stdin), cfg: cfg}
+
+	fmt.Println("This walks through connecting gognestcli to your Nest devices. Settings are")
+	fmt.Println("saved after each step, so it's safe to stop and run init again.")
+
+	if ok, err := w.prerequisites(); err != nil || !ok {
+		return err
+	}
+	if err := w.client(); err != nil {
+		return err
+	}
+	if err := c.redirect(w); err != nil {
+		return err
+	}
+
+	w.heading("Authorize access")
+	if err := authorize(cfg, c.Manual, consentScopes(cfg, true, false)); err != nil {
+		return err
+	}
+
+	tokenFn, err := newTokenFn(cfg)
+	if err != nil {
+		return err
+	}
+	client := newClient(cfg, tokenFn)
+	if err := w.devices(client); err != nil {
+		return err
+	}
+	if err := w.subscription(); err != nil {
+		return err
+	}
+	if err := w.snapshot(g); err != nil {
+		return err
+	}
+
+	fmt.Println("\nSetup complete. Next:")
+	fmt.Println("  gognestcli live          # live view")
+	fmt.Println("  gognestcli events        # capture on motion and person events")
+	fmt.Println("  gognestcli doctor        # re-check everything later")
+	return nil
+}
+
+// prerequisites checks that the console-side setup, which can't be done from
+// here, is in place.
+func (w *wizard) prerequisites() (bool, error) {
+	w.heading("Device Access project")
+	fmt.Println("You need:")
+	fmt.Println("  - a Google Cloud project with the Smart Device Management API enabled")
+	fmt.Println("      https://console.cloud.google.com/apis/api/smartdevicemanagement.googleapis.com")
+	fmt.Println("  - an OAuth 2.0 client (type: Web application) in that project")
+	fmt.Println("      https://console.cloud.google.com/apis/credentials")
+	fmt.Println("  - a Device Access project ($5 one-time fee) using that OAuth client ID")
+	fmt.Println("      https://console.nest.google.com/device-access")
+	ok, err := w.confirm("Are these set up?", true)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		fmt.Println("Create them with the links above, then run: gognestcli init")
+	}
+	return ok, nil
+}
+
+// client collects the OAuth client and Device Access project ID, catching
+// the common mix-ups.
+func (w *wizard) client() error {
+	w.heading("OAuth client")
+	cfg := w.cfg
+	for {
+		id, err := w.ask("Client ID", cfg.ClientID)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(id, ".apps.googleusercontent.com") {
+			cfg.ClientID = id
+			break
+		}
+		fmt.Println("  Client IDs end in .apps.googleusercontent.com (APIs & Services → Credentials).")
+	}
+
+	def := ""
+	if cfg.ClientSecret != "" {
+		def = "keep current"
+	}
+	secret, err := w.ask("Client Secret", def)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return errors.New("client secret cannot be empty")
+	}
+	if secret != "keep current" {
+		cfg.ClientSecret = secret
+	}
+
+	for {
+		id, err := w.ask("Device Access project ID", cfg.ProjectID)
+		if err != nil {
+			return err
+		}
+		if uuidPattern.MatchString(id) {
+			cfg.ProjectID = id
+			break
+		}
+		fmt.Println("  Device Access project IDs are UUIDs, shown in the Device Access Console,")
+		fmt.Println("  not the Google Cloud project ID.")
+		if keep, err := w.confirm("Use it anyway?", false); err != nil {
+			return err
+		} else if keep {
+			cfg.ProjectID = id
+			break
+		}
+	}
+	return w.save()
+}
+
+// redirect checks that Google accepts the redirect URI the OAuth flow will
+// use, before sending the user to a browser error page.
+func (c *InitCmd) redirect(w *wizard) error {
+	w.heading("Redirect URI")
+	uri := auth.DefaultRedirect
+	if c.Manual {
+		uri = manualRedirect
+	}
+	for {
+		err := auth.CheckClient(w.cfg.ClientID, uri)
+		switch {
+		case err == nil:
+			fmt.Printf("  ok: %s is registered\n", uri)
+			return nil
+		case errors.Is(err, auth.ErrUnknownClient):
+			return fmt.Errorf("%w; check the Client ID and run init again", err)
+		case errors.Is(err, auth.ErrRedirectMismatch):
+			fmt.Printf("  Add %s as an Authorized redirect URI of the OAuth client\n", uri)
+			fmt.Println("  (APIs & Services → Credentials → OAuth 2.0 Client). Changes can take a few minutes.")
+		default:
+			fmt.Printf("  Couldn't check the redirect URI: %v\n", err)
+			return nil
+		}
+		if again, err := w.confirm("Check again?", true); err != nil || !again {
+			return err
+		}
+	}
+}
+
+// devices lists the shared devices and picks the default camera.
+func (w *wizard) devices(client sdm.DeviceAPI) error {
+	w.heading("Devices")
+	devices, err := client.ListDevices()
+	switch {
+	case err != nil && strings.Contains(err.Error(), "returned 404"):
+		return fmt.Errorf("%w\nThe Device Access project ID looks wrong; run init again", err)
+	case err != nil && strings.Contains(err.Error(), "returned 403"):
+		return fmt.Errorf("%w\nAuthorize your Nest account for this project, then run init again", err)
+	case err != nil:
+		return err
+	}
+
+	var cameras []sdm.Device
+	for _, dev := range devices {
+		fmt.Printf("  %s (%s)\n", deviceDisplayName(dev), deviceDisplayNameFromFull(dev.Name))
+		if dev.LiveStream() != nil {
+			cameras = append(cameras, dev)
+		}
+	}
+	switch len(cameras) {
+	case 0:
+		fmt.Println("  No cameras shared with this project. Re-run init and select them on the device selection page.")
+		return nil
+	case 1:
+		w.cfg.DeviceID = deviceDisplayNameFromFull(cameras[0].Name)
+	default:
+		fmt.Println("Default camera for commands without --device-id:")
+		for i, dev := range cameras {
+			fmt.Printf("  %d. %s\n", i+1, deviceDisplayName(dev))
+		}
+		for {
+			val, err := w.ask("Number", "1")
+			if err != nil {
+				return err
+			}
+			if n, err := strconv.Atoi(val); err == nil && n >= 1 && n <= len(cameras) {
+				w.cfg.DeviceID = deviceDisplayNameFromFull(cameras[n-1].Name)
+				break
+			}
+		}
+	}
+	fmt.Printf("  Default camera: %s\n", w.cfg.DeviceID)
+	return w.save()
+}
+
+// subscription creates (or checks) the Pub/Sub subscription events needs.
+func (w *wizard) subscription() error {
+	w.heading("Pub/Sub subscription")
+	cfg := w.cfg
+	ctx, cancel := context.WithTimeout(commandCtx, 30*time.Second)
+	defer cancel()
+
+	if cfg.PubSubSub != "" {
+		err := newListener(cfg).CheckSubscription(ctx)
+		if err == nil {
+			fmt.Printf("  ok: %s\n", cfg.PubSubSub)
+			return nil
+		}
+		fmt.Printf("  %s: %v\n", cfg.PubSubSub, err)
+	}
+	ok, err := w.confirm("Create a subscription for events and watch --pubsub?", true)
+	if err != nil || !ok {
+		return err
+	}
+
+	fmt.Println("  Enable events for the Device Access project first; the console then shows its topic.")
+	topic, err := w.ask("Topic", pubsubTopic(cfg))
+	if err != nil {
+		return err
+	}
+	project, err := w.ask("Google Cloud project ID to create it in", "")
+	if err != nil {
+		return err
+	}
+	name, err := w.ask("Subscription name", "gognestcli-events")
+	if err != nil {
+		return err
+	}
+	if project == "" || name == "" {
+		return errors.New("project and subscription name are required")
+	}
+
+	cfg.PubSubSub = fmt.Sprintf("projects/%s/subscriptions/%s", project, name)
+	if topic != pubsubTopic(cfg) {
+		cfg.PubSubTopic = topic
+	}
+	listener := newListener(cfg)
+	if err := listener.CreateSubscription(ctx, topic); err != nil {
+		return fmt.Errorf("creating subscription: %w", err)
+	}
+	if err := listener.CheckSubscription(ctx); err != nil {
+		return fmt.Errorf("checking subscription: %w", err)
+	}
+	fmt.Printf("  ok: %s\n", cfg.PubSubSub)
+	return w.save()
+}
+
+// snapshot takes a test snapshot from the default camera, exercising WebRTC
+// and ffmpeg end to end.
+func (w *wizard) snapshot(g *Globals) error {
+	w.heading("Test snapshot")
+	if w.cfg.DeviceID == "" {
+		fmt.Println("  skipped: no camera")
+		return nil
+	}
+	ok, err := w.confirm("Take a test snapshot?", true)
+	if err != nil || !ok {
+		return err
+	}
+	snap := &SnapshotCmd{Output: "gognestcli-test.jpg"}
+	if err := snap.Run(g); err != nil {
+		fmt.Printf("  Test snapshot failed: %v\n", err)
+		fmt.Println("  Run gognestcli doctor to check ffmpeg and UDP connectivity.")
+	}
+	return nil
+}
@@ -3,26 +3,41 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"os/exec"
-	"os/signal"
+	"runtime"
 	"strings"
+	"time"
 
+	"github.com/brice/gognestcli/internal/proc"
 	"github.com/brice/gognestcli/internal/recorder"
 	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
 	"github.com/pion/webrtc/v4"
 )
 
 type LiveCmd struct {
-	DeviceID string `short:"d" help:"Device ID (uses config default if omitted)"`
+	DeviceID    string `short:"d" help:"Device ID (uses config default if omitted)"`
+	Player      string `help:"Player to show the video in instead of ffplay: mpv, vlc, a path to one of them, or a command line such as \"mpv --profile=low-latency {{.Input}}\" ({{.Input}}, {{.Format}}, {{.FPS}}, {{.Title}} and {{.Latency}} are filled in; without {{.Input}} video goes to its stdin)"`
+	Latency     string `help:"Buffering preset: ultra (lowest delay, may stutter), low, or normal (smoother, about a second behind; better for remote viewers)" enum:"ultra,low,normal" default:"low"`
+	PIP         bool   `name:"pip" help:"Show a small borderless always-on-top window (picture-in-picture)"`
+	PIPSize     string `name:"pip-size" help:"Window size with --pip, as WIDTHxHEIGHT" default:"480x270"`
+	PIPPosition string `name:"pip-position" placeholder:"X,Y" help:"Window position with --pip, in pixels from the top-left corner of the screen (default: let the window manager place it)"`
+	Transport   string `help:"How video reaches the player: stdin, or tcp over a loopback socket (auto uses tcp on Windows, where piping to stdin is unreliable)" enum:"auto,stdin,tcp" default:"auto"`
+	Stats       bool   `help:"Print frame rate, bitrate, dropped packets, time spent waiting for the player and frames skipped because it fell behind to stderr every few seconds"`
 }
 
-func (l *LiveCmd) Run() error {
-	if _, err := exec.LookPath("ffplay"); err != nil {
-		return fmt.Errorf("ffplay is required for live view; install it with: brew install ffmpeg")
+func (l *LiveCmd) Run(g *Globals) error {
+	client, cfg, err := newSDMClient()
+	if err != nil {
+		return err
 	}
 
-	client, cfg, err := newSDMClient()
+	player, err := recorder.FindPlayer(l.Player, cfg.FFplayPath)
+	if err != nil {
+		return fmt.Errorf("a player is required for live view: %w", err)
+	}
+	window, err := l.window()
 	if err != nil {
 		return err
 	}
@@ -31,88 +46,195 @@ func (l *LiveCmd) Run() error {
 	if err != nil {
 		return err
 	}
+	if err := ensureWebRTC(client, deviceName); err != nil {
+		return err
+	}
 
 	fmt.Printf("Starting live view from %s...\n", deviceDisplayNameFromFull(deviceName))
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(commandCtx)
 	defer cancel()
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
+	proc.NotifyInterrupt(sigCh)
 	go func() {
 		<-sigCh
 		fmt.Println("\nStopping live view...")
 		cancel()
 	}()
 
-	// Start ffplay reading H264 from stdin
-	ffplay := exec.CommandContext(ctx, "ffplay",
-		"-f", "h264",
-		"-framerate", "30",
-		"-probesize", "32",
-		"-analyzeduration", "0",
-		"-fflags", "nobuffer",
-		"-flags", "low_delay",
-		"-framedrop",
-		"-window_title", "gognestcli live",
-		"-",
-	)
-	ffplay.Stderr = os.Stderr
-
-	stdinPipe, err := ffplay.StdinPipe()
+	// Start the player reading H264 from stdin or a loopback socket
+	if player.StdinOnly() && l.Transport == "tcp" {
+		return fmt.Errorf("--transport tcp needs {{.Input}} in the player command")
+	}
+	input := "-"
+	var ln net.Listener
+	if !player.StdinOnly() && (l.Transport == "tcp" || (l.Transport == "auto" && runtime.GOOS == "windows")) {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("listening for the player: %w", err)
+		}
+		defer ln.Close()
+		input = "tcp://" + ln.Addr().String()
+	}
+	args, err := player.Args(recorder.PlayerOptions{
+		Input:   input,
+		HWAccel: g.hwaccel(cfg),
+		Latency: l.Latency,
+		Extra:   cfg.FFmpegArgs["live"],
+		Window:  window,
+	})
 	if err != nil {
-		return fmt.Errorf("creating ffplay pipe: %w", err)
+		return err
+	}
+	playerProc := proc.Command(ctx, player.Path, args...)
+	playerProc.Stderr = os.Stderr
+
+	var feed io.WriteCloser
+	if ln == nil {
+		if feed, err = playerProc.StdinPipe(); err != nil {
+			return fmt.Errorf("creating %s pipe: %w", player.Name(), err)
+		}
+	}
+	if err := playerProc.Start(); err != nil {
+		return err
+	}
+
+	// Wait for the player to exit (user closes window) or ctrl-c
+	done := make(chan error, 1)
+	exited := make(chan struct{})
+	go func() {
+		done <- playerProc.Wait()
+		close(exited)
+	}()
+	stop := func() {
+		if feed != nil {
+			feed.Close()
+		}
+		cancel()
+		<-done
 	}
 
-	if err := ffplay.Start(); err != nil {
-		return fmt.Errorf("starting ffplay: %w", err)
+	if ln != nil {
+		conn, err := acceptPlayer(ctx, ln, exited)
+		if err != nil {
+			stop()
+			return fmt.Errorf("%s didn't connect: %w", player.Name(), err)
+		}
+		feed = conn
 	}
 
-	writer := &recorder.PipeH264Writer{W: stdinPipe}
+	writer := &recorder.PipeH264Writer{W: feed, MaxLate: recorder.JitterDepth(l.Latency)}
+	if l.Stats {
+		stopStats := recorder.WatchProgress(writer, statsInterval, printLiveStats)
+		defer stopStats()
+	}
 
 	session, offerSDP, err := nestwebrtc.NewSession(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		if strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264) {
-			fmt.Println("Video track connected, streaming to ffplay...")
+			fmt.Printf("Video track connected, streaming to %s...\n", player.Name())
 			writer.HandleVideoTrack(track, ctx)
 		}
-	})
+	}, g.sessionOptions(cfg)...)
 	if err != nil {
-		stdinPipe.Close()
-		ffplay.Wait()
+		stop()
 		return fmt.Errorf("creating WebRTC session: %w", err)
 	}
 	defer session.Close()
 
-	answerSDP, mediaSessionID, err := client.GenerateWebRTCStream(deviceName, offerSDP)
+	answer, err := client.GenerateWebRTCStream(deviceName, offerSDP)
 	if err != nil {
-		stdinPipe.Close()
-		ffplay.Wait()
+		stop()
 		return fmt.Errorf("generating WebRTC stream: %w", err)
 	}
 
-	err = session.SetAnswer(answerSDP, mediaSessionID,
-		func(msid string) error { return client.ExtendWebRTCStream(deviceName, msid) },
+	err = session.SetAnswer(answer.AnswerSDP, answer.MediaSessionID, answer.ExpiresAt,
+		extendStream(client, deviceName),
 		func(msid string) error { return client.StopWebRTCStream(deviceName, msid) },
 	)
 	if err != nil {
-		stdinPipe.Close()
-		ffplay.Wait()
+		stop()
 		return fmt.Errorf("setting WebRTC answer: %w", err)
 	}
 
-	// Wait for ffplay to exit (user closes window) or ctrl-c
-	done := make(chan error, 1)
-	go func() { done <- ffplay.Wait() }()
-
 	select {
 	case err := <-done:
 		if err != nil && ctx.Err() == nil {
-			return fmt.Errorf("ffplay exited: %w", err)
+			return fmt.Errorf("%s exited: %w", player.Name(), err)
 		}
 	case <-ctx.Done():
-		stdinPipe.Close()
+		feed.Close()
 		<-done
 	}
 
 	return nil
 }
+
+// statsInterval is how often live --stats prints.
+const statsInterval = 2 * time.Second
+
+// printLiveStats prints a line of live view telemetry. Dropped packets point
+// at the network, a low frame rate with none dropped at the camera, and a
+// growing player wait, or frames skipped, at the player.
+func printLiveStats(p recorder.Progress) {
+	if p.Frames == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "  %s  %s  player wait %s  %d skipped\n",
+		p.Elapsed.Truncate(time.Second), streamRates(p), p.Blocked.Round(time.Millisecond), p.Discarded)
+}
+
+// acceptPlayer waits for the player to connect to ln, giving up if it exits
+// first or hasn't connected within 15 seconds.
+func acceptPlayer(ctx context.Context, ln net.Listener, exited <-chan struct{}) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		ch <- result{conn, err}
+	}()
+	var err error
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-exited:
+		err = fmt.Errorf("it exited")
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-time.After(15 * time.Second):
+		err = fmt.Errorf("timed out")
+	}
+	ln.Close()
+	if r := <-ch; r.conn != nil {
+		r.conn.Close()
+	}
+	return nil, err
+}
+
+// window returns the --pip window, or nil without --pip.
+func (l *LiveCmd) window() (*recorder.Window, error) {
+	if !l.PIP {
+		if l.PIPPosition != "" {
+			return nil, fmt.Errorf("--pip-position needs --pip")
+		}
+		return nil, nil
+	}
+	w := &recorder.Window{OnTop: true, Borderless: true}
+	var err error
+	if w.Width, w.Height, err = recorder.ParseSize(l.PIPSize); err != nil {
+		return nil, fmt.Errorf("--pip-size: %w", err)
+	}
+	if w.Width < 0 || w.Height < 0 {
+		return nil, fmt.Errorf("--pip-size: both sides must be given")
+	}
+	if l.PIPPosition != "" {
+		if w.X, w.Y, err = recorder.ParsePosition(l.PIPPosition); err != nil {
+			return nil, fmt.Errorf("--pip-position: %w", err)
+		}
+		w.Positioned = true
+	}
+	return w, nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// logLevel is the level of every console logger: Info, or Debug with
+// --debug.
+var logLevel slog.LevelVar
+
+// consoleHandler prints log records the way commands print their own
+// messages: Info as the bare message, Warn and Error prefixed "Warning: "
+// and "Error: ", and Debug with a timestamp and level like the log package.
+// Attributes follow as key=value, except "err", which follows the message
+// after a colon.
+type consoleHandler struct {
+	w     io.Writer
+	mu    *sync.Mutex
+	attrs []prefixedAttr // from WithAttrs
+	group string         // prefix for keys, from WithGroup
+}
+
+type prefixedAttr struct {
+	prefix string
+	slog.Attr
+}
+
+// newConsoleLogger returns a logger printing to w with consoleHandler.
+func newConsoleLogger(w io.Writer) *slog.Logger {
+	return slog.New(&consoleHandler{w: w, mu: &sync.Mutex{}})
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= logLevel.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b, attrs strings.Builder
+	switch {
+	case r.Level < slog.LevelInfo:
+		b.WriteString(r.Time.Format("2006/01/02 15:04:05 ") + r.Level.String() + " ")
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	}
+	b.WriteString(r.Message)
+
+	for _, a := range h.attrs {
+		appendAttr(&attrs, &b, a.prefix, a.Attr)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&attrs, &b, h.group, a)
+		return true
+	})
+	b.WriteString(attrs.String())
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// appendAttr writes a as " key=value" to attrs, or as ": value" to msg if
+// it's a top-level "err".
+func appendAttr(attrs, msg *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(attrs, msg, prefix, ga)
+		}
+		return
+	}
+	v := a.Value.String()
+	if prefix == "" && a.Key == "err" {
+		msg.WriteString(": " + v)
+		return
+	}
+	if v == "" || strings.ContainsAny(v, " =\"") {
+		v = strconv.Quote(v)
+	}
+	attrs.WriteString(" " + prefix + a.Key + "=" + v)
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clip(h.attrs)
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, prefixedAttr{h.group, a})
+	}
+	return &h2
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group += name + "."
+	return &h2
+}
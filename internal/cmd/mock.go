@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/brice/gognestcli/internal/auth"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/mock"
+	"github.com/brice/gognestcli/internal/pubsub"
+	"github.com/brice/gognestcli/internal/quota"
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+// mockServer is set by --mock. Commands then talk to the in-process fake
+// SDM and Pub/Sub API instead of Google, with no credentials needed.
+var mockServer *mock.Server
+
+// loadConfig loads and validates the config. With --mock, credentials,
+// project and subscription point at the fake API and uploads are disabled;
+// everything else (ffmpeg, policies, templates) is kept.
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, withExitCode(ExitConfig, err)
+	}
+	if mockServer != nil {
+		cfg.ClientID = "mock"
+		cfg.ClientSecret = "mock"
+		cfg.ProjectID = mock.ProjectID
+		cfg.PubSubSub = mock.Subscription
+		cfg.PubSubCredentials = ""
+		cfg.PubSubSubscriptions = nil
+		cfg.DeviceID = ""
+		cfg.Upload = nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, withExitCode(ExitConfig, err)
+	}
+	return cfg, nil
+}
+
+// newClient returns an SDM client for cfg, pointed at the fake API with
+// --mock or at an overridden endpoint (see sdmEndpoint). Stream and event
+// image generation are charged to the shared call budget (see sharedBudget).
+func newClient(cfg *config.Config, tokenFn func() (string, error)) sdm.API {
+	client := sdm.NewClient(cfg.ProjectID, tokenFn)
+	if url := sdmEndpoint(cfg); url != "" {
+		client.SetBaseURL(url)
+	}
+	client.SetContext(commandCtx)
+	return quota.Wrap(client, sharedBudget(cfg))
+}
+
+// newListener returns a Pub/Sub listener for cfg's subscription, pointed at
+// the fake API with --mock or at an overridden endpoint (see
+// pubsubEndpoint), authenticated by pubsubTokenFn.
+func newListener(cfg *config.Config) *pubsub.Listener {
+	fn, _ := pubsubTokenFn(cfg)
+	listener := pubsub.NewListener(cfg.PubSubSub, fn)
+	if url := pubsubEndpoint(cfg); url != "" {
+		listener.SetBaseURL(url)
+	}
+	if cfg.PubSubForwardTopic != "" {
+		listener.ForwardTo(cfg.PubSubForwardTopic)
+	}
+	return listener
+}
+
+// pubsubTopic returns the topic the subscription is created on: pubsub_topic,
+// or the Device Access topic of the project.
+func pubsubTopic(cfg *config.Config) string {
+	if cfg.PubSubTopic != "" {
+		return cfg.PubSubTopic
+	}
+	return "projects/sdm-prod/topics/enterprise-" + cfg.ProjectID
+}
+
+// pubsubTokenFn returns the token source for Pub/Sub calls and who it
+// authenticates as: the user's token limited to the Pub/Sub scope, unless
+// pubsub_credentials names other credentials. If the credentials can't be
+// loaded, every call returns the error, so it surfaces with the first
+// Pub/Sub request.
+func pubsubTokenFn(cfg *config.Config) (fn func() (string, error), identity string) {
+	return pubsubCredentialsFn(cfg, cfg.PubSubCredentials, "pubsub_credentials")
+}
+
+// pubsubCredentialsFn is pubsubTokenFn for credentials set at key in the
+// config file.
+func pubsubCredentialsFn(cfg *config.Config, credentials, key string) (fn func() (string, error), identity string) {
+	if credentials == "" {
+		fn, err := userTokenFn(cfg, auth.PubSubScope)
+		if err != nil {
+			return func() (string, error) { return "", err }, "your Google account"
+		}
+		return fn, "your Google account"
+	}
+	fn, identity, err := auth.CredentialsTokenFn(credentials, auth.PubSubScope)
+	if err != nil {
+		return func() (string, error) { return "", fmt.Errorf("%s: %w", key, err) }, "the " + key + " identity"
+	}
+	return fn, identity
+}
+
+// newListeners returns a listener for pubsub_subscription and one for each
+// of pubsub_subscriptions, labelled, or only those whose labels are in only.
+// With recreate, each recreates its subscription if it's gone (see
+// pubsub.Listener.AutoHeal).
+func newListeners(cfg *config.Config, only []string, recreate bool) ([]*pubsub.Listener, error) {
+	main := newListener(cfg)
+	if recreate {
+		main.AutoHeal(pubsubTopic(cfg))
+	}
+	if len(cfg.PubSubSubscriptions) == 0 {
+		if len(only) > 0 && !slices.Equal(only, []string{config.DefaultSubscriptionLabel}) {
+			return nil, withExitCode(ExitConfig, fmt.Errorf("--subscription: no pubsub_subscriptions configured"))
+		}
+		return []*pubsub.Listener{main}, nil
+	}
+
+	main.SetLabel(config.DefaultSubscriptionLabel)
+	all := []*pubsub.Listener{main}
+	for i, s := range cfg.PubSubSubscriptions {
+		fn, _ := pubsubCredentialsFn(cfg, cmp.Or(s.Credentials, cfg.PubSubCredentials), fmt.Sprintf("pubsub_subscriptions[%d].credentials", i))
+		l := pubsub.NewListener(s.Subscription, fn)
+		if url := pubsubEndpoint(cfg); url != "" {
+			l.SetBaseURL(url)
+		}
+		if cfg.PubSubForwardTopic != "" {
+			l.ForwardTo(cfg.PubSubForwardTopic)
+		}
+		if recreate && s.Topic != "" {
+			l.AutoHeal(s.Topic)
+		}
+		l.SetLabel(s.Label)
+		all = append(all, l)
+	}
+	if len(only) == 0 {
+		return all, nil
+	}
+	var out []*pubsub.Listener
+	for _, label := range only {
+		i := slices.IndexFunc(all, func(l *pubsub.Listener) bool { return l.Label() == label })
+		if i < 0 {
+			return nil, withExitCode(ExitConfig, fmt.Errorf("--subscription: no subscription labelled %q", label))
+		}
+		out = append(out, all[i])
+	}
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/crash"
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+// Device name refreshes: every namesInterval, and when an unknown device
+// shows up, at most every namesMinInterval.
+const (
+	namesInterval    = 15 * time.Minute
+	namesMinInterval = time.Minute
+)
+
+// deviceNames maps device resource names to human-readable names for the
+// events log, filenames and fan-out messages, refreshed from ListDevices.
+type deviceNames struct {
+	client sdm.DeviceAPI
+
+	mu       sync.Mutex
+	names    map[string]string
+	fetched  time.Time
+	fetching bool
+}
+
+func newDeviceNames(client sdm.DeviceAPI) *deviceNames {
+	n := &deviceNames{client: client, names: map[string]string{}}
+	n.refresh()
+	return n
+}
+
+// friendlyName is a device's custom name, else its room, else its ID.
+func friendlyName(dev sdm.Device) string {
+	return cmp.Or(dev.CustomName(), deviceDisplayName(dev))
+}
+
+// refresh reloads the names. If the list can't be fetched, the old names are
+// kept.
+func (n *deviceNames) refresh() {
+	n.mu.Lock()
+	if n.fetching {
+		n.mu.Unlock()
+		return
+	}
+	n.fetching = true
+	n.mu.Unlock()
+
+	devices, err := n.client.ListDevices()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.fetching = false
+	n.fetched = time.Now()
+	if err != nil {
+		return
+	}
+	names := make(map[string]string, len(devices))
+	for _, dev := range devices {
+		names[dev.Name] = friendlyName(dev)
+	}
+	n.names = names
+}
+
+// loop refreshes the names every namesInterval until ctx is done.
+func (n *deviceNames) loop(ctx context.Context) {
+	ticker := time.NewTicker(namesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.refresh()
+		}
+	}
+}
+
+// name returns the human-readable name of deviceName, or its ID while it's
+// unknown; an unknown device triggers a refresh in the background.
+func (n *deviceNames) name(deviceName string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if name, ok := n.names[deviceName]; ok {
+		return name
+	}
+	if !n.fetching && time.Since(n.fetched) >= namesMinInterval {
+		crash.Go("device names", n.refresh)
+	}
+	return deviceDisplayNameFromFull(deviceName)
+}
+
+// friendly returns the human-readable name of deviceName (see deviceNames),
+// or its ID without names (as for the capture command).
+func (e *EventsCmd) friendly(deviceName string) string {
+	if e.names == nil {
+		return deviceDisplayNameFromFull(deviceName)
+	}
+	return e.names.name(deviceName)
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+)
+
+type NetcheckCmd struct {
+	CheckTimeout time.Duration `help:"Timeout for each check" default:"10s"`
+}
+
+// Run tests whether this network can carry WebRTC media from a camera,
+// without contacting one: STUN over UDP, how the NAT maps ports, the ICE
+// candidates a stream would offer and each configured TURN relay.
+func (c *NetcheckCmd) Run(g *Globals) error {
+	d := &doctor{}
+	fmt.Println("Checking network connectivity for camera streams...")
+
+	cfg, err := config.Load()
+	if err != nil {
+		d.warn("config", err.Error(), "checking without TURN relays")
+		cfg = &config.Config{}
+	}
+
+	c.checkNAT(d)
+	c.checkGather(d, g, cfg)
+	c.checkTURN(d, g, cfg)
+
+	if d.failed > 0 {
+		return fmt.Errorf("%d check(s) failed", d.failed)
+	}
+	fmt.Println("All checks passed.")
+	return nil
+}
+
+func (c *NetcheckCmd) checkNAT(d *doctor) {
+	m, err := nestwebrtc.ProbeNAT(c.CheckTimeout)
+	if err != nil {
+		d.fail("UDP (STUN)", err, "allow DNS and outbound UDP, or configure a TURN relay over TCP/TLS")
+		d.skip("NAT mapping", "no STUN response")
+		return
+	}
+	var seen []string
+	for i, addr := range m.Mapped {
+		if addr != "" {
+			seen = append(seen, addr+" via "+m.Servers[i])
+		}
+	}
+	d.ok("UDP (STUN)", "public address "+strings.Join(seen, ", "))
+
+	switch {
+	case len(seen) < 2:
+		d.skip("NAT mapping", "only one STUN server answered")
+	case m.EndpointIndependent():
+		d.ok("NAT mapping", "same public port for every destination; direct connections should work")
+	default:
+		d.warn("NAT mapping", "public port changes per destination (symmetric NAT)",
+			"direct connections to cameras will likely fail here; configure a TURN relay")
+	}
+}
+
+// checkGather gathers candidates as a stream would and reports what kinds
+// the camera could reach.
+func (c *NetcheckCmd) checkGather(d *doctor, g *Globals, cfg *config.Config) {
+	cands, err := nestwebrtc.GatherCandidates(c.CheckTimeout, g.sessionOptions(cfg)...)
+	if err != nil {
+		d.fail("ICE candidates", err, "")
+		return
+	}
+	counts := map[string]int{}
+	for _, cand := range cands {
+		counts[cand.Type]++
+	}
+	var parts []string
+	for _, typ := range []string{"host", "srflx", "relay"} {
+		if counts[typ] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[typ], typ))
+		}
+	}
+	switch {
+	case counts["srflx"]+counts["relay"] > 0:
+		d.ok("ICE candidates", strings.Join(parts, ", "))
+	case len(cands) > 0:
+		d.fail("ICE candidates", fmt.Errorf("only local addresses (%s)", strings.Join(parts, ", ")),
+			"the camera can't reach this machine; allow outbound UDP or configure a TURN relay over TCP/TLS")
+	default:
+		d.fail("ICE candidates", fmt.Errorf("none gathered"), "check network access; with relay_only, check the TURN relays below")
+	}
+}
+
+// checkTURN allocates a relay on each configured TURN server in turn.
+func (c *NetcheckCmd) checkTURN(d *doctor, g *Globals, cfg *config.Config) {
+	if len(cfg.TURN) == 0 {
+		d.skip("TURN", "none configured (see turn in the config)")
+		return
+	}
+	for _, t := range cfg.TURN {
+		name := "TURN " + turnHost(t.URL)
+		opts := append(g.sessionOptions(cfg), nestwebrtc.WithTURN([]nestwebrtc.TURNServer{nestwebrtc.TURNServer(t)}, true))
+		cands, err := nestwebrtc.GatherCandidates(c.CheckTimeout, opts...)
+		if err != nil {
+			d.fail(name, err, "")
+			continue
+		}
+		var relays []string
+		for _, cand := range cands {
+			if cand.Type == "relay" {
+				relays = append(relays, cand.Address+" over "+cand.RelayProtocol)
+			}
+		}
+		if len(relays) == 0 {
+			d.fail(name, fmt.Errorf("no relay allocated by %s", t.URL),
+				"check the URL, username and credential, and that the server's port is reachable (TCP and TLS relays go through HTTPS_PROXY)")
+			continue
+		}
+		d.ok(name, "relay "+strings.Join(relays, ", "))
+	}
+}
+
+// turnHost returns the host of a "turn:host:port?transport=tcp" URL.
+func turnHost(url string) string {
+	_, rest, _ := strings.Cut(url, ":")
+	host, _, _ := strings.Cut(rest, "?")
+	return host
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/brice/gognestcli/internal/presence"
+)
+
+type PresenceCmd struct {
+	State string `arg:"" optional:"" help:"Set the household state: home, away or unknown (prints the current state if omitted)"`
+}
+
+func (p *PresenceCmd) Run() error {
+	if p.State == "" {
+		st, err := presence.Get()
+		if err != nil {
+			return err
+		}
+		fmt.Println(st)
+		return nil
+	}
+
+	st, err := presence.Parse(p.State)
+	if err != nil {
+		return err
+	}
+	if err := presence.Set(st); err != nil {
+		return fmt.Errorf("saving presence: %w", err)
+	}
+	fmt.Printf("Presence set to %s\n", st)
+	return nil
+}
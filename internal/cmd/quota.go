@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/quota"
+)
+
+var (
+	budgetOnce sync.Once
+	budget     *quota.Budget
+)
+
+// sharedBudget returns the process-wide SDM call budget, created from the
+// first config it's asked with. Real runs share usage with other gognestcli
+// processes through quota.json in the state directory; --mock keeps it in
+// memory so simulated calls don't count.
+func sharedBudget(cfg *config.Config) *quota.Budget {
+	budgetOnce.Do(func() {
+		path := ""
+		if mockServer == nil {
+			if p, err := config.StatePath("quota.json"); err == nil {
+				path = p
+			}
+		}
+		budget = quota.New(path, quotaLimits(cfg.Quota))
+	})
+	return budget
+}
+
+// quotaLimits applies the defaults to q: 0 keeps a default, -1 means
+// unlimited. An invalid window falls back to the default; config validate
+// reports it.
+func quotaLimits(q *config.QuotaConfig) quota.Limits {
+	l := quota.Limits{PerDevice: quota.DefaultPerDevice, PerProject: quota.DefaultPerProject, Window: quota.DefaultWindow}
+	if q == nil {
+		return l
+	}
+	limit := func(n, def int) int {
+		switch {
+		case n == 0:
+			return def
+		case n < 0:
+			return 0
+		}
+		return n
+	}
+	l.PerDevice = limit(q.PerDevice, l.PerDevice)
+	l.PerProject = limit(q.PerProject, l.PerProject)
+	if w, err := q.WindowPeriod(); err == nil && w > 0 {
+		l.Window = w
+	}
+	return l
+}
+
+// printQuota writes the --show-quota report. Without a client made during
+// the run, usage recorded by earlier runs is shown.
+func printQuota(w io.Writer) error {
+	b := budget
+	if b == nil {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+		b = sharedBudget(cfg)
+	}
+	fmt.Fprintf(w, "SDM call budget (GenerateWebRtcStream, GenerateImage) per %s:\n", b.Window())
+	for _, u := range b.Report() {
+		limit := "unlimited"
+		if u.Limit > 0 {
+			limit = strconv.Itoa(u.Limit)
+		}
+		line := fmt.Sprintf("  %-40s %d/%s", u.Scope, u.Used, limit)
+		if u.Denied > 0 {
+			line += fmt.Sprintf("  (%d denied in the last 24h)", u.Denied)
+		}
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
@@ -3,23 +3,49 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/brice/gognestcli/internal/capture"
 	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/proc"
 	"github.com/brice/gognestcli/internal/recorder"
+	"github.com/brice/gognestcli/internal/schedule"
 	"github.com/brice/gognestcli/internal/sdm"
 	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
 	"github.com/pion/webrtc/v4"
 )
 
 type RecordCmd struct {
-	Duration int    `short:"d" help:"Recording duration in seconds" default:"15"`
-	Output   string `short:"o" help:"Output file path" default:"recording.mp4"`
-	DeviceID string `help:"Device ID (uses config default if omitted)"`
+	Duration       seconds `short:"d" help:"Recording duration in seconds or as a duration like 10m (0 records until Ctrl-C)" default:"15"`
+	UntilInterrupt bool    `help:"Record until Ctrl-C, reconnecting when the stream session expires (same as --duration 0)"`
+	Output         string  `short:"o" help:"Output file path" default:"recording.mp4"`
+	DeviceID       string  `help:"Device ID (uses config default if omitted)"`
+	Overlay        bool    `help:"Burn the wall-clock time and camera name into the clip (re-encodes)"`
+	Schedule       string  `help:"Record on a cron schedule, e.g. '0 8 * * 1-5', until Ctrl-C; each run is saved with its start time in the file name"`
+	Missed         string  `help:"With --schedule, what to do when started inside a scheduled window: skip it, or catch-up and record the rest" enum:"skip,catch-up" default:"skip"`
 }
 
-func (r *RecordCmd) Run() error {
+func (r *RecordCmd) Run(g *Globals) error {
+	duration := time.Duration(r.Duration)
+	if r.UntilInterrupt {
+		duration = 0
+	}
+	var sched *schedule.Schedule
+	if r.Schedule != "" {
+		if duration <= 0 {
+			return fmt.Errorf("--schedule needs a --duration")
+		}
+		var err error
+		if sched, err = schedule.Parse(r.Schedule); err != nil {
+			return err
+		}
+	}
+
 	client, cfg, err := newSDMClient()
 	if err != nil {
 		return err
@@ -29,26 +55,120 @@ func (r *RecordCmd) Run() error {
 	if err != nil {
 		return err
 	}
+	if err := ensureWebRTC(client, deviceName); err != nil {
+		return err
+	}
+
+	stream := newStreamCheck(client, deviceName, warnStderr)
+	opts := append(g.ffmpegOptions(cfg, "record"),
+		recorder.WithProgress(progressPrinter(duration)),
+		recorder.WithMetadata(clipMetadata(client, deviceName, "")),
+	)
+	if r.Overlay {
+		opts = append(opts, recorder.WithOverlay(deviceLabel(client, deviceName)))
+	}
+
+	// A hub rather than a bare session, so long recordings swap in a new
+	// stream when the old one can't be extended.
+	startStream := nestwebrtc.NewStreamHub(streamStarter(client, deviceName, g.sessionOptions(cfg))).Subscribe
+
+	if sched != nil {
+		return r.recordOnSchedule(g, client, cfg, deviceName, sched, duration, startStream, opts)
+	}
+
+	started := time.Now()
+	if duration == 0 {
+		stop := make(chan struct{})
+		sigCh := make(chan os.Signal, 1)
+		proc.NotifyInterrupt(sigCh)
+		go func() {
+			<-sigCh
+			fmt.Println("\nStopping, saving recording...")
+			close(stop)
+		}()
+		fmt.Printf("Recording %s until Ctrl-C...\n", deviceDisplayNameFromFull(deviceName))
+		err = recorder.RecordUntil(r.Output, stop, startStream, append(opts, stream.option())...)
+	} else {
+		fmt.Printf("Recording %s for %s...\n", deviceDisplayNameFromFull(deviceName), duration)
+		err = recorder.RecordClip(r.Output, duration, startStream, append(opts, stream.option())...)
+	}
+	if err != nil {
+		return fmt.Errorf("recording failed: %w", err)
+	}
+
+	fmt.Printf("Recording saved to %s\n", r.Output)
+	if g.sidecar(cfg) {
+		if err := writeSidecar(client, cfg, deviceName, r.Output, started, stream.fill(capture.Sidecar{})); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: writing sidecar: %v\n", err)
+		}
+	}
+	return nil
+}
 
-	duration := time.Duration(r.Duration) * time.Second
-	fmt.Printf("Recording %s for %s...\n", deviceDisplayNameFromFull(deviceName), duration)
+// recordOnSchedule records for duration at every occurrence of sched until
+// Ctrl-C, saving each run next to r.Output with its start time appended.
+func (r *RecordCmd) recordOnSchedule(g *Globals, client sdm.API, cfg *config.Config, deviceName string, sched *schedule.Schedule, duration time.Duration, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts []recorder.Option) error {
+	ctx, cancel := context.WithCancel(commandCtx)
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	proc.NotifyInterrupt(sigCh)
+	go func() {
+		<-sigCh
+		fmt.Println("\nStopping after the current recording (Ctrl-C again to abort)...")
+		cancel()
+	}()
 
-	err = recorder.RecordClip(r.Output, duration, func(ctx context.Context, handler func(*webrtc.TrackRemote, *webrtc.RTPReceiver)) error {
+	fmt.Printf("Recording %s for %s on schedule %q (next: %s)\n",
+		deviceDisplayNameFromFull(deviceName), duration, sched, sched.Next(time.Now()).Format("Mon Jan 2 15:04"))
+
+	schedule.Loop(ctx, sched, duration, r.Missed == "catch-up", func(length time.Duration) {
+		started := time.Now()
+		output := timestampedPath(r.Output, started)
+		fmt.Printf("Recording %s to %s...\n", length.Truncate(time.Second), output)
+		stream := newStreamCheck(client, deviceName, warnStderr)
+		if err := recorder.RecordClip(output, length, startStream, append(slices.Clip(opts), stream.option())...); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: scheduled recording failed: %v\n", err)
+			return
+		}
+		fmt.Printf("Recording saved to %s\n", output)
+		if g.sidecar(cfg) {
+			if err := writeSidecar(client, cfg, deviceName, output, started, stream.fill(capture.Sidecar{})); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: writing sidecar: %v\n", err)
+			}
+		}
+	})
+	return nil
+}
+
+// timestampedPath inserts t before the extension, e.g. "clip.mp4" →
+// "clip-20240101-080000.mp4".
+func timestampedPath(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + t.Format("20060102-150405") + ext
+}
+
+// streamStarter returns a StreamHub opener that opens a WebRTC session to
+// deviceName and closes it when the hub's context ends, or if it doesn't
+// connect within 30 seconds.
+func streamStarter(client sdm.StreamAPI, deviceName string, opts []nestwebrtc.Option) nestwebrtc.Opener {
+	return func(ctx context.Context, handler func(nestwebrtc.Track), expiring, closed func()) error {
 		session, offerSDP, err := nestwebrtc.NewSession(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-			handler(track, receiver)
-		})
+			handler(track)
+		}, opts...)
 		if err != nil {
 			return err
 		}
+		session.OnExpiringSoon(expiring)
+		session.OnClosed(func(error) { closed() })
 
-		answerSDP, mediaSessionID, err := client.GenerateWebRTCStream(deviceName, offerSDP)
+		answer, err := client.GenerateWebRTCStream(deviceName, offerSDP)
 		if err != nil {
 			session.Close()
 			return fmt.Errorf("generating WebRTC stream: %w", err)
 		}
 
-		err = session.SetAnswer(answerSDP, mediaSessionID,
-			func(msid string) error { return client.ExtendWebRTCStream(deviceName, msid) },
+		err = session.SetAnswer(answer.AnswerSDP, answer.MediaSessionID, answer.ExpiresAt,
+			extendStream(client, deviceName),
 			func(msid string) error { return client.StopWebRTCStream(deviceName, msid) },
 		)
 		if err != nil {
@@ -57,26 +177,106 @@ func (r *RecordCmd) Run() error {
 		}
 
 		go func() {
+			select {
+			case <-session.Connected:
+			case <-time.After(30 * time.Second):
+				// No tracks will come: close it so the hub opens a new
+				// stream for the next reader.
+				session.Close()
+			case <-ctx.Done():
+			}
 			<-ctx.Done()
 			time.Sleep(500 * time.Millisecond)
 			session.Close()
 		}()
 
 		return nil
-	})
+	}
+}
 
-	if err != nil {
-		return fmt.Errorf("recording failed: %w", err)
+// extendStream returns the session's function for extending deviceName's
+// streams. Extensions the API turns down outright (400 or 404: the media
+// session is gone) are reported as nestwebrtc.ErrExtendRejected, so the
+// session stops retrying and a StreamHub replaces the stream instead.
+func extendStream(client sdm.StreamAPI, deviceName string) nestwebrtc.ExtendFunc {
+	return func(msid string) (string, time.Time, error) {
+		stream, err := client.ExtendWebRTCStream(deviceName, msid)
+		if err != nil {
+			if msg := err.Error(); strings.Contains(msg, "returned 400") || strings.Contains(msg, "returned 404") {
+				err = fmt.Errorf("%w: %v", nestwebrtc.ErrExtendRejected, err)
+			}
+			return "", time.Time{}, err
+		}
+		return stream.MediaSessionID, stream.ExpiresAt, nil
 	}
+}
 
-	fmt.Printf("Recording saved to %s\n", r.Output)
+// seconds is a duration flag that accepts plain seconds ("15") as well as Go
+// durations ("10m", "1h30m").
+type seconds time.Duration
+
+func (s *seconds) UnmarshalText(text []byte) error {
+	if n, err := strconv.Atoi(string(text)); err == nil {
+		*s = seconds(time.Duration(n) * time.Second)
+		return nil
+	}
+	d, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (want seconds or e.g. 10m)", text)
+	}
+	*s = seconds(d)
 	return nil
 }
 
-// resolveDevice determines the device name to use, checking the argument,
-// config, or auto-detecting the first camera.
-func resolveDevice(client *sdm.Client, cfg *config.Config, deviceID string) (string, error) {
+// clipMetadata describes a clip from deviceName for its container tags.
+func clipMetadata(client sdm.DeviceAPI, deviceName, eventType string) recorder.Metadata {
+	m := recorder.Metadata{Device: deviceDisplayNameFromFull(deviceName), EventType: eventType}
+	if dev, err := client.GetDevice(deviceName); err == nil {
+		if name := dev.CustomName(); name != "" {
+			m.Device = name
+		}
+		m.Room = deviceRoom(*dev)
+	}
+	return m
+}
+
+// progressPrinter returns a progress callback that draws a single status line
+// on stderr when it is a terminal, and does nothing otherwise. A zero total
+// shows elapsed time only.
+func progressPrinter(total time.Duration) func(recorder.Progress) {
+	if fi, err := os.Stderr.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return func(p recorder.Progress) {
+		elapsed := p.Elapsed.Truncate(time.Second)
+		if total > 0 && elapsed > total {
+			elapsed = total
+		}
+		limit := ""
+		if total > 0 {
+			limit = " / " + total.String()
+		}
+		fmt.Fprintf(os.Stderr, "\r  %s%s  %d frames  %.1f MB  %d keyframes  %s   ",
+			elapsed, limit, p.Frames, float64(p.Bytes)/(1<<20), p.Keyframes, streamRates(p))
+		if total > 0 && p.Elapsed >= total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+// streamRates formats the rolling frame rate and bitrate of p with its
+// dropped packets, to tell a slow camera (low fps) from a lossy network
+// (drops) in a stuttering capture.
+func streamRates(p recorder.Progress) string {
+	return fmt.Sprintf("%.1f fps  %.2f Mbit/s  %d dropped", p.FPS, p.Bitrate/1e6, p.Dropped)
+}
+
+// resolveDevice determines the device name to use, checking the argument
+// (a device ID or an alias from devices in config), config, or
+// auto-detecting the first camera.
+func resolveDevice(client sdm.DeviceAPI, cfg *config.Config, deviceID string) (string, error) {
 	if deviceID != "" {
+		deviceID = cfg.DeviceRef(deviceID)
 		if strings.HasPrefix(deviceID, "enterprises/") {
 			return deviceID, nil
 		}
@@ -100,5 +300,5 @@ func resolveDevice(client *sdm.Client, cfg *config.Config, deviceID string) (str
 			return dev.Name, nil
 		}
 	}
-	return "", fmt.Errorf("no camera device found; specify --device-id or set device_id in config")
+	return "", withExitCode(ExitNotFound, fmt.Errorf("no camera device found; specify --device-id or set device_id in config"))
 }
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/capture"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/history"
+	"github.com/brice/gognestcli/internal/recorder"
+)
+
+// retentionInterval is how often the events command deletes expired
+// captures.
+const retentionInterval = time.Hour
+
+// retentionPeriods returns the retention configured for each device, keyed
+// by device ID as recorded in the history.
+func retentionPeriods(cfg *config.Config) (map[string]time.Duration, error) {
+	periods := map[string]time.Duration{}
+	for key, d := range cfg.Devices {
+		period, err := d.RetentionPeriod()
+		if err != nil {
+			return nil, fmt.Errorf("devices.%s: %w", key, err)
+		}
+		if period > 0 {
+			periods[deviceDisplayNameFromFull(cfg.DeviceRef(key))] = period
+		}
+	}
+	return periods, nil
+}
+
+// retentionLoop deletes captures past their device's retention at startup
+// and then every retentionInterval.
+func (e *EventsCmd) retentionLoop(ctx context.Context, periods map[string]time.Duration) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		n, err := pruneCaptures(e.OutputDir, periods, time.Now())
+		if err != nil {
+			fmt.Printf("Warning: pruning captures failed: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("Deleted %d capture(s) past their retention\n", n)
+			e.refreshGallery()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneCaptures deletes the captures in dir's history that are older than
+// their device's retention period, with their sidecars and done markers,
+// and removes directories under dir left empty. It returns how many
+// captures were deleted.
+func pruneCaptures(dir string, periods map[string]time.Duration, now time.Time) (int, error) {
+	records, err := history.Read(dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, r := range records {
+		period, ok := periods[r.Device]
+		if r.Kind != history.KindCapture || !ok || now.Sub(r.Time) < period {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(r.Path))
+		if err := os.Remove(path); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				fmt.Printf("  Warning: %v\n", err)
+			}
+			continue
+		}
+		n++
+		os.Remove(path + capture.SidecarSuffix)
+		os.Remove(path + recorder.DoneSuffix)
+		removeEmptyDirs(filepath.Dir(path), dir)
+	}
+	return n, nil
+}
+
+// removeEmptyDirs removes from and its parents while they are empty,
+// stopping at root. Directories outside root are left alone.
+func removeEmptyDirs(from, root string) {
+	for {
+		rel, err := filepath.Rel(root, from)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return
+		}
+		if os.Remove(from) != nil {
+			return
+		}
+		from = filepath.Dir(from)
+	}
+}
@@ -1,14 +1,55 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/crash"
+	"github.com/brice/gognestcli/internal/httpdebug"
+	"github.com/brice/gognestcli/internal/mock"
+	"github.com/brice/gognestcli/internal/proc"
+	"github.com/brice/gognestcli/internal/recorder"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
 )
 
 var version = "dev"
 
+// commandCtx is the parent of every context a command creates. It carries
+// the --timeout deadline, so API calls, streams and ffmpeg all give up when
+// it passes.
+var commandCtx = context.Background()
+
+// Globals holds flags shared by all commands. Commands that need them take
+// a *Globals argument in Run.
+type Globals struct {
+	Debug        bool          `help:"Log debug output to stderr, including ffmpeg/ffplay stderr"`
+	DebugHTTP    string        `name:"debug-http" placeholder:"FILE" help:"Append every SDM, Pub/Sub and token request and response (with credentials redacted) to FILE"`
+	VideoProfile string        `help:"H264 profile to request: baseline, main, high, or any to let the camera choose (overrides video_profile in config)" enum:",baseline,main,high,any" default:""`
+	Verify       bool          `help:"Verify captures after muxing (JPEG decode, MP4 moov atom, ffprobe duration) and re-mux once if broken (or set verify in config)"`
+	DoneMarker   bool          `help:"Write an empty <file>.done marker after each capture is complete (or set done_marker in config)"`
+	Sidecar      bool          `help:"Write a <file>.json metadata sidecar (device, room, event, timestamps, resolution, SHA256) next to each capture (or set sidecar in config)"`
+	Mock         bool          `help:"Use an in-process fake SDM/Pub/Sub API with simulated devices instead of Google (no account or hardware needed)"`
+	HWAccel      string        `name:"hwaccel" help:"Hardware video decoding for ffmpeg/ffplay: auto, vaapi, videotoolbox, nvenc, qsv, or v4l2m2m (overrides hwaccel in config)" enum:",auto,vaapi,videotoolbox,nvenc,qsv,v4l2m2m" default:""`
+	ClipAudio    string        `name:"clip-audio" help:"Record the camera microphone into clips: aac (transcoded, for MP4 players and cloud services), copy (kept as Opus) or none (overrides clip_audio in config)" enum:",none,aac,copy" default:""`
+	ICEInterface []string      `name:"ice-interface" placeholder:"NAME" help:"Only use this network interface for WebRTC (repeatable, shell patterns like en* allowed; overrides ice_interfaces in config)"`
+	ICENetwork   string        `name:"ice-network" help:"Only use ipv4 or ipv6 addresses for WebRTC (overrides ice_network in config)" enum:",ipv4,ipv6" default:""`
+	ICEUDPPorts  string        `name:"ice-udp-ports" placeholder:"MIN-MAX" help:"Take WebRTC's UDP ports from this range, e.g. 50000-50100, for firewall rules (overrides ice_udp_ports in config)"`
+	ShowQuota    bool          `name:"show-quota" help:"Print SDM stream/image call budget usage per device and project to stderr when the command finishes"`
+	Timeout      time.Duration `help:"Abort the whole command (API calls, stream setup, ffmpeg) if it hasn't finished after this long, e.g. 30s (0 = no limit)" default:"0"`
+	Profile      string        `help:"Use a separate config, state and set of stored credentials (e.g. for a second account or project)"`
+	StateDir     string        `name:"state-dir" placeholder:"DIR" type:"path" help:"Directory for runtime state: device cache, retry queue, call budget and presence (default: $XDG_STATE_HOME/gognestcli, or the user cache dir on macOS and Windows)"`
+}
+
 type CLI struct {
+	Globals
+
+	Init     InitCmd     `cmd:"" help:"Guided first-run setup: OAuth client, authorization, devices, Pub/Sub and a test snapshot"`
 	Auth     AuthCmd     `cmd:"" help:"Authenticate with Google Nest"`
 	Devices  DevicesCmd  `cmd:"" help:"List Nest devices"`
 	Info     InfoCmd     `cmd:"" help:"Show camera details"`
@@ -16,27 +57,182 @@ type CLI struct {
 	Record   RecordCmd   `cmd:"" help:"Record a video clip"`
 	Live     LiveCmd     `cmd:"" help:"Live view via ffplay"`
 	Stream   StreamCmd   `cmd:"" help:"Stream raw H264 to stdout"`
+	Talk     TalkCmd     `cmd:"" help:"Send microphone audio to a doorbell or camera speaker"`
 	Events   EventsCmd   `cmd:"" help:"Listen for motion/person events"`
+	Capture  CaptureCmd  `cmd:"" help:"Capture a snapshot and/or clip now, saved, published and uploaded like event captures"`
+	Watch    WatchCmd    `cmd:"" help:"Print device trait changes over time"`
+	Command  CommandCmd  `cmd:"" help:"Execute a raw SDM command and print the results"`
+	Exporter ExporterCmd `cmd:"" help:"Export thermostat and sensor metrics (Prometheus, CSV, InfluxDB)"`
+	Gallery  GalleryCmd  `cmd:"" help:"Build a static HTML gallery of captures"`
+	Digest   DigestCmd   `cmd:"" help:"Summarize recent events from the capture history"`
+	Import   ImportCmd   `cmd:"" help:"Index captures added to an output dir by other tools into its history"`
+	Presence PresenceCmd `cmd:"" help:"Show or set home/away state for presence-aware capturing"`
+	Top      TopCmd      `cmd:"" help:"Live dashboard of cameras, captures in progress, disk usage and events for an events output dir"`
+	Doctor   DoctorCmd   `cmd:"" help:"Check config, credentials, API access, ffmpeg and network, with suggested fixes"`
+	Netcheck NetcheckCmd `cmd:"" help:"Test STUN, NAT behaviour, ICE candidates and TURN relays for camera streams on this network"`
+	Config   ConfigCmd   `cmd:"" help:"Validate the config file or print its schema"`
+	Update   UpdateCmd   `cmd:"" help:"Replace this binary with the latest GitHub release, after verifying its checksum"`
 	Version  VersionCmd  `cmd:"" help:"Print version"`
 }
 
-type VersionCmd struct{}
+type VersionCmd struct {
+	Check bool `help:"Also check GitHub for a newer release"`
+}
 
 func (v *VersionCmd) Run() error {
 	fmt.Println("gognestcli", version)
-	return nil
+	if !v.Check {
+		return nil
+	}
+	return (&UpdateCmd{Check: true}).Run()
+}
+
+// sessionOptions returns WebRTC session options from config and global flags.
+func (g *Globals) sessionOptions(cfg *config.Config) []nestwebrtc.Option {
+	profile := cfg.VideoProfile
+	if g.VideoProfile != "" {
+		profile = g.VideoProfile
+	}
+	turn := make([]nestwebrtc.TURNServer, len(cfg.TURN))
+	for i, t := range cfg.TURN {
+		turn[i] = nestwebrtc.TURNServer(t)
+	}
+	ifaces := cfg.ICEInterfaces
+	if len(g.ICEInterface) > 0 {
+		ifaces = g.ICEInterface
+	}
+	family := cfg.ICENetwork
+	if g.ICENetwork != "" {
+		family = g.ICENetwork
+	}
+	ports := cfg.ICEUDPPorts
+	if g.ICEUDPPorts != "" {
+		ports = g.ICEUDPPorts
+	}
+	return []nestwebrtc.Option{
+		nestwebrtc.WithVideoProfile(profile),
+		nestwebrtc.WithH264Fmtp(cfg.H264Fmtp),
+		nestwebrtc.WithTURN(turn, cfg.RelayOnly),
+		nestwebrtc.WithInterfaces(ifaces),
+		nestwebrtc.WithIPFamily(family),
+		nestwebrtc.WithUDPPorts(ports),
+	}
 }
 
-func Execute() int {
+// ffmpegOptions returns recorder options for the configured ffmpeg binary,
+// the extra arguments for command, hardware acceleration, verification and
+// completion markers.
+func (g *Globals) ffmpegOptions(cfg *config.Config, command string) []recorder.Option {
+	opts := []recorder.Option{
+		recorder.WithFFmpeg(cfg.FFmpegPath, cfg.FFmpegArgs[command]...),
+		recorder.WithHWAccel(g.hwaccel(cfg)),
+		recorder.WithAudio(g.clipAudio(cfg)),
+		recorder.WithContext(commandCtx),
+	}
+	if g.Verify || cfg.Verify {
+		opts = append(opts, recorder.WithVerify())
+	}
+	if g.doneMarker(cfg) {
+		opts = append(opts, recorder.WithDoneMarker())
+	}
+	return opts
+}
+
+// doneMarker reports whether completion markers are enabled by flag or config.
+func (g *Globals) doneMarker(cfg *config.Config) bool {
+	return g.DoneMarker || cfg.DoneMarker
+}
+
+// clipAudio returns the clip audio mode from the flag or config.
+func (g *Globals) clipAudio(cfg *config.Config) string {
+	if g.ClipAudio != "" {
+		return g.ClipAudio
+	}
+	return cfg.ClipAudio
+}
+
+// hwaccel returns the hardware accelerator from the flag or config.
+func (g *Globals) hwaccel(cfg *config.Config) string {
+	if g.HWAccel != "" {
+		return g.HWAccel
+	}
+	return cfg.HWAccel
+}
+
+func Execute() (code int) {
+	// Last resort for a panic outside the goroutines that recover their own:
+	// report it and flush state, then exit instead of crashing.
+	defer func() {
+		if v := recover(); v != nil {
+			crash.Fatal("main", v)
+			proc.Cleanup()
+			code = ExitError
+		}
+	}()
 	var cli CLI
 	ctx := kong.Parse(&cli,
 		kong.Name("gognestcli"),
 		kong.Description("CLI for Google Nest cameras via the Smart Device Management API"),
 		kong.UsageOnError(),
 	)
-	if err := ctx.Run(); err != nil {
+	if cli.Debug {
+		logLevel.Set(slog.LevelDebug)
+	}
+	slog.SetDefault(newConsoleLogger(os.Stderr))
+	proc.HandleSignals()
+	defer proc.Cleanup()
+	config.KeyFunc = configKey
+	if err := config.SetProfile(cli.Profile); err != nil {
+		fmt.Fprintf(ctx.Stderr, "Error: %v\n", err)
+		return ExitConfig
+	}
+	config.SetStateDir(cli.StateDir)
+	if cli.DebugHTTP != "" {
+		f, err := os.OpenFile(cli.DebugHTTP, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		// Every API client uses the default transport, so wrapping it covers
+		// SDM, Pub/Sub, token refresh and uploads alike.
+		http.DefaultTransport = httpdebug.New(f, http.DefaultTransport)
+	}
+	if cli.Mock {
+		srv, err := mock.Start()
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer srv.Close()
+		mockServer = srv
+		fmt.Fprintf(ctx.Stderr, "Using mock SDM API at %s\n", srv.URL)
+	}
+	if cli.Timeout > 0 {
+		var cancel context.CancelFunc
+		commandCtx, cancel = context.WithTimeout(context.Background(), cli.Timeout)
+		defer cancel()
+		// Backstop for anything that doesn't watch the context (a blocking
+		// read in a library): give it a moment to unwind, then exit anyway.
+		stop := time.AfterFunc(cli.Timeout+10*time.Second, func() {
+			fmt.Fprintf(ctx.Stderr, "Error: timed out after %s\n", cli.Timeout)
+			proc.Cleanup()
+			os.Exit(ExitTimeout)
+		})
+		defer stop.Stop()
+	}
+	err := ctx.Run(&cli.Globals)
+	if cli.ShowQuota {
+		if qerr := printQuota(ctx.Stderr); qerr != nil {
+			fmt.Fprintf(ctx.Stderr, "Error: %v\n", qerr)
+		}
+	}
+	if err != nil && commandCtx.Err() != nil {
+		err = fmt.Errorf("timed out after %s: %w", cli.Timeout, err)
+	}
+	if err != nil {
 		fmt.Fprintf(ctx.Stderr, "Error: %v\n", err)
-		return 1
+		return exitCode(err)
 	}
 	return 0
 }
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brice/gognestcli/internal/capture"
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/recorder"
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+// sidecar reports whether metadata sidecars are enabled by flag or config.
+func (g *Globals) sidecar(cfg *config.Config) bool {
+	return g.Sidecar || cfg.Sidecar
+}
+
+// writeSidecar completes s with the device's name and room and the capture's
+// resolution and duration, then writes it next to path. started is when the
+// capture began. A resolution in s, from the stream, is kept when the file
+// can't be inspected.
+func writeSidecar(client sdm.DeviceAPI, cfg *config.Config, deviceName, path string, started time.Time, s capture.Sidecar) error {
+	s.Device = deviceDisplayNameFromFull(deviceName)
+	if dev, err := client.GetDevice(deviceName); err == nil {
+		s.DeviceName = dev.CustomName()
+		s.Room = deviceRoom(*dev)
+	}
+	s.Started = started
+	s.Finished = time.Now()
+
+	info, err := recorder.Inspect(path, cfg.FFmpegPath)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if info.Width > 0 {
+		s.Width, s.Height = info.Width, info.Height
+	}
+	s.Duration = info.Duration.Seconds()
+
+	return capture.WriteSidecar(path, s)
+}
@@ -1,23 +1,39 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
-
+	"github.com/brice/gognestcli/internal/capture"
+	"github.com/brice/gognestcli/internal/history"
+	"github.com/brice/gognestcli/internal/proc"
 	"github.com/brice/gognestcli/internal/recorder"
-	"github.com/pion/webrtc/v4"
+	"github.com/brice/gognestcli/internal/sdm"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
 )
 
 type SnapshotCmd struct {
 	Output   string `short:"o" help:"Output file path" default:"snapshot.jpg"`
 	DeviceID string `short:"d" help:"Device ID (uses config default if omitted)"`
+	Quality  int    `help:"JPEG quality from 1 to 100 (default: best)"`
+	Scale    string `help:"Scale to WIDTHxHEIGHT, e.g. 1280x720 or 640x-1 to keep aspect ratio"`
+	Crop     string `help:"Crop to WIDTHxHEIGHT+X+Y before scaling, e.g. 1920x1080+960+0"`
+	Overlay  bool   `help:"Burn the capture time and camera name into the image"`
+
+	FromLastEvent bool          `help:"Download the image of the device's latest event from the events history instead of starting a stream, falling back to a live snapshot when there's none recent enough"`
+	EventsDir     string        `help:"Output dir of the events command, whose history --from-last-event reads" default:"events"`
+	MaxAge        time.Duration `help:"With --from-last-event, ignore events older than this (SDM event images expire after about 30 seconds unless events already saved them)" default:"30s"`
 }
 
-func (s *SnapshotCmd) Run() error {
+func (s *SnapshotCmd) Run(g *Globals) error {
+	imageOpts, err := s.imageOptions()
+	if err != nil {
+		return err
+	}
+
 	client, cfg, err := newSDMClient()
 	if err != nil {
 		return err
@@ -27,49 +43,147 @@ func (s *SnapshotCmd) Run() error {
 	if err != nil {
 		return err
 	}
-
-	fmt.Printf("Taking snapshot from %s...\n", deviceDisplayNameFromFull(deviceName))
-
-	err = recorder.TakeSnapshot(s.Output, func(ctx context.Context, handler func(*webrtc.TrackRemote, *webrtc.RTPReceiver)) error {
-		session, offerSDP, err := nestwebrtc.NewSession(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-			handler(track, receiver)
-		})
+	if s.FromLastEvent {
+		if len(imageOpts) > 0 || s.Overlay {
+			return fmt.Errorf("--from-last-event saves the event image as is; drop --quality, --scale, --crop and --overlay")
+		}
+		started := time.Now()
+		event, err := s.fromLastEvent(client, deviceName, g.doneMarker(cfg))
 		if err != nil {
 			return err
 		}
-
-		answerSDP, mediaSessionID, err := client.GenerateWebRTCStream(deviceName, offerSDP)
-		if err != nil {
-			session.Close()
-			return fmt.Errorf("generating WebRTC stream: %w", err)
+		if event != nil {
+			fmt.Printf("Snapshot saved to %s\n", s.Output)
+			if g.sidecar(cfg) {
+				err := writeSidecar(client, cfg, deviceName, s.Output, started, capture.Sidecar{
+					EventType: event.Type,
+					EventID:   event.EventID,
+					EventTime: event.Time,
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: writing sidecar: %v\n", err)
+				}
+			}
+			return nil
 		}
+	}
+	if err := ensureWebRTC(client, deviceName); err != nil {
+		return err
+	}
 
-		err = session.SetAnswer(answerSDP, mediaSessionID,
-			func(msid string) error { return client.ExtendWebRTCStream(deviceName, msid) },
-			func(msid string) error { return client.StopWebRTCStream(deviceName, msid) },
-		)
-		if err != nil {
-			session.Close()
-			return err
-		}
+	if s.Overlay {
+		imageOpts = append(imageOpts, recorder.WithOverlay(deviceLabel(client, deviceName)))
+	}
 
-		go func() {
-			<-ctx.Done()
-			time.Sleep(500 * time.Millisecond)
-			session.Close()
-		}()
+	fmt.Printf("Taking snapshot from %s...\n", deviceDisplayNameFromFull(deviceName))
+	started := time.Now()
 
-		return nil
-	})
+	startStream := nestwebrtc.NewStreamHub(streamStarter(client, deviceName, g.sessionOptions(cfg))).Subscribe
+	stream := newStreamCheck(client, deviceName, warnStderr)
+	imageOpts = append(imageOpts, stream.option())
+	err = recorder.TakeSnapshot(s.Output, startStream, append(g.ffmpegOptions(cfg, "snapshot"), imageOpts...)...)
 
 	if err != nil {
 		return fmt.Errorf("snapshot failed: %w", err)
 	}
 
 	fmt.Printf("Snapshot saved to %s\n", s.Output)
+	if g.sidecar(cfg) {
+		if err := writeSidecar(client, cfg, deviceName, s.Output, started, stream.fill(capture.Sidecar{})); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: writing sidecar: %v\n", err)
+		}
+	}
 	return nil
 }
 
+// fromLastEvent saves the image of deviceName's latest event within MaxAge
+// to Output: a copy of the image events already saved for it, or else a
+// fresh download via CameraEventImage. With marker, a completion marker is
+// written as for other captures. It returns the event used, or nil
+// when there is none and a live snapshot is needed.
+func (s *SnapshotCmd) fromLastEvent(client sdm.StreamAPI, deviceName string, marker bool) (*history.Record, error) {
+	records, err := history.Read(s.EventsDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	device := deviceDisplayNameFromFull(deviceName)
+	var event *history.Record
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Kind == history.KindEvent && r.Device == device && r.EventID != "" {
+			event = &records[i]
+			break
+		}
+	}
+	if event == nil || time.Since(event.Time) > s.MaxAge {
+		fmt.Printf("No event from %s in the last %s in %s; taking a live snapshot\n", device, s.MaxAge, filepath.Join(s.EventsDir, history.FileName))
+		return nil, nil
+	}
+
+	tmp := recorder.PartialPath(s.Output)
+	proc.AddTemp(tmp)
+	defer proc.RemoveTemp(tmp)
+
+	// Prefer the image events already saved, which needs no API call.
+	saved := false
+	for _, r := range records {
+		if r.Kind != history.KindCapture || r.EventID != event.EventID || !strings.EqualFold(filepath.Ext(r.Path), ".jpg") {
+			continue
+		}
+		src := filepath.FromSlash(r.Path)
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(s.EventsDir, src)
+		}
+		if data, err := os.ReadFile(src); err == nil && os.WriteFile(tmp, data, 0644) == nil {
+			fmt.Printf("Using the %s image events saved at %s\n", event.Type, event.Time.Local().Format("15:04:05"))
+			saved = true
+			break
+		}
+	}
+
+	if !saved {
+		fmt.Printf("Downloading the %s image from %s at %s...\n", event.Type, device, event.Time.Local().Format("15:04:05"))
+		img, err := client.GenerateEventImage(deviceName, event.EventID)
+		if err != nil {
+			fmt.Printf("Event image unavailable (%v); taking a live snapshot\n", err)
+			return nil, nil
+		}
+		if err := client.DownloadEventImage(img, tmp); err != nil {
+			return nil, fmt.Errorf("downloading event image: %w", err)
+		}
+	}
+	if err := recorder.Commit(tmp, s.Output, marker); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// imageOptions converts the quality, scale and crop flags to recorder options.
+func (s *SnapshotCmd) imageOptions() ([]recorder.Option, error) {
+	var opts []recorder.Option
+	if s.Quality != 0 {
+		if s.Quality < 1 || s.Quality > 100 {
+			return nil, fmt.Errorf("--quality must be between 1 and 100")
+		}
+		opts = append(opts, recorder.WithJPEGQuality(s.Quality))
+	}
+	if s.Scale != "" {
+		w, h, err := recorder.ParseSize(s.Scale)
+		if err != nil {
+			return nil, fmt.Errorf("--scale: %w", err)
+		}
+		opts = append(opts, recorder.WithScale(w, h))
+	}
+	if s.Crop != "" {
+		w, h, x, y, err := recorder.ParseCrop(s.Crop)
+		if err != nil {
+			return nil, fmt.Errorf("--crop: %w", err)
+		}
+		opts = append(opts, recorder.WithCrop(w, h, x, y))
+	}
+	return opts, nil
+}
+
 func deviceDisplayNameFromFull(name string) string {
 	parts := strings.Split(name, "/")
 	if len(parts) > 0 {
@@ -4,19 +4,40 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/brice/gognestcli/internal/proc"
 	"github.com/brice/gognestcli/internal/recorder"
 	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
 	"github.com/pion/webrtc/v4"
 )
 
 type StreamCmd struct {
-	DeviceID string `short:"d" help:"Device ID (uses config default if omitted)"`
+	DeviceID   string `short:"d" help:"Device ID (uses config default if omitted)"`
+	BinarySafe bool   `help:"Guarantee stdout carries only H264: refuse to write to a terminal and send anything else printed to stderr" default:"false"`
+
+	CMAF        string `help:"Write CMAF (fragmented MP4) segments with an init.mp4 and an HLS playlist instead of H264 to stdout: into this directory, or with PUT under this http(s) URL" placeholder:"DIR|URL"`
+	SegmentSecs int    `help:"With --cmaf, minimum segment length in seconds; segments start at keyframes" default:"2"`
+	Window      int    `help:"With --cmaf, segments listed in the playlist; older ones are deleted (0 keeps them all)" default:"0"`
 }
 
-func (s *StreamCmd) Run() error {
+func (s *StreamCmd) Run(g *Globals) error {
+	// Status messages go to stderr; stdout is the video. With --binary-safe,
+	// os.Stdout itself points at stderr for the rest of the run, so nothing
+	// printed anywhere in the process can end up in the stream.
+	out := os.Stdout
+	if s.CMAF != "" {
+		return s.runCMAF(g)
+	}
+	if s.BinarySafe {
+		if fi, err := out.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("stdout is a terminal; pipe the stream to a player or redirect it to a file")
+		}
+		os.Stdout = os.Stderr
+	}
+
 	client, cfg, err := newSDMClient()
 	if err != nil {
 		return err
@@ -26,15 +47,18 @@ func (s *StreamCmd) Run() error {
 	if err != nil {
 		return err
 	}
+	if err := ensureWebRTC(client, deviceName); err != nil {
+		return err
+	}
 
 	fmt.Fprintf(os.Stderr, "Streaming H264 from %s to stdout...\n", deviceDisplayNameFromFull(deviceName))
 	fmt.Fprintf(os.Stderr, "Pipe to a player: gognestcli stream | ffplay -f h264 -\n")
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(commandCtx)
 	defer cancel()
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
+	proc.NotifyInterrupt(sigCh)
 	go func() {
 		<-sigCh
 		fmt.Fprintf(os.Stderr, "\nStopping stream...\n")
@@ -42,26 +66,28 @@ func (s *StreamCmd) Run() error {
 	}()
 
 	// Write raw H264 directly to stdout
-	writer := &recorder.StdoutH264Writer{}
+	writer := &recorder.PipeH264Writer{W: out}
 
 	session, offerSDP, err := nestwebrtc.NewSession(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		if strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264) {
 			fmt.Fprintf(os.Stderr, "Video track connected\n")
 			writer.HandleVideoTrack(track, ctx)
+			// The reader went away (e.g. the player was closed).
+			cancel()
 		}
-	})
+	}, g.sessionOptions(cfg)...)
 	if err != nil {
 		return fmt.Errorf("creating WebRTC session: %w", err)
 	}
 	defer session.Close()
 
-	answerSDP, mediaSessionID, err := client.GenerateWebRTCStream(deviceName, offerSDP)
+	answer, err := client.GenerateWebRTCStream(deviceName, offerSDP)
 	if err != nil {
 		return fmt.Errorf("generating WebRTC stream: %w", err)
 	}
 
-	err = session.SetAnswer(answerSDP, mediaSessionID,
-		func(msid string) error { return client.ExtendWebRTCStream(deviceName, msid) },
+	err = session.SetAnswer(answer.AnswerSDP, answer.MediaSessionID, answer.ExpiresAt,
+		extendStream(client, deviceName),
 		func(msid string) error { return client.StopWebRTCStream(deviceName, msid) },
 	)
 	if err != nil {
@@ -71,3 +97,110 @@ func (s *StreamCmd) Run() error {
 	<-ctx.Done()
 	return nil
 }
+
+// runCMAF streams into CMAF segments at --cmaf until Ctrl-C or the stream
+// ends, then finishes the playlist.
+func (s *StreamCmd) runCMAF(g *Globals) error {
+	if s.SegmentSecs <= 0 {
+		return fmt.Errorf("--segment-secs must be positive")
+	}
+	var sink recorder.ChunkSink
+	if strings.HasPrefix(s.CMAF, "http://") || strings.HasPrefix(s.CMAF, "https://") {
+		sink = recorder.NewHTTPSink(s.CMAF, "")
+	} else {
+		dir, err := recorder.NewDirSink(s.CMAF)
+		if err != nil {
+			return err
+		}
+		sink = dir
+	}
+
+	client, cfg, err := newSDMClient()
+	if err != nil {
+		return err
+	}
+	deviceName, err := resolveDevice(client, cfg, s.DeviceID)
+	if err != nil {
+		return err
+	}
+	if err := ensureWebRTC(client, deviceName); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(commandCtx)
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	proc.NotifyInterrupt(sigCh)
+	go func() {
+		<-sigCh
+		fmt.Printf("\nStopping stream...\n")
+		cancel()
+	}()
+
+	watched := &watchedSink{ChunkSink: sink, cancel: cancel}
+	writer := recorder.NewH264WriterTo(recorder.NewCMAFWriter(watched, time.Duration(s.SegmentSecs)*time.Second, s.Window))
+	fmt.Printf("Streaming CMAF from %s to %s (playlist %s)...\n", deviceDisplayNameFromFull(deviceName), s.CMAF, recorder.CMAFPlaylist)
+
+	session, offerSDP, err := nestwebrtc.NewSession(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264) {
+			fmt.Printf("Video track connected\n")
+			writer.HandleVideoTrack(track, ctx)
+			cancel()
+		}
+	}, g.sessionOptions(cfg)...)
+	if err != nil {
+		return fmt.Errorf("creating WebRTC session: %w", err)
+	}
+	defer session.Close()
+
+	answer, err := client.GenerateWebRTCStream(deviceName, offerSDP)
+	if err != nil {
+		return fmt.Errorf("generating WebRTC stream: %w", err)
+	}
+
+	err = session.SetAnswer(answer.AnswerSDP, answer.MediaSessionID, answer.ExpiresAt,
+		extendStream(client, deviceName),
+		func(msid string) error { return client.StopWebRTCStream(deviceName, msid) },
+	)
+	if err != nil {
+		return fmt.Errorf("setting WebRTC answer: %w", err)
+	}
+
+	<-ctx.Done()
+	err = writer.Close()
+	if werr := watched.Err(); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// watchedSink stops the stream on the first failed write, rather than
+// carrying on with a gap in the segments.
+type watchedSink struct {
+	recorder.ChunkSink
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+func (w *watchedSink) Put(name string, data []byte) error {
+	err := w.ChunkSink.Put(name, data)
+	if err != nil {
+		w.mu.Lock()
+		if w.err == nil {
+			w.err = err
+			w.cancel()
+		}
+		w.mu.Unlock()
+	}
+	return err
+}
+
+// Err returns the first write error.
+func (w *watchedSink) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/brice/gognestcli/internal/capture"
+	"github.com/brice/gognestcli/internal/recorder"
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+// streamCheck keeps the resolution and profile a capture's video arrived
+// in, for its sidecar, and warns when the camera sends less than the
+// resolution it advertises, which usually means it's short of bandwidth.
+type streamCheck struct {
+	client     sdm.DeviceAPI
+	deviceName string
+	warn       func(msg string)
+
+	mu   sync.Mutex
+	info recorder.StreamInfo
+}
+
+// warnStderr prints a stream check warning for the one-shot commands.
+func warnStderr(msg string) { fmt.Fprintf(os.Stderr, "Warning: %s\n", msg) }
+
+// warnStream prints a stream check warning in the events log.
+func warnStream(msg string) { fmt.Printf("  Warning: %s\n", msg) }
+
+func newStreamCheck(client sdm.DeviceAPI, deviceName string, warn func(msg string)) *streamCheck {
+	return &streamCheck{client: client, deviceName: deviceName, warn: warn}
+}
+
+// option returns the recorder option reporting to c.
+func (c *streamCheck) option() recorder.Option {
+	return recorder.WithStreamInfo(c.report)
+}
+
+func (c *streamCheck) report(info recorder.StreamInfo) {
+	c.mu.Lock()
+	first := c.info == recorder.StreamInfo{}
+	changed := !first && (info.Width != c.info.Width || info.Height != c.info.Height)
+	c.info = info
+	c.mu.Unlock()
+	slog.Debug("video stream", "device", deviceDisplayNameFromFull(c.deviceName), "info", info.String())
+	if !first && !changed {
+		return
+	}
+
+	dev, err := c.client.GetDevice(c.deviceName)
+	if err != nil {
+		return
+	}
+	ls := dev.LiveStream()
+	if ls == nil || ls.MaxVideoResolution.Width == 0 {
+		return
+	}
+	if maxW, maxH := ls.MaxVideoResolution.Width, ls.MaxVideoResolution.Height; info.Width*info.Height < maxW*maxH {
+		c.warn(fmt.Sprintf("%s is sending %dx%d video, below its %dx%d maximum; its connection may be short of bandwidth",
+			deviceDisplayNameFromFull(c.deviceName), info.Width, info.Height, maxW, maxH))
+	}
+}
+
+// fill adds the stream's resolution and profile to s. A resolution read
+// from the finished file takes precedence.
+func (c *streamCheck) fill(s capture.Sidecar) capture.Sidecar {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.info.Width > 0 {
+		s.Width, s.Height = c.info.Width, c.info.Height
+		s.Profile, s.Level = c.info.Profile, c.info.Level
+	}
+	return s
+}
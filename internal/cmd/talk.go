@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/brice/gognestcli/internal/proc"
+	"github.com/brice/gognestcli/internal/recorder"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+type TalkCmd struct {
+	DeviceID    string `short:"d" help:"Device ID (uses config default if omitted)"`
+	Mic         string `help:"Microphone input passed to ffmpeg -i ('default' picks the platform default)" default:"default"`
+	InputFormat string `help:"ffmpeg input format for the microphone (avfoundation, pulse, alsa, dshow); auto-detected if omitted"`
+}
+
+func (t *TalkCmd) Run(g *Globals) error {
+	format, input, err := micInput(t.InputFormat, t.Mic)
+	if err != nil {
+		return err
+	}
+
+	client, cfg, err := newSDMClient()
+	if err != nil {
+		return err
+	}
+
+	ffmpegPath, err := recorder.FindTool("ffmpeg", cfg.FFmpegPath)
+	if err != nil {
+		return fmt.Errorf("ffmpeg is required for talk: %w", err)
+	}
+
+	deviceName, err := resolveDevice(client, cfg, t.DeviceID)
+	if err != nil {
+		return err
+	}
+	if err := ensureWebRTC(client, deviceName); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(commandCtx)
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	proc.NotifyInterrupt(sigCh)
+	go func() {
+		<-sigCh
+		fmt.Println("\nStopping talk...")
+		cancel()
+	}()
+
+	session, offerSDP, err := nestwebrtc.NewSession(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		// Drain incoming media so the receive buffers don't fill up.
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := track.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}, append(g.sessionOptions(cfg), nestwebrtc.WithTalkback())...)
+	if err != nil {
+		return fmt.Errorf("creating WebRTC session: %w", err)
+	}
+	defer session.Close()
+
+	answer, err := client.GenerateWebRTCStream(deviceName, offerSDP)
+	if err != nil {
+		return fmt.Errorf("generating WebRTC stream: %w", err)
+	}
+
+	err = session.SetAnswer(answer.AnswerSDP, answer.MediaSessionID, answer.ExpiresAt,
+		extendStream(client, deviceName),
+		func(msid string) error { return client.StopWebRTCStream(deviceName, msid) },
+	)
+	if err != nil {
+		return fmt.Errorf("setting WebRTC answer: %w", err)
+	}
+
+	select {
+	case <-session.Connected:
+	case <-time.After(30 * time.Second):
+		return fmt.Errorf("timed out waiting for WebRTC connection")
+	case <-ctx.Done():
+		return nil
+	}
+
+	// Encode the microphone to 20 ms Opus packets in an Ogg stream on stdout.
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, cfg.FFmpegArgs["talk"]...)
+	args = append(args,
+		"-f", format,
+		"-i", input,
+		"-ac", "2",
+		"-ar", "48000",
+		"-c:a", "libopus",
+		"-application", "voip",
+		"-page_duration", "20000",
+		"-f", "ogg",
+		"-",
+	)
+	ffmpeg := proc.Command(ctx, ffmpegPath, args...)
+	ffmpeg.Stderr = os.Stderr
+	stdout, err := ffmpeg.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("creating ffmpeg pipe: %w", err)
+	}
+	if err := ffmpeg.Start(); err != nil {
+		return err
+	}
+	defer ffmpeg.Wait()
+
+	fmt.Printf("Talking to %s — press Ctrl-C to stop\n", deviceDisplayNameFromFull(deviceName))
+
+	if err := sendOgg(stdout, session); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// sendOgg forwards Opus packets from an Ogg stream to the session, using the
+// granule position to derive each sample's duration.
+func sendOgg(r io.Reader, session *nestwebrtc.Session) error {
+	ogg, _, err := oggreader.NewWith(r)
+	if err != nil {
+		return fmt.Errorf("reading ffmpeg output: %w", err)
+	}
+
+	var lastGranule uint64
+	for {
+		page, header, err := ogg.ParseNextPage()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading ffmpeg output: %w", err)
+		}
+
+		samples := header.GranulePosition - lastGranule
+		lastGranule = header.GranulePosition
+		duration := time.Duration(float64(samples)/48000*1000) * time.Millisecond
+		if duration <= 0 {
+			continue // header/tags pages
+		}
+
+		if err := session.WriteAudio(media.Sample{Data: page, Duration: duration}); err != nil {
+			return fmt.Errorf("sending audio: %w", err)
+		}
+	}
+}
+
+// micInput maps --mic/--input-format to ffmpeg -f/-i values for this platform.
+func micInput(format, mic string) (string, string, error) {
+	if format == "" {
+		switch runtime.GOOS {
+		case "darwin":
+			format = "avfoundation"
+		case "linux":
+			format = "pulse"
+		case "windows":
+			format = "dshow"
+		default:
+			return "", "", fmt.Errorf("unsupported platform; pass --input-format")
+		}
+	}
+
+	if mic != "default" {
+		if format == "dshow" {
+			return format, "audio=" + mic, nil
+		}
+		return format, mic, nil
+	}
+
+	switch format {
+	case "avfoundation":
+		return format, ":0", nil
+	case "pulse", "alsa":
+		return format, "default", nil
+	default:
+		return "", "", fmt.Errorf("no default microphone for %s; pass --mic with a device name", format)
+	}
+}
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/history"
+	"github.com/brice/gognestcli/internal/proc"
+	"github.com/brice/gognestcli/internal/sdm"
+	"golang.org/x/term"
+)
+
+type TopCmd struct {
+	Dir            string        `short:"d" help:"Output directory of the events daemon to monitor" default:"events"`
+	Interval       time.Duration `help:"Screen refresh interval" default:"2s"`
+	DeviceInterval time.Duration `help:"How often to refresh camera status from the SDM API" default:"1m"`
+	Offline        bool          `help:"Don't query the SDM API; show only what the output dir records" default:"false"`
+}
+
+// logLines is how many history records top keeps for the event log.
+const logLines = 200
+
+// topState is what top knows about the events daemon, gathered from the SDM
+// API and the daemon's output dir.
+type topState struct {
+	devices    []sdm.Device
+	devicesErr error
+	devicesAt  time.Time
+
+	offset    int64 // bytes of history.ndjson already read
+	log       []history.Record
+	lastEvent map[string]history.Record // by device ID
+	today     map[string]int            // events per device ID since midnight
+	day       time.Time
+
+	inProgress []inProgress
+	diskBytes  int64
+	diskFiles  int
+}
+
+// inProgress is a capture the daemon is still writing.
+type inProgress struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+func (t *TopCmd) Run() error {
+	var client sdm.DeviceAPI
+	if !t.Offline {
+		c, _, err := newSDMClient()
+		if err != nil {
+			return fmt.Errorf("%w (or use --offline)", err)
+		}
+		client = c
+	}
+
+	ctx, cancel := context.WithCancel(commandCtx)
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	proc.NotifyInterrupt(sigCh)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// Draw on the alternate screen so the shell (or tmux pane) is restored on
+	// exit.
+	fmt.Print("\033[?1049h")
+	defer fmt.Print("\033[?1049l")
+
+	st := &topState{lastEvent: map[string]history.Record{}, today: map[string]int{}}
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	for {
+		if client != nil && time.Since(st.devicesAt) >= t.DeviceInterval {
+			st.devices, st.devicesErr = client.ListDevices()
+			st.devicesAt = time.Now()
+		}
+		st.readHistory(t.Dir)
+		st.scanDir(t.Dir)
+
+		fmt.Print("\033[H\033[2J" + t.render(st))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readHistory reads records appended to the history file since the last
+// call, starting over if the file was truncated or replaced.
+func (st *topState) readHistory(dir string) {
+	f, err := os.Open(filepath.Join(dir, history.FileName))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.Size() < st.offset {
+		st.offset = 0
+		st.log = nil
+		clear(st.lastEvent)
+		clear(st.today)
+	}
+	if _, err := f.Seek(st.offset, io.SeekStart); err != nil {
+		return
+	}
+
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !midnight.Equal(st.day) {
+		st.day = midnight
+		clear(st.today)
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// Leave a partly written last line for the next read.
+			break
+		}
+		st.offset += int64(len(line))
+		var rec history.Record
+		if json.Unmarshal(line, &rec) != nil {
+			continue
+		}
+		st.log = append(st.log, rec)
+		if rec.Kind == history.KindEvent {
+			st.lastEvent[rec.Device] = rec
+			if !rec.Time.Before(st.day) {
+				st.today[rec.Device]++
+			}
+		}
+	}
+	if len(st.log) > logLines {
+		st.log = slices.Clone(st.log[len(st.log)-logLines:])
+	}
+}
+
+// scanDir totals the captures in dir and lists the ones still being written.
+func (st *topState) scanDir(dir string) {
+	st.inProgress = st.inProgress[:0]
+	st.diskBytes, st.diskFiles = 0, 0
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		st.diskBytes += info.Size()
+		if proc.IsTemp(d.Name()) {
+			if strings.HasSuffix(path, ".tmp.ogg") {
+				return nil // a recording's audio, listed with its video
+			}
+			st.inProgress = append(st.inProgress, inProgress{path: path, size: info.Size(), modified: info.ModTime()})
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jpg", ".jpeg", ".mp4", ".mkv", ".webm":
+			st.diskFiles++
+		}
+		return nil
+	})
+}
+
+func (t *TopCmd) render(st *topState) string {
+	var b strings.Builder
+	now := time.Now()
+	fmt.Fprintf(&b, "gognestcli top — %s — %s (every %s, Ctrl-C to quit)\n\n", t.Dir, now.Format("15:04:05"), t.Interval)
+
+	// Cameras: everything that can stream, plus any device seen only in the
+	// history (e.g. with --offline).
+	type row struct{ id, name, status string }
+	var rows []row
+	names := map[string]string{} // device ID → display name
+	for _, dev := range st.devices {
+		if dev.LiveStream() == nil {
+			continue
+		}
+		id := deviceDisplayNameFromFull(dev.Name)
+		status := dev.Connectivity()
+		if status == "" {
+			status = "-"
+		}
+		rows = append(rows, row{id, deviceDisplayName(dev), status})
+		names[id] = deviceDisplayName(dev)
+	}
+	var extra []string
+	for id := range st.lastEvent {
+		if _, ok := names[id]; !ok {
+			extra = append(extra, id)
+		}
+	}
+	sort.Strings(extra)
+	for _, id := range extra {
+		rows = append(rows, row{id, id, "?"})
+	}
+
+	fmt.Fprintf(&b, "%-24s  %-8s  %-6s  %s\n", "CAMERA", "STATUS", "TODAY", "LAST EVENT")
+	if st.devicesErr != nil {
+		fmt.Fprintf(&b, "  (camera status unavailable: %v)\n", st.devicesErr)
+	}
+	for _, r := range rows {
+		last := "-"
+		if ev, ok := st.lastEvent[r.id]; ok {
+			last = fmt.Sprintf("%s %s (%s ago)", ev.Type, ev.Time.Local().Format("15:04:05"), agoString(now.Sub(ev.Time)))
+		}
+		fmt.Fprintf(&b, "%-24s  %-8s  %-6d  %s\n", truncate(r.name, 24), r.status, st.today[r.id], last)
+	}
+
+	fmt.Fprintf(&b, "\nIN PROGRESS (%d)\n", len(st.inProgress))
+	for _, p := range st.inProgress {
+		stage := "converting"
+		if strings.HasSuffix(p.path, ".tmp.h264") {
+			stage = "recording"
+		}
+		rel, _ := filepath.Rel(t.Dir, p.path)
+		fmt.Fprintf(&b, "  %-10s  %-48s  %7.1f MB  updated %s ago\n", stage, truncate(rel, 48), float64(p.size)/(1<<20), agoString(now.Sub(p.modified)))
+	}
+
+	fmt.Fprintf(&b, "\nDISK  %.1f MB in %d captures\n", float64(st.diskBytes)/(1<<20), st.diskFiles)
+
+	// Fill the rest of the terminal with the newest history records.
+	height := 40
+	if _, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		height = h
+	}
+	used := strings.Count(b.String(), "\n") + 2
+	n := max(height-used-1, 3)
+	b.WriteString("\nEVENT LOG\n")
+	start := max(len(st.log)-n, 0)
+	for _, rec := range st.log[start:] {
+		what := rec.Type
+		if rec.Kind == history.KindCapture {
+			what = "saved " + rec.Path
+		}
+		name := rec.Device
+		if n, ok := names[name]; ok {
+			name = n
+		}
+		fmt.Fprintf(&b, "  %s  %-20s  %s\n", rec.Time.Local().Format("15:04:05"), truncate(name, 20), what)
+	}
+	return b.String()
+}
+
+// agoString formats d compactly for "n ago" columns.
+func agoString(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brice/gognestcli/internal/update"
+)
+
+type UpdateCmd struct {
+	Check bool `help:"Only report whether a newer release is available"`
+	Force bool `help:"Reinstall the latest release even if this version is the same"`
+}
+
+func (u *UpdateCmd) Run() error {
+	ctx, cancel := context.WithTimeout(commandCtx, 5*time.Minute)
+	defer cancel()
+
+	rel, err := update.Latest(ctx)
+	if err != nil {
+		return fmt.Errorf("checking for updates: %w", err)
+	}
+	if !update.Newer(rel.TagName, version) && !u.Force {
+		fmt.Printf("Up to date (latest release: %s)\n", rel.TagName)
+		return nil
+	}
+	if u.Check {
+		fmt.Printf("gognestcli %s is available: %s\n", rel.TagName, rel.HTMLURL)
+		fmt.Println("Run: gognestcli update")
+		return nil
+	}
+
+	if update.PublicKey == "" {
+		return fmt.Errorf("%w; download %s from %s instead", update.ErrNoPublicKey, update.AssetName(), rel.HTMLURL)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	fmt.Printf("Updating %s from %s to %s...\n", exe, version, rel.TagName)
+	if err := update.Apply(ctx, rel, exe); err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	fmt.Printf("Updated to %s.\n", rel.TagName)
+	return nil
+}
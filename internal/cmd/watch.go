@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/proc"
+	"github.com/brice/gognestcli/internal/pubsub"
+)
+
+type WatchCmd struct {
+	DeviceID string        `arg:"" optional:"" help:"Device ID or full resource name (uses config default if omitted)"`
+	Interval time.Duration `help:"Polling interval" default:"30s"`
+	PubSub   bool          `name:"pubsub" help:"Follow trait updates from the Pub/Sub subscription instead of polling (consumes messages shared with events)" default:"false"`
+}
+
+func (w *WatchCmd) Run() error {
+	client, cfg, err := newSDMClient()
+	if err != nil {
+		return err
+	}
+
+	deviceName, err := resolveDevice(client, cfg, w.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(commandCtx)
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	proc.NotifyInterrupt(sigCh)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	// Seed with the current traits so the first output is a full snapshot.
+	dev, err := client.GetDevice(deviceName)
+	if err != nil {
+		return fmt.Errorf("getting device: %w", err)
+	}
+	state := flattenTraits(dev.Traits)
+	printTraitDiff(time.Now(), nil, state)
+
+	if w.PubSub {
+		if cfg.PubSubSub == "" {
+			return fmt.Errorf("pubsub_subscription not configured in config.json")
+		}
+		_, err = followTraits(ctx, newListener(cfg), deviceName, state)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		dev, err := client.GetDevice(deviceName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			continue
+		}
+		next := flattenTraits(dev.Traits)
+		printTraitDiff(time.Now(), state, next)
+		state = next
+	}
+}
+
+// followTraits prints deviceName's trait changes from source, starting from
+// state, until source ends or ctx is done. It returns the last state.
+func followTraits(ctx context.Context, source pubsub.EventSource, deviceName string, state map[string]string) (map[string]string, error) {
+	source.OnTraitUpdate(func(u pubsub.TraitUpdate) {
+		if u.DeviceName != deviceName {
+			return
+		}
+		// Updates carry only the changed traits; merge them into the state.
+		next := make(map[string]string, len(state))
+		for k, v := range state {
+			next[k] = v
+		}
+		for name, raw := range u.Traits {
+			prefix := shortTraitName(name) + "."
+			for k := range next {
+				if strings.HasPrefix(k, prefix) {
+					delete(next, k)
+				}
+			}
+			flattenJSON(shortTraitName(name), raw, next)
+		}
+		printTraitDiff(u.Timestamp, state, next)
+		state = next
+	})
+	err := source.Listen(ctx, func(pubsub.Event) {})
+	return state, err
+}
+
+// flattenTraits turns trait JSON into dotted keys, e.g.
+// "Temperature.ambientTemperatureCelsius" → "21.5".
+func flattenTraits(traits map[string]json.RawMessage) map[string]string {
+	out := make(map[string]string)
+	for name, raw := range traits {
+		flattenJSON(shortTraitName(name), raw, out)
+	}
+	return out
+}
+
+func flattenJSON(prefix string, raw json.RawMessage, out map[string]string) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		if len(obj) == 0 {
+			out[prefix] = "{}"
+		}
+		for k, v := range obj {
+			flattenJSON(prefix+"."+k, v, out)
+		}
+		return
+	}
+	out[prefix] = string(raw)
+}
+
+func shortTraitName(name string) string {
+	parts := strings.Split(name, ".")
+	return parts[len(parts)-1]
+}
+
+// printTraitDiff prints added (+), changed (~) and removed (-) keys.
+func printTraitDiff(ts time.Time, prev, next map[string]string) {
+	var keys []string
+	seen := make(map[string]bool)
+	for k := range next {
+		keys = append(keys, k)
+		seen[k] = true
+	}
+	for k := range prev {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	stamp := ts.Format("15:04:05")
+	for _, k := range keys {
+		old, hadOld := prev[k]
+		cur, hasCur := next[k]
+		switch {
+		case !hadOld && hasCur:
+			fmt.Printf("[%s] + %s: %s\n", stamp, k, cur)
+		case hadOld && !hasCur:
+			fmt.Printf("[%s] - %s\n", stamp, k)
+		case old != cur:
+			fmt.Printf("[%s] ~ %s: %s → %s\n", stamp, k, old, cur)
+		}
+	}
+}
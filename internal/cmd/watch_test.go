@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/brice/gognestcli/internal/pubsub"
+)
+
+// fakeSource is a pubsub.EventSource that delivers updates and then ends.
+type fakeSource struct {
+	updates  []pubsub.TraitUpdate
+	onTraits func(pubsub.TraitUpdate)
+}
+
+var _ pubsub.EventSource = (*fakeSource)(nil)
+
+func (s *fakeSource) OnTraitUpdate(fn func(pubsub.TraitUpdate)) { s.onTraits = fn }
+
+func (s *fakeSource) Listen(ctx context.Context, handler func(pubsub.Event)) error {
+	for _, u := range s.updates {
+		if s.onTraits != nil {
+			s.onTraits(u)
+		}
+	}
+	return nil
+}
+
+func TestFollowTraits(t *testing.T) {
+	const thermo = "enterprises/p/devices/thermo"
+	raw := func(s string) json.RawMessage { return json.RawMessage(s) }
+	start := flattenTraits(map[string]json.RawMessage{
+		"sdm.devices.traits.Temperature":          raw(`{"ambientTemperatureCelsius": 20}`),
+		"sdm.devices.traits.ThermostatMode":       raw(`{"mode": "HEAT", "availableModes": ["HEAT", "OFF"]}`),
+		"sdm.devices.traits.ThermostatEco":        raw(`{}`),
+		"sdm.devices.traits.ThermostatHvacStatus": raw(`{"status": "HEATING"}`),
+	})
+	source := &fakeSource{updates: []pubsub.TraitUpdate{
+		{DeviceName: thermo, Timestamp: time.Now(), Traits: map[string]json.RawMessage{
+			"sdm.devices.traits.Temperature": raw(`{"ambientTemperatureCelsius": 21.5}`),
+		}},
+		// Another device's update is ignored.
+		{DeviceName: "enterprises/p/devices/other", Timestamp: time.Now(), Traits: map[string]json.RawMessage{
+			"sdm.devices.traits.Temperature": raw(`{"ambientTemperatureCelsius": 5}`),
+		}},
+		// An updated trait replaces all of the trait's keys.
+		{DeviceName: thermo, Timestamp: time.Now(), Traits: map[string]json.RawMessage{
+			"sdm.devices.traits.ThermostatMode": raw(`{"mode": "OFF"}`),
+		}},
+	}}
+
+	state, err := followTraits(context.Background(), source, thermo, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Temperature.ambientTemperatureCelsius": "21.5",
+		"ThermostatMode.mode":                   `"OFF"`,
+		"ThermostatEco":                         "{}",
+		"ThermostatHvacStatus.status":           `"HEATING"`,
+	}
+	if len(state) != len(want) {
+		t.Errorf("state = %v, want %v", state, want)
+	}
+	for k, v := range want {
+		if state[k] != v {
+			t.Errorf("state[%s] = %q, want %q", k, state[k], v)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to path by way of a temp file in the same
+// directory that's renamed over path once complete, so readers never see a
+// truncated file. The file is created with mode 0600.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	_, werr := tmp.Write(data)
+	if werr == nil {
+		werr = tmp.Sync()
+	}
+	if cerr := tmp.Close(); werr != nil || cerr != nil {
+		os.Remove(tmp.Name())
+		return errors.Join(werr, cerr)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -3,53 +3,602 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const configFile = "config.json"
-
 // Config holds the application configuration persisted to disk.
 type Config struct {
+	// Version is the SchemaVersion the file was written for.
+	Version int `json:"version,omitempty"`
+
+	// EncryptSecrets stores client_secret, passwords and tokens encrypted
+	// with a key kept in the OS keyring.
+	EncryptSecrets bool `json:"encrypt_secrets,omitempty"`
+
 	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
+	ClientSecret string `json:"client_secret" secret:"true"`
 	ProjectID    string `json:"project_id"`
 	DeviceID     string `json:"device_id,omitempty"`
 	PubSubSub    string `json:"pubsub_subscription,omitempty"`
+
+	// PubSubTopic is the topic pubsub_subscription is attached to, used when
+	// it's recreated. Defaults to the Device Access project's topic.
+	PubSubTopic string `json:"pubsub_topic,omitempty"`
+
+	// PubSubForwardTopic republishes every message pulled from PubSubSub to
+	// a topic you own ("projects/<project>/topics/<topic>"), e.g. to feed a
+	// BigQuery subscription or Cloud Functions.
+	PubSubForwardTopic string `json:"pubsub_forward_topic,omitempty"`
+
+	// PubSubCredentials authenticates Pub/Sub calls apart from the user's
+	// SDM token: "adc" for Application Default Credentials, or the path of a
+	// service-account key file. Empty uses the user's token.
+	PubSubCredentials string `json:"pubsub_credentials,omitempty"`
+
+	// PubSubSubscriptions are more subscriptions the events command listens
+	// to alongside pubsub_subscription, e.g. for another home's Device
+	// Access project. Their events are merged, labelled with Label.
+	PubSubSubscriptions []PubSubSubscription `json:"pubsub_subscriptions,omitempty"`
+
+	// SDMEndpoint and PubSubEndpoint replace the Google API roots, e.g. with
+	// the Pub/Sub emulator ("http://localhost:8085/v1").
+	SDMEndpoint    string `json:"sdm_endpoint,omitempty"`
+	PubSubEndpoint string `json:"pubsub_endpoint,omitempty"`
+
+	// DeviceCacheTTL is how long `devices` and `info` reuse fetched devices
+	// ("5m" by default, "0s" to disable).
+	DeviceCacheTTL string `json:"device_cache_ttl,omitempty"`
+
+	// VideoProfile is the H264 profile requested from cameras (baseline, main,
+	// high or any); H264Fmtp overrides it with a raw fmtp line.
+	VideoProfile string `json:"video_profile,omitempty"`
+	H264Fmtp     string `json:"h264_fmtp,omitempty"`
+
+	// TURN relays WebRTC media over TCP or TLS on networks that block UDP;
+	// RelayOnly skips direct connections entirely.
+	TURN      []TURNServer `json:"turn,omitempty"`
+	RelayOnly bool         `json:"relay_only,omitempty"`
+
+	// ICEInterfaces limits WebRTC to network interfaces matching these
+	// names or patterns (e.g. "eth0", "en*"), and ICENetwork to "ipv4" or
+	// "ipv6" addresses; by default every interface and both are used.
+	ICEInterfaces []string `json:"ice_interfaces,omitempty"`
+	ICENetwork    string   `json:"ice_network,omitempty"`
+
+	// ICEUDPPorts is the range WebRTC's UDP ports are taken from, e.g.
+	// "50000-50100", so firewall rules can allow them; random by default.
+	ICEUDPPorts string `json:"ice_udp_ports,omitempty"`
+
+	// FilenameTemplate controls where event captures are written relative to
+	// the output directory; see capture.NameData for available fields.
+	FilenameTemplate string `json:"filename_template,omitempty"`
+
+	// FFmpegPath and FFplayPath override the binaries found on PATH.
+	// FFmpegArgs adds extra arguments per command (snapshot, record, events,
+	// live, talk), inserted before the input, e.g. ["-hwaccel", "auto"].
+	FFmpegPath string              `json:"ffmpeg_path,omitempty"`
+	FFplayPath string              `json:"ffplay_path,omitempty"`
+	FFmpegArgs map[string][]string `json:"ffmpeg_args,omitempty"`
+
+	// HWAccel selects hardware video decoding for ffmpeg and ffplay: auto,
+	// vaapi, videotoolbox, nvenc, qsv or v4l2m2m (Raspberry Pi).
+	HWAccel string `json:"hwaccel,omitempty"`
+
+	// ClipAudio records the camera microphone into clips: "aac" transcodes it
+	// for MP4s, "copy" keeps the Opus track, "none" (the default) leaves
+	// clips silent.
+	ClipAudio string `json:"clip_audio,omitempty"`
+
+	// ClipFormat is the container for event clips: "mp4" (the default) or
+	// "mkv", which is muxed without ffmpeg and keeps the capture timestamps.
+	ClipFormat string `json:"clip_format,omitempty"`
+
+	// Verify checks every capture after muxing; see recorder.Verify.
+	Verify bool `json:"verify,omitempty"`
+
+	// DoneMarker writes an empty "<capture>.done" file once a capture has been
+	// renamed into place, for watchers that can't rely on rename events.
+	DoneMarker bool `json:"done_marker,omitempty"`
+
+	// Sidecar writes "<capture>.json" metadata next to every capture; see
+	// capture.Sidecar.
+	Sidecar bool `json:"sidecar,omitempty"`
+
+	Upload *UploadConfig `json:"upload,omitempty"`
+
+	// Fanout forwards events and completed captures from the events command
+	// to streaming systems.
+	Fanout *FanoutConfig `json:"fanout,omitempty"`
+
+	// Triggers accept manual capture requests (device, snapshot, clip) while
+	// the events command runs, e.g. from a gate contact or a button.
+	Triggers *TriggersConfig `json:"triggers,omitempty"`
+
+	// EventLog writes the events command's events and capture results to a
+	// rotating JSON log file, as an audit trail.
+	EventLog *EventLogConfig `json:"event_log,omitempty"`
+
+	// CrashReport sends panics recovered in the events command to Sentry or
+	// a webhook.
+	CrashReport *CrashReportConfig `json:"crash_report,omitempty"`
+
+	// Policies decide what the events command captures per event type and
+	// device. When empty, the --capture/--clip flags apply to the events
+	// selected by CaptureEvents (Motion/Person by default).
+	Policies []CapturePolicy `json:"policies,omitempty"`
+
+	CaptureEvents *EventFilter `json:"capture_events,omitempty"`
+
+	// Automations chain actions on other devices to events, e.g. a doorbell
+	// Chime also snapshotting the driveway camera. They run alongside
+	// policies.
+	Automations []Automation `json:"automations,omitempty"`
+
+	// Devices holds per-device settings for the events command, keyed by
+	// device ID or by an alias (with the device ID in the entry's ID).
+	Devices map[string]DeviceConfig `json:"devices,omitempty"`
+
+	Presence *PresenceConfig `json:"presence,omitempty"`
+
+	// Schedules are recurring recordings made while the events command runs.
+	Schedules []RecordSchedule `json:"schedules,omitempty"`
+
+	Quota *QuotaConfig `json:"quota,omitempty"`
+}
+
+// QuotaConfig limits GenerateWebRtcStream and GenerateImage calls per device
+// and per project within a rolling Window ("1m", "1h"). Zero keeps the
+// default limit; -1 removes it.
+type QuotaConfig struct {
+	PerDevice  int    `json:"per_device,omitempty"`
+	PerProject int    `json:"per_project,omitempty"`
+	Window     string `json:"window,omitempty"`
+}
+
+// WindowPeriod parses Window. It returns 0 when no window is set.
+func (q *QuotaConfig) WindowPeriod() (time.Duration, error) {
+	if q == nil || q.Window == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(q.Window)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid quota window %q", q.Window)
+	}
+	return d, nil
+}
+
+// PubSubSubscription is an extra Pub/Sub subscription for the events
+// command. Credentials is as pubsub_credentials, which it defaults to.
+// Topic is where events --recreate-subscription recreates the subscription;
+// without it, the subscription isn't recreated.
+type PubSubSubscription struct {
+	Label        string `json:"label"`
+	Subscription string `json:"subscription"`
+	Credentials  string `json:"credentials,omitempty"`
+	Topic        string `json:"topic,omitempty"`
+}
+
+// DefaultSubscriptionLabel labels events from pubsub_subscription when
+// pubsub_subscriptions adds others.
+const DefaultSubscriptionLabel = "default"
+
+// DeviceConfig overrides where and how long the events command keeps one
+// device's captures. OutputDir is relative to the events output directory
+// unless absolute. Retention ("90d", "12h") deletes older captures. Policies
+// apply to this device only and replace the --capture/--clip defaults for it;
+// ClipSecs is the clip length when a policy doesn't set one.
+type DeviceConfig struct {
+	ID        string          `json:"id,omitempty"`
+	OutputDir string          `json:"output_dir,omitempty"`
+	Retention string          `json:"retention,omitempty"`
+	Policies  []CapturePolicy `json:"policies,omitempty"`
+	ClipSecs  int             `json:"clip_secs,omitempty"`
+
+	// CaptureEvents replaces the top-level include and/or exclude lists for
+	// this device.
+	CaptureEvents *EventFilter `json:"capture_events,omitempty"`
+}
+
+// RetentionPeriod parses Retention as a Go duration or a whole number of
+// days ("90d"). It returns 0 when no retention is set.
+func (d DeviceConfig) RetentionPeriod() (time.Duration, error) {
+	if d.Retention == "" {
+		return 0, nil
+	}
+	period, ok := parsePeriod(d.Retention)
+	if !ok {
+		return 0, fmt.Errorf("invalid retention %q", d.Retention)
+	}
+	return period, nil
+}
+
+// parsePeriod parses a positive Go duration or whole number of days ("90d").
+func parsePeriod(s string) (time.Duration, bool) {
+	var period time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, false
+		}
+		period = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if period, err = time.ParseDuration(s); err != nil {
+			return 0, false
+		}
+	}
+	return period, period > 0
+}
+
+// DeviceRef resolves an alias from Devices to its device ID. Any other
+// reference is returned unchanged.
+func (c *Config) DeviceRef(ref string) string {
+	if d, ok := c.Devices[ref]; ok && d.ID != "" {
+		return d.ID
+	}
+	return ref
+}
+
+// DeviceSettings returns the Devices entry for deviceName, a full resource
+// name, and its key.
+func (c *Config) DeviceSettings(deviceName string) (key string, d DeviceConfig, ok bool) {
+	for k, v := range c.Devices {
+		id := c.DeviceRef(k)
+		if id == deviceName || strings.HasSuffix(deviceName, "/"+id) {
+			return k, v, true
+		}
+	}
+	return "", DeviceConfig{}, false
+}
+
+// RecordSchedule records Device (the default camera when empty) for Duration
+// (e.g. "10m") at every occurrence of the five-field Cron expression. With
+// CatchUp, a window already in progress at startup is recorded for its
+// remainder instead of skipped.
+type RecordSchedule struct {
+	Cron     string `json:"cron"`
+	Device   string `json:"device,omitempty"`
+	Duration string `json:"duration"`
+	CatchUp  bool   `json:"catch_up,omitempty"`
+}
+
+// PresenceConfig gates event captures on household occupancy, set with
+// `gognestcli presence home|away`. Devices limits the gate to specific
+// device IDs (e.g. indoor cameras); empty means all devices. CaptureWhen is
+// "away" (default) or "home".
+type PresenceConfig struct {
+	Devices     []string `json:"devices,omitempty"`
+	CaptureWhen string   `json:"capture_when,omitempty"`
+}
+
+// CapturePolicy is one rule of the events capture policy, e.g. "Person →
+// snapshot + 20 s clip". Event is a short type ("Person", "Chime") or "*";
+// Device optionally restricts the rule to one device ID and Zone to events
+// detected in one activity zone.
+type CapturePolicy struct {
+	Event    string `json:"event"`
+	Device   string `json:"device,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Snapshot bool   `json:"snapshot,omitempty"`
+	Clip     bool   `json:"clip,omitempty"`
+	ClipSecs int    `json:"clip_secs,omitempty"`
+}
+
+// Automation runs Actions when an event matches Event, Device and Zone,
+// which work as in a CapturePolicy. Every matching automation runs.
+type Automation struct {
+	Name    string             `json:"name,omitempty"`
+	Event   string             `json:"event"`
+	Device  string             `json:"device,omitempty"`
+	Zone    string             `json:"zone,omitempty"`
+	Actions []AutomationAction `json:"actions"`
 }
 
-// Load reads the config from the config directory. Returns an empty config if
-// the file doesn't exist.
+// AutomationAction captures from Device (the event's device when empty) or
+// sends it an SDM Command with Params, e.g.
+// "sdm.devices.commands.ThermostatEco.SetMode" with {"mode": "OFF"}.
+type AutomationAction struct {
+	Device   string         `json:"device,omitempty"`
+	Snapshot bool           `json:"snapshot,omitempty"`
+	Clip     bool           `json:"clip,omitempty"`
+	ClipSecs int            `json:"clip_secs,omitempty"`
+	Command  string         `json:"command,omitempty"`
+	Params   map[string]any `json:"params,omitempty"`
+}
+
+// EventFilter picks the event types that get the events command's default
+// captures when no policies are configured: those matching Include
+// (Motion and Person when empty) and not Exclude. Entries are event names
+// ("Person", "DoorbellChime.Chime", "*") or regular expressions between
+// slashes ("/Camera(Motion|Person)/").
+type EventFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// UploadConfig configures off-site archival of event captures.
+type UploadConfig struct {
+	S3          *S3Config    `json:"s3,omitempty"`
+	GCS         *GCSConfig   `json:"gcs,omitempty"`
+	Drive       *DriveConfig `json:"drive,omitempty"`
+	SFTP        *SFTPConfig  `json:"sftp,omitempty"`
+	DeleteLocal bool         `json:"delete_local,omitempty"`
+}
+
+// S3Config describes an S3-compatible bucket (AWS S3, MinIO, Backblaze B2).
+// Endpoint defaults to AWS; set PathStyle for MinIO and most self-hosted
+// services. Credentials fall back to AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY.
+type S3Config struct {
+	Endpoint        string `json:"endpoint,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty" secret:"true"`
+	PathStyle       bool   `json:"path_style,omitempty"`
+}
+
+// GCSConfig describes a Google Cloud Storage bucket. Uploads use the same
+// OAuth credentials as the SDM API (run: gognestcli auth --storage).
+type GCSConfig struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// DriveConfig describes a Google Drive destination folder. FolderID is the ID
+// from the folder's URL; empty means the root of My Drive.
+type DriveConfig struct {
+	FolderID string `json:"folder_id,omitempty"`
+}
+
+// SFTPConfig describes a remote host reachable with the system sftp client
+// using key authentication. RemotePath is a Go template over .Key, .Device and
+// .File; it defaults to "{{.Key}}" relative to the login directory.
+type SFTPConfig struct {
+	Host         string `json:"host"`
+	Port         int    `json:"port,omitempty"`
+	User         string `json:"user,omitempty"`
+	IdentityFile string `json:"identity_file,omitempty"`
+	RemotePath   string `json:"remote_path,omitempty"`
+	Retries      int    `json:"retries,omitempty"`
+}
+
+// FanoutConfig configures where events are forwarded.
+type FanoutConfig struct {
+	NATS     *NATSConfig     `json:"nats,omitempty"`
+	Kafka    *KafkaConfig    `json:"kafka,omitempty"`
+	Handlers []HandlerConfig `json:"handlers,omitempty"`
+}
+
+// HandlerConfig runs Command (the program, then its arguments) as a
+// long-lived subprocess that is sent each message as a line of JSON on stdin
+// and acknowledges it with a line on stdout. Name, used in logs, defaults to
+// the program's.
+type HandlerConfig struct {
+	Name    string   `json:"name,omitempty"`
+	Command []string `json:"command"`
+}
+
+// NATSConfig publishes to "<Subject>.event.<device>" and
+// "<Subject>.capture.<device>" on a NATS server ("nats://host:4222", or
+// "tls://" for TLS). Subject defaults to "nest".
+type NATSConfig struct {
+	URL      string `json:"url"`
+	Subject  string `json:"subject,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty" secret:"true"`
+	Token    string `json:"token,omitempty" secret:"true"`
+}
+
+// KafkaConfig produces to Topic through a Kafka REST Proxy (Confluent REST
+// Proxy or Redpanda's HTTP Proxy) at RESTURL, keyed by device ID.
+type KafkaConfig struct {
+	RESTURL  string `json:"rest_url"`
+	Topic    string `json:"topic"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty" secret:"true"`
+}
+
+// TriggersConfig configures where the events command takes manual capture
+// requests from.
+type TriggersConfig struct {
+	// HTTPListen serves POST /capture on this address, e.g. ":8787".
+	HTTPListen string `json:"http_listen,omitempty"`
+	// HTTPToken, when set, must be sent as "Authorization: Bearer <token>".
+	HTTPToken string `json:"http_token,omitempty" secret:"true"`
+
+	MQTT *MQTTConfig `json:"mqtt,omitempty"`
+}
+
+// EventLogConfig configures the events command's JSON log file. Path is
+// rotated once it reaches MaxSizeMB (default 50) and, with Rotate ("24h",
+// "1h"), at the start of each period. Rotated files are kept up to
+// MaxBackups (default 10) and MaxAge ("30d"), gzipped with Compress.
+type EventLogConfig struct {
+	Path       string `json:"path"`
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"`
+	Rotate     string `json:"rotate,omitempty"`
+	MaxBackups int    `json:"max_backups,omitempty"`
+	MaxAge     string `json:"max_age,omitempty"`
+	Compress   bool   `json:"compress,omitempty"`
+}
+
+// CrashReportConfig sends panics to a Sentry project by its DSN and/or as
+// JSON to URL, with Token as a bearer token.
+type CrashReportConfig struct {
+	DSN   string `json:"dsn,omitempty" secret:"true"`
+	URL   string `json:"url,omitempty"`
+	Token string `json:"token,omitempty" secret:"true"`
+}
+
+// Periods parses Rotate and MaxAge; each is 0 when unset.
+func (c *EventLogConfig) Periods() (rotate, maxAge time.Duration, err error) {
+	if c.Rotate != "" {
+		var ok bool
+		if rotate, ok = parsePeriod(c.Rotate); !ok {
+			return 0, 0, fmt.Errorf("invalid rotate period %q", c.Rotate)
+		}
+	}
+	if c.MaxAge != "" {
+		var ok bool
+		if maxAge, ok = parsePeriod(c.MaxAge); !ok {
+			return 0, 0, fmt.Errorf("invalid max_age %q", c.MaxAge)
+		}
+	}
+	return rotate, maxAge, nil
+}
+
+// MQTTConfig subscribes to capture requests on Topic (default
+// "gognestcli/capture") at an MQTT broker ("mqtt://host:1883", or
+// "mqtts://" for TLS).
+type MQTTConfig struct {
+	URL      string `json:"url"`
+	Topic    string `json:"topic,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty" secret:"true"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// TURNServer is a TURN relay, e.g. "turns:turn.example.com:443" or
+// "turn:turn.example.com:3478?transport=tcp".
+type TURNServer struct {
+	URL        string `json:"url"`
+	Username   string `json:"username,omitempty"`
+	Credential string `json:"credential,omitempty" secret:"true"`
+}
+
+// Load reads the config file from the config directory (see Path) and
+// decrypts its secrets. Returns an empty config if there is none.
 func Load() (*Config, error) {
-	dir, err := Dir()
+	return load(true)
+}
+
+// load is Load; with migrate, secrets added in plain text are encrypted in
+// the file.
+func load(migrate bool) (*Config, error) {
+	path, err := Path()
 	if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(filepath.Join(dir, configFile))
+	data, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return &Config{}, nil
 		}
 		return nil, err
 	}
+	cfg, err := Parse(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	set, encrypted := cfg.Secrets()
+	if err := cfg.decryptSecrets(); err != nil {
+		return nil, fmt.Errorf("%s: decrypting secrets: %w", filepath.Base(path), err)
+	}
+	// Encrypt secrets added in plain text since. YAML files are left alone,
+	// since saving them drops their comments.
+	if migrate && !readOnly && cfg.EncryptSecrets && encrypted < set && !isYAML(path) {
+		// Rewrite the file as it is now, in case another process saved it
+		// since it was read.
+		if err := Update(func(*Config) error { return nil }); err != nil {
+			return nil, fmt.Errorf("%s: encrypting secrets: %w", filepath.Base(path), err)
+		}
+	}
+	return cfg, nil
+}
+
+// Parse decodes a config file's contents as YAML or JSON, according to the
+// extension of path.
+func Parse(path string, data []byte) (*Config, error) {
+	if isYAML(path) {
+		v, err := decodeYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
 	var cfg Config
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	if cfg.Version > SchemaVersion {
+		return nil, fmt.Errorf("config version %d is newer than this gognestcli supports (%d)", cfg.Version, SchemaVersion)
+	}
 	return &cfg, nil
 }
 
-// Save writes the config to the config directory.
+// Save writes the config to the config directory, in the format of the
+// existing file, with secrets encrypted if EncryptSecrets is set. The whole
+// file is rewritten from c, so a YAML file loses its comments and key
+// order; if it had comments, the old file is kept as <name>.bak (see
+// SaveDropsComments). The file is replaced atomically under the config
+// lock; to change a config another process may be saving too, use Update.
 func (c *Config) Save() error {
-	dir, err := EnsureDir()
+	if readOnly {
+		return ErrReadOnly
+	}
+	unlock, err := lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	return c.write()
+}
+
+// write saves the config. Callers hold the config lock.
+func (c *Config) write() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	c.Version = SchemaVersion
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(dir, configFile), data, 0600)
+	if c.EncryptSecrets {
+		// Encrypt a copy; c keeps the plain values for the caller.
+		var enc Config
+		if err := json.Unmarshal(data, &enc); err != nil {
+			return err
+		}
+		if err := enc.encryptSecrets(); err != nil {
+			return err
+		}
+		if data, err = json.MarshalIndent(&enc, "", "  "); err != nil {
+			return err
+		}
+	}
+	if isYAML(path) {
+		if data, err = encodeYAML(data); err != nil {
+			return err
+		}
+		if old, err := os.ReadFile(path); err == nil && hasComments(old) {
+			if err := WriteFileAtomic(path+".bak", old); err != nil {
+				return fmt.Errorf("keeping a copy of %s: %w", filepath.Base(path), err)
+			}
+		}
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// SaveDropsComments reports whether saving the config would drop comments
+// from its file, which is then a YAML file with comments, and returns the
+// file's path. Commands warn before such a save.
+func SaveDropsComments() (path string, drops bool) {
+	path, err := Path()
+	if err != nil || !isYAML(path) {
+		return path, false
+	}
+	data, err := os.ReadFile(path)
+	return path, err == nil && hasComments(data)
 }
 
 // Validate checks that required fields are present.
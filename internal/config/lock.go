@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrReadOnly is returned by Save and Update in a process that called
+// SetReadOnly.
+var ErrReadOnly = errors.New("config is read-only in this process")
+
+const (
+	lockFile = ".config.lock"
+	// lockWait is how long a writer waits for another to finish.
+	lockWait = 10 * time.Second
+	// lockStale is the age after which a lock is taken to be left behind
+	// by a process that died; writes hold it for milliseconds.
+	lockStale = 30 * time.Second
+)
+
+var readOnly bool
+
+// SetReadOnly stops this process from writing the config file, so a
+// long-running command never overwrites edits made while it runs. Secrets
+// added in plain text are then left for the next writable command to
+// encrypt.
+func SetReadOnly(on bool) { readOnly = on }
+
+// Update loads the config, applies fn and saves the result while holding
+// the config lock, so concurrent updates from other processes aren't lost.
+// The config passed to fn has its secrets decrypted.
+func Update(fn func(*Config) error) error {
+	if readOnly {
+		return ErrReadOnly
+	}
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	cfg, err := load(false)
+	if err != nil {
+		return err
+	}
+	if err := fn(cfg); err != nil {
+		return err
+	}
+	return cfg.write()
+}
+
+// lock takes the config lock, a file created exclusively in the config
+// directory, waiting up to lockWait for another process to release it.
+func lock() (unlock func(), err error) {
+	dir, err := EnsureDir()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err = LockFile(filepath.Join(dir, lockFile))
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	return unlock, nil
+}
+
+// LockFile takes a lock shared with other gognestcli processes by creating
+// the file at path exclusively, waiting up to 10 seconds for another
+// process to release it. A lock older than 30 seconds is taken to be left
+// behind by a process that died, so it's for short read-modify-write
+// cycles, like updating a state file.
+func LockFile(path string) (unlock func(), err error) {
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("locking: %w", err)
+		}
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > lockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("locked by another gognestcli process (remove %s if none is running)", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
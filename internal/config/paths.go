@@ -1,19 +1,59 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 )
 
 const appName = "gognestcli"
 
-// Dir returns the configuration directory (~/.config/gognestcli/).
+// configFiles are the config file names looked for in Dir, YAML first.
+var configFiles = []string{"config.yaml", "config.yml", "config.json"}
+
+// profile is the profile selected with --profile; "" is the default one.
+var profile string
+
+// profileName matches valid profile names.
+var profileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// SetProfile selects a named profile (--profile): a separate config and
+// state directory under profiles/<name>, and separate keyring items. "" or
+// "default" selects the default profile.
+func SetProfile(name string) error {
+	if name == "default" {
+		name = ""
+	}
+	if name != "" && !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (use letters, digits, '.', '_' and '-')", name)
+	}
+	profile = name
+	return nil
+}
+
+// Profile returns the selected profile, "" for the default one.
+func Profile() string { return profile }
+
+// profileDir places dir's per-profile subdirectory for the selected profile.
+func profileDir(dir string) string {
+	if profile == "" {
+		return dir
+	}
+	return filepath.Join(dir, "profiles", profile)
+}
+
+// Dir returns the configuration directory (~/.config/gognestcli/, or
+// ~/.config/gognestcli/profiles/<name>/ for a named profile).
 func Dir() (string, error) {
 	base, err := os.UserConfigDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(base, appName), nil
+	return profileDir(filepath.Join(base, appName)), nil
 }
 
 // EnsureDir creates the config directory if it doesn't exist.
@@ -27,3 +67,87 @@ func EnsureDir() (string, error) {
 	}
 	return dir, nil
 }
+
+// stateDir overrides the OS default state directory when set.
+var stateDir string
+
+// SetStateDir makes StateDir return dir (--state-dir) instead of the OS
+// default.
+func SetStateDir(dir string) { stateDir = dir }
+
+// StateDir returns the directory for runtime state, kept apart from the
+// config: the device cache, retry queue, call budget and presence state. It
+// is $XDG_STATE_HOME/gognestcli (~/.local/state/gognestcli) on Linux and
+// other Unixes, and under the user cache directory on macOS
+// (~/Library/Caches) and Windows (%LocalAppData%), with a profiles/<name>
+// subdirectory for a named profile.
+func StateDir() (string, error) {
+	if stateDir != "" {
+		return stateDir, nil
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		return profileDir(filepath.Join(base, appName)), nil
+	}
+	// Relative values are invalid per the XDG spec and ignored.
+	if base := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(base) {
+		return profileDir(filepath.Join(base, appName)), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return profileDir(filepath.Join(home, ".local", "state", appName)), nil
+}
+
+// StatePath returns the path of the state file name, creating the state
+// directory. A file of that name left in the config directory by an older
+// version is moved there first.
+func StatePath(name string) (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if cfgDir, err := Dir(); err == nil && cfgDir != dir {
+			// Best effort: a failed move just starts the state afresh.
+			os.Rename(filepath.Join(cfgDir, name), path)
+		}
+	}
+	return path, nil
+}
+
+// Path returns the config file in use: config.yaml (or config.yml) if
+// present, otherwise config.json, which may not exist yet. Having both a
+// YAML and a JSON file is an error.
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for _, name := range configFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return filepath.Join(dir, "config.json"), nil
+	case 1:
+		return filepath.Join(dir, found[0]), nil
+	}
+	return "", fmt.Errorf("found both %s and %s in %s; remove one", found[0], found[1], dir)
+}
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SchemaVersion is the version of the config file layout, written to the
+// "version" key on save. Load rejects files from a newer version.
+const SchemaVersion = 1
+
+// Issue is a problem Check found at Path, e.g. "devices.doorbell.retention"
+// or "policies[2].clip_secs".
+type Issue struct {
+	Path    string
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Path == "" {
+		return i.Message
+	}
+	return i.Path + ": " + i.Message
+}
+
+// Check reports keys the schema doesn't know, values of the wrong type and
+// missing required keys in a config file's contents. It returns an error
+// only if the file can't be parsed at all.
+func Check(path string, data []byte) ([]Issue, error) {
+	v, err := decodeGeneric(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = map[string]any{}
+	}
+	var issues []Issue
+	checkValue(&issues, "", v, reflect.TypeFor[Config]())
+	if n, ok := v.(map[string]any)["version"].(int64); ok && n > SchemaVersion {
+		issues = append(issues, Issue{"version", fmt.Sprintf("%d is newer than this gognestcli supports (%d)", n, SchemaVersion)})
+	}
+	return issues, nil
+}
+
+// Schema returns a JSON Schema for the config file, generated from Config.
+// Keys without omitempty are required.
+func Schema() map[string]any {
+	s := schemaFor(reflect.TypeFor[Config]())
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = fmt.Sprintf("gognestcli config (version %d)", SchemaVersion)
+	s["properties"].(map[string]any)["version"] = map[string]any{"type": "integer", "minimum": 1, "maximum": SchemaVersion}
+	return s
+}
+
+// decodeGeneric parses a config file as YAML or JSON according to its
+// extension, with numbers as int64 or float64.
+func decodeGeneric(path string, data []byte) (any, error) {
+	if isYAML(path) {
+		return decodeYAML(data)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return normalizeNumbers(v), nil
+}
+
+func normalizeNumbers(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalizeNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = normalizeNumbers(e)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// jsonField is a struct field as it appears in the config file.
+type jsonField struct {
+	name     string
+	typ      reflect.Type
+	required bool
+}
+
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		if !f.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		fields = append(fields, jsonField{name, f.Type, !strings.Contains(opts, "omitempty")})
+	}
+	return fields
+}
+
+func checkValue(issues *[]Issue, path string, v any, t reflect.Type) {
+	if v == nil {
+		return
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	wrongType := func(want string) {
+		*issues = append(*issues, Issue{path, fmt.Sprintf("expected %s, got %s", want, describe(v))})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			wrongType("a mapping")
+			return
+		}
+		fields := jsonFields(t)
+		known := map[string]jsonField{}
+		for _, f := range fields {
+			known[f.name] = f
+		}
+		for _, k := range sortedKeys(m) {
+			f, ok := known[k]
+			if !ok {
+				msg := fmt.Sprintf("unknown key %q", k)
+				if s := closest(k, fields); s != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", s)
+				}
+				*issues = append(*issues, Issue{path, msg})
+				continue
+			}
+			checkValue(issues, joinPath(path, k), m[k], f.typ)
+		}
+		for _, f := range fields {
+			if _, ok := m[f.name]; f.required && !ok {
+				*issues = append(*issues, Issue{path, fmt.Sprintf("missing required key %q", f.name)})
+			}
+		}
+	case reflect.Map:
+		m, ok := v.(map[string]any)
+		if !ok {
+			wrongType("a mapping")
+			return
+		}
+		for _, k := range sortedKeys(m) {
+			checkValue(issues, joinPath(path, k), m[k], t.Elem())
+		}
+	case reflect.Slice:
+		list, ok := v.([]any)
+		if !ok {
+			wrongType("a list")
+			return
+		}
+		for i, e := range list {
+			checkValue(issues, fmt.Sprintf("%s[%d]", path, i), e, t.Elem())
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			wrongType("a string")
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			wrongType("true or false")
+		}
+	case reflect.Int, reflect.Int64:
+		if _, ok := v.(int64); !ok {
+			wrongType("an integer")
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describe(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return "a mapping"
+	case []any:
+		return "a list"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case int64, float64:
+		return fmt.Sprintf("number %v", v)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// closest returns the field name within two edits of key, if any.
+func closest(key string, fields []jsonField) string {
+	best, bestDist := "", 3
+	for _, f := range fields {
+		if d := editDistance(key, f.name); d < bestDist {
+			best, bestDist = f.name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func schemaFor(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []string
+		for _, f := range jsonFields(t) {
+			props[f.name] = schemaFor(f.typ)
+			if f.required {
+				required = append(required, f.name)
+			}
+		}
+		s := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+		return s
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Interface:
+		return map[string]any{}
+	}
+	return map[string]any{"type": "string"}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// encryptedPrefix marks an encrypted secret value: AES-256-GCM, nonce
+// first, base64-encoded.
+const encryptedPrefix = "enc:v1:"
+
+// KeyFunc returns the key that encrypts secret values, creating one if
+// create is set and there is none. The cmd package points it at the OS
+// keyring; without it, encrypted configs can't be loaded or saved.
+var KeyFunc func(create bool) ([]byte, error)
+
+// IsEncrypted reports whether a config value is an encrypted secret.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, encryptedPrefix)
+}
+
+// Secrets counts the config's secret values that are set, and how many of
+// them are encrypted.
+func (c *Config) Secrets() (set, encrypted int) {
+	forEachSecret(reflect.ValueOf(c).Elem(), func(p *string) error {
+		if *p != "" {
+			set++
+		}
+		if IsEncrypted(*p) {
+			encrypted++
+		}
+		return nil
+	})
+	return set, encrypted
+}
+
+// decryptSecrets decrypts the encrypted secret values in place.
+func (c *Config) decryptSecrets() error {
+	if _, encrypted := c.Secrets(); encrypted == 0 {
+		return nil
+	}
+	aead, err := secretCipher(false)
+	if err != nil {
+		return err
+	}
+	return forEachSecret(reflect.ValueOf(c).Elem(), func(p *string) error {
+		if !IsEncrypted(*p) {
+			return nil
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(*p, encryptedPrefix))
+		if err != nil || len(data) < aead.NonceSize() {
+			return errors.New("malformed encrypted value")
+		}
+		plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+		if err != nil {
+			return errors.New("encrypted value doesn't match the key in the keyring")
+		}
+		*p = string(plain)
+		return nil
+	})
+}
+
+// encryptSecrets encrypts the plaintext secret values in place.
+func (c *Config) encryptSecrets() error {
+	if set, encrypted := c.Secrets(); set == encrypted {
+		return nil
+	}
+	aead, err := secretCipher(true)
+	if err != nil {
+		return err
+	}
+	return forEachSecret(reflect.ValueOf(c).Elem(), func(p *string) error {
+		if *p == "" || IsEncrypted(*p) {
+			return nil
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		*p = encryptedPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(*p), nil))
+		return nil
+	})
+}
+
+func secretCipher(create bool) (cipher.AEAD, error) {
+	if KeyFunc == nil {
+		return nil, errors.New("no keyring for encrypted secrets")
+	}
+	key, err := KeyFunc(create)
+	if err != nil {
+		return nil, fmt.Errorf("config key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("config key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// forEachSecret calls fn with each string field tagged secret:"true" in v,
+// a struct, and the structs it holds.
+func forEachSecret(v reflect.Value, fn func(*string) error) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return forEachSecret(v.Elem(), fn)
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if f.Type.Kind() == reflect.String && f.Tag.Get("secret") == "true" {
+				if err := fn(v.Field(i).Addr().Interface().(*string)); err != nil {
+					return fmt.Errorf("%s: %w", strings.Split(f.Tag.Get("json"), ",")[0], err)
+				}
+				continue
+			}
+			if err := forEachSecret(v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			if err := forEachSecret(v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		for _, k := range v.MapKeys() {
+			// Map values aren't addressable; work on a copy.
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			if err := forEachSecret(e, fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+		}
+	}
+	return nil
+}
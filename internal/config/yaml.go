@@ -0,0 +1,630 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file implements the subset of YAML config files need: block mappings
+// and sequences, single-line flow collections ([a, b] and {k: v}), plain and
+// quoted scalars, and comments. Anchors, tags, block scalars (| and >) and
+// multi-document streams are rejected.
+
+// yamlLine is one non-blank line of a YAML document.
+type yamlLine struct {
+	num    int    // 1-based line number
+	indent int    // leading spaces
+	text   string // content without indentation or comment
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+// decodeYAML parses a YAML document into the values encoding/json decodes
+// into an interface{}: map[string]any, []any, string, bool, nil, and int64
+// or float64 for numbers.
+func decodeYAML(data []byte) (any, error) {
+	p := &yamlParser{}
+	docs := 0
+	for n, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", n+1)
+		}
+		text = strings.TrimSpace(stripComment(text))
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		switch {
+		case text == "":
+			continue
+		case indent == 0 && text == "---":
+			if docs++; docs > 1 || len(p.lines) > 0 {
+				return nil, fmt.Errorf("line %d: only one YAML document is supported", n+1)
+			}
+			continue
+		case indent == 0 && strings.HasPrefix(text, "%"):
+			continue // directive
+		}
+		p.lines = append(p.lines, yamlLine{num: n + 1, indent: indent, text: text})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.lines[p.i].num, fmt.Sprintf(format, args...))
+}
+
+// block parses the mapping, sequence or scalar starting at the current line.
+func (p *yamlParser) block(indent int) (any, error) {
+	l := p.lines[p.i]
+	if isSeqItem(l.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitKey(l.text); ok {
+		return p.mapping(indent)
+	}
+	p.i++
+	return parseFlow(l.text, l.num)
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := map[string]any{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if isSeqItem(l.text) {
+			return nil, p.errorf("expected a key, found a list item")
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, p.errorf("expected \"key: value\"")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.i++
+
+		if rest != "" {
+			v, err := parseFlow(rest, l.num)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		// The value is the indented block that follows (a sequence may also
+		// sit at the key's own indentation), or null.
+		m[key] = nil
+		if p.i < len(p.lines) {
+			next := p.lines[p.i]
+			if next.indent > indent || (next.indent == indent && isSeqItem(next.text)) {
+				v, err := p.block(next.indent)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = v
+			}
+		}
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	var list []any
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent || (l.indent == indent && !isSeqItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		rest := strings.TrimSpace(l.text[1:])
+		if rest == "" {
+			p.i++
+			var v any
+			if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+				var err error
+				if v, err = p.block(p.lines[p.i].indent); err != nil {
+					return nil, err
+				}
+			}
+			list = append(list, v)
+			continue
+		}
+
+		// "- key: value" and "- - item" start a nested block at the column
+		// after the dash; parse the rest of the line as if it stood there.
+		col := indent + len(l.text) - len(rest)
+		var v any
+		var err error
+		if _, _, isKey := splitKey(rest); isKey || isSeqItem(rest) {
+			p.lines[p.i] = yamlLine{num: l.num, indent: col, text: rest}
+			v, err = p.block(col)
+		} else {
+			p.i++
+			v, err = parseFlow(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits a "key: value" line. ok is false if the line isn't a
+// mapping entry.
+func splitKey(text string) (key, rest string, ok bool) {
+	if text == "" || strings.ContainsRune("[{", rune(text[0])) {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		fp := &flowParser{s: text}
+		k, err := fp.quoted()
+		if err != nil {
+			return "", "", false
+		}
+		after := strings.TrimLeft(text[fp.pos:], " ")
+		if after == ":" || strings.HasPrefix(after, ": ") {
+			return k.(string), strings.TrimSpace(after[1:]), true
+		}
+		return "", "", false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// hasComments reports whether a YAML document has any comments.
+func hasComments(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		if stripComment(line) != line {
+			return true
+		}
+	}
+	return false
+}
+
+// stripComment removes a trailing "# comment" outside quoted scalars.
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// Quotes only open a scalar at its start, not in "it's".
+			prev := strings.TrimRight(text[:i], " ")
+			if prev == "" || strings.ContainsRune(":,[{-", rune(prev[len(prev)-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// flowParser parses a scalar or flow collection on a single line.
+type flowParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func parseFlow(text string, line int) (any, error) {
+	fp := &flowParser{s: text, line: line}
+	v, err := fp.value(false)
+	if err != nil {
+		return nil, err
+	}
+	fp.skipSpace()
+	if fp.pos < len(fp.s) {
+		return nil, fp.errorf("unexpected %q after value", fp.s[fp.pos:])
+	}
+	return v, nil
+}
+
+func (fp *flowParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", fp.line, fmt.Sprintf(format, args...))
+}
+
+func (fp *flowParser) skipSpace() {
+	for fp.pos < len(fp.s) && fp.s[fp.pos] == ' ' {
+		fp.pos++
+	}
+}
+
+// value parses one value; inFlow is set inside [..] or {..}, where commas
+// and closing brackets end plain scalars.
+func (fp *flowParser) value(inFlow bool) (any, error) {
+	fp.skipSpace()
+	if fp.pos == len(fp.s) {
+		return nil, nil
+	}
+	switch c := fp.s[fp.pos]; c {
+	case '[':
+		return fp.list()
+	case '{':
+		return fp.object()
+	case '"', '\'':
+		return fp.quoted()
+	case '|', '>':
+		return nil, fp.errorf("block scalars (| and >) are not supported; use a quoted string")
+	case '&', '*', '!':
+		return nil, fp.errorf("anchors, aliases and tags are not supported")
+	}
+	return resolvePlain(fp.plain(inFlow, false)), nil
+}
+
+// plain reads an unquoted scalar. In flow context it stops at , ] and },
+// and at ": " when reading a key.
+func (fp *flowParser) plain(inFlow, key bool) string {
+	start := fp.pos
+	for ; fp.pos < len(fp.s); fp.pos++ {
+		c := fp.s[fp.pos]
+		if inFlow && strings.IndexByte(",]}", c) >= 0 {
+			break
+		}
+		if key && c == ':' && (fp.pos+1 == len(fp.s) || strings.IndexByte(" ,]}", fp.s[fp.pos+1]) >= 0) {
+			break
+		}
+	}
+	return strings.TrimSpace(fp.s[start:fp.pos])
+}
+
+func (fp *flowParser) list() (any, error) {
+	fp.pos++ // [
+	list := []any{}
+	for {
+		fp.skipSpace()
+		if fp.pos < len(fp.s) && fp.s[fp.pos] == ']' {
+			fp.pos++
+			return list, nil
+		}
+		v, err := fp.value(true)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		if err := fp.separator(']'); err != nil {
+			return nil, err
+		}
+		if fp.s[fp.pos-1] == ']' {
+			return list, nil
+		}
+	}
+}
+
+func (fp *flowParser) object() (any, error) {
+	fp.pos++ // {
+	m := map[string]any{}
+	for {
+		fp.skipSpace()
+		if fp.pos < len(fp.s) && fp.s[fp.pos] == '}' {
+			fp.pos++
+			return m, nil
+		}
+		var key string
+		if fp.pos < len(fp.s) && (fp.s[fp.pos] == '"' || fp.s[fp.pos] == '\'') {
+			k, err := fp.quoted()
+			if err != nil {
+				return nil, err
+			}
+			key = k.(string)
+		} else {
+			key = fp.plain(true, true)
+		}
+		fp.skipSpace()
+		if fp.pos == len(fp.s) || fp.s[fp.pos] != ':' {
+			return nil, fp.errorf("expected \":\" after key %q", key)
+		}
+		fp.pos++
+		if _, dup := m[key]; dup {
+			return nil, fp.errorf("duplicate key %q", key)
+		}
+		v, err := fp.value(true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+		if err := fp.separator('}'); err != nil {
+			return nil, err
+		}
+		if fp.s[fp.pos-1] == '}' {
+			return m, nil
+		}
+	}
+}
+
+// separator consumes the comma or closing bracket after a flow item.
+func (fp *flowParser) separator(end byte) error {
+	fp.skipSpace()
+	if fp.pos == len(fp.s) {
+		return fp.errorf("missing %q (flow collections must fit on one line)", end)
+	}
+	if c := fp.s[fp.pos]; c != ',' && c != end {
+		return fp.errorf("expected \",\" or %q, found %q", end, c)
+	}
+	fp.pos++
+	return nil
+}
+
+var doubleQuoteEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", 'n': "\n", 'v': "\v", 'f': "\f",
+	'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/", '\\': "\\",
+}
+
+func (fp *flowParser) quoted() (any, error) {
+	q := fp.s[fp.pos]
+	fp.pos++
+	var b strings.Builder
+	for fp.pos < len(fp.s) {
+		c := fp.s[fp.pos]
+		fp.pos++
+		switch {
+		case c == q && q == '\'' && fp.pos < len(fp.s) && fp.s[fp.pos] == '\'':
+			b.WriteByte('\'')
+			fp.pos++
+		case c == q:
+			return b.String(), nil
+		case c == '\\' && q == '"':
+			if fp.pos == len(fp.s) {
+				return nil, fp.errorf("unterminated escape")
+			}
+			e := fp.s[fp.pos]
+			fp.pos++
+			if s, ok := doubleQuoteEscapes[e]; ok {
+				b.WriteString(s)
+				continue
+			}
+			digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+			if digits == 0 || fp.pos+digits > len(fp.s) {
+				return nil, fp.errorf("invalid escape \\%c", e)
+			}
+			r, err := strconv.ParseUint(fp.s[fp.pos:fp.pos+digits], 16, 32)
+			if err != nil {
+				return nil, fp.errorf("invalid escape \\%c%s", e, fp.s[fp.pos:fp.pos+digits])
+			}
+			fp.pos += digits
+			b.WriteRune(rune(r))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, fp.errorf("unterminated quoted string")
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// resolvePlain applies the YAML 1.2 core schema to an unquoted scalar.
+func resolvePlain(s string) any {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	switch {
+	case yamlInt.MatchString(s):
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case strings.HasPrefix(s, "0x"):
+		if n, err := strconv.ParseInt(s[2:], 16, 64); err == nil {
+			return n
+		}
+	case strings.HasPrefix(s, "0o"):
+		if n, err := strconv.ParseInt(s[2:], 8, 64); err == nil {
+			return n
+		}
+	}
+	if yamlFloat.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// encodeYAML converts a JSON document to block-style YAML, keeping the key
+// order of the JSON.
+func encodeYAML(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := readOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	if m, ok := v.(*orderedMap); ok && len(m.keys) > 0 {
+		emitYAML(&b, v, 0, "")
+	} else {
+		b.WriteString(yamlScalar(v) + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// orderedMap is a JSON object with its keys in document order.
+type orderedMap struct {
+	keys []string
+	vals []any
+}
+
+func readOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		m := &orderedMap{}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := readOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			m.keys = append(m.keys, k.(string))
+			m.vals = append(m.vals, v)
+		}
+		_, err := dec.Token() // }
+		return m, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			v, err := readOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		_, err := dec.Token() // ]
+		return list, err
+	}
+	return tok, nil
+}
+
+// emitYAML writes a non-empty mapping or sequence at indent. lead replaces
+// the indentation of the first line, e.g. with "- " for a list item.
+func emitYAML(b *strings.Builder, v any, indent int, lead string) {
+	pad := strings.Repeat(" ", indent)
+	if lead == "" {
+		lead = pad
+	}
+	switch v := v.(type) {
+	case *orderedMap:
+		for i, k := range v.keys {
+			if i > 0 {
+				lead = pad
+			}
+			b.WriteString(lead + yamlScalar(k) + ":")
+			if isEmptyYAML(v.vals[i]) {
+				b.WriteString(" " + yamlScalar(v.vals[i]) + "\n")
+				continue
+			}
+			b.WriteString("\n")
+			emitYAML(b, v.vals[i], indent+2, "")
+		}
+	case []any:
+		for i, item := range v {
+			if i > 0 {
+				lead = pad
+			}
+			if isEmptyYAML(item) {
+				b.WriteString(lead + "- " + yamlScalar(item) + "\n")
+				continue
+			}
+			emitYAML(b, item, indent+2, lead+"- ")
+		}
+	}
+}
+
+// isEmptyYAML reports whether v is written inline: a scalar or an empty
+// collection.
+func isEmptyYAML(v any) bool {
+	switch v := v.(type) {
+	case *orderedMap:
+		return len(v.keys) == 0
+	case []any:
+		return len(v) == 0
+	}
+	return true
+}
+
+// yamlScalar formats a scalar (or empty collection), quoting strings that
+// would otherwise read back as something else.
+func yamlScalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case *orderedMap:
+		return "{}"
+	case []any:
+		return "[]"
+	case string:
+		if plainSafe(v) {
+			return v
+		}
+		q, _ := json.Marshal(v)
+		return string(q)
+	}
+	return fmt.Sprint(v)
+}
+
+func plainSafe(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) || !utf8.ValidString(s) {
+		return false
+	}
+	if _, isString := resolvePlain(s).(string); !isString {
+		return false
+	}
+	if strings.ContainsRune("-?:,[]{}#&*!|>'\"%@`", rune(s[0])) ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return false
+	}
+	for _, r := range s {
+		if r < ' ' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedKeys returns m's keys in order, for deterministic reports.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
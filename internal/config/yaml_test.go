@@ -0,0 +1,182 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want any
+	}{
+		{"empty", "", nil},
+		{"comment only", "# nothing\n", nil},
+		{"scalars", "a: 1\nb: -2.5\nc: true\nd: ~\ne: hello world\nf: 0x1f\ng: 1e3\n", map[string]any{
+			"a": int64(1), "b": -2.5, "c": true, "d": nil, "e": "hello world", "f": int64(31), "g": 1000.0,
+		}},
+		{"quoted", `a: "x: y # z"` + "\nb: 'it''s'\nc: \"tab\\tnl\\n\\u00e9\"\nd: \"1\"\n", map[string]any{
+			"a": "x: y # z", "b": "it's", "c": "tab\tnl\né", "d": "1",
+		}},
+		{"comments", "a: b # trailing\nc: it's#not a comment\n", map[string]any{
+			"a": "b", "c": "it's#not a comment",
+		}},
+		{"nested", "a:\n  b:\n    c: 1\n  d: x\n", map[string]any{
+			"a": map[string]any{"b": map[string]any{"c": int64(1)}, "d": "x"},
+		}},
+		{"sequence", "list:\n  - 1\n  - two\n  -\n", map[string]any{
+			"list": []any{int64(1), "two", nil},
+		}},
+		{"sequence at key indent", "list:\n- a\n- b\nnext: 1\n", map[string]any{
+			"list": []any{"a", "b"}, "next": int64(1),
+		}},
+		{"sequence of mappings", "devices:\n  - name: front\n    clip_secs: 10\n  - name: back\n", map[string]any{
+			"devices": []any{
+				map[string]any{"name": "front", "clip_secs": int64(10)},
+				map[string]any{"name": "back"},
+			},
+		}},
+		{"nested sequences", "- - a\n  - b\n- - c\n", []any{[]any{"a", "b"}, []any{"c"}}},
+		{"flow", "a: [1, x, \"y, z\"]\nb: {k: v, \"q\": [true]}\nc: []\nd: {}\n", map[string]any{
+			"a": []any{int64(1), "x", "y, z"},
+			"b": map[string]any{"k": "v", "q": []any{true}},
+			"c": []any{},
+			"d": map[string]any{},
+		}},
+		{"url value", "url: https://example.com:8080/x\n", map[string]any{"url": "https://example.com:8080/x"}},
+		{"empty value", "a:\nb: 1\n", map[string]any{"a": nil, "b": int64(1)}},
+		{"document marker", "---\na: 1\n", map[string]any{"a": int64(1)}},
+		{"crlf", "a: 1\r\nb: 2\r\n", map[string]any{"a": int64(1), "b": int64(2)}},
+		{"top-level scalar", "42\n", int64(42)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("decodeYAML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeYAML = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"tab indent", "a:\n\tb: 1\n", "line 2: tabs"},
+		{"duplicate key", "a: 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"bad indent", "a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"list in mapping", "a: 1\n- b\n", "line 2: expected a key"},
+		{"block scalar", "a: |\n  text\n", "block scalars"},
+		{"anchor", "a: &x 1\n", "anchors"},
+		{"two documents", "a: 1\n---\nb: 2\n", "only one YAML document"},
+		{"unterminated flow", "a: [1, 2\n", "must fit on one line"},
+		{"unterminated quote", "a: \"x\n", "unterminated quoted string"},
+		{"bad escape", `a: "\q"` + "\n", `invalid escape \q`},
+		{"junk after value", "a: [1] x\n", "unexpected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeYAML([]byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("decodeYAML error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeYAMLRoundTrip(t *testing.T) {
+	in := `{"client_id":"abc","port":8080,"ratio":0.5,"on":true,"none":null,` +
+		`"tricky":["true","1","- x","a: b","#c","",  " pad", "it's"],` +
+		`"devices":{"front":{"alias":"Front Door","zones":[]},"back":{}},` +
+		`"list":[{"a":1,"b":[1,2]},[3,4]]}`
+	out, err := encodeYAML([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "client_id: abc\nport: 8080\n") {
+		t.Errorf("encodeYAML didn't keep key order:\n%s", out)
+	}
+
+	got, err := decodeYAML(out)
+	if err != nil {
+		t.Fatalf("decoding encoded YAML: %v\n%s", err, out)
+	}
+	var want any
+	json.Unmarshal([]byte(in), &want)
+	// Compare through JSON, which doesn't tell int64 and float64 apart.
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("round trip:\n got %s\nwant %s\nYAML:\n%s", gotJSON, wantJSON, out)
+	}
+}
+
+func TestHasComments(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want bool
+	}{
+		{"client_id: abc\nport: 8080\n", false},
+		{"# OAuth client\nclient_id: abc\n", true},
+		{"client_id: abc  # from the console\n", true},
+		{"output: \"a #b\"\nname: x#y\n", false},
+		{"devices:\n  front:\n    # the porch\n    alias: Front\n", true},
+	}
+	for _, tt := range tests {
+		if got := hasComments([]byte(tt.doc)); got != tt.want {
+			t.Errorf("hasComments(%q) = %v, want %v", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestSaveYAMLKeepsCommentedCopy(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir, err := EnsureDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	commented := "# OAuth client from the Cloud console\nclient_id: abc\nclient_secret: s\nproject_id: p\n"
+	if err := os.WriteFile(path, []byte(commented), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, drops := SaveDropsComments(); got != path || !drops {
+		t.Fatalf("SaveDropsComments = %q, %v", got, drops)
+	}
+
+	cfg, err := Parse(path, []byte(commented))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ProjectID = "q"
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if bak, _ := os.ReadFile(path + ".bak"); string(bak) != commented {
+		t.Errorf("%s.bak = %q, want the commented file", path, bak)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "project_id: q") {
+		t.Errorf("saved config:\n%s", data)
+	}
+
+	// The saved file has no comments left, so saving again keeps the copy.
+	if _, drops := SaveDropsComments(); drops {
+		t.Error("SaveDropsComments after saving = true")
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if bak, _ := os.ReadFile(path + ".bak"); string(bak) != commented {
+		t.Errorf("second save replaced %s.bak: %q", path, bak)
+	}
+}
@@ -0,0 +1,128 @@
+// Package crash keeps a panic in one of the events command's goroutines (a
+// snapshot, clip, upload or background loop) from killing the daemon: it's
+// recovered, logged with its stack and passed to the reporter, if one is
+// set. A panic that still ends the process runs the registered flush
+// functions first, so state isn't left half-written.
+package crash
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Report describes a panic.
+type Report struct {
+	Time  time.Time `json:"time"`
+	Where string    `json:"where"`
+	Panic string    `json:"panic"`
+	Stack string    `json:"stack"`
+	// Fatal is set when the panic ended the process.
+	Fatal bool   `json:"fatal,omitempty"`
+	OS    string `json:"os"`
+	Arch  string `json:"arch"`
+}
+
+var (
+	mu       sync.Mutex
+	reporter func(Report)
+	flushes  []func()
+	count    atomic.Int64
+)
+
+// SetReporter sets the function panics are reported to, e.g. from
+// NewReporter. It's called in the background for recovered panics.
+func SetReporter(fn func(Report)) {
+	mu.Lock()
+	reporter = fn
+	mu.Unlock()
+}
+
+// OnFatal registers fn to run before a panic ends the process.
+func OnFatal(fn func()) {
+	mu.Lock()
+	flushes = append(flushes, fn)
+	mu.Unlock()
+}
+
+// Count returns the number of panics recovered so far.
+func Count() int64 {
+	return count.Load()
+}
+
+// Go runs fn in a new goroutine, recovering and reporting a panic in it.
+// where names the work in the log, e.g. "clip".
+func Go(where string, fn func()) {
+	go func() {
+		defer Recover(where)
+		fn()
+	}()
+}
+
+// Recover recovers and reports a panic. Defer it directly at the top of a
+// goroutine or callback:
+//
+//	defer crash.Recover("upload")
+func Recover(where string) {
+	if v := recover(); v != nil {
+		count.Add(1)
+		r := newReport(where, v, false)
+		fmt.Fprintf(os.Stderr, "Error: recovered panic in %s: %s\n%s", where, r.Panic, r.Stack)
+		go send(r)
+	}
+}
+
+// Fatal handles a panic v that is about to end the process: it's logged and
+// reported, waiting for the reporter, and the OnFatal functions are run.
+// Call it from the last deferred recover of main.
+func Fatal(where string, v any) {
+	r := newReport(where, v, true)
+	fmt.Fprintf(os.Stderr, "Error: panic in %s: %s\n%s", where, r.Panic, r.Stack)
+	send(r)
+
+	mu.Lock()
+	fns := flushes
+	flushes = nil
+	mu.Unlock()
+	for _, fn := range fns {
+		flush(fn)
+	}
+}
+
+func newReport(where string, v any, fatal bool) Report {
+	return Report{
+		Time:  time.Now(),
+		Where: where,
+		Panic: fmt.Sprint(v),
+		Stack: string(debug.Stack()),
+		Fatal: fatal,
+		OS:    runtime.GOOS,
+		Arch:  runtime.GOARCH,
+	}
+}
+
+// send passes r to the reporter. A reporter that panics itself is ignored.
+func send(r Report) {
+	mu.Lock()
+	fn := reporter
+	mu.Unlock()
+	if fn == nil {
+		return
+	}
+	defer func() { recover() }()
+	fn(r)
+}
+
+// flush runs a flush function, carrying on past a panic in it.
+func flush(fn func()) {
+	defer func() {
+		if v := recover(); v != nil {
+			fmt.Fprintf(os.Stderr, "Error: panic while flushing state: %v\n", v)
+		}
+	}()
+	fn()
+}
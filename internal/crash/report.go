@@ -0,0 +1,138 @@
+package crash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// reportTimeout bounds each report, so a fatal panic doesn't hang on an
+// unreachable endpoint.
+const reportTimeout = 10 * time.Second
+
+// NewReporter returns a reporter that sends panics to a Sentry project,
+// given its DSN (https://<key>@<host>/<project>), and/or POSTs the Report
+// as JSON to endpoint, with token as a bearer token when set. release tags
+// the reports with the gognestcli version. Failures are logged and dropped.
+func NewReporter(dsn, endpoint, token, release string) (func(Report), error) {
+	var sentry *sentryTarget
+	if dsn != "" {
+		var err error
+		if sentry, err = parseDSN(dsn); err != nil {
+			return nil, err
+		}
+	}
+	if endpoint != "" && !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("url must be http:// or https://, not %q", endpoint)
+	}
+	if sentry == nil && endpoint == "" {
+		return nil, errors.New("dsn or url is required")
+	}
+
+	client := &http.Client{Timeout: reportTimeout}
+	return func(r Report) {
+		if sentry != nil {
+			if err := sentry.send(client, r, release); err != nil {
+				fmt.Printf("Warning: reporting panic to Sentry: %v\n", err)
+			}
+		}
+		if endpoint != "" {
+			if err := post(client, endpoint, r, map[string]string{"Authorization": bearer(token)}); err != nil {
+				fmt.Printf("Warning: reporting panic: %v\n", err)
+			}
+		}
+	}, nil
+}
+
+func bearer(token string) string {
+	if token == "" {
+		return ""
+	}
+	return "Bearer " + token
+}
+
+// post sends v as JSON to endpoint with the non-empty headers.
+func post(client *http.Client, endpoint string, v any, headers map[string]string) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		if v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return nil
+}
+
+// sentryTarget is a Sentry project's store endpoint and public key.
+type sentryTarget struct {
+	store string
+	key   string
+}
+
+// parseDSN turns a DSN such as https://abc@o1.ingest.sentry.io/42 into its
+// store endpoint, https://o1.ingest.sentry.io/api/42/store/.
+func parseDSN(dsn string) (*sentryTarget, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid dsn %q", dsn)
+	}
+	dir, project := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if project == "" {
+		return nil, fmt.Errorf("invalid dsn %q: no project ID", dsn)
+	}
+	store := url.URL{Scheme: u.Scheme, Host: u.Host, Path: path.Join(dir, "api", project, "store") + "/"}
+	return &sentryTarget{store: store.String(), key: u.User.Username()}, nil
+}
+
+// send stores r as a Sentry event: an exception of type "panic", with the
+// stack as extra data.
+func (s *sentryTarget) send(client *http.Client, r Report, release string) error {
+	id := make([]byte, 16)
+	rand.Read(id)
+	level := "error"
+	if r.Fatal {
+		level = "fatal"
+	}
+	event := map[string]any{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": r.Time.UTC().Format(time.RFC3339),
+		"level":     level,
+		"platform":  "go",
+		"logger":    "gognestcli",
+		"release":   "gognestcli@" + release,
+		"tags":      map[string]string{"where": r.Where, "os": r.OS, "arch": r.Arch},
+		"exception": map[string]any{
+			"values": []map[string]any{{
+				"type":      "panic",
+				"value":     r.Panic,
+				"mechanism": map[string]any{"type": "recover", "handled": !r.Fatal},
+			}},
+		},
+		"extra": map[string]string{"stack": r.Stack},
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=gognestcli/%s", s.key, release)
+	return post(client, s.store, event, map[string]string{"X-Sentry-Auth": auth})
+}
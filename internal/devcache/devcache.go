@@ -0,0 +1,149 @@
+// Package devcache caches SDM device listings and traits on disk for a short
+// time, so `devices` followed by `info` on each device doesn't re-fetch what
+// the listing already returned.
+package devcache
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+// DefaultTTL is how long cached devices are used.
+const DefaultTTL = 5 * time.Minute
+
+// state is the cache file's contents.
+type state struct {
+	Project  string           `json:"project"`
+	ListedAt time.Time        `json:"listed_at,omitzero"`
+	Order    []string         `json:"order,omitempty"` // device names as listed
+	Devices  map[string]entry `json:"devices"`
+}
+
+type entry struct {
+	FetchedAt time.Time  `json:"fetched_at"`
+	Device    sdm.Device `json:"device"`
+}
+
+// client serves ListDevices and GetDevice from the cache while it's fresh.
+// Commands invalidate the device they're sent to, since they change traits.
+type client struct {
+	sdm.API
+	mu      sync.Mutex
+	path    string
+	project string
+	ttl     time.Duration
+	refresh bool
+	st      state
+}
+
+// Wrap returns api with device reads cached in path (in memory only when path
+// is empty) for ttl. With refresh, reads always go to the API but still
+// update the cache.
+func Wrap(api sdm.API, path, project string, ttl time.Duration, refresh bool) sdm.API {
+	c := &client{API: api, path: path, project: project, ttl: ttl, refresh: refresh}
+	c.load()
+	return c
+}
+
+func (c *client) fresh(t time.Time) bool {
+	return !c.refresh && !t.IsZero() && time.Since(t) < c.ttl
+}
+
+func (c *client) ListDevices() ([]sdm.Device, error) {
+	c.mu.Lock()
+	if c.fresh(c.st.ListedAt) {
+		devices := make([]sdm.Device, 0, len(c.st.Order))
+		for _, name := range c.st.Order {
+			if e, ok := c.st.Devices[name]; ok {
+				devices = append(devices, e.Device)
+			}
+		}
+		if len(devices) == len(c.st.Order) {
+			c.mu.Unlock()
+			return devices, nil
+		}
+	}
+	c.mu.Unlock()
+
+	devices, err := c.API.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.st.ListedAt = now
+	c.st.Order = c.st.Order[:0]
+	c.st.Devices = map[string]entry{}
+	for _, dev := range devices {
+		c.st.Order = append(c.st.Order, dev.Name)
+		c.st.Devices[dev.Name] = entry{FetchedAt: now, Device: dev}
+	}
+	c.save()
+	return devices, nil
+}
+
+func (c *client) GetDevice(name string) (*sdm.Device, error) {
+	c.mu.Lock()
+	if e, ok := c.st.Devices[name]; ok && c.fresh(e.FetchedAt) {
+		c.mu.Unlock()
+		return &e.Device, nil
+	}
+	c.mu.Unlock()
+
+	dev, err := c.API.GetDevice(name)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.st.Devices[name] = entry{FetchedAt: time.Now(), Device: *dev}
+	c.save()
+	return dev, nil
+}
+
+func (c *client) ExecuteCommand(deviceName, command string, params map[string]interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	if _, ok := c.st.Devices[deviceName]; ok {
+		delete(c.st.Devices, deviceName)
+		c.save()
+	}
+	c.mu.Unlock()
+	return c.API.ExecuteCommand(deviceName, command, params)
+}
+
+// load reads the cache file, starting empty if it's missing, unreadable or
+// for another project.
+func (c *client) load() {
+	c.st = state{Project: c.project, Devices: map[string]entry{}}
+	if c.path == "" {
+		return
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+	var st state
+	if json.Unmarshal(data, &st) != nil || st.Project != c.project || st.Devices == nil {
+		return
+	}
+	c.st = st
+}
+
+// save writes the cache file atomically. Errors are ignored: a missing cache
+// only costs API calls.
+func (c *client) save() {
+	if c.path == "" {
+		return
+	}
+	data, err := json.Marshal(c.st)
+	if err != nil {
+		return
+	}
+	config.WriteFileAtomic(c.path, data)
+}
@@ -0,0 +1,188 @@
+package digest
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/history"
+)
+
+// maxContactSheet caps the number of Person snapshots shown in a digest.
+const maxContactSheet = 24
+
+// Count is a labelled event count.
+type Count struct {
+	Label string
+	N     int
+}
+
+// Summary aggregates history records over a period.
+type Summary struct {
+	From, To     time.Time
+	Total        int
+	ByDevice     []Count
+	ByType       []Count
+	ByHour       [24]int
+	BusiestHours []Count
+	Persons      []history.Record // Person snapshots, newest first
+}
+
+// Period returns the [from, to) window for "daily" or "weekly" digests ending
+// at the most recent midnight before now.
+func Period(period string, now time.Time) (time.Time, time.Time, error) {
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case "daily":
+		return to.AddDate(0, 0, -1), to, nil
+	case "weekly":
+		return to.AddDate(0, 0, -7), to, nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unknown digest period %q (want daily or weekly)", period)
+	}
+}
+
+// Build summarizes records within [from, to).
+func Build(records []history.Record, from, to time.Time) *Summary {
+	s := &Summary{From: from, To: to}
+	devices := make(map[string]int)
+	types := make(map[string]int)
+
+	for _, r := range records {
+		if r.Time.Before(from) || !r.Time.Before(to) {
+			continue
+		}
+		switch r.Kind {
+		case history.KindEvent:
+			s.Total++
+			devices[r.Device]++
+			types[r.Type]++
+			s.ByHour[r.Time.Local().Hour()]++
+		case history.KindCapture:
+			if strings.EqualFold(r.Type, "person") && isImage(r.Path) {
+				s.Persons = append(s.Persons, r)
+			}
+		}
+	}
+
+	s.ByDevice = sortedCounts(devices)
+	s.ByType = sortedCounts(types)
+
+	var hours []Count
+	for h, n := range s.ByHour {
+		if n > 0 {
+			hours = append(hours, Count{Label: fmt.Sprintf("%02d:00", h), N: n})
+		}
+	}
+	sort.SliceStable(hours, func(i, j int) bool { return hours[i].N > hours[j].N })
+	if len(hours) > 3 {
+		hours = hours[:3]
+	}
+	s.BusiestHours = hours
+
+	sort.Slice(s.Persons, func(i, j int) bool { return s.Persons[i].Time.After(s.Persons[j].Time) })
+	if len(s.Persons) > maxContactSheet {
+		s.Persons = s.Persons[:maxContactSheet]
+	}
+	return s
+}
+
+// WriteText writes a plain-text digest suitable for a terminal or email body.
+func (s *Summary) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Nest event digest %s – %s\n\n", s.From.Format("2006-01-02"), s.To.Format("2006-01-02"))
+	fmt.Fprintf(w, "Total events: %d\n", s.Total)
+	writeCounts(w, "By device", s.ByDevice)
+	writeCounts(w, "By type", s.ByType)
+	writeCounts(w, "Busiest hours", s.BusiestHours)
+	if len(s.Persons) > 0 {
+		fmt.Fprintf(w, "\nPerson snapshots:\n")
+		for _, r := range s.Persons {
+			fmt.Fprintf(w, "  %s  %-20s  %s\n", r.Time.Local().Format("01-02 15:04"), r.Device, r.Path)
+		}
+	}
+	return nil
+}
+
+// WriteHTML writes an HTML digest with a contact sheet of Person snapshots.
+// Image paths are resolved relative to the captures dir via prefix.
+func (s *Summary) WriteHTML(w io.Writer, prefix string) error {
+	return htmlTmpl.Execute(w, struct {
+		*Summary
+		Prefix string
+	}{s, prefix})
+}
+
+func writeCounts(w io.Writer, title string, counts []Count) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, c := range counts {
+		fmt.Fprintf(w, "  %-24s %d\n", c.Label, c.N)
+	}
+}
+
+func sortedCounts(m map[string]int) []Count {
+	var out []Count
+	for k, n := range m {
+		out = append(out, Count{Label: k, N: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].N != out[j].N {
+			return out[i].N > out[j].N
+		}
+		return out[i].Label < out[j].Label
+	})
+	return out
+}
+
+func isImage(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+var htmlTmpl = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"join": func(prefix, p string) string {
+		if prefix == "" {
+			return p
+		}
+		return path.Join(prefix, p)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Nest event digest</title>
+<style>
+body { font-family: -apple-system, system-ui, sans-serif; margin: 1.5rem; }
+table { border-collapse: collapse; margin-bottom: 1rem; }
+td, th { padding: .2rem .8rem; text-align: left; border-bottom: 1px solid #ddd; }
+.sheet { display: grid; grid-template-columns: repeat(auto-fill, minmax(180px, 1fr)); gap: .5rem; }
+.sheet figure { margin: 0; }
+.sheet img { width: 100%; display: block; }
+.sheet figcaption { font-size: .75rem; color: #555; }
+</style>
+</head>
+<body>
+<h1>Nest event digest</h1>
+<p>{{.From.Format "2006-01-02"}} – {{.To.Format "2006-01-02"}} &middot; {{.Total}} events</p>
+{{if .ByDevice}}<h2>By device</h2>
+<table>{{range .ByDevice}}<tr><td>{{.Label}}</td><td>{{.N}}</td></tr>{{end}}</table>{{end}}
+{{if .ByType}}<h2>By type</h2>
+<table>{{range .ByType}}<tr><td>{{.Label}}</td><td>{{.N}}</td></tr>{{end}}</table>{{end}}
+{{if .BusiestHours}}<h2>Busiest hours</h2>
+<table>{{range .BusiestHours}}<tr><td>{{.Label}}</td><td>{{.N}}</td></tr>{{end}}</table>{{end}}
+{{if .Persons}}<h2>Person snapshots</h2>
+<div class="sheet">
+{{range .Persons}}<figure><img src="{{join $.Prefix .Path}}" loading="lazy" alt="{{.Path}}"><figcaption>{{.Time.Local.Format "01-02 15:04"}} &middot; {{.Device}}</figcaption></figure>
+{{end}}</div>{{end}}
+</body>
+</html>
+`))
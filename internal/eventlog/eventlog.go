@@ -0,0 +1,257 @@
+// Package eventlog writes the events command's audit log: one JSON object
+// per line, rotated by size and time, with rotated files gzipped and pruned
+// by count and age.
+package eventlog
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for Options left zero.
+const (
+	DefaultMaxSize    = 50 << 20
+	DefaultMaxBackups = 10
+)
+
+// Options control rotation. The log is rotated once it would grow past
+// MaxSize bytes, and when a write falls in a different Every period (UTC,
+// e.g. each midnight for 24h) than the file's last write; 0 rotates by size
+// only. Rotated files are kept up to MaxBackups and, if MaxAge is set, for
+// MaxAge. With Compress they're gzipped.
+type Options struct {
+	MaxSize    int64
+	Every      time.Duration
+	MaxBackups int
+	MaxAge     time.Duration
+	Compress   bool
+}
+
+// Log is an open event log. It's safe for concurrent use.
+type Log struct {
+	path string
+	opts Options
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	lastTime time.Time
+	wg       sync.WaitGroup // compressions in flight
+}
+
+// Open opens (or creates) the log at path for appending.
+func Open(path string, opts Options) (*Log, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = DefaultMaxBackups
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	l := &Log{path: path, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size, l.lastTime = f, info.Size(), info.ModTime()
+	if l.size == 0 {
+		l.lastTime = time.Now()
+	}
+	return nil
+}
+
+// Write appends v as a line of JSON, rotating first if needed.
+func (l *Log) Write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	now := time.Now()
+	if l.size > 0 && (l.size+int64(len(data)) > l.opts.MaxSize || l.newPeriod(now)) {
+		if err := l.rotate(now); err != nil {
+			return fmt.Errorf("rotating %s: %w", l.path, err)
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	l.lastTime = now
+	return err
+}
+
+func (l *Log) newPeriod(now time.Time) bool {
+	if l.opts.Every <= 0 {
+		return false
+	}
+	return !now.UTC().Truncate(l.opts.Every).Equal(l.lastTime.UTC().Truncate(l.opts.Every))
+}
+
+// rotate renames the current file to a timestamped backup, e.g.
+// events-20240101T120000.ndjson, and starts a new one. Callers hold mu.
+func (l *Log) rotate(now time.Time) error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	backup := l.backupName(now)
+	if err := os.Rename(l.path, backup); err != nil {
+		// Keep appending to the current file rather than losing entries.
+		if oerr := l.open(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	l.lastTime = now
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		if l.opts.Compress {
+			compress(backup)
+		}
+		l.prune(now)
+	}()
+	return nil
+}
+
+func (l *Log) backupName(now time.Time) string {
+	ext := filepath.Ext(l.path)
+	base := strings.TrimSuffix(l.path, ext)
+	name := base + "-" + now.UTC().Format("20060102T150405") + ext
+	// Rotations within the same second get a counter.
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%s.%d%s", base, now.UTC().Format("20060102T150405"), i, ext)
+	}
+	return name
+}
+
+// backups returns the rotated files, oldest first.
+func (l *Log) backups() []string {
+	ext := filepath.Ext(l.path)
+	base := strings.TrimSuffix(filepath.Base(l.path), ext)
+	entries, err := os.ReadDir(filepath.Dir(l.path))
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, base+"-") {
+			continue
+		}
+		if trimmed := strings.TrimSuffix(name, ".gz"); strings.HasSuffix(trimmed, ext) {
+			out = append(out, filepath.Join(filepath.Dir(l.path), name))
+		}
+	}
+	slices.SortFunc(out, func(a, b string) int {
+		ta, na := l.stamp(a)
+		tb, nb := l.stamp(b)
+		return cmp.Or(strings.Compare(ta, tb), cmp.Compare(na, nb))
+	})
+	return out
+}
+
+// stamp splits a backup's name into its timestamp, which sorts lexically,
+// and its same-second counter.
+func (l *Log) stamp(path string) (string, int) {
+	ext := filepath.Ext(l.path)
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".gz"), ext)
+	name = strings.TrimPrefix(name, strings.TrimSuffix(filepath.Base(l.path), ext)+"-")
+	ts, counter, _ := strings.Cut(name, ".")
+	n, _ := strconv.Atoi(counter)
+	return ts, n
+}
+
+// prune removes backups beyond MaxBackups and older than MaxAge.
+func (l *Log) prune(now time.Time) {
+	backups := l.backups()
+	for i, path := range backups {
+		old := len(backups)-i > l.opts.MaxBackups
+		if !old && l.opts.MaxAge > 0 {
+			if info, err := os.Stat(path); err == nil && now.Sub(info.ModTime()) > l.opts.MaxAge {
+				old = true
+			}
+		}
+		if old {
+			os.Remove(path)
+		}
+	}
+}
+
+// compress gzips path to path.gz and removes it. On failure the
+// uncompressed file is kept.
+func compress(path string) {
+	in, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return
+	}
+	os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Close closes the log after waiting for rotated files to be compressed.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	var err error
+	if l.file != nil {
+		err = l.file.Close()
+		l.file = nil
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+	return err
+}
@@ -0,0 +1,160 @@
+// Package fanout forwards events and completed captures from the events
+// command to streaming systems (NATS, Kafka) and handler programs as JSON
+// messages.
+package fanout
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/crash"
+)
+
+// Message kinds.
+const (
+	KindEvent   = "event"
+	KindCapture = "capture"
+)
+
+// Message is one forwarded event or completed capture.
+type Message struct {
+	Kind          string    `json:"kind"`
+	Time          time.Time `json:"time"`
+	Device        string    `json:"device"` // device ID
+	DeviceName    string    `json:"device_name"`
+	DisplayName   string    `json:"display_name,omitempty"` // custom name or room
+	Type          string    `json:"type"`                   // short event type, e.g. "Person"
+	EventID       string    `json:"event_id,omitempty"`
+	SessionID     string    `json:"event_session_id,omitempty"`
+	ThreadID      string    `json:"event_thread_id,omitempty"`
+	ThreadState   string    `json:"event_thread_state,omitempty"` // STARTED, UPDATED or ENDED
+	ResourceGroup []string  `json:"resource_group,omitempty"`
+	UserID        string    `json:"user_id,omitempty"`
+	Zones         []string  `json:"zones,omitempty"`
+	FamiliarFaces []string  `json:"familiar_faces,omitempty"`
+	Loudness      float64   `json:"loudness_db,omitempty"`
+	Path          string    `json:"path,omitempty"`         // capture path relative to the output dir
+	Subscription  string    `json:"subscription,omitempty"` // label, when events listens to several
+}
+
+// Publisher sends messages to one system. New in-process sinks implement
+// it; out-of-process ones can be a Handler instead.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+	Name() string
+	Close() error
+}
+
+// queueSize bounds the messages waiting to be sent; beyond it new messages
+// are dropped so a slow broker never holds up captures.
+const queueSize = 256
+
+// publishTimeout bounds a single message to a single publisher.
+const publishTimeout = 10 * time.Second
+
+// Manager publishes messages to every configured target in the background,
+// in order.
+type Manager struct {
+	targets []Publisher
+	queue   chan Message
+	done    chan struct{}
+	once    sync.Once
+
+	mu     sync.Mutex
+	closed bool // queue is closed; Publish drops messages
+}
+
+// NewManager builds the publishers described by cfg and starts sending.
+// outputDir is the events output dir, for handlers. It returns nil if no
+// target is configured.
+func NewManager(cfg *config.FanoutConfig, outputDir string) (*Manager, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	var targets []Publisher
+	if cfg.NATS != nil {
+		n, err := NewNATS(*cfg.NATS)
+		if err != nil {
+			return nil, fmt.Errorf("configuring nats fanout: %w", err)
+		}
+		targets = append(targets, n)
+	}
+	if cfg.Kafka != nil {
+		k, err := NewKafka(*cfg.Kafka)
+		if err != nil {
+			return nil, fmt.Errorf("configuring kafka fanout: %w", err)
+		}
+		targets = append(targets, k)
+	}
+	for i, hc := range cfg.Handlers {
+		h, err := NewHandler(hc, outputDir)
+		if err != nil {
+			return nil, fmt.Errorf("configuring fanout handler %d: %w", i+1, err)
+		}
+		targets = append(targets, h)
+	}
+
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	m := &Manager{targets: targets, queue: make(chan Message, queueSize), done: make(chan struct{})}
+	go m.run()
+	return m, nil
+}
+
+// Publish queues msg for every target without blocking. After Close, msg
+// is dropped.
+func (m *Manager) Publish(msg Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.queue <- msg:
+	default:
+		fmt.Printf("  Warning: fanout queue full, dropping %s %s\n", msg.Kind, msg.Type)
+	}
+}
+
+func (m *Manager) run() {
+	defer close(m.done)
+	for msg := range m.queue {
+		for _, t := range m.targets {
+			publish(t, msg)
+		}
+	}
+}
+
+// publish sends msg to t, logging a failure. A panic in the target is
+// recovered so it doesn't stop the queue.
+func publish(t Publisher, msg Message) {
+	defer crash.Recover("fanout " + t.Name())
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := t.Publish(ctx, msg); err != nil {
+		fmt.Printf("  Warning: %s publish failed: %v\n", t.Name(), err)
+	}
+}
+
+// Close sends what's queued, waiting up to timeout, and closes the targets.
+func (m *Manager) Close(timeout time.Duration) {
+	m.once.Do(func() {
+		m.mu.Lock()
+		m.closed = true
+		close(m.queue)
+		m.mu.Unlock()
+		select {
+		case <-m.done:
+		case <-time.After(timeout):
+			fmt.Printf("Warning: fanout: %d message(s) not sent\n", len(m.queue))
+		}
+		for _, t := range m.targets {
+			t.Close()
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package fanout
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Publisher that keeps what it's sent.
+type recorder struct {
+	mu     sync.Mutex
+	got    []string
+	closed bool
+}
+
+func (r *recorder) Publish(_ context.Context, msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.got = append(r.got, msg.Type)
+	return nil
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func newTestManager(targets ...Publisher) *Manager {
+	m := &Manager{targets: targets, queue: make(chan Message, queueSize), done: make(chan struct{})}
+	go m.run()
+	return m
+}
+
+func TestManagerPublish(t *testing.T) {
+	r := &recorder{}
+	m := newTestManager(r)
+	for _, typ := range []string{"Motion", "Person", "Chime"} {
+		m.Publish(Message{Kind: KindEvent, Type: typ})
+	}
+	m.Close(5 * time.Second)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.got) != 3 || r.got[0] != "Motion" || r.got[2] != "Chime" {
+		t.Errorf("sent %v, want the three messages in order", r.got)
+	}
+	if !r.closed {
+		t.Error("target not closed")
+	}
+}
+
+func TestManagerPublishAfterClose(t *testing.T) {
+	r := &recorder{}
+	m := newTestManager(r)
+	m.Close(time.Second)
+	m.Close(time.Second)
+	m.Publish(Message{Kind: KindEvent, Type: "Motion"}) // mustn't panic
+
+	// Publishing while another goroutine closes.
+	m = newTestManager(&recorder{})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				m.Publish(Message{Kind: KindCapture})
+			}
+		}()
+	}
+	m.Close(time.Second)
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.got) != 0 {
+		t.Errorf("message published after Close was sent: %v", r.got)
+	}
+}
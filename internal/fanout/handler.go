@@ -0,0 +1,233 @@
+package fanout
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/proc"
+)
+
+// HandlerProtocol is the version of the handler protocol, passed to handlers
+// in GOGNESTCLI_HANDLER_PROTOCOL.
+const HandlerProtocol = 1
+
+// restartDelay is how long a handler that exited or failed to answer is left
+// before it's started again.
+const restartDelay = 5 * time.Second
+
+// Handler runs an external program that receives messages as NDJSON: one
+// JSON Message per line on its stdin, each answered by one line on its
+// stdout, {"ok":true} or {"error":"..."}. Its stderr is logged. It starts on
+// the first message and is restarted on the next one after it exits or
+// stops answering, so third-party sinks need no changes to gognestcli.
+type Handler struct {
+	name      string
+	argv      []string
+	outputDir string
+
+	mu        sync.Mutex
+	proc      *proc.Process
+	stdin     io.WriteCloser
+	stdout    io.ReadCloser
+	replies   chan handlerReply
+	exited    chan struct{}
+	nextStart time.Time
+}
+
+type handlerReply struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// NewHandler checks that the handler's program exists. outputDir, where
+// message paths are relative to, is passed to it in GOGNESTCLI_OUTPUT_DIR.
+func NewHandler(cfg config.HandlerConfig, outputDir string) (*Handler, error) {
+	if len(cfg.Command) == 0 || cfg.Command[0] == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	path, err := exec.LookPath(cfg.Command[0])
+	if err != nil {
+		return nil, fmt.Errorf("command %q: %w", cfg.Command[0], err)
+	}
+	name := cfg.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), ".exe")
+	}
+	if outputDir != "" {
+		if abs, err := filepath.Abs(outputDir); err == nil {
+			outputDir = abs
+		}
+	}
+	argv := append([]string{path}, cfg.Command[1:]...)
+	return &Handler{name: name, argv: argv, outputDir: outputDir}, nil
+}
+
+// Name returns the target name used in log messages.
+func (h *Handler) Name() string { return "handler " + h.name }
+
+// Publish sends msg to the handler and waits for its answer.
+func (h *Handler) Publish(ctx context.Context, msg Message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.proc == nil {
+		if wait := time.Until(h.nextStart); wait > 0 {
+			return fmt.Errorf("not running; restarting in %s", wait.Round(time.Second))
+		}
+		if err := h.start(); err != nil {
+			h.nextStart = time.Now().Add(restartDelay)
+			return err
+		}
+	}
+
+	select {
+	case <-h.replies: // stale, from a message that timed out
+	default:
+	}
+	if _, err := h.stdin.Write(append(line, '\n')); err != nil {
+		h.stop()
+		return fmt.Errorf("writing to handler: %w", err)
+	}
+	select {
+	case r := <-h.replies:
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+		return nil
+	case <-h.exited:
+		h.stop()
+		return fmt.Errorf("handler exited")
+	case <-ctx.Done():
+		// A late answer would be taken for the next message's, so start over.
+		h.stop()
+		return fmt.Errorf("no answer from handler: %w", ctx.Err())
+	}
+}
+
+// start runs the handler. Callers hold mu.
+func (h *Handler) start() error {
+	p := proc.Command(context.Background(), h.argv[0], h.argv[1:]...)
+	p.Env = append(os.Environ(),
+		fmt.Sprintf("GOGNESTCLI_HANDLER_PROTOCOL=%d", HandlerProtocol),
+		"GOGNESTCLI_OUTPUT_DIR="+h.outputDir)
+	p.Stderr = &lineLogger{prefix: "  " + h.name + ": "}
+	stdin, err := p.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := p.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := p.Start(); err != nil {
+		return err
+	}
+
+	// Answers are buffered so the reader never blocks; one nobody asked for
+	// is dropped.
+	replies := make(chan handlerReply, 1)
+	readDone := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var r handlerReply
+			if err := json.Unmarshal([]byte(text), &r); err != nil {
+				r.Error = fmt.Sprintf("invalid answer %q", text)
+			} else if !r.OK && r.Error == "" {
+				r.Error = "handler answered without ok"
+			}
+			select {
+			case replies <- r:
+			default:
+				fmt.Printf("  Warning: %s: dropping unexpected answer %q\n", h.Name(), text)
+			}
+		}
+	}()
+	go func() {
+		<-readDone
+		if err := p.Wait(); err != nil {
+			fmt.Printf("  Warning: %s: %v\n", h.Name(), err)
+		}
+		close(exited)
+	}()
+
+	h.proc, h.stdin, h.stdout, h.replies, h.exited = p, stdin, stdout, replies, exited
+	return nil
+}
+
+// stop kills the handler and delays its restart. Callers hold mu.
+func (h *Handler) stop() {
+	if h.proc == nil {
+		return
+	}
+	h.kill()
+	h.proc = nil
+	h.nextStart = time.Now().Add(restartDelay)
+}
+
+// Close closes the handler's stdin and gives it a few seconds to exit.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.proc == nil {
+		return nil
+	}
+	h.stdin.Close()
+	select {
+	case <-h.exited:
+	case <-time.After(5 * time.Second):
+		h.kill()
+	}
+	h.proc = nil
+	return nil
+}
+
+// kill kills the handler and waits for it. Closing stdout as well stops the
+// reader even if a child of the handler still holds it open. Callers hold mu.
+func (h *Handler) kill() {
+	h.stdin.Close()
+	h.proc.Process.Kill()
+	h.stdout.Close()
+	<-h.exited
+}
+
+// lineLogger prints what's written to it line by line with a prefix.
+type lineLogger struct {
+	prefix string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		fmt.Printf("%s%s\n", l.prefix, bytes.TrimRight(l.buf[:i], "\r"))
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,245 @@
+package fanout
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// natsWriteTimeout bounds each write to the server, PONGs included, and
+// publishes whose context has no earlier deadline.
+const natsWriteTimeout = 10 * time.Second
+
+// NATS publishes messages over the NATS client protocol, reconnecting on the
+// next message after the connection drops.
+type NATS struct {
+	cfg     config.NATSConfig
+	addr    string
+	tls     bool
+	host    string
+	subject string
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+// NewNATS checks the server URL; the connection is made on first publish.
+func NewNATS(cfg config.NATSConfig) (*NATS, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", cfg.URL)
+	}
+	n := &NATS{cfg: cfg, host: u.Hostname(), subject: cfg.Subject}
+	switch u.Scheme {
+	case "nats":
+	case "tls":
+		n.tls = true
+	default:
+		return nil, fmt.Errorf("url %q must start with nats:// or tls://", cfg.URL)
+	}
+	if u.User != nil && cfg.User == "" {
+		n.cfg.User = u.User.Username()
+		n.cfg.Password, _ = u.User.Password()
+	}
+	port := u.Port()
+	if port == "" {
+		port = "4222"
+	}
+	n.addr = net.JoinHostPort(n.host, port)
+	if n.subject == "" {
+		n.subject = "nest"
+	}
+	return n, nil
+}
+
+// Name returns the target name used in log messages.
+func (n *NATS) Name() string { return "nats" }
+
+// Publish sends msg to "<subject>.<kind>.<device>", retrying once on a fresh
+// connection if the old one has gone away.
+func (n *NATS) Publish(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	subject := n.subject + "." + msg.Kind + "." + subjectToken(msg.Device)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if n.conn == nil {
+			if err := n.connect(ctx); err != nil {
+				return err
+			}
+		}
+		deadline := time.Now().Add(natsWriteTimeout)
+		if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+			deadline = dl
+		}
+		fmt.Fprintf(n.w, "PUB %s %d\r\n", subject, len(data))
+		n.w.Write(data)
+		n.w.WriteString("\r\n")
+		err := n.flush(deadline)
+		if err == nil {
+			return nil
+		}
+		n.drop()
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+// Close closes the connection.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.drop()
+	return nil
+}
+
+// flush writes out what's buffered by deadline, then clears the deadline so
+// it can't fail a later write. A failed writer keeps its error, so the
+// caller drops the connection. Callers hold mu.
+func (n *NATS) flush(deadline time.Time) error {
+	n.conn.SetWriteDeadline(deadline)
+	err := n.w.Flush()
+	n.conn.SetWriteDeadline(time.Time{})
+	return err
+}
+
+// drop closes the current connection. Callers hold mu.
+func (n *NATS) drop() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn = nil
+		n.w = nil
+	}
+}
+
+// connect dials the server, upgrades to TLS if asked, and completes the
+// CONNECT/PING handshake. Callers hold mu.
+func (n *NATS) connect(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", n.addr, err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("%s is not a NATS server", n.addr)
+	}
+	if n.tls {
+		tc := tls.Client(conn, &tls.Config{ServerName: n.host})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return fmt.Errorf("tls handshake with %s: %w", n.addr, err)
+		}
+		conn = tc
+		r = bufio.NewReader(conn)
+	}
+
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "gognestcli",
+		"lang":     "go",
+		"version":  "1.0.0",
+		"protocol": 0,
+	}
+	if n.cfg.User != "" {
+		opts["user"] = n.cfg.User
+		opts["pass"] = n.cfg.Password
+	}
+	if n.cfg.Token != "" {
+		opts["auth_token"] = n.cfg.Token
+	}
+	connect, _ := json.Marshal(opts)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return fmt.Errorf("connecting to %s: %w", n.addr, err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("connecting to %s: %w", n.addr, err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return fmt.Errorf("nats server: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	n.conn = conn
+	n.w = bufio.NewWriter(conn)
+	go n.read(conn, r)
+	return nil
+}
+
+// read answers server PINGs and reports protocol errors until conn closes.
+func (n *NATS) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			n.mu.Lock()
+			if n.conn == conn {
+				n.drop()
+			}
+			n.mu.Unlock()
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PING":
+			n.mu.Lock()
+			if n.conn == conn {
+				n.w.WriteString("PONG\r\n")
+				if n.flush(time.Now().Add(natsWriteTimeout)) != nil {
+					n.drop()
+				}
+			}
+			n.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			fmt.Printf("  Warning: nats server: %s\n", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// subjectToken makes s safe as a single subject token.
+func subjectToken(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,232 @@
+package fanout
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+func TestNewNATS(t *testing.T) {
+	tests := []struct {
+		url, addr, user string
+		tls, ok         bool
+	}{
+		{"nats://localhost", "localhost:4222", "", false, true},
+		{"nats://bob:pw@10.0.0.1:4333", "10.0.0.1:4333", "bob", false, true},
+		{"tls://nats.example.com", "nats.example.com:4222", "", true, true},
+		{"http://localhost:4222", "", "", false, false},
+		{"localhost:4222", "", "", false, false},
+	}
+	for _, tt := range tests {
+		n, err := NewNATS(config.NATSConfig{URL: tt.url})
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("NewNATS(%q) succeeded, want an error", tt.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewNATS(%q): %v", tt.url, err)
+			continue
+		}
+		if n.addr != tt.addr || n.tls != tt.tls || n.cfg.User != tt.user || n.subject != "nest" {
+			t.Errorf("NewNATS(%q) = addr %q tls %v user %q subject %q", tt.url, n.addr, n.tls, n.cfg.User, n.subject)
+		}
+	}
+}
+
+func TestSubjectToken(t *testing.T) {
+	tests := map[string]string{
+		"AVPHwEtyzgSxu6EuaIOfvzmr": "AVPHwEtyzgSxu6EuaIOfvzmr",
+		"a.b*c>d e":                "a_b_c_d_e",
+		"":                         "unknown",
+	}
+	for in, want := range tests {
+		if got := subjectToken(in); got != want {
+			t.Errorf("subjectToken(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// fakeNATS accepts one connection at a time and speaks just enough of the
+// protocol: INFO, the CONNECT/PING handshake, PUB and PING/PONG.
+type fakeNATS struct {
+	ln      net.Listener
+	conns   chan net.Conn
+	connect chan map[string]any
+	pubs    chan string // "<subject> <payload>"
+	pongs   chan struct{}
+	refuse  string // -ERR message for the handshake, if set
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeNATS{
+		ln:      ln,
+		conns:   make(chan net.Conn, 4),
+		connect: make(chan map[string]any, 4),
+		pubs:    make(chan string, 16),
+		pongs:   make(chan struct{}, 4),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeNATS) url() string { return "nats://" + s.ln.Addr().String() }
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		verb, rest, _ := strings.Cut(line, " ")
+		switch verb {
+		case "CONNECT":
+			var opts map[string]any
+			json.Unmarshal([]byte(rest), &opts)
+			s.connect <- opts
+		case "PING":
+			if s.refuse != "" {
+				conn.Write([]byte("-ERR '" + s.refuse + "'\r\n"))
+				return
+			}
+			conn.Write([]byte("PONG\r\n"))
+		case "PONG":
+			s.pongs <- struct{}{}
+		case "PUB":
+			subject, size, _ := strings.Cut(rest, " ")
+			n, _ := strconv.Atoi(size)
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.pubs <- subject + " " + string(payload[:n])
+		}
+	}
+}
+
+func recv[T any](t *testing.T, ch chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the client")
+		panic("unreachable")
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	s := newFakeNATS(t)
+	n, err := NewNATS(config.NATSConfig{URL: s.url(), Subject: "home", Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	// A short context deadline applies to that publish only.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	err = n.Publish(ctx, Message{Kind: "event", Device: "dev.1", Type: "Motion"})
+	cancel()
+	if err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if opts := recv(t, s.connect); opts["auth_token"] != "secret" || opts["verbose"] != false {
+		t.Errorf("CONNECT options = %v", opts)
+	}
+	subject, payload, _ := strings.Cut(recv(t, s.pubs), " ")
+	if subject != "home.event.dev_1" {
+		t.Errorf("subject = %q, want home.event.dev_1", subject)
+	}
+	var msg Message
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil || msg.Type != "Motion" {
+		t.Errorf("payload = %q (%v)", payload, err)
+	}
+
+	// Once that deadline has passed, the connection must still be usable:
+	// server PINGs get a PONG and the next publish reuses it.
+	time.Sleep(300 * time.Millisecond)
+	conn := recv(t, s.conns)
+	conn.Write([]byte("PING\r\n"))
+	recv(t, s.pongs)
+	if err := n.Publish(context.Background(), Message{Kind: "event", Device: "dev.1"}); err != nil {
+		t.Fatalf("second Publish: %v", err)
+	}
+	recv(t, s.pubs)
+	select {
+	case <-s.conns:
+		t.Error("second publish reconnected")
+	default:
+	}
+}
+
+func TestNATSReconnect(t *testing.T) {
+	s := newFakeNATS(t)
+	n, err := NewNATS(config.NATSConfig{URL: s.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	if err := n.Publish(context.Background(), Message{Kind: "event", Device: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	recv(t, s.pubs)
+	recv(t, s.conns).Close()
+	// The reader notices the close and drops the connection; the next
+	// publish dials again.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n.mu.Lock()
+		gone := n.conn == nil
+		n.mu.Unlock()
+		if gone || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := n.Publish(context.Background(), Message{Kind: "event", Device: "a"}); err != nil {
+		t.Fatalf("Publish after disconnect: %v", err)
+	}
+	recv(t, s.pubs)
+}
+
+func TestNATSConnectRefused(t *testing.T) {
+	s := newFakeNATS(t)
+	s.refuse = "Authorization Violation"
+	n, err := NewNATS(config.NATSConfig{URL: s.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Publish(context.Background(), Message{Kind: "event", Device: "a"})
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Publish error = %v, want the server's -ERR", err)
+	}
+}
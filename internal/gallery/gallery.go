@@ -0,0 +1,183 @@
+package gallery
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Item is a single capture shown in the gallery.
+type Item struct {
+	Href    string // path relative to the gallery file
+	Name    string
+	Time    time.Time
+	IsVideo bool
+}
+
+// DeviceGroup holds one device's captures for a single day.
+type DeviceGroup struct {
+	Device string
+	Items  []Item
+}
+
+// Day holds all captures for a calendar day, grouped by device.
+type Day struct {
+	Date    string
+	Devices []DeviceGroup
+}
+
+var mediaExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".mp4":  true,
+	".webm": true,
+	".mkv":  true,
+}
+
+var videoExts = map[string]bool{
+	".mp4":  true,
+	".webm": true,
+	".mkv":  true,
+}
+
+// Generate scans dir for captures and writes a static HTML gallery to out.
+// Captures in subdirectories are attributed to the device named by their
+// first path segment; flat layouts are listed under "All devices".
+func Generate(dir, out string) (int, error) {
+	outDir, err := filepath.Abs(filepath.Dir(out))
+	if err != nil {
+		return 0, err
+	}
+
+	byDay := make(map[string]map[string][]Item)
+	count := 0
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if !mediaExts[ext] || strings.Contains(d.Name(), ".tmp.") || strings.Contains(d.Name(), ".partial.") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		device := "All devices"
+		if parts := strings.Split(filepath.ToSlash(rel), "/"); len(parts) > 1 {
+			device = parts[0]
+		}
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		href, err := filepath.Rel(outDir, abs)
+		if err != nil {
+			href = abs
+		}
+
+		day := info.ModTime().Format("2006-01-02")
+		if byDay[day] == nil {
+			byDay[day] = make(map[string][]Item)
+		}
+		byDay[day][device] = append(byDay[day][device], Item{
+			Href:    filepath.ToSlash(href),
+			Name:    d.Name(),
+			Time:    info.ModTime(),
+			IsVideo: videoExts[ext],
+		})
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("scanning %s: %w", dir, err)
+	}
+
+	var days []Day
+	for date, devices := range byDay {
+		day := Day{Date: date}
+		for device, items := range devices {
+			sort.Slice(items, func(i, j int) bool { return items[i].Time.After(items[j].Time) })
+			day.Devices = append(day.Devices, DeviceGroup{Device: device, Items: items})
+		}
+		sort.Slice(day.Devices, func(i, j int) bool { return day.Devices[i].Device < day.Devices[j].Device })
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date > days[j].Date })
+
+	// Write to a temp file first so a browser refresh never sees a partial page.
+	tmp := out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	err = pageTmpl.Execute(f, struct {
+		Generated time.Time
+		Count     int
+		Days      []Day
+	}{time.Now(), count, days})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("writing gallery: %w", err)
+	}
+	return count, os.Rename(tmp, out)
+}
+
+var pageTmpl = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>gognestcli captures</title>
+<style>
+body { font-family: -apple-system, system-ui, sans-serif; margin: 1.5rem; background: #111; color: #eee; }
+h1 { font-size: 1.4rem; }
+h2 { font-size: 1.2rem; border-bottom: 1px solid #333; padding-bottom: .3rem; margin-top: 2rem; }
+h3 { font-size: 1rem; color: #aaa; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(240px, 1fr)); gap: .75rem; }
+figure { margin: 0; background: #1c1c1c; border-radius: 6px; overflow: hidden; }
+img, video { width: 100%; display: block; background: #000; }
+figcaption { font-size: .8rem; padding: .3rem .5rem; color: #bbb; }
+.meta { color: #777; font-size: .85rem; }
+</style>
+</head>
+<body>
+<h1>gognestcli captures</h1>
+<p class="meta">{{.Count}} captures &middot; generated {{.Generated.Format "2006-01-02 15:04:05"}}</p>
+{{range .Days}}
+<h2>{{.Date}}</h2>
+{{range .Devices}}
+<h3>{{.Device}} ({{len .Items}})</h3>
+<div class="grid">
+{{range .Items}}<figure>
+{{if .IsVideo}}<video src="{{.Href}}" controls preload="metadata"></video>{{else}}<a href="{{.Href}}"><img src="{{.Href}}" loading="lazy" alt="{{.Name}}"></a>{{end}}
+<figcaption>{{.Time.Format "15:04:05"}} &middot; {{.Name}}</figcaption>
+</figure>
+{{end}}</div>
+{{end}}
+{{else}}
+<p>No captures yet.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,93 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileName is the history file kept alongside captures in the output dir.
+const FileName = "history.ndjson"
+
+// Record kinds.
+const (
+	KindEvent   = "event"
+	KindCapture = "capture"
+)
+
+// Record is one line of the event history.
+type Record struct {
+	Kind    string    `json:"kind"`
+	Time    time.Time `json:"time"`
+	Device  string    `json:"device"`
+	Type    string    `json:"type"`
+	EventID string    `json:"event_id,omitempty"`
+	Path    string    `json:"path,omitempty"` // capture path relative to the output dir
+
+	// Subscription labels the Pub/Sub subscription an event came from when
+	// events listens to several.
+	Subscription string `json:"subscription,omitempty"`
+}
+
+// Log appends records to an NDJSON history file.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens (or creates) the history file in dir for appending.
+func Open(dir string) (*Log, error) {
+	f, err := os.OpenFile(filepath.Join(dir, FileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{file: f}, nil
+}
+
+// Append writes a record as a single JSON line.
+func (l *Log) Append(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the history file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Read returns all records in dir's history file. A missing file yields no
+// records; malformed lines are skipped.
+func Read(dir string) ([]Record, error) {
+	f, err := os.Open(filepath.Join(dir, FileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
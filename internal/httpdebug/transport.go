@@ -0,0 +1,199 @@
+// Package httpdebug logs HTTP traffic for --debug-http. Requests and
+// responses are written with their headers and text bodies; credentials in
+// headers and query strings, token requests (including signed JWT
+// assertions) and token responses are redacted.
+package httpdebug
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBody caps how much of each body is logged.
+const maxBody = 64 << 10
+
+// sensitiveHeaders are replaced with "[REDACTED]", as are other headers
+// whose names look like credentials (see sensitiveName), such as custom
+// ones set in config.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Goog-Api-Key":      true,
+	"X-Sentry-Auth":       true,
+}
+
+// sensitiveWords mark header and query parameter names that carry
+// credentials, e.g. "X-Auth-Token", "X-Amz-Signature" or "sentry_key".
+var sensitiveWords = []string{"token", "secret", "password", "passwd", "signature", "credential", "apikey", "_key", "-key"}
+
+// sensitiveParams are query parameters redacted by exact name.
+var sensitiveParams = map[string]bool{"key": true, "sig": true, "code": true, "assertion": true, "auth": true}
+
+var (
+	jsonSecret = regexp.MustCompile(`("(?:access_token|refresh_token|id_token|client_secret|private_key|token)"\s*:\s*)"[^"]*"`)
+	formSecret = regexp.MustCompile(`((?:^|&)(?:client_secret|refresh_token|code|access_token|assertion)=)[^&]*`)
+)
+
+// Transport is an http.RoundTripper that logs each exchange to a writer
+// before handing the response back unchanged.
+type Transport struct {
+	next http.RoundTripper
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+// New returns a Transport that logs to w and sends requests via next.
+func New(w io.Writer, next http.RoundTripper) *Transport {
+	return &Transport{next: next, w: w}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.seq++
+	id := t.seq
+	t.mu.Unlock()
+
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody && isText(req.Header.Get("Content-Type")) {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = data
+		req.Body = io.NopCloser(bytes.NewReader(data))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--> #%d %s %s %s\n", id, time.Now().Format(time.RFC3339Nano), req.Method, redactURL(req.URL))
+	writeHeaders(&b, req.Header)
+	writeBody(&b, reqBody, req.ContentLength, req.Header.Get("Content-Type"))
+	t.write(b.String())
+
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	b.Reset()
+	if err != nil {
+		fmt.Fprintf(&b, "<-- #%d error after %s: %v\n\n", id, time.Since(started).Round(time.Millisecond), err)
+		t.write(b.String())
+		return nil, err
+	}
+
+	var respBody []byte
+	if isText(resp.Header.Get("Content-Type")) {
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		respBody = data
+	}
+
+	fmt.Fprintf(&b, "<-- #%d %s in %s\n", id, resp.Status, time.Since(started).Round(time.Millisecond))
+	writeHeaders(&b, resp.Header)
+	writeBody(&b, respBody, resp.ContentLength, resp.Header.Get("Content-Type"))
+	t.write(b.String())
+	return resp, nil
+}
+
+func (t *Transport) write(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, s)
+}
+
+func writeHeaders(b *strings.Builder, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := strings.Join(h[k], ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(k)] || sensitiveName(k) {
+			v = "[REDACTED]"
+		}
+		fmt.Fprintf(b, "%s: %s\n", k, v)
+	}
+}
+
+// writeBody logs a text body with secrets redacted, or a size summary for
+// binary content, followed by a blank separator line.
+func writeBody(b *strings.Builder, body []byte, length int64, contentType string) {
+	switch {
+	case body != nil:
+		text := strings.TrimRight(redact(string(body)), "\n")
+		if len(text) > maxBody {
+			text = fmt.Sprintf("%s\n[truncated, %d bytes total]", text[:maxBody], len(text))
+		}
+		if text != "" {
+			b.WriteString("\n" + text + "\n")
+		}
+	case length > 0:
+		fmt.Fprintf(b, "\n[%d bytes of %s]\n", length, contentType)
+	}
+	b.WriteString("\n")
+}
+
+func redact(s string) string {
+	s = jsonSecret.ReplaceAllString(s, `$1"[REDACTED]"`)
+	return formSecret.ReplaceAllString(s, `${1}[REDACTED]`)
+}
+
+// redactURL returns u with its password and the values of sensitive query
+// parameters replaced, keeping the parameters' order.
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Redacted()
+	}
+	c := *u
+	params := strings.Split(c.RawQuery, "&")
+	for i, p := range params {
+		name, _, hasValue := strings.Cut(p, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if hasValue && (sensitiveParams[strings.ToLower(name)] || sensitiveName(name)) {
+			params[i] = p[:strings.Index(p, "=")+1] + "[REDACTED]"
+		}
+	}
+	c.RawQuery = strings.Join(params, "&")
+	return c.Redacted()
+}
+
+// sensitiveName reports whether a header or query parameter name looks like
+// it carries a credential.
+func sensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range sensitiveWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// isText reports whether a body of this content type is worth logging.
+func isText(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "json") ||
+		mt == "application/x-www-form-urlencoded"
+}
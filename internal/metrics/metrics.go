@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+// Sample is one gauge reading taken from a device's traits.
+type Sample struct {
+	Metric string            // e.g. "nest_ambient_temperature_celsius"
+	Labels map[string]string // always includes device and room
+	Value  float64
+	Time   time.Time
+}
+
+// traitFields is the union of the numeric/status fields used by the traits
+// Extract understands.
+type traitFields struct {
+	AmbientTemperatureCelsius *float64 `json:"ambientTemperatureCelsius"`
+	AmbientHumidityPercent    *float64 `json:"ambientHumidityPercent"`
+	HeatCelsius               *float64 `json:"heatCelsius"`
+	CoolCelsius               *float64 `json:"coolCelsius"`
+	Status                    string   `json:"status"`
+	Mode                      string   `json:"mode"`
+}
+
+// Extract reads thermostat and sensor gauges from a device. room is the
+// human-readable room name used as a label.
+func Extract(dev sdm.Device, room string, now time.Time) []Sample {
+	id := dev.Name[strings.LastIndex(dev.Name, "/")+1:]
+	base := map[string]string{"device": id, "room": room}
+
+	var out []Sample
+	add := func(metric string, value float64, extra ...string) {
+		labels := make(map[string]string, len(base)+len(extra)/2)
+		for k, v := range base {
+			labels[k] = v
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			labels[extra[i]] = extra[i+1]
+		}
+		out = append(out, Sample{Metric: metric, Labels: labels, Value: value, Time: now})
+	}
+
+	for name, raw := range dev.Traits {
+		var t traitFields
+		if err := json.Unmarshal(raw, &t); err != nil {
+			continue
+		}
+
+		switch name {
+		case "sdm.devices.traits.Temperature":
+			if t.AmbientTemperatureCelsius != nil {
+				add("nest_ambient_temperature_celsius", *t.AmbientTemperatureCelsius)
+			}
+		case "sdm.devices.traits.Humidity":
+			if t.AmbientHumidityPercent != nil {
+				add("nest_ambient_humidity_percent", *t.AmbientHumidityPercent)
+			}
+		case "sdm.devices.traits.ThermostatTemperatureSetpoint":
+			if t.HeatCelsius != nil {
+				add("nest_setpoint_heat_celsius", *t.HeatCelsius)
+			}
+			if t.CoolCelsius != nil {
+				add("nest_setpoint_cool_celsius", *t.CoolCelsius)
+			}
+		case "sdm.devices.traits.ThermostatEco":
+			if t.HeatCelsius != nil {
+				add("nest_eco_heat_celsius", *t.HeatCelsius)
+			}
+			if t.CoolCelsius != nil {
+				add("nest_eco_cool_celsius", *t.CoolCelsius)
+			}
+			if t.Mode != "" {
+				add("nest_eco_active", boolValue(t.Mode != "OFF"))
+			}
+		case "sdm.devices.traits.ThermostatHvac":
+			if t.Status != "" {
+				add("nest_hvac_heating", boolValue(t.Status == "HEATING"))
+				add("nest_hvac_cooling", boolValue(t.Status == "COOLING"))
+			}
+		case "sdm.devices.traits.ThermostatMode":
+			if t.Mode != "" {
+				add("nest_thermostat_mode", 1, "mode", t.Mode)
+			}
+		case "sdm.devices.traits.Connectivity":
+			if t.Status != "" {
+				add("nest_online", boolValue(t.Status == "ONLINE"))
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Metric < out[j].Metric })
+	return out
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WritePrometheus writes samples in the Prometheus text exposition format.
+// Samples are grouped by metric, as the format requires each family's
+// samples to follow its one TYPE line, keeping their order within it.
+func WritePrometheus(w io.Writer, samples []Sample) error {
+	samples = slices.Clone(samples)
+	slices.SortStableFunc(samples, func(a, b Sample) int { return strings.Compare(a.Metric, b.Metric) })
+	var last string
+	for _, s := range samples {
+		if s.Metric != last {
+			fmt.Fprintf(w, "# TYPE %s gauge\n", s.Metric)
+			last = s.Metric
+		}
+		if _, err := fmt.Fprintf(w, "%s{%s} %s\n", s.Metric, promLabels(s.Labels), formatFloat(s.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteInflux writes samples as InfluxDB line protocol, one line per sample.
+func WriteInflux(w io.Writer, samples []Sample) error {
+	for _, s := range samples {
+		var tags strings.Builder
+		for _, k := range sortedKeys(s.Labels) {
+			fmt.Fprintf(&tags, ",%s=%s", k, influxEscape(s.Labels[k]))
+		}
+		if _, err := fmt.Fprintf(w, "%s%s value=%s %d\n", s.Metric, tags.String(), formatFloat(s.Value), s.Time.UnixNano()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CSVHeader is the header row written by WriteCSV.
+const CSVHeader = "time,device,room,metric,labels,value\n"
+
+// WriteCSV writes samples as CSV rows (without header).
+func WriteCSV(w io.Writer, samples []Sample) error {
+	for _, s := range samples {
+		var extra []string
+		for _, k := range sortedKeys(s.Labels) {
+			if k != "device" && k != "room" {
+				extra = append(extra, k+"="+s.Labels[k])
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s,%s,%s,%s,%s,%s\n",
+			s.Time.Format(time.RFC3339), csvEscape(s.Labels["device"]), csvEscape(s.Labels["room"]),
+			s.Metric, csvEscape(strings.Join(extra, ";")), formatFloat(s.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func promLabels(labels map[string]string) string {
+	var parts []string
+	for _, k := range sortedKeys(labels) {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, strconv.Quote(labels[k])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func influxEscape(s string) string {
+	r := strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	return r.Replace(s)
+}
+
+func csvEscape(s string) string {
+	if strings.ContainsAny(s, ",\"\n") {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+func thermostat(name, temp string) sdm.Device {
+	return sdm.Device{
+		Name: "enterprises/p/devices/" + name,
+		Traits: map[string]json.RawMessage{
+			"sdm.devices.traits.Temperature":  json.RawMessage(`{"ambientTemperatureCelsius": ` + temp + `}`),
+			"sdm.devices.traits.Humidity":     json.RawMessage(`{"ambientHumidityPercent": 40}`),
+			"sdm.devices.traits.Connectivity": json.RawMessage(`{"status": "ONLINE"}`),
+		},
+	}
+}
+
+func TestWritePrometheusGroupsFamiliesAcrossDevices(t *testing.T) {
+	now := time.Unix(0, 0)
+	var samples []Sample
+	samples = append(samples, Extract(thermostat("a", "20.5"), "Hall", now)...)
+	samples = append(samples, Extract(thermostat("b", "18"), "Attic", now)...)
+
+	var b strings.Builder
+	if err := WritePrometheus(&b, samples); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE nest_ambient_humidity_percent gauge
+nest_ambient_humidity_percent{device="a",room="Hall"} 40
+nest_ambient_humidity_percent{device="b",room="Attic"} 40
+# TYPE nest_ambient_temperature_celsius gauge
+nest_ambient_temperature_celsius{device="a",room="Hall"} 20.5
+nest_ambient_temperature_celsius{device="b",room="Attic"} 18
+# TYPE nest_online gauge
+nest_online{device="a",room="Hall"} 1
+nest_online{device="b",room="Attic"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("WritePrometheus:\n%s\nwant:\n%s", got, want)
+	}
+	if samples[0].Labels["device"] != "a" || samples[3].Labels["device"] != "b" {
+		t.Error("WritePrometheus reordered its input")
+	}
+}
@@ -0,0 +1,194 @@
+package mock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	streamFPS = 10
+	keyEvery  = 5 // frames between key frames
+)
+
+// h264Fmtp are the profiles the simulated camera accepts. It always sends
+// Constrained Baseline, which every H264 decoder can play.
+var h264Fmtp = []string{
+	"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f",
+	"level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032",
+}
+
+// cameraSession is one simulated WebRTC stream.
+type cameraSession struct {
+	pc   *webrtc.PeerConnection
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *cameraSession) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.pc.Close()
+	})
+}
+
+// generateStream answers a WebRTC offer with a peer connection that streams
+// the test card once connected.
+func (s *Server) generateStream(device, offerSDP string) (answerSDP, mediaSessionID string, err error) {
+	m := &webrtc.MediaEngine{}
+	for i, fmtp := range h264Fmtp {
+		if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: fmtp},
+			PayloadType:        webrtc.PayloadType(96 + i),
+		}, webrtc.RTPCodecTypeVideo); err != nil {
+			return "", "", err
+		}
+	}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		PayloadType:        111,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return "", "", err
+	}
+
+	// Like a real camera, answer NACKs so lost packets are resent.
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, ir); err != nil {
+		return "", "", err
+	}
+
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(ir)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return "", "", fmt.Errorf("creating peer connection: %w", err)
+	}
+	sess := &cameraSession{pc: pc, done: make(chan struct{})}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+		MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: h264Fmtp[0],
+	}, "video", "mock")
+	if err != nil {
+		pc.Close()
+		return "", "", err
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("invalid offer: %w", err)
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		return "", "", err
+	}
+	// Like a real camera, send a key frame next when the viewer asks for one.
+	keyframe := make(chan struct{}, 1)
+	go readPLI(sender, keyframe)
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("creating answer: %w", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		return "", "", err
+	}
+	<-gathered
+
+	var started sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			started.Do(func() { go streamTestCard(track, keyframe, sess.done) })
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			sess.close()
+		}
+	})
+
+	id := fmt.Sprintf("mock-media-%s-%d", device[len(devicePrefix):], s.seq.Add(1))
+	s.mu.Lock()
+	s.sessions[id] = sess
+	s.mu.Unlock()
+	return pc.LocalDescription().SDP, id, nil
+}
+
+func (s *Server) stopStream(id string) {
+	s.mu.Lock()
+	sess := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if sess != nil {
+		sess.close()
+	}
+}
+
+// readPLI signals keyframe for each Picture Loss Indication the viewer sends,
+// until the sender is closed.
+func readPLI(sender *webrtc.RTPSender, keyframe chan<- struct{}) {
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, pkt := range pkts {
+			if _, ok := pkt.(*rtcp.PictureLossIndication); ok {
+				select {
+				case keyframe <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// streamTestCard writes the animated test card to track until done closes,
+// with a key frame every keyEvery frames and whenever keyframe fires.
+// Packets are paced because an unpaced key frame (~100 packets) overflows
+// socket buffers even on loopback.
+func streamTestCard(track *webrtc.TrackLocalStaticRTP, keyframe <-chan struct{}, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second / streamFPS)
+	defer ticker.Stop()
+	packetizer := rtp.NewPacketizer(1200, 0, 0, &codecs.H264Payloader{}, rtp.NewRandomSequencer(), 90000)
+
+	var pic picture
+	var idrID, frameNum uint
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		requested := false
+		select {
+		case <-keyframe:
+			requested = true
+		default:
+		}
+
+		var data []byte
+		if i%keyEvery == 0 || requested {
+			drawTestCard(&pic, i)
+			data = idrFrame(&pic, idrID)
+			idrID++
+			frameNum = 1
+		} else {
+			data = skipFrame(frameNum)
+			frameNum++
+		}
+		for n, pkt := range packetizer.Packetize(data, 90000/streamFPS) {
+			if err := track.WriteRTP(pkt); err != nil {
+				return
+			}
+			if n%8 == 7 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package mock
+
+import (
+	"math"
+	"time"
+)
+
+// devices returns the simulated devices: a camera, a doorbell and a
+// thermostat whose temperature drifts over the day.
+func devices() []map[string]any {
+	liveStream := map[string]any{
+		"maxVideoResolution": map[string]any{"width": frameWidth, "height": frameHeight},
+		"videoCodecs":        []string{"H264"},
+		"audioCodecs":        []string{"OPUS"},
+		"supportedProtocols": []string{"WEB_RTC"},
+	}
+	room := func(id, name string) []map[string]any {
+		return []map[string]any{{
+			"parent":      "enterprises/" + ProjectID + "/structures/mock-home/rooms/" + id,
+			"displayName": name,
+		}}
+	}
+
+	return []map[string]any{
+		{
+			"name": devicePrefix + "mock-camera",
+			"type": "sdm.devices.types.CAMERA",
+			"traits": map[string]any{
+				"sdm.devices.traits.Info":             map[string]any{"customName": "Mock Camera"},
+				"sdm.devices.traits.CameraLiveStream": liveStream,
+				"sdm.devices.traits.CameraImage":      map[string]any{"maxImageResolution": map[string]any{"width": 640, "height": 480}},
+				"sdm.devices.traits.CameraEventImage": map[string]any{},
+				"sdm.devices.traits.CameraMotion":     map[string]any{},
+				"sdm.devices.traits.CameraPerson":     map[string]any{},
+				"sdm.devices.traits.CameraSound":      map[string]any{},
+			},
+			"parentRelations": room("backyard", "Backyard"),
+		},
+		{
+			"name": devicePrefix + "mock-doorbell",
+			"type": "sdm.devices.types.DOORBELL",
+			"traits": map[string]any{
+				"sdm.devices.traits.Info":             map[string]any{"customName": "Mock Doorbell"},
+				"sdm.devices.traits.CameraLiveStream": liveStream,
+				"sdm.devices.traits.CameraEventImage": map[string]any{},
+				"sdm.devices.traits.CameraMotion":     map[string]any{},
+				"sdm.devices.traits.CameraPerson":     map[string]any{},
+				"sdm.devices.traits.DoorbellChime":    map[string]any{},
+			},
+			"parentRelations": room("entryway", "Entryway"),
+		},
+		{
+			"name": devicePrefix + "mock-thermostat",
+			"type": "sdm.devices.types.THERMOSTAT",
+			"traits": map[string]any{
+				"sdm.devices.traits.Info":                          map[string]any{"customName": "Mock Thermostat"},
+				"sdm.devices.traits.Connectivity":                  map[string]any{"status": "ONLINE"},
+				"sdm.devices.traits.Temperature":                   map[string]any{"ambientTemperatureCelsius": temperature(time.Now())},
+				"sdm.devices.traits.Humidity":                      map[string]any{"ambientHumidityPercent": 45},
+				"sdm.devices.traits.ThermostatMode":                map[string]any{"mode": "HEAT", "availableModes": []string{"HEAT", "COOL", "HEATCOOL", "OFF"}},
+				"sdm.devices.traits.ThermostatHvac":                map[string]any{"status": "HEATING"},
+				"sdm.devices.traits.ThermostatTemperatureSetpoint": map[string]any{"heatCelsius": 21.0},
+			},
+			"parentRelations": room("living-room", "Living Room"),
+		},
+	}
+}
+
+// findDevice returns the device with the given resource name, or nil.
+func findDevice(name string) map[string]any {
+	for _, dev := range devices() {
+		if dev["name"] == name {
+			return dev
+		}
+	}
+	return nil
+}
+
+// temperature swings between 19 and 23 °C over an hour, rounded to 0.1.
+func temperature(t time.Time) float64 {
+	phase := float64(t.Unix()%3600) / 3600 * 2 * math.Pi
+	return math.Round((21+2*math.Sin(phase))*10) / 10
+}
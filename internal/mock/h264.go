@@ -0,0 +1,205 @@
+package mock
+
+// A tiny H264 (Constrained Baseline) encoder for the simulated camera. Key
+// frames are uncompressed I_PCM macroblocks and the frames in between are all
+// skipped P macroblocks, so no real encoder is needed and any decoder can
+// play the result.
+
+const (
+	frameWidth  = 320
+	frameHeight = 240
+	mbCols      = frameWidth / 16
+	mbRows      = frameHeight / 16
+)
+
+// bitWriter writes an RBSP most significant bit first.
+type bitWriter struct {
+	buf []byte
+	cur byte
+	n   uint
+}
+
+func (w *bitWriter) bit(b uint) {
+	w.cur = w.cur<<1 | byte(b&1)
+	w.n++
+	if w.n == 8 {
+		w.buf = append(w.buf, w.cur)
+		w.cur, w.n = 0, 0
+	}
+}
+
+func (w *bitWriter) bits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> uint(i))
+	}
+}
+
+// ue writes an unsigned Exp-Golomb code.
+func (w *bitWriter) ue(v uint) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.bits(0, n)
+	w.bits(v, n+1)
+}
+
+// se writes a signed Exp-Golomb code.
+func (w *bitWriter) se(v int) {
+	if v > 0 {
+		w.ue(uint(2*v - 1))
+	} else {
+		w.ue(uint(-2 * v))
+	}
+}
+
+func (w *bitWriter) align() {
+	for w.n != 0 {
+		w.bit(0)
+	}
+}
+
+// trailing writes rbsp_trailing_bits and returns the RBSP.
+func (w *bitWriter) trailing() []byte {
+	w.bit(1)
+	w.align()
+	return w.buf
+}
+
+// nal wraps an RBSP in an Annex B NAL unit, adding emulation prevention bytes.
+func nal(header byte, rbsp []byte) []byte {
+	out := []byte{0, 0, 0, 1, header}
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+func sps() []byte {
+	var w bitWriter
+	w.bits(66, 8)   // profile_idc: Baseline
+	w.bits(0xe0, 8) // constraint_set0..2 (Constrained Baseline)
+	w.bits(31, 8)   // level_idc 3.1
+	w.ue(0)         // seq_parameter_set_id
+	w.ue(0)         // log2_max_frame_num_minus4
+	w.ue(2)         // pic_order_cnt_type: output order = decode order
+	w.ue(1)         // max_num_ref_frames
+	w.bit(0)        // gaps_in_frame_num_value_allowed_flag
+	w.ue(mbCols - 1)
+	w.ue(mbRows - 1)
+	w.bit(1) // frame_mbs_only_flag
+	w.bit(1) // direct_8x8_inference_flag
+	w.bit(0) // frame_cropping_flag
+	w.bit(0) // vui_parameters_present_flag
+	return nal(0x67, w.trailing())
+}
+
+func pps() []byte {
+	var w bitWriter
+	w.ue(0)      // pic_parameter_set_id
+	w.ue(0)      // seq_parameter_set_id
+	w.bit(0)     // entropy_coding_mode_flag: CAVLC
+	w.bit(0)     // bottom_field_pic_order_in_frame_present_flag
+	w.ue(0)      // num_slice_groups_minus1
+	w.ue(0)      // num_ref_idx_l0_default_active_minus1
+	w.ue(0)      // num_ref_idx_l1_default_active_minus1
+	w.bit(0)     // weighted_pred_flag
+	w.bits(0, 2) // weighted_bipred_idc
+	w.se(0)      // pic_init_qp_minus26
+	w.se(0)      // pic_init_qs_minus26
+	w.se(0)      // chroma_qp_index_offset
+	w.bit(1)     // deblocking_filter_control_present_flag
+	w.bit(0)     // constrained_intra_pred_flag
+	w.bit(0)     // redundant_pic_cnt_present_flag
+	return nal(0x68, w.trailing())
+}
+
+// picture is a 4:2:0 frame.
+type picture struct {
+	y      [frameWidth * frameHeight]byte
+	cb, cr [frameWidth * frameHeight / 4]byte
+}
+
+// idrFrame encodes pic as SPS, PPS and an IDR slice of I_PCM macroblocks.
+func idrFrame(pic *picture, idrID uint) []byte {
+	var w bitWriter
+	w.ue(0)         // first_mb_in_slice
+	w.ue(7)         // slice_type: I
+	w.ue(0)         // pic_parameter_set_id
+	w.bits(0, 4)    // frame_num
+	w.ue(idrID & 1) // idr_pic_id: consecutive IDRs must differ
+	w.bit(0)        // no_output_of_prior_pics_flag
+	w.bit(0)        // long_term_reference_flag
+	w.se(0)         // slice_qp_delta
+	w.ue(1)         // disable_deblocking_filter_idc
+
+	const cw = frameWidth / 2
+	for my := 0; my < mbRows; my++ {
+		for mx := 0; mx < mbCols; mx++ {
+			w.ue(25) // mb_type: I_PCM
+			w.align()
+			for y := 0; y < 16; y++ {
+				row := (my*16+y)*frameWidth + mx*16
+				w.buf = append(w.buf, pic.y[row:row+16]...)
+			}
+			for _, plane := range [][]byte{pic.cb[:], pic.cr[:]} {
+				for y := 0; y < 8; y++ {
+					row := (my*8+y)*cw + mx*8
+					w.buf = append(w.buf, plane[row:row+8]...)
+				}
+			}
+		}
+	}
+
+	frame := append(sps(), pps()...)
+	return append(frame, nal(0x65, w.trailing())...)
+}
+
+// skipFrame encodes a P slice that repeats the previous frame.
+func skipFrame(frameNum uint) []byte {
+	var w bitWriter
+	w.ue(0)                // first_mb_in_slice
+	w.ue(5)                // slice_type: P
+	w.ue(0)                // pic_parameter_set_id
+	w.bits(frameNum%16, 4) // frame_num
+	w.bit(0)               // num_ref_idx_active_override_flag
+	w.bit(0)               // ref_pic_list_modification_flag_l0
+	w.bit(0)               // adaptive_ref_pic_marking_mode_flag
+	w.se(0)                // slice_qp_delta
+	w.ue(1)                // disable_deblocking_filter_idc
+	w.ue(mbCols * mbRows)  // mb_skip_run: every macroblock
+	return nal(0x41, w.trailing())
+}
+
+// drawTestCard fills pic with a moving gradient and a bar sweeping across
+// the frame, so consecutive key frames visibly differ.
+func drawTestCard(pic *picture, tick int) {
+	bar := (tick * 8) % frameWidth
+	for y := 0; y < frameHeight; y++ {
+		for x := 0; x < frameWidth; x++ {
+			v := 16 + (x+y+tick*4)%200
+			if x >= bar && x < bar+24 {
+				v = 235
+			}
+			pic.y[y*frameWidth+x] = byte(v)
+		}
+	}
+	const cw, ch = frameWidth / 2, frameHeight / 2
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw; x++ {
+			pic.cb[y*cw+x] = byte(16 + (x*224/cw+tick*3)%224)
+			pic.cr[y*cw+x] = byte(16 + (y*224/ch+tick*5)%224)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package mock is a fake SDM and Pub/Sub API with simulated devices, so
+// commands can be exercised end to end without Nest hardware or Google
+// credentials. Cameras answer WebRTC offers with a synthetic H264 stream, and
+// the subscription emits motion, person, chime and temperature updates.
+package mock
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Names used by the fake API.
+const (
+	ProjectID    = "mock-project"
+	Subscription = "projects/mock-project/subscriptions/mock-events"
+	AccessToken  = "mock-token"
+)
+
+const devicePrefix = "enterprises/" + ProjectID + "/devices/"
+
+// mockUserID is the userId carried by fake Pub/Sub messages.
+const mockUserID = "mock-user"
+
+// Server serves the fake APIs on a loopback port.
+type Server struct {
+	// URL is the API root to use in place of the SDM and Pub/Sub base URLs.
+	URL string
+
+	// EventInterval is how often the subscription delivers a new event.
+	EventInterval time.Duration
+
+	srv      *http.Server
+	seq      atomic.Int64
+	mu       sync.Mutex
+	sessions map[string]*cameraSession
+	next     time.Time
+	tick     int
+}
+
+// Start starts a server on 127.0.0.1 with a random port.
+func Start() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("starting mock server: %w", err)
+	}
+	s := &Server{
+		URL:           "http://" + ln.Addr().String() + "/v1",
+		EventInterval: 15 * time.Second,
+		sessions:      map[string]*cameraSession{},
+		next:          time.Now().Add(3 * time.Second),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/", s.handleAPI)
+	mux.HandleFunc("/image/", s.handleImage)
+	s.srv = &http.Server{Handler: mux}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+// Close stops the server and any simulated streams.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	for id, sess := range s.sessions {
+		sess.close()
+		delete(s.sessions, id)
+	}
+	s.mu.Unlock()
+	return s.srv.Close()
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case r.Method == http.MethodGet && path == "enterprises/"+ProjectID+"/devices":
+		writeJSON(w, map[string]any{"devices": devices()})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, devicePrefix):
+		dev := findDevice(path)
+		if dev == nil {
+			writeError(w, http.StatusNotFound, "Device "+path+" not found.")
+			return
+		}
+		writeJSON(w, dev)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, ":executeCommand"):
+		s.executeCommand(w, r, strings.TrimSuffix(path, ":executeCommand"))
+	case r.Method == http.MethodPost && path == Subscription+":pull":
+		s.pull(w, r)
+	case r.Method == http.MethodPost && (path == Subscription+":acknowledge" || path == Subscription+":modifyAckDeadline"):
+		writeJSON(w, map[string]any{})
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "projects/"+ProjectID+"/topics/") && strings.HasSuffix(path, ":publish"):
+		s.publish(w, r)
+	case r.Method == http.MethodGet && path == Subscription:
+		writeJSON(w, map[string]any{"name": Subscription, "ackDeadlineSeconds": 10})
+	case r.Method == http.MethodPost && path == Subscription+":testIamPermissions":
+		var req struct {
+			Permissions []string `json:"permissions"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		writeJSON(w, map[string]any{"permissions": req.Permissions})
+	default:
+		writeError(w, http.StatusNotFound, "Unknown mock endpoint "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) executeCommand(w http.ResponseWriter, r *http.Request, name string) {
+	if findDevice(name) == nil {
+		writeError(w, http.StatusNotFound, "Device "+name+" not found.")
+		return
+	}
+	var req struct {
+		Command string         `json:"command"`
+		Params  map[string]any `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON payload received.")
+		return
+	}
+	param := func(key string) string {
+		v, _ := req.Params[key].(string)
+		return v
+	}
+
+	switch req.Command {
+	case "sdm.devices.commands.CameraLiveStream.GenerateWebRtcStream":
+		answer, id, err := s.generateStream(name, param("offerSdp"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, map[string]any{"results": map[string]any{
+			"answerSdp":      answer,
+			"mediaSessionId": id,
+			"expiresAt":      time.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		}})
+	case "sdm.devices.commands.CameraLiveStream.ExtendWebRtcStream":
+		writeJSON(w, map[string]any{"results": map[string]any{
+			"mediaSessionId": param("mediaSessionId"),
+			"expiresAt":      time.Now().Add(5 * time.Minute).Format(time.RFC3339),
+		}})
+	case "sdm.devices.commands.CameraLiveStream.StopWebRtcStream":
+		s.stopStream(param("mediaSessionId"))
+		writeJSON(w, map[string]any{"results": map[string]any{}})
+	case "sdm.devices.commands.CameraEventImage.GenerateImage":
+		writeJSON(w, map[string]any{"results": map[string]any{
+			"url":   strings.TrimSuffix(s.URL, "/v1") + "/image/" + param("eventId"),
+			"token": "mock-image-token",
+		}})
+	default:
+		if !strings.HasPrefix(req.Command, "sdm.devices.commands.") {
+			writeError(w, http.StatusBadRequest, "Command "+req.Command+" not supported.")
+			return
+		}
+		writeJSON(w, map[string]any{"results": map[string]any{}})
+	}
+}
+
+// pull waits up to 10 seconds for the next simulated event, like a Pub/Sub
+// pull that returns early when messages arrive.
+func (s *Server) pull(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	wait := time.Until(s.next)
+	s.mu.Unlock()
+	if wait > 10*time.Second {
+		wait = 10 * time.Second
+	}
+	select {
+	case <-r.Context().Done():
+		return
+	case <-time.After(wait):
+	}
+
+	s.mu.Lock()
+	var messages []map[string]any
+	if !time.Now().Before(s.next) {
+		data, _ := json.Marshal(s.nextEvent())
+		messages = append(messages, map[string]any{
+			"ackId": fmt.Sprintf("mock-ack-%d", s.seq.Add(1)),
+			"message": map[string]any{
+				"data":        base64.StdEncoding.EncodeToString(data),
+				"publishTime": time.Now().UTC().Format(time.RFC3339Nano),
+			},
+		})
+		s.next = time.Now().Add(s.EventInterval)
+	}
+	s.mu.Unlock()
+	writeJSON(w, map[string]any{"receivedMessages": messages})
+}
+
+// publish accepts messages for any topic in the mock project and discards
+// them, so pubsub_forward_topic can be tried under --mock.
+func (s *Server) publish(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid publish request.")
+		return
+	}
+	ids := make([]string, len(req.Messages))
+	for i := range ids {
+		ids[i] = fmt.Sprintf("mock-message-%d", s.seq.Add(1))
+	}
+	writeJSON(w, map[string]any{"messageIds": ids})
+}
+
+// nextEvent cycles through camera motion, camera person, camera sound,
+// doorbell chime and a thermostat temperature change. Device events are in
+// the thread of their session, STARTED or ENDED by turns. Callers hold s.mu.
+func (s *Server) nextEvent() map[string]any {
+	n := s.seq.Add(1)
+	id := fmt.Sprintf("mock-event-%d", n)
+	session := fmt.Sprintf("mock-session-%d", n/2)
+	event := func(device, eventType string) map[string]any {
+		return map[string]any{
+			"name": devicePrefix + device,
+			"events": map[string]any{
+				eventType: map[string]any{"eventSessionId": session, "eventId": id},
+			},
+		}
+	}
+
+	var update map[string]any
+	thread := true
+	switch s.tick % 5 {
+	case 0:
+		update = event("mock-camera", "sdm.devices.events.CameraMotion.Motion")
+	case 1:
+		update = event("mock-camera", "sdm.devices.events.CameraPerson.Person")
+	case 2:
+		update = event("mock-camera", "sdm.devices.events.CameraSound.Sound")
+	case 3:
+		update = event("mock-doorbell", "sdm.devices.events.DoorbellChime.Chime")
+	case 4:
+		thread = false
+		update = map[string]any{
+			"name": devicePrefix + "mock-thermostat",
+			"traits": map[string]any{
+				"sdm.devices.traits.Temperature": map[string]any{"ambientTemperatureCelsius": temperature(time.Now())},
+			},
+		}
+	}
+	s.tick++
+	msg := map[string]any{
+		"eventId":        id,
+		"timestamp":      time.Now().UTC().Format(time.RFC3339Nano),
+		"resourceUpdate": update,
+		"userId":         mockUserID,
+		"resourceGroup":  []string{update["name"].(string)},
+	}
+	if thread {
+		msg["eventThreadId"] = fmt.Sprintf("mock-thread-%d", n/2)
+		msg["eventThreadState"] = "STARTED"
+		if n%2 == 1 {
+			msg["eventThreadState"] = "ENDED"
+		}
+	}
+	return msg
+}
+
+// handleImage serves a generated JPEG for any event image URL.
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	shift := int(time.Now().Unix() % 256)
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			img.Set(x, y, color.RGBA{uint8(x*255/640 + shift), uint8(y * 255 / 480), 128, 255})
+		}
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	jpeg.Encode(w, img, &jpeg.Options{Quality: 80})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a Google-style error body.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{
+		"code":    code,
+		"message": message,
+		"status":  http.StatusText(code),
+	}})
+}
@@ -0,0 +1,145 @@
+// Package pool runs the events command's captures: one worker per camera
+// and capture kind, each with a bounded queue, so a burst of events on a
+// camera waits its turn (up to the queue depth) instead of being skipped,
+// and one busy camera doesn't hold up the others.
+package pool
+
+import (
+	"sync"
+
+	"github.com/brice/gognestcli/internal/crash"
+)
+
+// Stats counts the captures of one queue.
+type Stats struct {
+	Queued  int64 `json:"queued"`  // accepted, including those run at once
+	Dropped int64 `json:"dropped"` // refused because the queue was full
+	Waiting int   `json:"waiting"` // queued behind the running one now
+	Running bool  `json:"running"`
+}
+
+// Pool is a set of queues by key, e.g. "front-door/clip". It's safe for
+// concurrent use.
+type Pool struct {
+	depth int
+
+	mu     sync.Mutex
+	room   *sync.Cond // signalled when a queue shrinks
+	queues map[string]*queue
+}
+
+type queue struct {
+	jobs  []func()
+	stats Stats
+}
+
+// New returns a pool whose queues hold up to depth jobs waiting behind the
+// running one; with 0 a job is only accepted while its queue is idle.
+func New(depth int) *Pool {
+	p := &Pool{depth: max(depth, 0), queues: map[string]*queue{}}
+	p.room = sync.NewCond(&p.mu)
+	return p
+}
+
+// Submit queues job on key's worker. It reports false, counting a drop, if
+// the queue is full.
+func (p *Pool) Submit(key string, job func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q := p.queue(key)
+	if p.full(q) {
+		q.stats.Dropped++
+		return false
+	}
+	p.push(key, q, job)
+	return true
+}
+
+// SubmitWait queues job on key's worker, waiting for room if the queue is
+// full.
+func (p *Pool) SubmitWait(key string, job func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q := p.queue(key)
+	for p.full(q) {
+		p.room.Wait()
+	}
+	p.push(key, q, job)
+}
+
+// Stats returns the counts of each queue by key.
+func (p *Pool) Stats() map[string]Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[string]Stats, len(p.queues))
+	for key, q := range p.queues {
+		st := q.stats
+		st.Waiting = len(q.jobs)
+		out[key] = st
+	}
+	return out
+}
+
+// Dropped returns the number of jobs refused across all queues.
+func (p *Pool) Dropped() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var n int64
+	for _, q := range p.queues {
+		n += q.stats.Dropped
+	}
+	return n
+}
+
+// queue returns key's queue, creating it. Callers hold mu.
+func (p *Pool) queue(key string) *queue {
+	q := p.queues[key]
+	if q == nil {
+		q = &queue{}
+		p.queues[key] = q
+	}
+	return q
+}
+
+// full reports whether q can't take another job. Callers hold mu.
+func (p *Pool) full(q *queue) bool {
+	return q.stats.Running && len(q.jobs) >= p.depth
+}
+
+// push queues job on q, or starts q's worker on it if it's idle. Callers
+// hold mu.
+func (p *Pool) push(key string, q *queue, job func()) {
+	q.stats.Queued++
+	if q.stats.Running {
+		q.jobs = append(q.jobs, job)
+		return
+	}
+	q.stats.Running = true
+	go p.work(key, q, job)
+}
+
+// work runs job, then q's queued jobs in order until it's empty. Workers
+// exit when idle, so cameras without events cost nothing.
+func (p *Pool) work(key string, q *queue, job func()) {
+	for {
+		run(key, job)
+
+		p.mu.Lock()
+		if len(q.jobs) == 0 {
+			q.stats.Running = false
+			p.mu.Unlock()
+			p.room.Broadcast()
+			return
+		}
+		job = q.jobs[0]
+		q.jobs = q.jobs[1:]
+		p.mu.Unlock()
+		p.room.Broadcast()
+	}
+}
+
+// run runs a job, recovering a panic so the worker carries on.
+func run(key string, job func()) {
+	defer crash.Recover(key)
+	job()
+}
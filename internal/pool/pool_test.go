@@ -0,0 +1,131 @@
+package pool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubmitOrder(t *testing.T) {
+	p := New(10)
+	var mu sync.Mutex
+	var got []int
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		if !p.Submit("cam/clip", func() {
+			defer wg.Done()
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+		}) {
+			t.Fatalf("job %d refused", i)
+		}
+	}
+	wg.Wait()
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("jobs ran in order %v", got)
+		}
+	}
+	waitFor(t, "the worker to go idle", func() bool { return !p.Stats()["cam/clip"].Running })
+}
+
+func TestDepth(t *testing.T) {
+	tests := []struct {
+		depth            int
+		accepted, waited int
+	}{
+		{0, 1, 0},
+		{1, 2, 1},
+		{3, 4, 3},
+	}
+	for _, tt := range tests {
+		p := New(tt.depth)
+		release := make(chan struct{})
+		accepted := 0
+		for range 5 {
+			if p.Submit("cam/clip", func() { <-release }) {
+				accepted++
+			}
+		}
+		st := p.Stats()["cam/clip"]
+		if accepted != tt.accepted || st.Queued != int64(tt.accepted) || st.Dropped != int64(5-tt.accepted) ||
+			st.Waiting != tt.waited || !st.Running {
+			t.Errorf("depth %d: accepted %d, stats %+v", tt.depth, accepted, st)
+		}
+		if p.Dropped() != int64(5-tt.accepted) {
+			t.Errorf("depth %d: Dropped = %d", tt.depth, p.Dropped())
+		}
+		close(release)
+		waitFor(t, "the queue to drain", func() bool { return !p.Stats()["cam/clip"].Running })
+	}
+}
+
+func TestKeysIndependent(t *testing.T) {
+	p := New(0)
+	block := make(chan struct{})
+	defer close(block)
+	p.Submit("front/clip", func() { <-block })
+
+	done := make(chan struct{})
+	if !p.Submit("back/clip", func() { close(done) }) {
+		t.Fatal("job on an idle camera refused")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a busy camera held up another")
+	}
+}
+
+func TestSubmitWait(t *testing.T) {
+	p := New(0)
+	release := make(chan struct{})
+	p.Submit("cam/clip", func() { <-release })
+
+	ran := make(chan struct{})
+	submitted := make(chan struct{})
+	go func() {
+		p.SubmitWait("cam/clip", func() { close(ran) })
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("SubmitWait didn't wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-submitted
+	<-ran
+	if st := p.Stats()["cam/clip"]; st.Dropped != 0 || st.Queued != 2 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestPanicKeepsWorker(t *testing.T) {
+	p := New(2)
+	release := make(chan struct{})
+	p.Submit("cam/snapshot", func() { <-release })
+	p.Submit("cam/snapshot", func() { panic("boom") })
+	done := make(chan struct{})
+	p.Submit("cam/snapshot", func() { close(done) })
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job after a panic didn't run")
+	}
+}
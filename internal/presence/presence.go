@@ -0,0 +1,86 @@
+package presence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+const stateFile = "presence"
+
+// State is the household occupancy state.
+type State string
+
+const (
+	Home    State = "home"
+	Away    State = "away"
+	Unknown State = "unknown"
+)
+
+// Parse validates a state name.
+func Parse(s string) (State, error) {
+	switch st := State(strings.ToLower(strings.TrimSpace(s))); st {
+	case Home, Away, Unknown:
+		return st, nil
+	}
+	return "", fmt.Errorf("invalid presence state %q (want home, away or unknown)", s)
+}
+
+// Get returns the current state. The SDM API does not expose structure
+// occupancy, so the state is set externally (gognestcli presence home|away)
+// by home automation, geofencing or a phone shortcut.
+func Get() (State, error) {
+	path, err := config.StatePath(stateFile)
+	if err != nil {
+		return Unknown, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Unknown, nil
+		}
+		return Unknown, err
+	}
+	st, err := Parse(string(data))
+	if err != nil {
+		return Unknown, nil
+	}
+	return st, nil
+}
+
+// Set persists the current state.
+func Set(st State) error {
+	path, err := config.StatePath(stateFile)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(string(st)+"\n"), 0600)
+}
+
+// Allows reports whether capture is permitted for deviceName given the
+// current state and the presence config. Unknown state always allows capture.
+func Allows(cfg *config.PresenceConfig, st State, deviceName string) bool {
+	if cfg == nil || st == Unknown {
+		return true
+	}
+	if len(cfg.Devices) > 0 {
+		listed := false
+		for _, d := range cfg.Devices {
+			if d == deviceName || strings.HasSuffix(deviceName, "/"+d) {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			return true
+		}
+	}
+	want := Away
+	if strings.EqualFold(cfg.CaptureWhen, string(Home)) {
+		want = Home
+	}
+	return st == want
+}
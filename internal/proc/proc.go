@@ -0,0 +1,232 @@
+// Package proc runs ffmpeg, ffplay and other helper processes so that they
+// never outlive gognestcli: every child is tracked and killed on SIGINT or
+// SIGTERM, runs can be bounded by a timeout, stderr is kept for error
+// messages and logged, and registered temp files are removed on exit.
+package proc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	mu       sync.Mutex
+	running  = map[*Process]struct{}{}
+	temps    = map[string]struct{}{}
+	graceful atomic.Bool
+)
+
+// Process is an exec.Cmd that is tracked while running. Set Timeout before
+// Start to kill the process if it runs too long.
+type Process struct {
+	*exec.Cmd
+	Timeout time.Duration
+
+	name     string
+	stderr   *tail
+	timer    *time.Timer
+	timedOut atomic.Bool
+}
+
+// Command is like exec.CommandContext. Cancelling ctx kills the process.
+func Command(ctx context.Context, name string, args ...string) *Process {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = 5 * time.Second
+	return &Process{Cmd: cmd, name: filepath.Base(name)}
+}
+
+// Start starts the process and tracks it until Wait returns. Unless Stderr
+// was set, stderr is captured for the error returned by Wait.
+func (p *Process) Start() error {
+	p.stderr = &tail{name: p.name}
+	if p.Stderr == nil {
+		p.Stderr = p.stderr
+	}
+	if err := p.Cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", p.name, err)
+	}
+	slog.Debug("process started", "name", p.name, "pid", p.Cmd.Process.Pid, "args", p.Args[1:])
+
+	mu.Lock()
+	running[p] = struct{}{}
+	mu.Unlock()
+
+	if p.Timeout > 0 {
+		p.timer = time.AfterFunc(p.Timeout, func() {
+			p.timedOut.Store(true)
+			p.kill()
+		})
+	}
+	return nil
+}
+
+// Wait waits for the process and stops tracking it. Errors include the tail
+// of stderr when it was captured.
+func (p *Process) Wait() error {
+	err := p.Cmd.Wait()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	mu.Lock()
+	delete(running, p)
+	mu.Unlock()
+
+	if p.timedOut.Load() {
+		return fmt.Errorf("%s timed out after %s", p.name, p.Timeout)
+	}
+	if err != nil {
+		if out := p.stderr.String(); out != "" {
+			return fmt.Errorf("%s failed: %w\n%s", p.name, err, out)
+		}
+		return fmt.Errorf("%s failed: %w", p.name, err)
+	}
+	return nil
+}
+
+// Run starts the process and waits for it.
+func (p *Process) Run() error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+// Output runs the process and returns its stdout.
+func (p *Process) Output() ([]byte, error) {
+	var out bytes.Buffer
+	p.Stdout = &out
+	err := p.Run()
+	return out.Bytes(), err
+}
+
+func (p *Process) kill() {
+	if p.Cmd.Process != nil {
+		p.Cmd.Process.Kill()
+	}
+}
+
+// AddTemp registers a temp file to delete if gognestcli is interrupted.
+func AddTemp(path string) {
+	mu.Lock()
+	temps[path] = struct{}{}
+	mu.Unlock()
+}
+
+// RemoveTemp deletes a temp file registered with AddTemp.
+func RemoveTemp(path string) {
+	os.Remove(path)
+	mu.Lock()
+	delete(temps, path)
+	mu.Unlock()
+}
+
+// Cleanup kills all running children and removes registered temp files.
+func Cleanup() {
+	mu.Lock()
+	defer mu.Unlock()
+	for p := range running {
+		p.kill()
+	}
+	for path := range temps {
+		os.Remove(path)
+	}
+	temps = map[string]struct{}{}
+}
+
+// NotifyInterrupt is signal.Notify for os.Interrupt, for commands that stop
+// gracefully on Ctrl-C. HandleSignals then leaves the first interrupt to the
+// command and only forces an exit on the second.
+func NotifyInterrupt(ch chan<- os.Signal) {
+	graceful.Store(true)
+	signal.Notify(ch, os.Interrupt)
+}
+
+// HandleSignals cleans up and exits on SIGTERM, and on SIGINT unless the
+// running command registered with NotifyInterrupt.
+func HandleSignals() {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		interrupts := 0
+		for sig := range ch {
+			if sig == os.Interrupt {
+				interrupts++
+				if graceful.Load() && interrupts == 1 {
+					continue
+				}
+			}
+			Cleanup()
+			if sig == syscall.SIGTERM {
+				os.Exit(143)
+			}
+			os.Exit(130)
+		}
+	}()
+}
+
+// SweepTemps removes *.tmp.h264, *.tmp.ogg and .*.partial.* files under dir
+// that haven't been written to for olderThan, left behind by a crash or
+// kill -9.
+func SweepTemps(dir string, olderThan time.Duration) {
+	cutoff := time.Now().Add(-olderThan)
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !IsTemp(d.Name()) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+			if os.Remove(path) == nil {
+				slog.Info("removed stale temp file", "path", path)
+			}
+		}
+		return nil
+	})
+}
+
+// IsTemp reports whether name is an in-progress capture file: a raw
+// *.tmp.h264 recording, its *.tmp.ogg audio or a hidden .*.partial.* file
+// awaiting rename.
+func IsTemp(name string) bool {
+	return strings.HasSuffix(name, ".tmp.h264") || strings.HasSuffix(name, ".tmp.ogg") ||
+		(strings.HasPrefix(name, ".") && strings.Contains(name, ".partial."))
+}
+
+// tail keeps the last few KB written to it and logs each line at debug level.
+type tail struct {
+	name string
+	mu   sync.Mutex
+	buf  []byte
+}
+
+const tailSize = 4096
+
+func (t *tail) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		if line != "" {
+			slog.Debug("process stderr", "name", t.name, "line", line)
+		}
+	}
+	t.mu.Lock()
+	t.buf = append(t.buf, b...)
+	if len(t.buf) > tailSize {
+		t.buf = t.buf[len(t.buf)-tailSize:]
+	}
+	t.mu.Unlock()
+	return len(b), nil
+}
+
+func (t *tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.TrimSpace(string(t.buf))
+}
@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// While messages are held (see Event.Hold), their ack deadline is pushed
+// back to heldAckDeadline every ackExtendEvery, so Pub/Sub doesn't
+// redeliver them mid-capture.
+const heldAckDeadline = 60 // seconds
+
+var ackExtendEvery = 20 * time.Second
+
+// inflight is a pulled message that hasn't been acknowledged yet. It's
+// acknowledged once the handler has returned for each of its events and
+// every Hold on them has been released.
+type inflight struct {
+	l     *Listener
+	ackID string
+	refs  atomic.Int32
+}
+
+// Hold keeps the event's Pub/Sub message from being acknowledged until the
+// returned function is called, for handlers that carry on with the event in
+// the background: if the process stops first, the message is redelivered.
+// Call it before the handler returns. Events that didn't come from a
+// subscription, such as replayed ones, ignore it.
+func (e Event) Hold() (release func()) {
+	m := e.msg
+	if m == nil {
+		return func() {}
+	}
+	m.refs.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if m.release() {
+				go m.l.ackLate(m.ackID)
+			}
+		})
+	}
+}
+
+// track starts holding messages for the handler, until dispatch is done.
+func (l *Listener) track(messages []receivedMessage) {
+	l.heldMu.Lock()
+	defer l.heldMu.Unlock()
+	if l.held == nil {
+		l.held = map[*inflight]struct{}{}
+	}
+	for i := range messages {
+		m := &inflight{l: l, ackID: messages[i].AckID}
+		m.refs.Store(1)
+		messages[i].ack = m
+		l.held[m] = struct{}{}
+	}
+}
+
+// release drops a hold on m, reporting whether it was the last, so m is
+// ready to be acknowledged.
+func (m *inflight) release() bool {
+	if m.refs.Add(-1) != 0 {
+		return false
+	}
+	m.l.heldMu.Lock()
+	delete(m.l.held, m)
+	m.l.heldMu.Unlock()
+	return true
+}
+
+// ackLate acknowledges a message whose last hold was released after its
+// batch was handled.
+func (l *Listener) ackLate(ackID string) {
+	if err := l.acknowledge(context.Background(), []string{ackID}); err != nil {
+		fmt.Printf("Warning: ack error: %v\n", err)
+	}
+}
+
+// extendHeld pushes back the ack deadline of held messages every interval
+// until ctx is done.
+func (l *Listener) extendHeld(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		l.heldMu.Lock()
+		var ackIDs []string
+		for m := range l.held {
+			ackIDs = append(ackIDs, m.ackID)
+		}
+		l.heldMu.Unlock()
+		if len(ackIDs) == 0 {
+			continue
+		}
+		if err := l.modifyAckDeadline(ctx, ackIDs, heldAckDeadline); err != nil && ctx.Err() == nil {
+			fmt.Printf("Warning: extending ack deadline: %v\n", err)
+		}
+	}
+}
+
+func (l *Listener) modifyAckDeadline(ctx context.Context, ackIDs []string, seconds int) error {
+	tok, err := l.tokenFn()
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"ackIds":             ackIDs,
+		"ackDeadlineSeconds": seconds,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		fmt.Sprintf("%s/%s:modifyAckDeadline", l.baseURL, l.subscription),
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("modifyAckDeadline returned %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Retry timing for failed pulls and forwards: exponential backoff from
+// minBackoff to maxBackoff, each wait picked at random from the upper half
+// of its range so listeners don't retry in step. After breakerThreshold
+// failures in a row the circuit opens: retries go on at maxBackoff, and
+// further failures aren't logged until a pull gets through again.
+const (
+	minBackoff       = time.Second
+	maxBackoff       = 2 * time.Minute
+	breakerThreshold = 5
+)
+
+// Circuit states reported by Health.
+const (
+	StateOK       = "ok"       // pulls are succeeding
+	StateDegraded = "degraded" // recent pulls failed; retrying with backoff
+	StateOpen     = "open"     // pulls keep failing; retrying at the slowest rate
+)
+
+// Health is a snapshot of the listener's pull loop, for health endpoints.
+type Health struct {
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`             // consecutive failed attempts
+	LastError string    `json:"last_error,omitempty"` // of the current run of failures
+	Since     time.Time `json:"since"`                // when State was entered
+	LastPull  time.Time `json:"last_pull,omitzero"`   // last successful pull
+	RetryAt   time.Time `json:"retry_at,omitzero"`    // next attempt, while failing
+}
+
+// Healthy reports whether pulls are getting through, possibly after a few
+// retries; it's false only while the circuit is open.
+func (h Health) Healthy() bool {
+	return h.State != StateOpen
+}
+
+// breaker tracks consecutive failures of the pull loop.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	lastErr   string
+	since     time.Time
+	lastPull  time.Time
+	retryAt   time.Time
+	downSince time.Time
+}
+
+func (b *breaker) state() string {
+	switch {
+	case b.failures >= breakerThreshold:
+		return StateOpen
+	case b.failures > 0:
+		return StateDegraded
+	}
+	return StateOK
+}
+
+// succeeded records a pull that got through and logs the resumption if it follows
+// failures.
+func (b *breaker) succeeded(subscription string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.failures > 0 {
+		fmt.Printf("Listening on %s resumed after %d failed attempt(s) over %s\n",
+			subscription, b.failures, now.Sub(b.downSince).Round(time.Second))
+		b.since = now
+	}
+	b.failures, b.lastErr, b.retryAt = 0, "", time.Time{}
+	b.lastPull = now
+}
+
+// failed records a failure and returns how long to wait before retrying.
+// what describes the failed operation for the log.
+func (b *breaker) failed(what string, err error) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.failures == 0 {
+		b.downSince, b.since = now, now
+	}
+	b.failures++
+	b.lastErr = err.Error()
+
+	ceiling := maxBackoff
+	if b.failures < breakerThreshold {
+		ceiling = min(minBackoff<<(b.failures-1), maxBackoff)
+	}
+	wait := ceiling/2 + rand.N(ceiling/2)
+	b.retryAt = now.Add(wait)
+
+	switch {
+	case b.failures < breakerThreshold:
+		fmt.Printf("Warning: %s: %v (retrying in %s)\n", what, err, wait.Round(100*time.Millisecond))
+	case b.failures == breakerThreshold:
+		b.since = now
+		fmt.Printf("Warning: %s: %v; %d failures in a row, retrying every %s to %s and logging again once it recovers\n",
+			what, err, b.failures, maxBackoff/2, maxBackoff)
+	}
+	return wait
+}
+
+// backoff waits after a failed attempt, returning early if ctx is done.
+func (l *Listener) backoff(ctx context.Context, what string, err error) {
+	t := time.NewTimer(l.breaker.failed(what, err))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// Health returns the state of the pull loop. It's safe to call while Listen
+// runs.
+func (l *Listener) Health() Health {
+	b := &l.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	since := b.since
+	if since.IsZero() {
+		since = l.started
+	}
+	return Health{
+		State:     b.state(),
+		Failures:  b.failures,
+		LastError: b.lastErr,
+		Since:     since,
+		LastPull:  b.lastPull,
+		RetryAt:   b.retryAt,
+	}
+}
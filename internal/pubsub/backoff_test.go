@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	l := NewListener(testSub, nil)
+	b := &l.breaker
+	err := errors.New("pull returned 503")
+
+	// Waits double from a second, each in the upper half of its range,
+	// until the circuit opens and they stay at the maximum.
+	tests := []struct {
+		ceiling time.Duration
+		state   string
+	}{
+		{time.Second, StateDegraded},
+		{2 * time.Second, StateDegraded},
+		{4 * time.Second, StateDegraded},
+		{8 * time.Second, StateDegraded},
+		{maxBackoff, StateOpen},
+		{maxBackoff, StateOpen},
+	}
+	for i, tt := range tests {
+		wait := b.failed("pull error", err)
+		if wait < tt.ceiling/2 || wait >= tt.ceiling {
+			t.Errorf("failure %d: waiting %s, want [%s, %s)", i+1, wait, tt.ceiling/2, tt.ceiling)
+		}
+		h := l.Health()
+		if h.State != tt.state || h.Failures != i+1 || h.LastError != err.Error() {
+			t.Errorf("failure %d: Health = %+v, want state %s", i+1, h, tt.state)
+		}
+		if h.Healthy() != (tt.state != StateOpen) {
+			t.Errorf("failure %d: Healthy = %v", i+1, h.Healthy())
+		}
+	}
+	opened := l.Health().Since
+
+	b.succeeded(testSub)
+	h := l.Health()
+	if h.State != StateOK || h.Failures != 0 || h.LastError != "" || !h.RetryAt.IsZero() || h.LastPull.IsZero() {
+		t.Errorf("after a successful pull Health = %+v", h)
+	}
+	if h.Since.Before(opened) {
+		t.Errorf("Since = %s, want the time pulls resumed", h.Since)
+	}
+
+	// The next run of failures starts from the shortest wait again.
+	if wait := b.failed("pull error", err); wait >= time.Second {
+		t.Errorf("first wait after recovering = %s", wait)
+	}
+}
+
+func TestHealthBeforeFailures(t *testing.T) {
+	l := NewListener(testSub, nil)
+	l.started = time.Now()
+	if h := l.Health(); h.State != StateOK || !h.Since.Equal(l.started) || !h.Healthy() {
+		t.Errorf("Health = %+v", h)
+	}
+}
@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// deletedTopic is the topic Pub/Sub reports for a subscription whose topic
+// was deleted.
+const deletedTopic = "_deleted-topic_"
+
+// SubscriptionProblem is what Diagnose found wrong with a subscription.
+type SubscriptionProblem struct {
+	Reason string
+	Fix    string
+	// Healable means recreating the subscription would fix it.
+	Healable bool
+	// exists means the subscription must be deleted before it's recreated.
+	exists bool
+	// topic is the topic it was attached to, if still valid.
+	topic string
+}
+
+func (p *SubscriptionProblem) Error() string {
+	if p.Fix == "" {
+		return p.Reason
+	}
+	return p.Reason + " (" + p.Fix + ")"
+}
+
+// AutoHeal makes Listen recreate the subscription on topic when a pull
+// fails because it was deleted, or its topic was deleted or detached, as
+// happens when the Device Access project is re-consented. A subscription
+// still attached to a live topic is recreated on that one. Call before
+// Listen.
+func (l *Listener) AutoHeal(topic string) {
+	l.healTopic = topic
+}
+
+// isGone reports whether a pull error is NOT_FOUND or PERMISSION_DENIED,
+// the errors Diagnose explains.
+func isGone(err error) bool {
+	s := err.Error()
+	return strings.Contains(s, "returned 404") || strings.Contains(s, "returned 403")
+}
+
+// Diagnose explains a NOT_FOUND or PERMISSION_DENIED pull. It returns nil
+// if it can't tell, e.g. because the API is unreachable, or if the
+// subscription now looks fine.
+func (l *Listener) Diagnose(ctx context.Context) *SubscriptionProblem {
+	body, err := l.call(ctx, "GET", l.subscription, nil)
+	switch {
+	case err != nil && strings.Contains(err.Error(), "returned 404"):
+		return &SubscriptionProblem{
+			Reason:   "the subscription was deleted",
+			Fix:      "recreate it with events --recreate-subscription or gognestcli init",
+			Healable: true,
+		}
+	case err != nil && strings.Contains(err.Error(), "returned 403"):
+		return &SubscriptionProblem{
+			Reason: "no access to the subscription",
+			Fix:    "grant the Pub/Sub Subscriber role on it to the account events runs as",
+		}
+	case err != nil:
+		return nil
+	}
+
+	var sub struct {
+		Topic    string `json:"topic"`
+		Detached bool   `json:"detached"`
+	}
+	if err := json.Unmarshal(body, &sub); err != nil {
+		return nil
+	}
+	switch {
+	case sub.Topic == deletedTopic:
+		return &SubscriptionProblem{
+			Reason:   "the subscription's topic was deleted",
+			Fix:      "recreate it on the Device Access topic with events --recreate-subscription",
+			Healable: true,
+			exists:   true,
+		}
+	case sub.Detached:
+		return &SubscriptionProblem{
+			Reason:   "the subscription was detached from " + sub.Topic,
+			Fix:      "recreate it with events --recreate-subscription",
+			Healable: true,
+			exists:   true,
+			topic:    sub.Topic,
+		}
+	}
+
+	if err := l.CheckSubscription(ctx); err != nil && strings.Contains(err.Error(), "missing") {
+		return &SubscriptionProblem{
+			Reason: err.Error(),
+			Fix:    "grant the Pub/Sub Subscriber role on it to the account events runs as",
+		}
+	}
+	return nil
+}
+
+// heal recreates the subscription Diagnose found p wrong with.
+func (l *Listener) heal(ctx context.Context, p *SubscriptionProblem) error {
+	topic := p.topic
+	if topic == "" {
+		topic = l.healTopic
+	}
+	if p.exists {
+		if _, err := l.call(ctx, "DELETE", l.subscription, nil); err != nil && !strings.Contains(err.Error(), "returned 404") {
+			return fmt.Errorf("deleting %s: %w", l.subscription, err)
+		}
+	}
+	if err := l.CreateSubscription(ctx, topic); err != nil {
+		return fmt.Errorf("creating %s on %s: %w", l.subscription, topic, err)
+	}
+	fmt.Printf("Recreated %s on %s\n", l.subscription, topic)
+	return nil
+}
@@ -2,16 +2,20 @@ package pubsub
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
-const pubsubBaseURL = "https://pubsub.googleapis.com/v1"
+// DefaultBaseURL is the production Pub/Sub API root.
+const DefaultBaseURL = "https://pubsub.googleapis.com/v1"
 
 // Event represents a parsed Nest event from Pub/Sub.
 // Event represents a parsed Nest event from Pub/Sub.
@@ -19,21 +23,93 @@ type Event struct {
 	DeviceName string
 	EventType  string // "CameraMotion.Motion", "CameraPerson.Person", etc.
 	EventID    string // Used for CameraEventImage.GenerateImage
+	SessionID  string // eventSessionId, shared by related events
 	Timestamp  time.Time
 	Raw        json.RawMessage
+
+	// Loudness is the sound level in dB reported with CameraSound.Sound
+	// events, or 0 when the payload doesn't carry one.
+	Loudness float64
+
+	// FamiliarFaces names the familiar faces recognized in a Person event,
+	// when the payload includes them.
+	FamiliarFaces []string
+
+	// Zones names the activity zones the event was detected in, when the
+	// payload includes them.
+	Zones []string
+
+	// PublishTime is when Pub/Sub accepted the message and Received when
+	// this process pulled it; both are zero for replayed events.
+	PublishTime time.Time
+	Received    time.Time
+
+	// ThreadID and ThreadState (STARTED, UPDATED or ENDED) group the
+	// messages of one ongoing activity, such as a doorbell press followed by
+	// person detection; empty for devices that don't send threads.
+	ThreadID    string
+	ThreadState string
+
+	// ResourceGroup lists the resources the event concerns, starting with
+	// the device; UserID identifies the user the Device Access project
+	// acts for.
+	ResourceGroup []string
+	UserID        string
+
+	// Subscription is the label of the subscription the event came from
+	// (see Listener.SetLabel), or "" if it has none.
+	Subscription string
+
+	// msg is the message the event came from, for Hold; nil for events
+	// that weren't pulled from a subscription.
+	msg *inflight
+}
+
+// TraitUpdate is a device trait change delivered in a resourceUpdate message.
+type TraitUpdate struct {
+	DeviceName string
+	Timestamp  time.Time
+	Traits     map[string]json.RawMessage
+}
+
+// EventSource delivers Nest events and trait updates. *Listener reads them
+// from a subscription and *Replayer from a file; tests can substitute fakes.
+type EventSource interface {
+	// OnTraitUpdate registers a callback for trait changes. Call before Listen.
+	OnTraitUpdate(fn func(TraitUpdate))
+	// Listen sends events to handler until the source ends or ctx is done.
+	// Events of one device arrive in order, but handler may be called
+	// concurrently for different devices.
+	Listen(ctx context.Context, handler func(Event)) error
 }
 
+var (
+	_ EventSource = (*Listener)(nil)
+	_ EventSource = (*Replayer)(nil)
+)
+
 // Listener polls a Pub/Sub subscription for Nest device events.
 type Listener struct {
 	subscription string
+	baseURL      string
 	tokenFn      func() (string, error)
 	httpClient   *http.Client
+	onTraits     func(TraitUpdate)
+	forwardTopic string
+	healTopic    string
+	label        string
+	started      time.Time
+	breaker      breaker
+
+	heldMu sync.Mutex
+	held   map[*inflight]struct{} // messages not yet acknowledged
 }
 
 // NewListener creates a new Pub/Sub listener.
 func NewListener(subscription string, tokenFn func() (string, error)) *Listener {
 	return &Listener{
 		subscription: subscription,
+		baseURL:      DefaultBaseURL,
 		tokenFn:      tokenFn,
 		httpClient:   &http.Client{Timeout: 30 * time.Second},
 	}
@@ -52,19 +128,26 @@ type pullResponse struct {
 type receivedMessage struct {
 	AckID   string        `json:"ackId"`
 	Message pubsubMessage `json:"message"`
+
+	ack *inflight // set by track
 }
 
 type pubsubMessage struct {
 	Data        string            `json:"data"` // base64-encoded
 	Attributes  map[string]string `json:"attributes"`
 	PublishTime string            `json:"publishTime"`
+	OrderingKey string            `json:"orderingKey"`
 }
 
 // nestEventData is the decoded Pub/Sub message for Nest events.
 type nestEventData struct {
-	EventID         string                     `json:"eventId"`
-	Timestamp       string                     `json:"timestamp"`
-	ResourceUpdate  *resourceUpdate            `json:"resourceUpdate"`
+	EventID          string          `json:"eventId"`
+	Timestamp        string          `json:"timestamp"`
+	ResourceUpdate   *resourceUpdate `json:"resourceUpdate"`
+	UserID           string          `json:"userId"`
+	ResourceGroup    []string        `json:"resourceGroup"`
+	EventThreadID    string          `json:"eventThreadId"`
+	EventThreadState string          `json:"eventThreadState"`
 }
 
 type resourceUpdate struct {
@@ -73,10 +156,52 @@ type resourceUpdate struct {
 	Traits map[string]json.RawMessage        `json:"traits"`
 }
 
-// Listen starts polling for events and sends them to the handler.
-// It blocks until the context is cancelled.
+// SetBaseURL points the listener at another Pub/Sub API root, such as the
+// in-process fake used by --mock or the Pub/Sub emulator.
+func (l *Listener) SetBaseURL(url string) {
+	l.baseURL = url
+}
+
+// SetLabel labels the events pulled from the subscription, to tell them
+// apart when several listeners are merged (see Multi). Call before Listen.
+func (l *Listener) SetLabel(label string) {
+	l.label = label
+}
+
+// Subscription returns the subscription's resource name.
+func (l *Listener) Subscription() string {
+	return l.subscription
+}
+
+// Label returns the label set by SetLabel.
+func (l *Listener) Label() string {
+	return l.label
+}
+
+// OnTraitUpdate registers a callback for trait changes (connectivity,
+// temperature, ...) carried by the same subscription. Call before Listen.
+func (l *Listener) OnTraitUpdate(fn func(TraitUpdate)) {
+	l.onTraits = fn
+}
+
+// ForwardTo republishes every pulled message, data, attributes and ordering
+// key unchanged, to topic ("projects/<project>/topics/<topic>") before it is
+// handled and acknowledged. Messages that can't be forwarded are left unacknowledged so
+// Pub/Sub redelivers them. Call before Listen.
+func (l *Listener) ForwardTo(topic string) {
+	l.forwardTopic = topic
+}
+
+// Listen starts polling for events and sends them to the handler, each
+// device's in order and different devices' in parallel (see dispatch).
+// A message is acknowledged once the handler has returned for its events
+// and released any Hold on them. It blocks until the context is cancelled.
 func (l *Listener) Listen(ctx context.Context, handler func(Event)) error {
 	fmt.Printf("Listening for events on %s...\n", l.subscription)
+	l.breaker.mu.Lock()
+	l.started = time.Now()
+	l.breaker.mu.Unlock()
+	go l.extendHeld(ctx, ackExtendEvery)
 
 	for {
 		select {
@@ -90,18 +215,45 @@ func (l *Listener) Listen(ctx context.Context, handler func(Event)) error {
 			if ctx.Err() != nil {
 				return ctx.Err()
 			}
-			fmt.Printf("Warning: pull error: %v\n", err)
-			time.Sleep(5 * time.Second)
+			// Retrying won't help once the subscription is gone or out of
+			// reach: heal it if allowed, otherwise stop with the diagnosis.
+			if isGone(err) {
+				if p := l.Diagnose(ctx); p != nil {
+					if !p.Healable || l.healTopic == "" {
+						return fmt.Errorf("%s: %w", l.subscription, p)
+					}
+					fmt.Printf("Warning: %s: %s; recreating it\n", l.subscription, p.Reason)
+					if err := l.heal(ctx, p); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			l.backoff(ctx, "pull error", err)
 			continue
 		}
 
+		received := time.Now()
+		if l.forwardTopic != "" && len(messages) > 0 {
+			if err := l.forward(ctx, messages); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				l.backoff(ctx, fmt.Sprintf("forwarding to %s failed, leaving %d message(s) for redelivery", l.forwardTopic, len(messages)), err)
+				continue
+			}
+		}
+
+		l.breaker.succeeded(l.subscription)
+
+		l.track(messages)
+		l.dispatch(messages, received, handler)
+		// Messages still held are acknowledged when released.
 		var ackIDs []string
 		for _, msg := range messages {
-			events := l.parseMessage(msg)
-			for _, event := range events {
-				handler(event)
+			if msg.ack.release() {
+				ackIDs = append(ackIDs, msg.AckID)
 			}
-			ackIDs = append(ackIDs, msg.AckID)
 		}
 
 		if len(ackIDs) > 0 {
@@ -121,7 +273,7 @@ func (l *Listener) pull(ctx context.Context) ([]receivedMessage, error) {
 	body, _ := json.Marshal(pullRequest{MaxMessages: 10})
 
 	req, err := http.NewRequestWithContext(ctx, "POST",
-		fmt.Sprintf("%s/%s:pull", pubsubBaseURL, l.subscription),
+		fmt.Sprintf("%s/%s:pull", l.baseURL, l.subscription),
 		bytes.NewReader(body))
 	if err != nil {
 		return nil, err
@@ -164,7 +316,7 @@ func (l *Listener) acknowledge(ctx context.Context, ackIDs []string) error {
 	body, _ := json.Marshal(payload)
 
 	req, err := http.NewRequestWithContext(ctx, "POST",
-		fmt.Sprintf("%s/%s:acknowledge", pubsubBaseURL, l.subscription),
+		fmt.Sprintf("%s/%s:acknowledge", l.baseURL, l.subscription),
 		bytes.NewReader(body))
 	if err != nil {
 		return err
@@ -185,39 +337,198 @@ func (l *Listener) acknowledge(ctx context.Context, ackIDs []string) error {
 	return nil
 }
 
-func (l *Listener) parseMessage(msg receivedMessage) []Event {
+// forward publishes messages to the forward topic in one request.
+func (l *Listener) forward(ctx context.Context, messages []receivedMessage) error {
+	out := make([]map[string]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		m := map[string]interface{}{"data": msg.Message.Data}
+		if len(msg.Message.Attributes) > 0 {
+			m["attributes"] = msg.Message.Attributes
+		}
+		if msg.Message.OrderingKey != "" {
+			m["orderingKey"] = msg.Message.OrderingKey
+		}
+		out = append(out, m)
+	}
+	_, err := l.call(ctx, "POST", l.forwardTopic+":publish", map[string]interface{}{"messages": out})
+	return err
+}
+
+func (l *Listener) parseMessage(msg receivedMessage, received time.Time) []Event {
 	data, err := base64.StdEncoding.DecodeString(msg.Message.Data)
 	if err != nil {
 		return nil
 	}
+	published, _ := time.Parse(time.RFC3339Nano, msg.Message.PublishTime)
+	events := parseData(data, l.onTraits)
+	for i := range events {
+		events[i].PublishTime = published
+		events[i].Received = received
+		events[i].Subscription = l.label
+		events[i].msg = msg.ack
+	}
+	return events
+}
 
+// parseData parses a decoded Nest event payload, reporting trait changes to
+// onTraits (if set) and returning the events it carries.
+func parseData(data []byte, onTraits func(TraitUpdate)) []Event {
 	var ned nestEventData
 	if err := json.Unmarshal(data, &ned); err != nil {
 		return nil
 	}
 
-	if ned.ResourceUpdate == nil || len(ned.ResourceUpdate.Events) == 0 {
+	if ned.ResourceUpdate == nil {
 		return nil
 	}
 
 	ts, _ := time.Parse(time.RFC3339Nano, ned.Timestamp)
 
+	if onTraits != nil && len(ned.ResourceUpdate.Traits) > 0 {
+		onTraits(TraitUpdate{
+			DeviceName: ned.ResourceUpdate.Name,
+			Timestamp:  ts,
+			Traits:     ned.ResourceUpdate.Traits,
+		})
+	}
+
 	var events []Event
 	for eventType, raw := range ned.ResourceUpdate.Events {
 		// Extract eventId from the event data
 		var eventData struct {
-			EventSessionID string `json:"eventSessionId"`
-			EventID        string `json:"eventId"`
+			EventSessionID string  `json:"eventSessionId"`
+			EventID        string  `json:"eventId"`
+			Loudness       float64 `json:"loudness"`
+			FamiliarFaces  []label `json:"familiarFaces"`
+			Zones          []label `json:"zones"`
 		}
 		json.Unmarshal(raw, &eventData)
 
 		events = append(events, Event{
-			DeviceName: ned.ResourceUpdate.Name,
-			EventType:  eventType,
-			EventID:    eventData.EventID,
-			Timestamp:  ts,
-			Raw:        raw,
+			DeviceName:    ned.ResourceUpdate.Name,
+			EventType:     eventType,
+			EventID:       eventData.EventID,
+			SessionID:     eventData.EventSessionID,
+			Timestamp:     ts,
+			Raw:           raw,
+			Loudness:      eventData.Loudness,
+			ThreadID:      ned.EventThreadID,
+			ThreadState:   ned.EventThreadState,
+			ResourceGroup: ned.ResourceGroup,
+			UserID:        ned.UserID,
 		})
+		ev := &events[len(events)-1]
+		ev.FamiliarFaces = labels(eventData.FamiliarFaces)
+		ev.Zones = labels(eventData.Zones)
 	}
 	return events
 }
+
+// label is a familiar face or zone in an event payload, given either as a
+// name or as an object with a name or displayName. Anything else decodes as
+// "" rather than failing the whole event.
+type label string
+
+func (l *label) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		*l = label(name)
+		return nil
+	}
+	var obj struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	}
+	json.Unmarshal(data, &obj)
+	*l = label(cmp.Or(obj.DisplayName, obj.Name))
+	return nil
+}
+
+// labels returns the non-empty names in ls.
+func labels(ls []label) []string {
+	var names []string
+	for _, l := range ls {
+		if l != "" {
+			names = append(names, string(l))
+		}
+	}
+	return names
+}
+
+// consumePermission is what pull and acknowledge need on the subscription.
+const consumePermission = "pubsub.subscriptions.consume"
+
+// CheckSubscription verifies that the subscription exists and that the
+// caller may pull from it.
+func (l *Listener) CheckSubscription(ctx context.Context) error {
+	if _, err := l.call(ctx, "GET", l.subscription, nil); err != nil {
+		return err
+	}
+
+	body, err := l.call(ctx, "POST", l.subscription+":testIamPermissions",
+		map[string]interface{}{"permissions": []string{consumePermission}})
+	if err != nil {
+		return err
+	}
+	var granted struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.Unmarshal(body, &granted); err != nil {
+		return err
+	}
+	for _, p := range granted.Permissions {
+		if p == consumePermission {
+			return nil
+		}
+	}
+	return fmt.Errorf("missing %s permission on %s", consumePermission, l.subscription)
+}
+
+// CreateSubscription creates the subscription as a pull subscription on
+// topic, e.g. the Device Access topic "projects/sdm-prod/topics/enterprise-…".
+// A subscription that already exists is left as it is.
+func (l *Listener) CreateSubscription(ctx context.Context, topic string) error {
+	_, err := l.call(ctx, "PUT", l.subscription, map[string]interface{}{"topic": topic})
+	if err != nil && strings.Contains(err.Error(), "returned 409") {
+		return nil
+	}
+	return err
+}
+
+// call sends an authorized request for path under the API root and returns
+// the response body.
+func (l *Listener) call(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	tok, err := l.tokenFn()
+	if err != nil {
+		return nil, fmt.Errorf("getting token: %w", err)
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		data, _ := json.Marshal(payload)
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/%s", l.baseURL, path), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s returned %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,273 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSub = "projects/p/subscriptions/s"
+
+// fakePubSub serves a subscription's pull, acknowledge and
+// modifyAckDeadline calls. Each pull returns the next of batches, then
+// nothing once they run out. While pullStatus is set, pulls fail with it.
+type fakePubSub struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	pullStatus int
+	pulls      int
+	batches    [][]map[string]any
+	acked      []string
+	extended   []string
+	ackSeen    chan string
+}
+
+func newFakePubSub(t *testing.T, batches ...[]map[string]any) *fakePubSub {
+	f := &fakePubSub{batches: batches, ackSeen: make(chan string, 100)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakePubSub) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		http.Error(w, "no token", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		AckIDs []string `json:"ackIds"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch strings.TrimPrefix(r.URL.Path, "/"+testSub) {
+	case ":pull":
+		f.pulls++
+		if f.pullStatus != 0 {
+			http.Error(w, `{"error": {"message": "unavailable"}}`, f.pullStatus)
+			return
+		}
+		var messages []map[string]any
+		if len(f.batches) > 0 {
+			messages, f.batches = f.batches[0], f.batches[1:]
+		} else {
+			// Pub/Sub holds an empty pull open for a while.
+			f.mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			f.mu.Lock()
+		}
+		json.NewEncoder(w).Encode(map[string]any{"receivedMessages": messages})
+	case ":acknowledge":
+		f.acked = append(f.acked, req.AckIDs...)
+		for _, id := range req.AckIDs {
+			f.ackSeen <- id
+		}
+		w.Write([]byte("{}"))
+	case ":modifyAckDeadline":
+		f.extended = append(f.extended, req.AckIDs...)
+		w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakePubSub) listener() *Listener {
+	l := NewListener(testSub, func() (string, error) { return "tok", nil })
+	l.SetBaseURL(f.URL)
+	return l
+}
+
+// waitAck waits for an acknowledgement of ackID.
+func (f *fakePubSub) waitAck(t *testing.T, ackID string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case id := <-f.ackSeen:
+			if id == ackID {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s wasn't acknowledged", ackID)
+		}
+	}
+}
+
+func (f *fakePubSub) isAcked(ackID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Contains(f.acked, ackID)
+}
+
+// eventMessage returns a pulled message carrying one Nest event of device.
+func eventMessage(ackID, device, eventType, eventID string, at time.Time) map[string]any {
+	data, _ := json.Marshal(map[string]any{
+		"eventId":   eventID,
+		"timestamp": at.UTC().Format(time.RFC3339Nano),
+		"resourceUpdate": map[string]any{
+			"name": "enterprises/p/devices/" + device,
+			"events": map[string]any{
+				"sdm.devices.events." + eventType: map[string]any{"eventSessionId": "s-" + eventID, "eventId": eventID},
+			},
+		},
+	})
+	return map[string]any{
+		"ackId": ackID,
+		"message": map[string]any{
+			"data":        base64.StdEncoding.EncodeToString(data),
+			"publishTime": at.UTC().Format(time.RFC3339Nano),
+		},
+	}
+}
+
+func TestListen(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	traits, _ := json.Marshal(map[string]any{
+		"timestamp": now.UTC().Format(time.RFC3339Nano),
+		"resourceUpdate": map[string]any{
+			"name":   "enterprises/p/devices/thermo",
+			"traits": map[string]any{"sdm.devices.traits.Temperature": map[string]any{"ambientTemperatureCelsius": 21.5}},
+		},
+	})
+	f := newFakePubSub(t, []map[string]any{
+		eventMessage("ack-1", "front", "CameraMotion.Motion", "e1", now),
+		{"ackId": "ack-traits", "message": map[string]any{"data": base64.StdEncoding.EncodeToString(traits)}},
+		{"ackId": "ack-junk", "message": map[string]any{"data": "not base64!"}},
+	})
+	l := f.listener()
+	l.SetLabel("home")
+	updates := make(chan TraitUpdate, 1)
+	l.OnTraitUpdate(func(u TraitUpdate) { updates <- u })
+
+	events := make(chan Event, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- l.Listen(ctx, func(e Event) { events <- e }) }()
+
+	// Every message is acknowledged, including ones without events.
+	for _, id := range []string{"ack-1", "ack-traits", "ack-junk"} {
+		f.waitAck(t, id)
+	}
+	e := <-events
+	if e.DeviceName != "enterprises/p/devices/front" || e.EventType != "sdm.devices.events.CameraMotion.Motion" ||
+		e.EventID != "e1" || e.Subscription != "home" || !e.Timestamp.Equal(now) || !e.PublishTime.Equal(now) || e.Received.IsZero() {
+		t.Errorf("event = %+v", e)
+	}
+	u := <-updates
+	if u.DeviceName != "enterprises/p/devices/thermo" || u.Traits["sdm.devices.traits.Temperature"] == nil {
+		t.Errorf("trait update = %+v", u)
+	}
+	if h := l.Health(); h.State != StateOK || h.LastPull.IsZero() {
+		t.Errorf("Health = %+v", h)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Listen = %v, want context.Canceled", err)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+}
+
+func TestListenRetries(t *testing.T) {
+	f := newFakePubSub(t, []map[string]any{
+		eventMessage("ack-1", "front", "CameraMotion.Motion", "e1", time.Now()),
+	})
+	f.pullStatus = http.StatusServiceUnavailable
+	l := f.listener()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer func() { cancel(); <-done }()
+	go func() { l.Listen(ctx, func(Event) {}); close(done) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Health().State != StateDegraded {
+		if time.Now().After(deadline) {
+			t.Fatal("failed pull didn't degrade the listener")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if h := l.Health(); h.Failures != 1 || !strings.Contains(h.LastError, "pull returned 503") || h.RetryAt.IsZero() {
+		t.Errorf("Health = %+v", h)
+	}
+
+	// The first retry comes after half a second to a second.
+	f.mu.Lock()
+	f.pullStatus = 0
+	f.mu.Unlock()
+	f.waitAck(t, "ack-1")
+	if h := l.Health(); h.State != StateOK || h.Failures != 0 || h.LastError != "" {
+		t.Errorf("Health after recovering = %+v", h)
+	}
+}
+
+func TestListenSubscriptionGone(t *testing.T) {
+	f := newFakePubSub(t)
+	f.pullStatus = http.StatusNotFound
+	err := f.listener().Listen(context.Background(), func(Event) {})
+	if err == nil || !strings.Contains(err.Error(), "the subscription was deleted") {
+		t.Errorf("Listen = %v, want the subscription diagnosis", err)
+	}
+	if f.pulls != 1 {
+		t.Errorf("pulled %d times, want no retries", f.pulls)
+	}
+}
+
+func TestListenHold(t *testing.T) {
+	now := time.Now()
+	f := newFakePubSub(t, []map[string]any{
+		eventMessage("ack-front", "front", "CameraMotion.Motion", "e1", now),
+		eventMessage("ack-back", "back", "CameraPerson.Person", "e2", now),
+	})
+	defer func(d time.Duration) { ackExtendEvery = d }(ackExtendEvery)
+	ackExtendEvery = 10 * time.Millisecond
+
+	releases := make(chan func(), 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.listener().Listen(ctx, func(e Event) {
+		// The front camera's event carries on in the background.
+		if strings.HasSuffix(e.DeviceName, "/front") {
+			releases <- e.Hold()
+		}
+	})
+
+	f.waitAck(t, "ack-back")
+	release := <-releases
+	time.Sleep(50 * time.Millisecond)
+	if f.isAcked("ack-front") {
+		t.Fatal("held message acknowledged before its hold was released")
+	}
+	f.mu.Lock()
+	extended := slices.Contains(f.extended, "ack-front")
+	f.mu.Unlock()
+	if !extended {
+		t.Error("held message's ack deadline wasn't extended")
+	}
+
+	release()
+	release() // a second call is a no-op
+	f.waitAck(t, "ack-front")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if n := len(f.acked); n != 2 {
+		t.Errorf("acknowledged %d times, want 2: %v", n, f.acked)
+	}
+}
+
+func TestHoldWithoutMessage(t *testing.T) {
+	// Replayed events have no message; Hold does nothing.
+	release := Event{}.Hold()
+	release()
+}
@@ -0,0 +1,75 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+var _ EventSource = (*Multi)(nil)
+
+// Multi merges the events of several listeners, e.g. the subscriptions of
+// two Device Access projects, into one stream. Events carry their
+// listener's label (see Listener.SetLabel).
+type Multi struct {
+	listeners []*Listener
+}
+
+// NewMulti merges listeners, which should have distinct labels.
+func NewMulti(listeners ...*Listener) *Multi {
+	return &Multi{listeners: listeners}
+}
+
+// Listeners returns the merged listeners.
+func (m *Multi) Listeners() []*Listener {
+	return m.listeners
+}
+
+// OnTraitUpdate registers fn with every listener. Calls are serialized.
+// Call before Listen.
+func (m *Multi) OnTraitUpdate(fn func(TraitUpdate)) {
+	var mu sync.Mutex
+	for _, l := range m.listeners {
+		l.OnTraitUpdate(func(u TraitUpdate) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(u)
+		})
+	}
+}
+
+// Listen runs every listener until ctx is cancelled or one of them fails,
+// which stops the others. Like Listener.Listen, handler may be called
+// concurrently for different devices.
+func (m *Multi) Listen(ctx context.Context, handler func(Event)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(m.listeners))
+	for _, l := range m.listeners {
+		go func() {
+			err := l.Listen(ctx, handler)
+			if err != nil && ctx.Err() == nil && l.label != "" {
+				err = fmt.Errorf("%s: %w", l.label, err)
+			}
+			errs <- err
+		}()
+	}
+
+	// The first to return is the one that failed, or all stop on ctx.
+	err := <-errs
+	cancel()
+	for range len(m.listeners) - 1 {
+		<-errs
+	}
+	return err
+}
+
+// Health returns the health of each listener by label.
+func (m *Multi) Health() map[string]Health {
+	out := make(map[string]Health, len(m.listeners))
+	for _, l := range m.listeners {
+		out[l.label] = l.Health()
+	}
+	return out
+}
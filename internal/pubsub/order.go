@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// dispatch hands the events of a pulled batch to handler, one queue per
+// ordering key so each device's events are handled in order while
+// different devices' run in parallel. A message's key is its Pub/Sub
+// ordering key, or else its device. Events of messages without an ordering
+// key, which Pub/Sub may deliver out of order, are sorted by timestamp
+// within their queue. dispatch returns once every event has been handled.
+func (l *Listener) dispatch(messages []receivedMessage, received time.Time, handler func(Event)) {
+	type queue struct {
+		events  []Event
+		ordered bool
+	}
+	queues := map[string]*queue{}
+	var keys []string
+	for _, msg := range messages {
+		events := l.parseMessage(msg, received)
+		if len(events) == 0 {
+			continue
+		}
+		key := msg.Message.OrderingKey
+		if key == "" {
+			key = "device:" + events[0].DeviceName
+		}
+		q, ok := queues[key]
+		if !ok {
+			q = &queue{}
+			queues[key] = q
+			keys = append(keys, key)
+		}
+		q.events = append(q.events, events...)
+		q.ordered = q.ordered || msg.Message.OrderingKey != ""
+	}
+
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		q := queues[key]
+		if !q.ordered {
+			slices.SortStableFunc(q.events, func(a, b Event) int { return a.Timestamp.Compare(b.Timestamp) })
+		}
+		if len(keys) == 1 {
+			// Nothing to run in parallel with.
+			for _, event := range q.events {
+				handler(event)
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, event := range q.events {
+				handler(event)
+			}
+		}()
+	}
+	wg.Wait()
+}
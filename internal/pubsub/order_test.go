@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchOrder(t *testing.T) {
+	base := time.Now()
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+	keyed := func(m map[string]any, key string) map[string]any {
+		m["message"].(map[string]any)["orderingKey"] = key
+		return m
+	}
+	// Messages as pulled: the front camera's arrive out of order and
+	// without ordering keys; the back camera's carry one, so their
+	// delivery order stands even though a timestamp goes backwards.
+	raw := []map[string]any{
+		eventMessage("a3", "front", "CameraMotion.Motion", "f3", at(3)),
+		keyed(eventMessage("b1", "back", "CameraMotion.Motion", "b1", at(5)), "back"),
+		eventMessage("a1", "front", "CameraMotion.Motion", "f1", at(1)),
+		keyed(eventMessage("b2", "back", "CameraPerson.Person", "b2", at(4)), "back"),
+		eventMessage("a2", "front", "CameraMotion.Motion", "f2", at(2)),
+	}
+	messages := make([]receivedMessage, len(raw))
+	for i, m := range raw {
+		if err := remarshal(m, &messages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	got := map[string][]string{}
+	l := NewListener(testSub, nil)
+	l.dispatch(messages, time.Now(), func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		got[e.DeviceName] = append(got[e.DeviceName], e.EventID)
+	})
+
+	want := map[string][]string{
+		"enterprises/p/devices/front": {"f1", "f2", "f3"},
+		"enterprises/p/devices/back":  {"b1", "b2"},
+	}
+	for device, ids := range want {
+		if !slices.Equal(got[device], ids) {
+			t.Errorf("%s handled %v, want %v", device, got[device], ids)
+		}
+	}
+}
+
+func TestDispatchParallel(t *testing.T) {
+	now := time.Now()
+	var messages []receivedMessage
+	for _, device := range []string{"front", "back"} {
+		var m receivedMessage
+		if err := remarshal(eventMessage("ack-"+device, device, "CameraMotion.Motion", device, now), &m); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, m)
+	}
+
+	// Each device's handler waits for the other's to start: handled one
+	// after the other, they'd never finish.
+	var started sync.WaitGroup
+	started.Add(2)
+	done := make(chan struct{})
+	l := NewListener(testSub, nil)
+	go func() {
+		l.dispatch(messages, now, func(Event) {
+			started.Done()
+			started.Wait()
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("devices' events weren't handled in parallel")
+	}
+}
+
+// remarshal turns a message built by eventMessage into what pull decodes.
+func remarshal(m map[string]any, out *receivedMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Replayer feeds previously captured Pub/Sub messages through the same
+// parser as Listener. Input is NDJSON; each line may be a received message
+// from a pull response ({"ackId":..., "message":{...}}), a bare message
+// ({"data":...}), or the decoded Nest payload ({"eventId":...,
+// "resourceUpdate":{...}}).
+type Replayer struct {
+	name     string
+	r        io.Reader
+	onTraits func(TraitUpdate)
+}
+
+// NewReplayer returns a Replayer reading messages from r; name identifies
+// the input in messages.
+func NewReplayer(name string, r io.Reader) *Replayer {
+	return &Replayer{name: name, r: r}
+}
+
+// OnTraitUpdate registers a callback for replayed trait changes. Call before
+// Listen.
+func (p *Replayer) OnTraitUpdate(fn func(TraitUpdate)) {
+	p.onTraits = fn
+}
+
+// Listen sends the replayed events to handler in order. Lines that can't be
+// parsed are reported and skipped. It returns at the end of input or when
+// ctx is cancelled.
+func (p *Replayer) Listen(ctx context.Context, handler func(Event)) error {
+	fmt.Printf("Replaying events from %s...\n", p.name)
+
+	scanner := bufio.NewScanner(p.r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line++
+		text := scanner.Bytes()
+		if len(bytes.TrimSpace(text)) == 0 {
+			continue
+		}
+		data, err := replayPayload(text)
+		if err != nil {
+			fmt.Printf("Warning: line %d: %v\n", line, err)
+			continue
+		}
+		for _, event := range parseData(data, p.onTraits) {
+			handler(event)
+		}
+	}
+	return scanner.Err()
+}
+
+// replayPayload returns the decoded Nest payload from one replay line.
+func replayPayload(line []byte) ([]byte, error) {
+	var msg struct {
+		Message        *pubsubMessage  `json:"message"`
+		Data           *string         `json:"data"`
+		ResourceUpdate json.RawMessage `json:"resourceUpdate"`
+	}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var encoded string
+	switch {
+	case msg.Message != nil:
+		encoded = msg.Message.Data
+	case msg.Data != nil:
+		encoded = *msg.Data
+	case msg.ResourceUpdate != nil:
+		return line, nil
+	default:
+		return nil, fmt.Errorf("not a Pub/Sub message or Nest event")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding message data: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,219 @@
+// Package quota keeps a client-side budget of the SDM calls Google throttles
+// hardest (GenerateWebRtcStream and GenerateImage), per device and per
+// project, so one busy camera can't get the whole project rate limited.
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// Limits caps budgeted calls within a rolling Window. A zero limit means
+// unlimited.
+type Limits struct {
+	PerDevice  int
+	PerProject int
+	Window     time.Duration
+}
+
+// Default limits, kept well under the SDM API's own throttling.
+const (
+	DefaultPerDevice  = 10
+	DefaultPerProject = 50
+	DefaultWindow     = time.Minute
+)
+
+// deniedWindow is how long denied calls are remembered for the report.
+const deniedWindow = 24 * time.Hour
+
+// Call is one budgeted SDM call.
+type Call struct {
+	Time   time.Time `json:"time"`
+	Device string    `json:"device"` // device ID
+	Method string    `json:"method"`
+	Denied bool      `json:"denied,omitempty"`
+}
+
+// ExceededError is returned when a call would go over a limit.
+type ExceededError struct {
+	Scope      string // "device <id>" or "project"
+	Limit      int
+	Window     time.Duration
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("SDM call budget for %s exhausted (%d per %s); retry in %s",
+		e.Scope, e.Limit, e.Window, e.RetryAfter.Round(time.Second))
+}
+
+// Budget tracks budgeted calls. With a state file, calls are shared with
+// other gognestcli processes: the file is re-read before and rewritten
+// after every call, under a lock file next to it.
+type Budget struct {
+	mu     sync.Mutex
+	path   string
+	limits Limits
+	calls  []Call
+}
+
+// New returns a budget with the given limits, persisted to path if it is
+// not empty. An unreadable state file is treated as empty.
+func New(path string, limits Limits) *Budget {
+	b := &Budget{path: path, limits: limits}
+	b.load()
+	return b
+}
+
+// Take records a call to method for deviceName, a full resource name or
+// device ID, or returns an *ExceededError without recording it as made.
+func (b *Budget) Take(deviceName, method string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.lock()()
+	b.load()
+
+	now := time.Now()
+	device := deviceID(deviceName)
+	call := Call{Time: now, Device: device, Method: method}
+	var err error
+	if b.limits.PerDevice > 0 {
+		if err = b.check(now, "device "+device, b.limits.PerDevice, func(c Call) bool { return c.Device == device }); err != nil {
+			call.Denied = true
+		}
+	}
+	if err == nil && b.limits.PerProject > 0 {
+		if err = b.check(now, "project", b.limits.PerProject, func(Call) bool { return true }); err != nil {
+			call.Denied = true
+		}
+	}
+	b.calls = append(b.calls, call)
+	b.save()
+	return err
+}
+
+// check returns an *ExceededError if the calls matching in the current
+// window already reach limit.
+func (b *Budget) check(now time.Time, scope string, limit int, match func(Call) bool) error {
+	var recent []time.Time
+	for _, c := range b.calls {
+		if !c.Denied && match(c) && now.Sub(c.Time) < b.limits.Window {
+			recent = append(recent, c.Time)
+		}
+	}
+	if len(recent) < limit {
+		return nil
+	}
+	// The window frees up as the oldest calls age out.
+	oldest := recent[len(recent)-limit]
+	return &ExceededError{Scope: scope, Limit: limit, Window: b.limits.Window, RetryAfter: b.limits.Window - now.Sub(oldest)}
+}
+
+// Usage is one line of the quota report.
+type Usage struct {
+	Scope  string // device ID or "project"
+	Used   int    // calls in the current window
+	Limit  int    // 0 means unlimited
+	Denied int    // calls refused in the last 24 hours
+}
+
+// Report returns project usage followed by each device seen recently.
+func (b *Budget) Report() []Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.load()
+
+	now := time.Now()
+	project := Usage{Scope: "project", Limit: b.limits.PerProject}
+	devices := map[string]*Usage{}
+	for _, c := range b.calls {
+		d, ok := devices[c.Device]
+		if !ok {
+			d = &Usage{Scope: c.Device, Limit: b.limits.PerDevice}
+			devices[c.Device] = d
+		}
+		switch {
+		case c.Denied:
+			project.Denied++
+			d.Denied++
+		case now.Sub(c.Time) < b.limits.Window:
+			project.Used++
+			d.Used++
+		}
+	}
+	report := []Usage{project}
+	for _, d := range devices {
+		report = append(report, *d)
+	}
+	sort.Slice(report[1:], func(i, j int) bool { return report[i+1].Scope < report[j+1].Scope })
+	return report
+}
+
+// Window returns the rolling window the limits apply to.
+func (b *Budget) Window() time.Duration {
+	return b.limits.Window
+}
+
+// lock takes the state file's lock for a load-modify-save and returns its
+// release. If it can't be had, the call goes ahead unlocked: the budget is
+// only a safeguard. Callers hold mu.
+func (b *Budget) lock() (unlock func()) {
+	if b.path == "" {
+		return func() {}
+	}
+	unlock, err := config.LockFile(b.path + ".lock")
+	if err != nil {
+		return func() {}
+	}
+	return unlock
+}
+
+// load merges the state file into memory, dropping calls too old to matter.
+func (b *Budget) load() {
+	if b.path != "" {
+		if data, err := os.ReadFile(b.path); err == nil {
+			var calls []Call
+			if json.Unmarshal(data, &calls) == nil {
+				b.calls = calls
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+	}
+	b.prune(time.Now())
+}
+
+func (b *Budget) prune(now time.Time) {
+	keep := b.calls[:0]
+	for _, c := range b.calls {
+		if now.Sub(c.Time) < max(b.limits.Window, deniedWindow) {
+			keep = append(keep, c)
+		}
+	}
+	b.calls = keep
+}
+
+// save writes the calls to the state file atomically. Errors are ignored:
+// the in-memory budget still applies to this process.
+func (b *Budget) save() {
+	if b.path == "" {
+		return
+	}
+	data, err := json.Marshal(b.calls)
+	if err != nil {
+		return
+	}
+	config.WriteFileAtomic(b.path, data)
+}
+
+func deviceID(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -0,0 +1,47 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTakeSharedAcrossBudgets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	limits := Limits{PerDevice: 20, Window: time.Minute}
+	// Two budgets on one file stand in for two processes.
+	budgets := []*Budget{New(path, limits), New(path, limits)}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var allowed, denied int
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := budgets[i%2].Take("enterprises/p/devices/cam", "GenerateWebRtcStream")
+			mu.Lock()
+			defer mu.Unlock()
+			var exceeded *ExceededError
+			switch {
+			case err == nil:
+				allowed++
+			case errors.As(err, &exceeded):
+				denied++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 20 || denied != 20 {
+		t.Errorf("allowed %d, denied %d; want 20 each", allowed, denied)
+	}
+	report := New(path, limits).Report()
+	if report[0].Used != 20 || report[0].Denied != 20 {
+		t.Errorf("report = %+v, want 20 used and 20 denied", report[0])
+	}
+}
@@ -0,0 +1,56 @@
+package quota
+
+import (
+	"encoding/json"
+
+	"github.com/brice/gognestcli/internal/sdm"
+)
+
+// Budgeted SDM commands, as named in ExecuteCommand.
+const (
+	cmdGenerateStream = "sdm.devices.commands.CameraLiveStream.GenerateWebRtcStream"
+	cmdGenerateImage  = "sdm.devices.commands.CameraEventImage.GenerateImage"
+)
+
+// client charges stream and event image generation to a Budget. Extending
+// and stopping streams, and every other call, pass straight through.
+type client struct {
+	sdm.API
+	budget *Budget
+}
+
+// Wrap returns api with GenerateWebRTCStream and GenerateEventImage (and the
+// same commands sent through ExecuteCommand) refused once b is exhausted.
+func Wrap(api sdm.API, b *Budget) sdm.API {
+	return &client{API: api, budget: b}
+}
+
+func (c *client) GenerateWebRTCStream(deviceName, offerSDP string) (*sdm.WebRTCStream, error) {
+	if err := c.budget.Take(deviceName, "GenerateWebRtcStream"); err != nil {
+		return nil, err
+	}
+	return c.API.GenerateWebRTCStream(deviceName, offerSDP)
+}
+
+func (c *client) GenerateEventImage(deviceName, eventID string) (*sdm.EventImage, error) {
+	if err := c.budget.Take(deviceName, "GenerateImage"); err != nil {
+		return nil, err
+	}
+	return c.API.GenerateEventImage(deviceName, eventID)
+}
+
+func (c *client) ExecuteCommand(deviceName, command string, params map[string]interface{}) (json.RawMessage, error) {
+	var method string
+	switch command {
+	case cmdGenerateStream:
+		method = "GenerateWebRtcStream"
+	case cmdGenerateImage:
+		method = "GenerateImage"
+	}
+	if method != "" {
+		if err := c.budget.Take(deviceName, method); err != nil {
+			return nil, err
+		}
+	}
+	return c.API.ExecuteCommand(deviceName, command, params)
+}
@@ -0,0 +1,91 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PartialPath returns the hidden temp path a capture is written to before
+// Commit renames it into place, e.g. "clips/.front.partial.mp4" for
+// "clips/front.mp4". The extension is kept so ffmpeg still picks the right
+// muxer; watchers should ignore dotfiles or names containing ".partial.".
+func PartialPath(path string) string {
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	return filepath.Join(dir, "."+strings.TrimSuffix(name, ext)+".partial"+ext)
+}
+
+// DoneSuffix is appended to a capture's path for its completion marker.
+const DoneSuffix = ".done"
+
+// WithDoneMarker writes an empty "<output>.done" file after each capture has
+// been renamed into place, for watchers that can't rely on rename events.
+func WithDoneMarker() Option {
+	return func(o *options) { o.doneMarker = true }
+}
+
+// WithoutReplace makes a capture fail with an error matching fs.ErrExist,
+// rather than replace the file, if its output path already exists.
+func WithoutReplace() Option {
+	return func(o *options) { o.noReplace = true }
+}
+
+// Commit flushes tmp to disk and renames it to path, which must be on the same
+// filesystem, so readers only ever see a complete file. With marker, an empty
+// path+DoneSuffix file is created afterwards.
+func Commit(tmp, path string, marker bool) error {
+	return commit(tmp, path, marker, true)
+}
+
+// CommitNew is Commit for a path that must not exist yet: if it does, CommitNew
+// fails with an error matching fs.ErrExist and leaves tmp in place.
+func CommitNew(tmp, path string, marker bool) error {
+	return commit(tmp, path, marker, false)
+}
+
+func commit(tmp, path string, marker, replace bool) error {
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("syncing %s: %w", tmp, err)
+	}
+	if replace {
+		err = os.Rename(tmp, path)
+	} else {
+		err = place(tmp, path)
+	}
+	if err != nil {
+		return err
+	}
+	if marker {
+		if err := os.WriteFile(path+DoneSuffix, nil, 0644); err != nil {
+			return fmt.Errorf("writing completion marker: %w", err)
+		}
+	}
+	return nil
+}
+
+// place moves tmp to path unless path exists. A hard link claims path
+// atomically; on filesystems without them (some network and FAT mounts) it
+// falls back to checking first, then renaming.
+func place(tmp, path string) error {
+	err := os.Link(tmp, path)
+	if err == nil {
+		return os.Remove(tmp)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("not replacing %s: %w", path, fs.ErrExist)
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("not replacing %s: %w", path, fs.ErrExist)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,63 @@
+package recorder
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommit(t *testing.T) {
+	tests := []struct {
+		name    string
+		commit  func(tmp, path string, marker bool) error
+		replace bool
+	}{
+		{"Commit", Commit, true},
+		{"CommitNew", CommitNew, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "front.jpg")
+			tmp := PartialPath(path)
+
+			if err := os.WriteFile(tmp, []byte("first"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.commit(tmp, path, true); err != nil {
+				t.Fatalf("first commit: %v", err)
+			}
+			if _, err := os.Stat(tmp); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("temp file left behind: %v", err)
+			}
+			if _, err := os.Stat(path + DoneSuffix); err != nil {
+				t.Errorf("no completion marker: %v", err)
+			}
+
+			// A second capture to the same path.
+			if err := os.WriteFile(tmp, []byte("second"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			err := tt.commit(tmp, path, false)
+			want := "first"
+			if tt.replace {
+				want = "second"
+				if err != nil {
+					t.Fatalf("second commit: %v", err)
+				}
+			} else {
+				if !errors.Is(err, fs.ErrExist) {
+					t.Fatalf("second commit error = %v, want fs.ErrExist", err)
+				}
+				if data, _ := os.ReadFile(tmp); string(data) != "second" {
+					t.Errorf("refused capture's temp file = %q, want it kept", data)
+				}
+			}
+			if data, _ := os.ReadFile(path); string(data) != want {
+				t.Errorf("%s = %q, want %q", filepath.Base(path), data, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/proc"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+// Audio modes for WithAudio.
+const (
+	AudioNone = "none"
+	AudioAAC  = "aac"
+	AudioCopy = "copy"
+)
+
+// AudioModes lists the values WithAudio accepts, for flags and config.
+var AudioModes = []string{AudioNone, AudioAAC, AudioCopy}
+
+// opusFrame is the RTP timestamp step of one 20 ms Opus frame at 48 kHz,
+// used to join the audio of successive stream sessions.
+const opusFrame = 960
+
+// WithAudio records the camera's microphone into clips. MP4 clips get it
+// transcoded to AAC with AudioAAC, which more players and cloud services
+// accept than Opus in MP4, or as Opus with AudioCopy. WebM and MKV clips
+// keep the Opus track as is either way. AudioNone or "" leaves clips
+// silent, as do snapshots and pre-roll clips.
+func WithAudio(mode string) Option {
+	return func(o *options) {
+		if mode == AudioNone {
+			mode = ""
+		}
+		o.audio = mode
+	}
+}
+
+// ValidateAudio checks an audio mode from config or flags.
+func ValidateAudio(mode string) error {
+	switch mode {
+	case "", AudioNone, AudioAAC, AudioCopy:
+		return nil
+	}
+	return fmt.Errorf("unknown audio mode %q (none, aac or copy)", mode)
+}
+
+// OpusWriter collects Opus audio from WebRTC audio tracks into an Ogg file.
+// Tracks from successive stream sessions are joined into one.
+type OpusWriter struct {
+	mu      sync.Mutex
+	ogg     *oggwriter.OggWriter
+	packets int
+	next    uint32    // output timestamp following the last packet
+	t0      time.Time // when the first packet was written
+}
+
+// NewOpusWriter creates an Ogg/Opus file for the audio track.
+func NewOpusWriter(filename string) (*OpusWriter, error) {
+	ogg, err := oggwriter.New(filename, 48000, 2)
+	if err != nil {
+		return nil, err
+	}
+	return &OpusWriter{ogg: ogg}, nil
+}
+
+// HandleAudioTrack reads Opus RTP packets and writes them to the file,
+// shifting their timestamps to carry on from the previous track's, or from
+// the time since the first packet if that's later, so the gap between
+// sessions stays in step with the video.
+func (w *OpusWriter) HandleAudioTrack(track nestwebrtc.Track, ctx context.Context) {
+	reader := newPacketReader(track)
+	var offset uint32
+	first := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		pkt, err := reader.read()
+		if err != nil {
+			return
+		}
+		if len(pkt.Payload) == 0 {
+			reader.release(pkt)
+			continue
+		}
+
+		w.mu.Lock()
+		if w.ogg == nil {
+			w.mu.Unlock()
+			return
+		}
+		if first {
+			start := w.next
+			if w.packets == 0 {
+				w.t0 = time.Now()
+			} else if wall := uint32(time.Since(w.t0).Seconds() * 48000); int32(wall-start) > 0 {
+				start = wall
+			}
+			offset = start - pkt.Timestamp
+			first = false
+		}
+		pkt.Timestamp += offset
+		if w.ogg.WriteRTP(pkt) == nil {
+			w.packets++
+			w.next = pkt.Timestamp + opusFrame
+		}
+		w.mu.Unlock()
+		reader.release(pkt)
+	}
+}
+
+// Packets returns the number of audio packets written so far.
+func (w *OpusWriter) Packets() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.packets
+}
+
+// Close finishes the file.
+func (w *OpusWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.ogg == nil {
+		return nil
+	}
+	err := w.ogg.Close()
+	w.ogg = nil
+	return err
+}
+
+// newAudioWriter creates the temp audio file for a clip at outputPath if
+// audio is enabled, registered with proc.AddTemp; w is nil otherwise.
+func (o options) newAudioWriter(outputPath string) (w *OpusWriter, path string, err error) {
+	if o.audio == "" {
+		return nil, "", nil
+	}
+	path = outputPath + ".tmp.ogg"
+	proc.AddTemp(path)
+	if w, err = NewOpusWriter(path); err != nil {
+		proc.RemoveTemp(path)
+		return nil, "", fmt.Errorf("creating temp audio file: %w", err)
+	}
+	return w, path, nil
+}
+
+// finishAudio closes w and returns path for muxing, or "" if there's no
+// audio to add (w is nil, or the camera sent none).
+func finishAudio(w *OpusWriter, path string) string {
+	if w == nil {
+		return ""
+	}
+	w.Close()
+	if w.Packets() == 0 {
+		return ""
+	}
+	return path
+}
+
+// audioArgs returns the ffmpeg arguments adding the audio at audioPath, if
+// any, as the second input, or from the first input when it's inline: the
+// input itself and the output mapping and codec. MP4s get AAC unless the
+// mode is AudioCopy, with lost packets filled in with silence so the audio
+// stays in step with the video.
+func (o options) audioArgs(audioPath string, inline, mp4 bool) (in, out []string) {
+	if audioPath == "" {
+		return nil, nil
+	}
+	out = []string{"-map", "0:v:0", "-map", "0:a:0"}
+	if !inline {
+		in = []string{"-i", audioPath}
+		out[3] = "1:a:0"
+	}
+	if mp4 && o.audio == AudioAAC {
+		return in, append(out, "-c:a", "aac", "-b:a", "64k", "-af", "aresample=async=1")
+	}
+	return in, append(out, "-c:a", "copy")
+}
@@ -0,0 +1,68 @@
+package recorder
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// opusTrack is an audio track of 20 ms Opus frames starting at timestamp
+// ts, ending after count of them.
+type opusTrack struct {
+	seq   uint16
+	ts    uint32
+	count int
+}
+
+func (t *opusTrack) Read(b []byte) (int, interceptor.Attributes, error) {
+	if t.count == 0 {
+		return 0, nil, io.EOF
+	}
+	t.count--
+	pkt := rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 111, SequenceNumber: t.seq, Timestamp: t.ts},
+		Payload: []byte{0xfc, 0xff, 0xfe},
+	}
+	t.seq++
+	t.ts += opusFrame
+	n, err := pkt.MarshalTo(b)
+	return n, nil, err
+}
+
+func (t *opusTrack) Codec() webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000}}
+}
+
+func (t *opusTrack) Kind() webrtc.RTPCodecType { return webrtc.RTPCodecTypeAudio }
+
+func TestOpusWriterJoinsTracks(t *testing.T) {
+	w, err := NewOpusWriter(filepath.Join(t.TempDir(), "audio.ogg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	ctx := context.Background()
+
+	w.HandleAudioTrack(&opusTrack{ts: 123456, count: 3}, ctx)
+	if w.next != 3*opusFrame {
+		t.Fatalf("after the first track next = %d, want %d", w.next, 3*opusFrame)
+	}
+
+	// The next session starts 200 ms after the first: its audio picks up
+	// from there, not straight after the first session's last frame.
+	time.Sleep(200 * time.Millisecond)
+	w.HandleAudioTrack(&opusTrack{ts: 7, count: 2}, ctx)
+	if w.Packets() != 5 {
+		t.Errorf("Packets = %d, want 5", w.Packets())
+	}
+	gap := w.next - 2*opusFrame // where the second track started
+	if gap < 9600 || gap > 48000 {
+		t.Errorf("second track started at %d, want about 9600 (200 ms at 48 kHz)", gap)
+	}
+}
@@ -0,0 +1,290 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/proc"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+	"github.com/pion/webrtc/v4"
+)
+
+// Buffer keeps the last few seconds of a camera's video in memory from a
+// stream that stays open, so clips can start before they were asked for.
+// Like RecordUntil, it reconnects when the stream ends or stalls.
+type Buffer struct {
+	window time.Duration
+	writer *H264Writer
+	log    *slog.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	samples []bufferedSample
+	holds   map[int]time.Time // clips in progress, by ID → earliest time they need
+	nextID  int
+}
+
+type bufferedSample struct {
+	at     time.Time
+	data   []byte
+	key    bool // IDR frame
+	params bool // carries SPS/PPS
+}
+
+// StartBuffered opens a stream and keeps the last window of video in memory
+// until ctx is done or Close is called. It returns once video arrives. Of
+// opts, only WithLogger applies; clips take their own.
+func StartBuffered(ctx context.Context, window time.Duration, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts ...Option) (*Buffer, error) {
+	o := buildOptions(opts)
+	ctx, cancel := context.WithCancel(ctx)
+	b := &Buffer{
+		window: window,
+		log:    o.logger(),
+		cancel: cancel,
+		done:   make(chan struct{}),
+		holds:  map[int]time.Time{},
+	}
+	b.writer = NewH264WriterTo(bufferSink{b})
+	b.writer.skipToKeyframe = true
+	b.writer.log = b.log
+
+	streamCancel, ended, err := b.open(ctx, startStream)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go b.run(ctx, startStream, streamCancel, ended)
+	return b, nil
+}
+
+// open starts a stream and waits for video. ended fires when its video
+// track stops; cancel closes the stream.
+func (b *Buffer) open(ctx context.Context, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error) (context.CancelFunc, <-chan struct{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	gotVideo := make(chan struct{}, 1)
+	trackEnded := make(chan struct{}, 1)
+	err := startStream(ctx, func(track nestwebrtc.Track) {
+		if strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264) {
+			select {
+			case gotVideo <- struct{}{}:
+			default:
+			}
+			b.writer.HandleVideoTrack(track, ctx)
+			trackEnded <- struct{}{}
+		}
+	})
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("starting stream: %w", err)
+	}
+	select {
+	case <-gotVideo:
+		return cancel, trackEnded, nil
+	case <-ctx.Done():
+		cancel()
+		return nil, nil, ctx.Err()
+	case <-time.After(30 * time.Second):
+		cancel()
+		return nil, nil, fmt.Errorf("timed out waiting for video track")
+	}
+}
+
+// run keeps the stream going, reconnecting when it ends or stalls, until ctx
+// is done.
+func (b *Buffer) run(ctx context.Context, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, cancel context.CancelFunc, ended <-chan struct{}) {
+	defer close(b.done)
+	stall := time.NewTicker(stallTimeout)
+	defer stall.Stop()
+	frames := b.writer.Frames()
+
+	for {
+		select {
+		case <-ctx.Done():
+			cancel()
+			return
+		case <-ended:
+			b.log.Info("Buffered stream ended, reconnecting...")
+		case <-stall.C:
+			if n := b.writer.Frames(); n != frames {
+				frames = n
+				continue
+			}
+			b.log.Info("Buffered stream stalled, reconnecting...")
+		}
+
+		cancel()
+		for {
+			var err error
+			if cancel, ended, err = b.open(ctx, startStream); err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			b.log.Warn("reconnecting buffered stream failed", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+		frames = b.writer.Frames()
+		stall.Reset(stallTimeout)
+	}
+}
+
+// Close stops the stream and drops the buffered video.
+func (b *Buffer) Close() {
+	b.cancel()
+	<-b.done
+	b.mu.Lock()
+	b.samples = nil
+	b.mu.Unlock()
+}
+
+// Window returns how much video the buffer keeps.
+func (b *Buffer) Window() time.Duration { return b.window }
+
+// TriggerClip saves the video from pre before at to post after it to
+// outputPath, muxed like RecordClip (MP4, MKV or WebM by extension). at is
+// usually now, or when an event happened; pre can't exceed the buffer's
+// window. It returns once the clip is written.
+func (b *Buffer) TriggerClip(outputPath string, at time.Time, pre, post time.Duration, opts ...Option) error {
+	o := buildOptions(opts)
+	if pre > b.window {
+		return fmt.Errorf("pre-roll %s is longer than the %s buffer", pre, b.window)
+	}
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	if _, err := FindTool("ffmpeg", o.ffmpegPath); err != nil && o.needsFFmpeg(ext) {
+		return fmt.Errorf("ffmpeg is required for recording: %w", err)
+	}
+	if now := time.Now(); at.After(now) {
+		at = now
+	}
+	from, until := at.Add(-pre), at.Add(post)
+
+	// Hold on to the pre-roll while the rest arrives.
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.holds[id] = from
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.holds, id)
+		b.mu.Unlock()
+	}()
+	if o.firstFrame != nil {
+		go o.firstFrame()
+	}
+
+	select {
+	case <-time.After(time.Until(until)):
+	case <-b.done:
+		return fmt.Errorf("buffered stream closed")
+	case <-o.context().Done():
+		return o.context().Err()
+	}
+
+	b.mu.Lock()
+	i := b.startIndex(from)
+	var clip []bufferedSample
+	for _, s := range b.samples[i:] {
+		if s.at.After(until) {
+			break
+		}
+		clip = append(clip, s)
+	}
+	b.mu.Unlock()
+	if len(clip) == 0 {
+		return fmt.Errorf("no video buffered for %s to %s", from.Format("15:04:05"), until.Format("15:04:05"))
+	}
+	o.start = clip[0].at
+	if o.streamInfo != nil {
+		for _, s := range clip {
+			if info, ok := streamInfo(s.data); ok {
+				o.streamInfo(info)
+				break
+			}
+		}
+	}
+
+	tmpH264 := outputPath + ".tmp.h264"
+	proc.AddTemp(tmpH264)
+	defer proc.RemoveTemp(tmpH264)
+	f, err := os.Create(tmpH264)
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	spans := make([]frameSpan, 0, len(clip))
+	for _, s := range clip {
+		if _, err := f.Write(s.data); err != nil {
+			f.Close()
+			return fmt.Errorf("writing temp file: %w", err)
+		}
+		spans = append(spans, frameSpan{size: len(s.data), pts: s.at.Sub(o.start)})
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing temp file: %w", err)
+	}
+
+	return o.mux(outputPath, func(dst string) error {
+		return convertClip(o, ext, tmpH264, spans, "", dst)
+	})
+}
+
+// startIndex returns where decodable video at or before t begins: the last
+// keyframe no later than t, preceded by its parameter sets if they were sent
+// separately. Callers hold mu.
+func (b *Buffer) startIndex(t time.Time) int {
+	i := 0
+	for j, s := range b.samples {
+		if s.at.After(t) {
+			break
+		}
+		if s.key {
+			i = j
+		}
+	}
+	if i > 0 && b.samples[i-1].params && !b.samples[i-1].key {
+		i--
+	}
+	return i
+}
+
+// add appends a sample and drops what no clip can need any more. It keeps
+// data itself: the H264Writer hands over each access unit as a fresh slice.
+func (b *Buffer) add(data []byte) {
+	now := time.Now()
+	s := bufferedSample{at: now, data: data, key: isKeyframe(data), params: hasParameterSets(data)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.samples = append(b.samples, s)
+	cutoff := now.Add(-b.window)
+	for _, t := range b.holds {
+		if t.Before(cutoff) {
+			cutoff = t
+		}
+	}
+	if i := b.startIndex(cutoff); i > 0 {
+		b.samples = append(b.samples[:0:0], b.samples[i:]...)
+	}
+}
+
+// bufferSink receives the H264Writer's access units.
+type bufferSink struct{ b *Buffer }
+
+func (s bufferSink) Write(p []byte) (int, error) {
+	s.b.add(p)
+	return len(p), nil
+}
+
+func (s bufferSink) Close() error { return nil }
@@ -0,0 +1,105 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirSink writes CMAF files into a directory, each through a partial file
+// renamed into place so HLS servers never serve half a segment.
+type DirSink struct {
+	Dir string
+}
+
+// NewDirSink creates dir if needed and returns a sink writing into it.
+func NewDirSink(dir string) (*DirSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirSink{Dir: dir}, nil
+}
+
+// Put writes data to name in the directory.
+func (d *DirSink) Put(name string, data []byte) error {
+	path := filepath.Join(d.Dir, name)
+	tmp := PartialPath(path)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes name from the directory.
+func (d *DirSink) Remove(name string) error {
+	return os.Remove(filepath.Join(d.Dir, name))
+}
+
+// chunkTimeout bounds the upload of a single CMAF file.
+const chunkTimeout = 30 * time.Second
+
+// HTTPSink uploads CMAF files with PUT to URL + "/" + name, e.g. to a DASH
+// or HLS ingest endpoint, and removes expired segments with DELETE.
+type HTTPSink struct {
+	URL   string
+	Token string // sent as a bearer token when set
+
+	Client *http.Client
+}
+
+// NewHTTPSink returns a sink uploading under base.
+func NewHTTPSink(base, token string) *HTTPSink {
+	return &HTTPSink{
+		URL:    strings.TrimSuffix(base, "/"),
+		Token:  token,
+		Client: &http.Client{Timeout: chunkTimeout},
+	}
+}
+
+// Put uploads data as name.
+func (h *HTTPSink) Put(name string, data []byte) error {
+	contentType := "video/iso.segment"
+	switch filepath.Ext(name) {
+	case ".mp4":
+		contentType = "video/mp4"
+	case ".m3u8":
+		contentType = "application/vnd.apple.mpegurl"
+	}
+	return h.do(http.MethodPut, name, data, contentType)
+}
+
+// Remove deletes name from the server.
+func (h *HTTPSink) Remove(name string) error {
+	return h.do(http.MethodDelete, name, nil, "")
+}
+
+func (h *HTTPSink) do(method, name string, data []byte, contentType string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), chunkTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, h.URL+"/"+name, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, name, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s", method, name, resp.Status)
+	}
+	return nil
+}
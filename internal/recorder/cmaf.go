@@ -0,0 +1,376 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+)
+
+// ChunkSink stores the files of a CMAF stream by name: the init segment
+// (init.mp4), media segments (seg-00001.m4s, ...) and an HLS playlist
+// (index.m3u8). Remove drops segments that have left the playlist window.
+// See DirSink and HTTPSink.
+type ChunkSink interface {
+	Put(name string, data []byte) error
+	Remove(name string) error
+}
+
+// CMAF file names.
+const (
+	CMAFInit     = "init.mp4"
+	CMAFPlaylist = "index.m3u8"
+)
+
+// cmafTimescale is the media timescale of CMAF output, the RTP clock rate.
+const cmafTimescale = 90000
+
+// CMAFWriter cuts raw H264 into CMAF (fragmented MP4) segments without
+// ffmpeg, for HLS players, DASH packagers and other streaming consumers.
+// Each segment starts at a keyframe and lasts at least the target duration.
+// Pass it to NewH264WriterTo, or use RecordCMAF.
+type CMAFWriter struct {
+	sink   ChunkSink
+	target time.Duration
+	window int
+
+	mu       sync.Mutex
+	init     bool
+	frames   []cmafSample // segment being collected
+	seq      int
+	segments []cmafSegment // in the playlist
+	err      error
+}
+
+type cmafSample struct {
+	data []byte // AVCC
+	pts  time.Duration
+	key  bool
+}
+
+type cmafSegment struct {
+	name     string
+	duration time.Duration
+}
+
+// NewCMAFWriter returns a writer that sends segments of at least target to
+// sink. The playlist lists the last window segments and older ones are
+// removed from sink; 0 keeps them all.
+func NewCMAFWriter(sink ChunkSink, target time.Duration, window int) *CMAFWriter {
+	if target <= 0 {
+		target = 2 * time.Second
+	}
+	return &CMAFWriter{sink: sink, target: target, window: window}
+}
+
+// Write rejects access units without a capture time; CMAFWriter needs
+// H264Writer's WriteFrame.
+func (c *CMAFWriter) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("cmaf: access units need capture times")
+}
+
+// WriteFrame adds an Annex B access unit captured at pts. Units before the
+// first keyframe are dropped. It returns the first error from the sink,
+// after which nothing more is sent.
+func (c *CMAFWriter) WriteFrame(data []byte, pts time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	key := isKeyframe(data)
+	if !c.init {
+		if !key {
+			return len(data), nil
+		}
+		if c.err = c.writeInit(data); c.err != nil {
+			return 0, c.err
+		}
+		c.init = true
+	}
+	if key && len(c.frames) > 0 && pts-c.frames[0].pts >= c.target {
+		if c.err = c.flush(pts); c.err != nil {
+			return 0, c.err
+		}
+	}
+	c.frames = append(c.frames, cmafSample{data: annexBToAVCC(data), pts: pts, key: key})
+	return len(data), nil
+}
+
+// Close sends the last segment and ends the playlist.
+func (c *CMAFWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil || len(c.frames) == 0 {
+		return c.err
+	}
+	end := c.frames[len(c.frames)-1].pts
+	if n := len(c.frames); n > 1 {
+		end += (end - c.frames[0].pts) / time.Duration(n-1)
+	} else {
+		end += time.Second / 15
+	}
+	if c.err = c.flush(end); c.err != nil {
+		return c.err
+	}
+	c.err = c.sink.Put(CMAFPlaylist, c.playlist(true))
+	return c.err
+}
+
+// writeInit sends the init segment, described by the SPS and PPS in the
+// first keyframe.
+func (c *CMAFWriter) writeInit(keyframe []byte) error {
+	var sps, pps []byte
+	for _, nal := range nalUnits(keyframe) {
+		switch {
+		case len(nal) == 0:
+		case nal[0]&0x1f == nalSPS && sps == nil:
+			sps = nal
+		case nal[0]&0x1f == nalPPS && pps == nil:
+			pps = nal
+		}
+	}
+	if sps == nil || pps == nil {
+		return fmt.Errorf("cmaf: no SPS/PPS before the first keyframe")
+	}
+	info, err := parseSPS(sps)
+	if err != nil {
+		return err
+	}
+	return c.sink.Put(CMAFInit, cmafInit(sps, pps, info.Width, info.Height))
+}
+
+// flush sends the collected frames as a segment ending at next, and the
+// updated playlist.
+func (c *CMAFWriter) flush(next time.Duration) error {
+	c.seq++
+	name := fmt.Sprintf("seg-%05d.m4s", c.seq)
+	if err := c.sink.Put(name, cmafSegmentData(c.seq, c.frames, next)); err != nil {
+		return err
+	}
+	c.segments = append(c.segments, cmafSegment{name: name, duration: next - c.frames[0].pts})
+	c.frames = nil
+
+	if c.window > 0 && len(c.segments) > c.window {
+		expired := c.segments[:len(c.segments)-c.window]
+		c.segments = c.segments[len(expired):]
+		for _, s := range expired {
+			c.sink.Remove(s.name)
+		}
+	}
+	return c.sink.Put(CMAFPlaylist, c.playlist(false))
+}
+
+// playlist returns the HLS media playlist for the segments kept.
+func (c *CMAFWriter) playlist(ended bool) []byte {
+	var target time.Duration
+	for _, s := range c.segments {
+		target = max(target, s.duration)
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n")
+	if c.window == 0 {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int((target+time.Second-1)/time.Second))
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", c.seq-len(c.segments)+1)
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", CMAFInit)
+	for _, s := range c.segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration.Seconds(), s.name)
+	}
+	if ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return []byte(b.String())
+}
+
+// RecordCMAF records the video for duration as CMAF segments into sink,
+// without ffmpeg, with segments of about segment each and the last window
+// of them in the playlist (0 for all).
+func RecordCMAF(sink ChunkSink, segment time.Duration, window int, duration time.Duration, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts ...Option) error {
+	o := buildOptions(opts)
+	return captureTo(NewCMAFWriter(sink, segment, window), duration, sleepFor(duration), startStream, o)
+}
+
+// cmafInit builds the init segment: ftyp and a moov for one H264 track.
+func cmafInit(sps, pps []byte, width, height int) []byte {
+	var b mp4
+	b.box("ftyp", func(b *mp4) {
+		b.WriteString("iso6")
+		b.u32(0)
+		b.WriteString("iso6cmfcmp41")
+	})
+	b.box("moov", func(b *mp4) {
+		b.full("mvhd", 0, 0, func(b *mp4) {
+			b.u32(0)    // creation time
+			b.u32(0)    // modification time
+			b.u32(1000) // timescale
+			b.u32(0)    // duration
+			b.u32(0x00010000)
+			b.u16(0x0100)
+			b.Write(make([]byte, 10))
+			b.matrix()
+			b.Write(make([]byte, 24))
+			b.u32(2) // next track ID
+		})
+		b.box("trak", func(b *mp4) {
+			b.full("tkhd", 0, 3, func(b *mp4) {
+				b.u32(0)
+				b.u32(0)
+				b.u32(1) // track ID
+				b.u32(0)
+				b.u32(0) // duration
+				b.Write(make([]byte, 16))
+				b.matrix()
+				b.u32(uint32(width) << 16)
+				b.u32(uint32(height) << 16)
+			})
+			b.box("mdia", func(b *mp4) {
+				b.full("mdhd", 0, 0, func(b *mp4) {
+					b.u32(0)
+					b.u32(0)
+					b.u32(cmafTimescale)
+					b.u32(0)
+					b.u16(0x55c4) // "und"
+					b.u16(0)
+				})
+				b.full("hdlr", 0, 0, func(b *mp4) {
+					b.u32(0)
+					b.WriteString("vide")
+					b.Write(make([]byte, 12))
+					b.WriteString("VideoHandler\x00")
+				})
+				b.box("minf", func(b *mp4) {
+					b.full("vmhd", 0, 1, func(b *mp4) { b.Write(make([]byte, 8)) })
+					b.box("dinf", func(b *mp4) {
+						b.full("dref", 0, 0, func(b *mp4) {
+							b.u32(1)
+							b.full("url ", 0, 1, func(*mp4) {})
+						})
+					})
+					b.box("stbl", func(b *mp4) {
+						b.full("stsd", 0, 0, func(b *mp4) {
+							b.u32(1)
+							b.box("avc1", func(b *mp4) {
+								b.Write(make([]byte, 6))
+								b.u16(1) // data reference index
+								b.Write(make([]byte, 16))
+								b.u16(uint16(width))
+								b.u16(uint16(height))
+								b.u32(0x00480000) // 72 dpi
+								b.u32(0x00480000)
+								b.u32(0)
+								b.u16(1) // frame count
+								b.Write(make([]byte, 32))
+								b.u16(0x0018) // depth
+								b.u16(0xffff)
+								b.box("avcC", func(b *mp4) { b.Write(avcConfig(sps, pps)) })
+							})
+						})
+						for _, name := range []string{"stts", "stsc", "stco"} {
+							b.full(name, 0, 0, func(b *mp4) { b.u32(0) })
+						}
+						b.full("stsz", 0, 0, func(b *mp4) { b.u32(0); b.u32(0) })
+					})
+				})
+			})
+		})
+		b.box("mvex", func(b *mp4) {
+			b.full("trex", 0, 0, func(b *mp4) {
+				b.u32(1) // track ID
+				b.u32(1) // sample description index
+				b.u32(0)
+				b.u32(0)
+				b.u32(0)
+			})
+		})
+	})
+	return b.Bytes()
+}
+
+// cmafSegmentData builds a media segment, styp, moof and mdat, for frames;
+// next is when the frame after the last one starts.
+func cmafSegmentData(seq int, frames []cmafSample, next time.Duration) []byte {
+	ticks := func(d time.Duration) uint64 { return uint64(d * cmafTimescale / time.Second) }
+
+	moof := func(dataOffset uint32) []byte {
+		var b mp4
+		b.box("moof", func(b *mp4) {
+			b.full("mfhd", 0, 0, func(b *mp4) { b.u32(uint32(seq)) })
+			b.box("traf", func(b *mp4) {
+				b.full("tfhd", 0, 0x020000, func(b *mp4) { b.u32(1) }) // default-base-is-moof
+				b.full("tfdt", 1, 0, func(b *mp4) { b.u64(ticks(frames[0].pts)) })
+				// data offset, sample duration, size and flags present
+				b.full("trun", 0, 0x000701, func(b *mp4) {
+					b.u32(uint32(len(frames)))
+					b.u32(dataOffset)
+					for i, f := range frames {
+						end := next
+						if i+1 < len(frames) {
+							end = frames[i+1].pts
+						}
+						b.u32(uint32(ticks(end) - ticks(f.pts)))
+						b.u32(uint32(len(f.data)))
+						if f.key {
+							b.u32(0x02000000) // depends on no other sample
+						} else {
+							b.u32(0x01010000) // depends on others, not a sync sample
+						}
+					}
+				})
+			})
+		})
+		return b.Bytes()
+	}
+
+	var b mp4
+	b.box("styp", func(b *mp4) {
+		b.WriteString("msdh")
+		b.u32(0)
+		b.WriteString("msdhmsixcmfs")
+	})
+	head := moof(0)
+	b.Write(moof(uint32(len(head) + 8)))
+	b.box("mdat", func(b *mp4) {
+		for _, f := range frames {
+			b.Write(f.data)
+		}
+	})
+	return b.Bytes()
+}
+
+// mp4 builds ISO BMFF boxes in memory.
+type mp4 struct{ bytes.Buffer }
+
+func (b *mp4) u16(v uint16) { b.Write(binary.BigEndian.AppendUint16(nil, v)) }
+func (b *mp4) u32(v uint32) { b.Write(binary.BigEndian.AppendUint32(nil, v)) }
+func (b *mp4) u64(v uint64) { b.Write(binary.BigEndian.AppendUint64(nil, v)) }
+
+// matrix writes the identity transformation matrix of mvhd and tkhd.
+func (b *mp4) matrix() {
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		b.u32(v)
+	}
+}
+
+func (b *mp4) box(typ string, fill func(*mp4)) {
+	var child mp4
+	fill(&child)
+	b.u32(uint32(8 + child.Len()))
+	b.WriteString(typ)
+	b.Write(child.Bytes())
+}
+
+// full writes a full box, with a version and flags.
+func (b *mp4) full(typ string, version byte, flags uint32, fill func(*mp4)) {
+	b.box(typ, func(b *mp4) {
+		b.u32(uint32(version)<<24 | flags)
+		fill(b)
+	})
+}
@@ -0,0 +1,202 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memSink is a ChunkSink in memory.
+type memSink struct {
+	files   map[string][]byte
+	removed []string
+	fail    error
+}
+
+func (m *memSink) Put(name string, data []byte) error {
+	if m.fail != nil {
+		return m.fail
+	}
+	if m.files == nil {
+		m.files = map[string][]byte{}
+	}
+	m.files[name] = bytes.Clone(data)
+	return nil
+}
+
+func (m *memSink) Remove(name string) error {
+	delete(m.files, name)
+	m.removed = append(m.removed, name)
+	return nil
+}
+
+// box is one parsed ISO BMFF box.
+type box struct {
+	typ    string
+	offset int // of the box's header within the parent's data
+	data   []byte
+}
+
+func boxes(t *testing.T, data []byte) []box {
+	t.Helper()
+	var out []box
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			t.Fatalf("truncated box header at %d", pos)
+		}
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		if size < 8 || pos+size > len(data) {
+			t.Fatalf("box %q has bad size %d", data[pos+4:pos+8], size)
+		}
+		out = append(out, box{string(data[pos+4 : pos+8]), pos, data[pos+8 : pos+size]})
+		pos += size
+	}
+	return out
+}
+
+// path follows box types down from data; full boxes' version and flags
+// and stsd's entry count are skipped.
+func path(t *testing.T, data []byte, types ...string) []byte {
+	t.Helper()
+	for _, typ := range types {
+		found := false
+		for _, b := range boxes(t, data) {
+			if b.typ == typ {
+				data, found = b.data, true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("no %q box", typ)
+		}
+		if typ == "stsd" {
+			data = data[8:]
+		}
+	}
+	return data
+}
+
+func TestCMAFWriter(t *testing.T) {
+	sps := spsFields{profile: 66, constraints: 0xe0, level: 31, pocType: 2, widthMBs: 20, heightUnits: 15}.encode()
+	pps := []byte{0x68, 0xce, 0x38, 0x80}
+	start := func(nal []byte) []byte { return append([]byte{0, 0, 0, 1}, nal...) }
+	key := append(append(start(sps), start(pps)...), start([]byte{0x65, 0x88, 0x84})...)
+	p := start([]byte{0x41, 0x9a, 0x02})
+	ms := time.Millisecond
+
+	sink := &memSink{}
+	c := NewCMAFWriter(sink, 2*time.Second, 2)
+	frames := []struct {
+		data []byte
+		pts  time.Duration
+	}{
+		{p, 0}, // before the first keyframe: dropped
+		{key, 100 * ms},
+		{p, 600 * ms},
+		{key, 1100 * ms}, // too early to cut a segment
+		{p, 1600 * ms},
+		{key, 2600 * ms}, // seg-00001: 2.5s
+		{p, 3100 * ms},
+		{key, 4700 * ms}, // seg-00002: 2.1s
+		{p, 5100 * ms},
+	}
+	for _, f := range frames {
+		if _, err := c.WriteFrame(f.data, f.pts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil { // seg-00003: 0.8s
+		t.Fatal(err)
+	}
+
+	if len(sink.removed) != 1 || sink.removed[0] != "seg-00001.m4s" {
+		t.Errorf("removed %v, want seg-00001.m4s out of the window", sink.removed)
+	}
+	wantPlaylist := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:3\n#EXT-X-MEDIA-SEQUENCE:2\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:2.100,\nseg-00002.m4s\n#EXTINF:0.800,\nseg-00003.m4s\n#EXT-X-ENDLIST\n"
+	if got := string(sink.files[CMAFPlaylist]); got != wantPlaylist {
+		t.Errorf("playlist:\n%s\nwant:\n%s", got, wantPlaylist)
+	}
+
+	initSeg := sink.files[CMAFInit]
+	if top := boxes(t, initSeg); len(top) != 2 || top[0].typ != "ftyp" || top[1].typ != "moov" {
+		t.Fatalf("init segment boxes = %v", top)
+	}
+	avc1 := path(t, initSeg, "moov", "trak", "mdia", "minf", "stbl", "stsd", "avc1")
+	if w, h := binary.BigEndian.Uint16(avc1[24:]), binary.BigEndian.Uint16(avc1[26:]); w != 320 || h != 240 {
+		t.Errorf("avc1 size = %dx%d, want 320x240", w, h)
+	}
+	if avcC := path(t, avc1[78:], "avcC"); !bytes.Equal(avcC, avcConfig(sps, pps)) {
+		t.Errorf("avcC = % x", avcC)
+	}
+
+	// seg-00002 holds the keyframe at 2.6s and the P frame at 3.1s, which
+	// lasts until the next keyframe at 4.7s.
+	seg := sink.files["seg-00002.m4s"]
+	top := boxes(t, seg)
+	if len(top) != 3 || top[0].typ != "styp" || top[1].typ != "moof" || top[2].typ != "mdat" {
+		t.Fatalf("segment boxes = %v", top)
+	}
+	moof := top[1]
+	if n := binary.BigEndian.Uint32(path(t, moof.data, "mfhd")[4:]); n != 2 {
+		t.Errorf("mfhd sequence = %d, want 2", n)
+	}
+	traf := path(t, moof.data, "traf")
+	if dt := binary.BigEndian.Uint64(path(t, traf, "tfdt")[4:]); dt != 2600*cmafTimescale/1000 {
+		t.Errorf("tfdt = %d", dt)
+	}
+	trun := path(t, traf, "trun")
+	if n := binary.BigEndian.Uint32(trun[4:]); n != 2 {
+		t.Fatalf("trun sample count = %d, want 2", n)
+	}
+	offset := int(binary.BigEndian.Uint32(trun[8:]))
+	if want := top[2].offset + 8 - moof.offset; offset != want {
+		t.Errorf("trun data offset = %d, want %d (mdat payload relative to moof)", offset, want)
+	}
+	wantSamples := []struct {
+		duration, size, flags uint32
+	}{
+		{500 * cmafTimescale / 1000, uint32(len(annexBToAVCC(key))), 0x02000000},
+		{1600 * cmafTimescale / 1000, uint32(len(annexBToAVCC(p))), 0x01010000},
+	}
+	for i, want := range wantSamples {
+		s := trun[12+12*i:]
+		got := struct{ duration, size, flags uint32 }{
+			binary.BigEndian.Uint32(s), binary.BigEndian.Uint32(s[4:]), binary.BigEndian.Uint32(s[8:]),
+		}
+		if got != want {
+			t.Errorf("sample %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if mdat := seg[moof.offset+offset:]; !bytes.Equal(mdat, append(annexBToAVCC(key), annexBToAVCC(p)...)) {
+		t.Errorf("mdat = % x", mdat)
+	}
+}
+
+func TestCMAFWriterErrors(t *testing.T) {
+	start := []byte{0, 0, 0, 1}
+	if _, err := NewCMAFWriter(&memSink{}, 0, 0).WriteFrame(append(start, 0x65, 1), 0); err == nil {
+		t.Error("keyframe without SPS/PPS accepted")
+	}
+
+	errFull := errors.New("disk full")
+	sink := &memSink{fail: errFull}
+	c := NewCMAFWriter(sink, 0, 0)
+	sps := spsFields{profile: 66, level: 31, widthMBs: 20, heightUnits: 15}.encode()
+	key := append(append(append(start, sps...), append(start, 0x68, 0xce)...), append(start, 0x65, 1)...)
+	if _, err := c.WriteFrame(key, 0); !errors.Is(err, errFull) {
+		t.Errorf("WriteFrame error = %v, want the sink's", err)
+	}
+	sink.fail = nil
+	if _, err := c.WriteFrame(key, time.Second); !errors.Is(err, errFull) {
+		t.Errorf("WriteFrame after a sink error = %v, want the same error", err)
+	}
+	if err := c.Close(); !errors.Is(err, errFull) {
+		t.Errorf("Close = %v, want the sink's error", err)
+	}
+	if _, err := c.Write(key); err == nil {
+		t.Error("Write without a capture time accepted")
+	}
+}
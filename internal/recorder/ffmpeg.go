@@ -0,0 +1,100 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/brice/gognestcli/internal/proc"
+)
+
+// ErrToolNotFound means an ffmpeg-suite binary isn't installed where it was
+// looked for.
+var ErrToolNotFound = errors.New("not found")
+
+// WithFFmpeg runs the ffmpeg binary at path instead of the one on PATH, and
+// inserts extraArgs before the input (e.g. "-hwaccel", "auto" or
+// "-loglevel", "warning").
+func WithFFmpeg(path string, extraArgs ...string) Option {
+	return func(o *options) {
+		o.ffmpegPath = path
+		o.ffmpegArgs = extraArgs
+	}
+}
+
+// FindTool resolves an ffmpeg-suite binary such as "ffmpeg" or "ffplay".
+// A configured path wins; otherwise PATH is searched, and on Windows a few
+// common install locations (winget, scoop, chocolatey, C:\ffmpeg) as well.
+func FindTool(name, configured string) (string, error) {
+	if configured != "" {
+		path, err := exec.LookPath(configured)
+		if err != nil {
+			return "", fmt.Errorf("%s %w at %s: %w", name, ErrToolNotFound, configured, err)
+		}
+		return path, nil
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	if runtime.GOOS == "windows" {
+		for _, path := range windowsToolPaths(name) {
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%s %w; %s", name, ErrToolNotFound, installHint(name))
+}
+
+func windowsToolPaths(name string) []string {
+	exe := name + ".exe"
+	var paths []string
+	if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "Microsoft", "WinGet", "Links", exe))
+	}
+	if dir := os.Getenv("USERPROFILE"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "scoop", "shims", exe))
+	}
+	if dir := os.Getenv("ProgramData"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "chocolatey", "bin", exe))
+	}
+	if dir := os.Getenv("ProgramFiles"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "ffmpeg", "bin", exe))
+	}
+	return append(paths, filepath.Join(`C:\ffmpeg`, "bin", exe))
+}
+
+func installHint(name string) string {
+	switch runtime.GOOS {
+	case "windows":
+		return fmt.Sprintf("install it with: winget install ffmpeg, or set %s_path in config", name)
+	case "linux":
+		return fmt.Sprintf("install ffmpeg with your package manager, or set %s_path in config", name)
+	default:
+		return fmt.Sprintf("install it with: brew install ffmpeg, or set %s_path in config", name)
+	}
+}
+
+// convertTimeout bounds a single ffmpeg conversion of a finished capture.
+const convertTimeout = 5 * time.Minute
+
+// ffmpeg builds an ffmpeg command with the configured binary. Extra args go
+// right before the first -i so they can override defaults like -loglevel and
+// act as input options.
+func (o options) ffmpeg(args ...string) (*proc.Process, error) {
+	path, err := FindTool("ffmpeg", o.ffmpegPath)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.Index(args, "-i")
+	if i < 0 {
+		i = 0
+	}
+	full := slices.Concat(args[:i], o.ffmpegArgs, args[i:])
+	return proc.Command(o.context(), path, full...), nil
+}
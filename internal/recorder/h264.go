@@ -0,0 +1,256 @@
+package recorder
+
+import "fmt"
+
+// H264 NAL unit types used by the recorder.
+const (
+	nalIDR = 5
+	nalSPS = 7
+	nalPPS = 8
+)
+
+// nalUnits splits an Annex B buffer into NAL unit payloads (without start codes).
+func nalUnits(data []byte) [][]byte {
+	var units [][]byte
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			end := i
+			if end > start && data[end-1] == 0 {
+				end-- // 4-byte start code
+			}
+			units = append(units, data[start:end])
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(data) {
+		units = append(units, data[start:])
+	}
+	return units
+}
+
+// isKeyframe reports whether an Annex B access unit contains an IDR slice.
+func isKeyframe(data []byte) bool {
+	for _, nal := range nalUnits(data) {
+		if len(nal) > 0 && nal[0]&0x1f == nalIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// hasParameterSets reports whether an Annex B access unit carries an SPS or
+// PPS, which a decoder needs before the first IDR.
+func hasParameterSets(data []byte) bool {
+	for _, nal := range nalUnits(data) {
+		if len(nal) > 0 && (nal[0]&0x1f == nalSPS || nal[0]&0x1f == nalPPS) {
+			return true
+		}
+	}
+	return false
+}
+
+// bitReader reads the Exp-Golomb coded fields of an H264 parameter set.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+}
+
+func (r *bitReader) bit() uint {
+	if r.pos >= len(r.data)*8 {
+		r.pos++
+		return 0
+	}
+	b := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+	return uint(b)
+}
+
+func (r *bitReader) bits(n int) uint {
+	var v uint
+	for range n {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
+
+// ue reads an unsigned Exp-Golomb value.
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bit() == 0 && zeros < 32 {
+		zeros++
+	}
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+// se reads a signed Exp-Golomb value.
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v&1 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func (r *bitReader) overrun() bool { return r.pos > len(r.data)*8 }
+
+// unescapeRBSP removes the emulation prevention bytes from a NAL unit.
+func unescapeRBSP(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// StreamInfo describes the video a camera is sending, from its SPS.
+type StreamInfo struct {
+	Width   int
+	Height  int
+	Profile string // e.g. "High"
+	Level   string // e.g. "4.1"
+}
+
+// String formats the info like "1920x1080 High@4.1".
+func (i StreamInfo) String() string {
+	return fmt.Sprintf("%dx%d %s@%s", i.Width, i.Height, i.Profile, i.Level)
+}
+
+// profileNames maps profile_idc to the profile's name.
+var profileNames = map[uint]string{
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4",
+	44:  "CAVLC 4:4:4",
+}
+
+// streamInfo returns the info from the first SPS in an Annex B access
+// unit, if it has one.
+func streamInfo(data []byte) (StreamInfo, bool) {
+	for _, nal := range nalUnits(data) {
+		if len(nal) > 0 && nal[0]&0x1f == nalSPS {
+			info, err := parseSPS(nal)
+			return info, err == nil
+		}
+	}
+	return StreamInfo{}, false
+}
+
+// parseSPS reads the picture size, after cropping, and the profile and
+// level from an SPS NAL unit.
+func parseSPS(sps []byte) (StreamInfo, error) {
+	if len(sps) < 4 {
+		return StreamInfo{}, fmt.Errorf("SPS too short")
+	}
+	r := &bitReader{data: unescapeRBSP(sps[1:])}
+	profile := r.bits(8)
+	constraints := r.bits(8)
+	level := r.bits(8)
+	r.ue() // seq_parameter_set_id
+
+	info := StreamInfo{Profile: profileNames[profile], Level: fmt.Sprintf("%d.%d", level/10, level%10)}
+	if info.Profile == "" {
+		info.Profile = fmt.Sprintf("profile %d", profile)
+	}
+	if profile == 66 && constraints&0x40 != 0 {
+		info.Profile = "Constrained Baseline"
+	}
+
+	chromaFormat := uint(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			r.bit() // separate_colour_plane_flag
+		}
+		r.ue()  // bit_depth_luma_minus8
+		r.ue()  // bit_depth_chroma_minus8
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+
+		if r.bit() == 1 { // seq_scaling_matrix_present_flag
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := range lists {
+				if r.bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for range size {
+					if next != 0 {
+						next = (last + r.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+
+	switch r.ue() { // pic_order_cnt_type
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit() // delta_pic_order_always_zero_flag
+		r.se()  // offset_for_non_ref_pic
+		r.se()  // offset_for_top_to_bottom_field
+		for range r.ue() {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	widthMBs := int(r.ue()) + 1
+	heightUnits := int(r.ue()) + 1
+	frameMBsOnly := int(r.bit())
+	if frameMBsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+
+	width := widthMBs * 16
+	height := (2 - frameMBsOnly) * heightUnits * 16
+	if r.bit() == 1 { // frame_cropping_flag
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		cropX, cropY := 1, 2-frameMBsOnly
+		switch chromaFormat {
+		case 1:
+			cropX, cropY = 2, 2*(2-frameMBsOnly)
+		case 2:
+			cropX = 2
+		}
+		width -= cropX * (left + right)
+		height -= cropY * (top + bottom)
+	}
+	if r.overrun() || width <= 0 || height <= 0 {
+		return StreamInfo{}, fmt.Errorf("malformed SPS")
+	}
+	info.Width, info.Height = width, height
+	return info, nil
+}
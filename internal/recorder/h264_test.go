@@ -0,0 +1,236 @@
+package recorder
+
+import (
+	"bytes"
+	"testing"
+)
+
+// bitWriter writes an RBSP most significant bit first, for building test
+// parameter sets.
+type bitWriter struct {
+	buf []byte
+	cur byte
+	n   uint
+}
+
+func (w *bitWriter) bit(b uint) {
+	w.cur = w.cur<<1 | byte(b&1)
+	w.n++
+	if w.n == 8 {
+		w.buf = append(w.buf, w.cur)
+		w.cur, w.n = 0, 0
+	}
+}
+
+func (w *bitWriter) bits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> uint(i))
+	}
+}
+
+func (w *bitWriter) ue(v uint) {
+	v++
+	n := 0
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	w.bits(0, n)
+	w.bits(v, n+1)
+}
+
+func (w *bitWriter) se(v int) {
+	if v > 0 {
+		w.ue(uint(2*v - 1))
+	} else {
+		w.ue(uint(-2 * v))
+	}
+}
+
+// nal returns the RBSP after trailing bits as a NAL unit, with emulation
+// prevention bytes and no start code.
+func (w *bitWriter) nal(header byte) []byte {
+	w.bit(1)
+	for w.n != 0 {
+		w.bit(0)
+	}
+	out := []byte{header}
+	zeros := 0
+	for _, b := range w.buf {
+		if zeros == 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// spsFields are the SPS fields parseSPS looks at or has to skip.
+type spsFields struct {
+	profile, constraints, level uint
+	chroma                      uint // chroma_format_idc, for High profiles
+	scaling                     bool // send every scaling list
+	pocType                     uint
+	widthMBs, heightUnits       uint
+	interlaced                  bool
+	crop                        [4]uint // left, right, top, bottom
+}
+
+func (f spsFields) encode() []byte {
+	var w bitWriter
+	w.bits(f.profile, 8)
+	w.bits(f.constraints, 8)
+	w.bits(f.level, 8)
+	w.ue(0) // seq_parameter_set_id
+	if f.profile == 100 || f.profile == 244 {
+		w.ue(f.chroma)
+		if f.chroma == 3 {
+			w.bit(0)
+		}
+		w.ue(0)
+		w.ue(0)
+		w.bit(0)
+		if f.scaling {
+			w.bit(1)
+			lists := 8
+			if f.chroma == 3 {
+				lists = 12
+			}
+			for i := range lists {
+				w.bit(1)
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				for j := range size {
+					w.se(j%5 - 2)
+				}
+			}
+		} else {
+			w.bit(0)
+		}
+	}
+	w.ue(0) // log2_max_frame_num_minus4
+	w.ue(f.pocType)
+	switch f.pocType {
+	case 0:
+		w.ue(4)
+	case 1:
+		w.bit(0)
+		w.se(-1)
+		w.se(2)
+		w.ue(2)
+		w.se(3)
+		w.se(-3)
+	}
+	w.ue(4) // max_num_ref_frames
+	w.bit(0)
+	w.ue(f.widthMBs - 1)
+	w.ue(f.heightUnits - 1)
+	if f.interlaced {
+		w.bit(0)
+		w.bit(1)
+	} else {
+		w.bit(1)
+	}
+	w.bit(1) // direct_8x8_inference_flag
+	if f.crop != [4]uint{} {
+		w.bit(1)
+		for _, c := range f.crop {
+			w.ue(c)
+		}
+	} else {
+		w.bit(0)
+	}
+	w.bit(0) // vui_parameters_present_flag
+	return w.nal(0x67)
+}
+
+func TestParseSPS(t *testing.T) {
+	tests := []struct {
+		name string
+		sps  spsFields
+		want StreamInfo
+	}{
+		{"constrained baseline", spsFields{profile: 66, constraints: 0xe0, level: 31, pocType: 2, widthMBs: 20, heightUnits: 15},
+			StreamInfo{320, 240, "Constrained Baseline", "3.1"}},
+		{"main 1080p cropped", spsFields{profile: 77, level: 40, widthMBs: 120, heightUnits: 68, crop: [4]uint{0, 0, 0, 4}},
+			StreamInfo{1920, 1080, "Main", "4.0"}},
+		{"high with scaling lists", spsFields{profile: 100, level: 41, chroma: 1, scaling: true, pocType: 1, widthMBs: 80, heightUnits: 45},
+			StreamInfo{1280, 720, "High", "4.1"}},
+		{"interlaced", spsFields{profile: 100, level: 30, chroma: 1, widthMBs: 45, heightUnits: 18, interlaced: true, crop: [4]uint{0, 0, 0, 2}},
+			StreamInfo{720, 568, "High", "3.0"}},
+		{"4:4:4 cropped", spsFields{profile: 244, level: 51, chroma: 3, scaling: true, widthMBs: 4, heightUnits: 4, crop: [4]uint{1, 2, 3, 4}},
+			StreamInfo{61, 57, "High 4:4:4", "5.1"}},
+		{"unknown profile", spsFields{profile: 200, level: 10, widthMBs: 1, heightUnits: 1},
+			StreamInfo{16, 16, "profile 200", "1.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSPS(tt.sps.encode())
+			if err != nil {
+				t.Fatalf("parseSPS: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSPS = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSPSErrors(t *testing.T) {
+	full := spsFields{profile: 100, level: 41, chroma: 1, widthMBs: 80, heightUnits: 45}.encode()
+	tests := map[string][]byte{
+		"empty":     {},
+		"too short": {0x67, 100, 0},
+		"truncated": full[:6],
+		"cropped away": spsFields{profile: 66, level: 31, widthMBs: 1, heightUnits: 1,
+			crop: [4]uint{4, 4, 0, 0}}.encode(),
+	}
+	for name, sps := range tests {
+		if info, err := parseSPS(sps); err == nil {
+			t.Errorf("%s: parseSPS = %v, want an error", name, info)
+		}
+	}
+}
+
+func TestUnescapeRBSP(t *testing.T) {
+	tests := []struct{ in, want []byte }{
+		{[]byte{1, 2, 3}, []byte{1, 2, 3}},
+		{[]byte{0, 0, 3, 1}, []byte{0, 0, 1}},
+		{[]byte{0, 0, 3, 0, 0, 3}, []byte{0, 0, 0, 0}},
+		{[]byte{0, 3, 0}, []byte{0, 3, 0}},
+	}
+	for _, tt := range tests {
+		if got := unescapeRBSP(tt.in); !bytes.Equal(got, tt.want) {
+			t.Errorf("unescapeRBSP(% x) = % x, want % x", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNALUnits(t *testing.T) {
+	sps := []byte{0x67, 1, 2}
+	pps := []byte{0x68, 3}
+	idr := []byte{0x65, 4, 5, 6}
+	au := append(append(append([]byte{0, 0, 0, 1}, sps...), append([]byte{0, 0, 1}, pps...)...), append([]byte{0, 0, 0, 1}, idr...)...)
+
+	units := nalUnits(au)
+	if len(units) != 3 || !bytes.Equal(units[0], sps) || !bytes.Equal(units[1], pps) || !bytes.Equal(units[2], idr) {
+		t.Errorf("nalUnits = % x", units)
+	}
+	if !isKeyframe(au) || !hasParameterSets(au) {
+		t.Error("access unit with SPS, PPS and IDR not recognised")
+	}
+	p := []byte{0, 0, 0, 1, 0x41, 9}
+	if isKeyframe(p) || hasParameterSets(p) {
+		t.Error("P slice taken for a keyframe or parameter set")
+	}
+	if info, ok := streamInfo(append([]byte{0, 0, 0, 1}, spsFields{profile: 66, level: 31, widthMBs: 20, heightUnits: 15}.encode()...)); !ok || info.Width != 320 {
+		t.Errorf("streamInfo = %v, %v", info, ok)
+	}
+}
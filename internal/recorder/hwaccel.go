@@ -0,0 +1,42 @@
+package recorder
+
+import "fmt"
+
+// hwDecodeArgs are ffmpeg input options selecting a hardware H264 decoder,
+// keyed by the names accepted by WithHWAccel and PlayerHWAccelArgs.
+var hwDecodeArgs = map[string][]string{
+	"auto":         {"-hwaccel", "auto"},
+	"vaapi":        {"-hwaccel", "vaapi"},
+	"videotoolbox": {"-hwaccel", "videotoolbox"},
+	"nvenc":        {"-hwaccel", "cuda"},
+	"qsv":          {"-hwaccel", "qsv"},
+	"v4l2m2m":      {"-c:v", "h264_v4l2m2m"}, // Raspberry Pi
+}
+
+// WithHWAccel decodes with the named hardware accelerator (auto, vaapi,
+// videotoolbox, nvenc, qsv or v4l2m2m) wherever ffmpeg has to decode video,
+// e.g. JPEG snapshots. Empty disables it.
+func WithHWAccel(name string) Option {
+	return func(o *options) { o.hwaccel = name }
+}
+
+// PlayerHWAccelArgs returns ffplay options for hardware decoding with the
+// named accelerator. ffplay needs FFmpeg 7 or newer for -hwaccel.
+func PlayerHWAccelArgs(name string) ([]string, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if name == "v4l2m2m" {
+		return []string{"-vcodec", "h264_v4l2m2m"}, nil
+	}
+	args, ok := hwDecodeArgs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hwaccel %q", name)
+	}
+	return args, nil
+}
+
+// decodeArgs returns the hardware decode input options, if any.
+func (o options) decodeArgs() []string {
+	return hwDecodeArgs[o.hwaccel]
+}
@@ -0,0 +1,92 @@
+package recorder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type crop struct{ w, h, x, y int }
+
+// WithJPEGQuality sets snapshot JPEG quality from 1 (smallest) to 100 (best).
+// Zero keeps the default, which is close to 100.
+func WithJPEGQuality(quality int) Option {
+	return func(o *options) { o.quality = quality }
+}
+
+// WithScale scales snapshots to width x height. Either may be -1 to keep the
+// aspect ratio.
+func WithScale(width, height int) Option {
+	return func(o *options) { o.scaleW, o.scaleH = width, height }
+}
+
+// WithCrop crops snapshots to width x height at (x, y), before scaling.
+func WithCrop(width, height, x, y int) Option {
+	return func(o *options) { o.crop = &crop{width, height, x, y} }
+}
+
+// ParseSize parses "1280x720"; either side may be -1 (e.g. "640x-1").
+func ParseSize(s string) (width, height int, err error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid size %q (want WIDTHxHEIGHT)", s)
+	}
+	if width, err = strconv.Atoi(w); err != nil {
+		return 0, 0, fmt.Errorf("invalid size %q (want WIDTHxHEIGHT)", s)
+	}
+	if height, err = strconv.Atoi(h); err != nil {
+		return 0, 0, fmt.Errorf("invalid size %q (want WIDTHxHEIGHT)", s)
+	}
+	if width == 0 || height == 0 || width < -1 || height < -1 || (width == -1 && height == -1) {
+		return 0, 0, fmt.Errorf("invalid size %q", s)
+	}
+	return width, height, nil
+}
+
+// ParseCrop parses "WIDTHxHEIGHT+X+Y", e.g. "1920x1080+960+540". The offset
+// defaults to +0+0.
+func ParseCrop(s string) (width, height, x, y int, err error) {
+	size, offset, _ := strings.Cut(s, "+")
+	if width, height, err = ParseSize(size); err != nil {
+		return 0, 0, 0, 0, err
+	}
+	if width < 0 || height < 0 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid crop %q: size must be positive", s)
+	}
+	if offset != "" {
+		xs, ys, ok := strings.Cut(offset, "+")
+		if !ok {
+			return 0, 0, 0, 0, fmt.Errorf("invalid crop %q (want WIDTHxHEIGHT+X+Y)", s)
+		}
+		if x, err = strconv.Atoi(xs); err != nil || x < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("invalid crop %q (want WIDTHxHEIGHT+X+Y)", s)
+		}
+		if y, err = strconv.Atoi(ys); err != nil || y < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("invalid crop %q (want WIDTHxHEIGHT+X+Y)", s)
+		}
+	}
+	return width, height, x, y, nil
+}
+
+// imageArgs returns the ffmpeg output options for a JPEG snapshot.
+func (o options) imageArgs() []string {
+	var filters []string
+	if o.crop != nil {
+		filters = append(filters, fmt.Sprintf("crop=%d:%d:%d:%d", o.crop.w, o.crop.h, o.crop.x, o.crop.y))
+	}
+	if o.scaleW != 0 || o.scaleH != 0 {
+		filters = append(filters, fmt.Sprintf("scale=%d:%d", o.scaleW, o.scaleH))
+	}
+	filters = append(filters, o.overlayFilters(o.start)...)
+
+	var args []string
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	// ffmpeg's MJPEG qscale runs from 2 (best) to 31 (worst).
+	q := 2
+	if o.quality > 0 {
+		q = 2 + (100-min(o.quality, 100))*29/99
+	}
+	return append(args, "-q:v", strconv.Itoa(q))
+}
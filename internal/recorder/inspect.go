@@ -0,0 +1,79 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/proc"
+)
+
+// MediaInfo describes a finished capture.
+type MediaInfo struct {
+	Width    int
+	Height   int
+	Duration time.Duration // zero for images
+}
+
+// Inspect reads a capture's resolution and duration. JPEGs are read directly;
+// clips need ffprobe (looked up next to ffmpegPath first) and return an empty
+// MediaInfo without error when it isn't installed.
+func Inspect(path, ffmpegPath string) (MediaInfo, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		f, err := os.Open(path)
+		if err != nil {
+			return MediaInfo{}, err
+		}
+		defer f.Close()
+		cfg, err := jpeg.DecodeConfig(f)
+		if err != nil {
+			return MediaInfo{}, err
+		}
+		return MediaInfo{Width: cfg.Width, Height: cfg.Height}, nil
+	}
+
+	ffprobe, err := findFFprobe(ffmpegPath)
+	if err != nil {
+		return MediaInfo{}, nil
+	}
+	cmd := proc.Command(context.Background(), ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		path,
+	)
+	cmd.Timeout = time.Minute
+	out, err := cmd.Output()
+	if err != nil {
+		return MediaInfo{}, err
+	}
+
+	var result struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return MediaInfo{}, fmt.Errorf("parsing ffprobe output: %w", err)
+	}
+	var info MediaInfo
+	if len(result.Streams) > 0 {
+		info.Width, info.Height = result.Streams[0].Width, result.Streams[0].Height
+	}
+	if d, err := strconv.ParseFloat(result.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(d * float64(time.Second))
+	}
+	return info, nil
+}
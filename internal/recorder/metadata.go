@@ -0,0 +1,63 @@
+package recorder
+
+import (
+	"strings"
+	"time"
+)
+
+// Metadata describes a clip for the container's title, comment and
+// creation_time tags.
+type Metadata struct {
+	Device    string // human-readable device name
+	Room      string
+	EventType string
+	Created   time.Time // defaults to when video started arriving
+}
+
+// WithMetadata tags clips with m so players and asset managers show where
+// and when they were recorded.
+func WithMetadata(m Metadata) Option {
+	return func(o *options) { o.metadata = &m }
+}
+
+// tags returns the clip's title, comment and creation time, or zero values
+// without WithMetadata.
+func (o options) tags() (title, comment string, created time.Time) {
+	if o.metadata == nil {
+		return "", "", time.Time{}
+	}
+	m := *o.metadata
+	if m.Created.IsZero() {
+		m.Created = o.start
+	}
+
+	var titles, comments []string
+	if m.Device != "" {
+		titles = append(titles, m.Device)
+		comments = append(comments, "device="+m.Device)
+	}
+	if m.Room != "" {
+		comments = append(comments, "room="+m.Room)
+	}
+	if m.EventType != "" {
+		titles = append(titles, m.EventType)
+		comments = append(comments, "event="+m.EventType)
+	}
+	return strings.Join(titles, " - "), strings.Join(comments, " "), m.Created
+}
+
+// metadataArgs returns ffmpeg -metadata output options for the clip.
+func (o options) metadataArgs() []string {
+	title, comment, created := o.tags()
+	var args []string
+	if title != "" {
+		args = append(args, "-metadata", "title="+title)
+	}
+	if comment != "" {
+		args = append(args, "-metadata", "comment="+comment)
+	}
+	if !created.IsZero() {
+		args = append(args, "-metadata", "creation_time="+created.UTC().Format("2006-01-02T15:04:05.000000Z"))
+	}
+	return args
+}
@@ -0,0 +1,506 @@
+package recorder
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/brice/gognestcli/internal/proc"
+)
+
+// frameSpan locates one access unit in a raw H264 temp file, in file order,
+// with when it was captured relative to the first.
+type frameSpan struct {
+	size int
+	pts  time.Duration
+}
+
+// mkvFrame is one video access unit (Annex B) or Opus packet to mux.
+type mkvFrame struct {
+	data  []byte
+	pts   time.Duration
+	audio bool
+}
+
+// nativeMKV reports whether MKV clips are muxed without ffmpeg, which is
+// whenever the video is copied as is: the overlay needs it re-encoded.
+func (o options) nativeMKV() bool {
+	return !o.overlay
+}
+
+// needsFFmpeg reports whether capturing to a file with extension ext needs
+// ffmpeg.
+func (o options) needsFFmpeg(ext string) bool {
+	return ext != ".mkv" || !o.nativeMKV()
+}
+
+// h264ToMKV muxes the raw H264 at h264Path, plus the Ogg/Opus audio at
+// audioPath unless it's "", into a Matroska file. The video is copied with
+// the capture timestamps in spans, without ffmpeg, unless the overlay needs
+// it re-encoded.
+func h264ToMKV(o options, h264Path string, spans []frameSpan, audioPath, mkvPath string) error {
+	if !o.nativeMKV() {
+		return ffmpegMux(o, h264Path, spans, audioPath, mkvPath, false, false)
+	}
+	frames, opusHead, err := clipFrames(h264Path, spans, audioPath)
+	if err != nil {
+		return err
+	}
+	return writeMKV(mkvPath, frames, opusHead, o)
+}
+
+// clipFrames reads the raw H264 at h264Path, split into access units by
+// spans, and the Ogg/Opus audio at audioPath unless it's "".
+func clipFrames(h264Path string, spans []frameSpan, audioPath string) (frames []mkvFrame, opusHead []byte, err error) {
+	data, err := os.ReadFile(h264Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range spans {
+		if s.size > len(data) {
+			break
+		}
+		frames = append(frames, mkvFrame{data: data[:s.size], pts: s.pts})
+		data = data[s.size:]
+	}
+
+	if audioPath != "" {
+		var audio []mkvFrame
+		if opusHead, audio, err = readOggOpus(audioPath); err != nil {
+			return nil, nil, fmt.Errorf("reading audio: %w", err)
+		}
+		frames = append(frames, audio...)
+	}
+	return frames, opusHead, nil
+}
+
+// maxTimedInput is the largest raw H264 capture timedInput remuxes in
+// memory; longer recordings get an average frame rate instead.
+const maxTimedInput = 256 << 20
+
+// timedInput returns the ffmpeg input arguments for the captured clip at
+// h264Path. Raw H264 carries no timestamps, so ffmpeg would assume 25 fps:
+// a clip that lost frames on the way would come out short and drift from
+// its audio. Instead the video and audio are muxed natively into a temp MKV
+// timed by their capture timestamps, so a gap becomes a longer frame. inline
+// reports that the audio is the MKV's second stream rather than a separate
+// input. epoch is the capture time of the input's timestamp zero, for the
+// overlay clock. Call cleanup once ffmpeg is done.
+func timedInput(o options, h264Path string, spans []frameSpan, audioPath string) (args []string, inline bool, epoch time.Time, cleanup func()) {
+	args, epoch, cleanup = []string{"-f", "h264", "-i", h264Path}, o.start, func() {}
+	if len(spans) == 0 {
+		return args, false, epoch, cleanup
+	}
+	// Without the MKV, spread the frames evenly over the capture, which at
+	// least keeps its length right.
+	evenly := func() ([]string, bool, time.Time, func()) {
+		if d := spans[len(spans)-1].pts - spans[0].pts; d > 0 {
+			fps := float64(len(spans)-1) / d.Seconds()
+			args = append([]string{"-framerate", strconv.FormatFloat(fps, 'f', 3, 64)}, args...)
+		}
+		return args, false, o.start.Add(spans[0].pts), cleanup
+	}
+	var size int64
+	for _, s := range spans {
+		size += int64(s.size)
+	}
+	if size > maxTimedInput {
+		return evenly()
+	}
+
+	frames, opusHead, err := clipFrames(h264Path, spans, audioPath)
+	if err != nil {
+		return evenly()
+	}
+	tmp := h264Path + ".mkv"
+	proc.AddTemp(tmp)
+	if err := writeMKV(tmp, frames, opusHead, o); err != nil {
+		proc.RemoveTemp(tmp)
+		return evenly()
+	}
+	// writeMKV starts the timestamps at the first keyframe.
+	if i := slices.IndexFunc(frames, func(f mkvFrame) bool { return !f.audio && isKeyframe(f.data) }); i >= 0 {
+		epoch = o.start.Add(frames[i].pts)
+	}
+	return []string{"-f", "matroska", "-i", tmp}, audioPath != "", epoch, func() { proc.RemoveTemp(tmp) }
+}
+
+// ffmpegMux muxes a captured clip into dst with ffmpeg, re-encoding the
+// video for WebM or the overlay, reading it through timedInput.
+func ffmpegMux(o options, h264Path string, spans []frameSpan, audioPath, dst string, webm, mp4 bool) error {
+	input, inline, epoch, cleanup := timedInput(o, h264Path, spans, audioPath)
+	defer cleanup()
+	in, out := o.videoArgs(webm, epoch)
+	audioIn, audioOut := o.audioArgs(audioPath, inline, mp4)
+	args := append([]string{"-y"}, in...)
+	args = append(append(append(args, input...), audioIn...), out...)
+	args = append(args, audioOut...)
+	cmd, err := o.ffmpeg(append(args, dst)...)
+	if err != nil {
+		return err
+	}
+	cmd.Timeout = convertTimeout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg conversion failed: %w", err)
+	}
+	return nil
+}
+
+// writeMKV writes frames to path as Matroska: an H264 video track and, with
+// opusHead, an Opus audio track. Video before the first keyframe is
+// dropped; timestamps start at the first keyframe.
+func writeMKV(path string, frames []mkvFrame, opusHead []byte, o options) error {
+	first := slices.IndexFunc(frames, func(f mkvFrame) bool { return !f.audio && isKeyframe(f.data) })
+	if first < 0 {
+		return fmt.Errorf("no keyframe in captured video")
+	}
+	origin := frames[first].pts
+
+	var sps, pps []byte
+	var blocks []mkvFrame
+	for i, f := range frames {
+		if !f.audio && i < first {
+			continue
+		}
+		f.pts -= origin
+		if f.pts < 0 {
+			continue
+		}
+		if !f.audio {
+			for _, nal := range nalUnits(f.data) {
+				switch {
+				case len(nal) == 0:
+				case nal[0]&0x1f == nalSPS && sps == nil:
+					sps = nal
+				case nal[0]&0x1f == nalPPS && pps == nil:
+					pps = nal
+				}
+			}
+		}
+		blocks = append(blocks, f)
+	}
+	if sps == nil || pps == nil {
+		return fmt.Errorf("no SPS/PPS in captured video")
+	}
+	info, err := parseSPS(sps)
+	if err != nil {
+		return err
+	}
+	slices.SortStableFunc(blocks, func(a, b mkvFrame) int { return cmp.Compare(a.pts, b.pts) })
+
+	var duration time.Duration
+	for _, b := range blocks {
+		duration = max(duration, b.pts)
+	}
+
+	// Segment children before the clusters.
+	var head ebml
+	head.master(mkvInfo, func(e *ebml) {
+		e.uint(mkvTimestampScale, uint64(time.Millisecond))
+		e.str(mkvMuxingApp, "gognestcli")
+		e.str(mkvWritingApp, "gognestcli")
+		e.float(mkvDuration, float64(duration.Milliseconds()))
+		title, _, created := o.tags()
+		if !created.IsZero() {
+			e.bin(mkvDateUTC, binary.BigEndian.AppendUint64(nil, uint64(created.Sub(mkvEpoch).Nanoseconds())))
+		}
+		if title != "" {
+			e.str(mkvTitle, title)
+		}
+	})
+	head.master(mkvTracks, func(e *ebml) {
+		e.master(mkvTrackEntry, func(e *ebml) {
+			e.uint(mkvTrackNumber, 1)
+			e.uint(mkvTrackUID, 1)
+			e.uint(mkvTrackType, 1)
+			e.uint(mkvFlagLacing, 0)
+			e.str(mkvCodecID, "V_MPEG4/ISO/AVC")
+			e.bin(mkvCodecPrivate, avcConfig(sps, pps))
+			e.master(mkvVideo, func(e *ebml) {
+				e.uint(mkvPixelWidth, uint64(info.Width))
+				e.uint(mkvPixelHeight, uint64(info.Height))
+			})
+		})
+		if opusHead != nil {
+			e.master(mkvTrackEntry, func(e *ebml) {
+				e.uint(mkvTrackNumber, 2)
+				e.uint(mkvTrackUID, 2)
+				e.uint(mkvTrackType, 2)
+				e.uint(mkvFlagLacing, 0)
+				e.str(mkvCodecID, "A_OPUS")
+				e.bin(mkvCodecPrivate, opusHead)
+				preSkip := time.Duration(binary.LittleEndian.Uint16(opusHead[10:])) * time.Second / 48000
+				e.uint(mkvCodecDelay, uint64(preSkip))
+				e.uint(mkvSeekPreRoll, uint64(80*time.Millisecond))
+				e.master(mkvAudio, func(e *ebml) {
+					e.float(mkvSamplingFrequency, 48000)
+					e.uint(mkvChannels, uint64(opusHead[9]))
+				})
+			})
+		}
+	})
+	if _, comment, _ := o.tags(); comment != "" {
+		head.master(mkvTags, func(e *ebml) {
+			e.master(mkvTag, func(e *ebml) {
+				e.master(mkvTargets, func(*ebml) {})
+				e.master(mkvSimpleTag, func(e *ebml) {
+					e.str(mkvTagName, "COMMENT")
+					e.str(mkvTagString, comment)
+				})
+			})
+		})
+	}
+
+	// Clusters start at each keyframe, so players can seek to them, or
+	// when a block's offset would no longer fit in 16 bits.
+	var clusters, cues ebml
+	var cluster ebml
+	var clusterStart time.Duration
+	open := false
+	flush := func() {
+		if open {
+			clusters.master(mkvCluster, func(e *ebml) { e.Write(cluster.Bytes()) })
+			cluster.Reset()
+		}
+	}
+	for _, b := range blocks {
+		key := b.audio || isKeyframe(b.data)
+		if !open || (!b.audio && key) || b.pts-clusterStart > 30*time.Second {
+			flush()
+			open = true
+			clusterStart = b.pts
+			cluster.uint(mkvClusterTimestamp, uint64(b.pts.Milliseconds()))
+			if !b.audio {
+				pos := uint64(head.Len() + clusters.Len())
+				cues.master(mkvCuePoint, func(e *ebml) {
+					e.uint(mkvCueTime, uint64(b.pts.Milliseconds()))
+					e.master(mkvCueTrackPositions, func(e *ebml) {
+						e.uint(mkvCueTrack, 1)
+						e.uint(mkvCueClusterPosition, pos)
+					})
+				})
+			}
+		}
+		track, payload := byte(0x81), b.data
+		if b.audio {
+			track = 0x82
+		} else {
+			payload = annexBToAVCC(b.data)
+		}
+		block := []byte{track, 0, 0, 0}
+		binary.BigEndian.PutUint16(block[1:], uint16(int16((b.pts - clusterStart).Milliseconds())))
+		if key {
+			block[3] = 0x80
+		}
+		cluster.bin(mkvSimpleBlock, append(block, payload...))
+	}
+	flush()
+
+	var out ebml
+	out.master(mkvEBML, func(e *ebml) {
+		e.uint(mkvEBMLVersion, 1)
+		e.uint(mkvEBMLReadVersion, 1)
+		e.uint(mkvEBMLMaxIDLength, 4)
+		e.uint(mkvEBMLMaxSizeLength, 8)
+		e.str(mkvDocType, "matroska")
+		e.uint(mkvDocTypeVersion, 4)
+		e.uint(mkvDocTypeReadVersion, 2)
+	})
+	out.master(mkvSegment, func(e *ebml) {
+		e.Write(head.Bytes())
+		e.Write(clusters.Bytes())
+		e.master(mkvCues, func(e *ebml) { e.Write(cues.Bytes()) })
+	})
+	return os.WriteFile(path, out.Bytes(), 0644)
+}
+
+// avcConfig builds the AVCDecoderConfigurationRecord for an SPS and PPS,
+// with 4-byte NAL unit lengths.
+func avcConfig(sps, pps []byte) []byte {
+	b := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(sps)))
+	b = append(append(b, sps...), 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(pps)))
+	return append(b, pps...)
+}
+
+// annexBToAVCC replaces the start codes of an access unit with 4-byte
+// lengths.
+func annexBToAVCC(data []byte) []byte {
+	var out []byte
+	for _, nal := range nalUnits(data) {
+		if len(nal) == 0 {
+			continue
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(nal)))
+		out = append(out, nal...)
+	}
+	return out
+}
+
+// readOggOpus reads the Opus packets of an Ogg file written by OpusWriter,
+// timed by their pages' granule positions, and its OpusHead header.
+func readOggOpus(path string) (head []byte, packets []mkvFrame, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var packet []byte
+	var base uint64
+	n := 0
+	// A damaged last page ends the audio early rather than failing the clip:
+	// oggwriter rewrites it on Close assuming a single lacing value.
+pages:
+	for len(data) >= 27 && string(data[:4]) == "OggS" {
+		granule := binary.LittleEndian.Uint64(data[6:])
+		segments := int(data[26])
+		if len(data) < 27+segments {
+			break
+		}
+		lacing := data[27 : 27+segments]
+		body := data[27+segments:]
+		for _, l := range lacing {
+			if int(l) > len(body) {
+				break pages
+			}
+			packet = append(packet, body[:l]...)
+			body = body[l:]
+			if l == 255 {
+				continue
+			}
+			switch n {
+			case 0:
+				if len(packet) < 19 || string(packet[:8]) != "OpusHead" {
+					return nil, nil, fmt.Errorf("%s is not Ogg/Opus", path)
+				}
+				head = packet
+			case 1: // OpusTags
+			case 2:
+				base = granule
+				fallthrough
+			default:
+				pts := time.Duration(granule-base) * time.Second / 48000
+				packets = append(packets, mkvFrame{data: packet, pts: pts, audio: true})
+			}
+			packet = nil
+			n++
+		}
+		data = body
+	}
+	if head == nil {
+		return nil, nil, fmt.Errorf("%s is not Ogg/Opus", path)
+	}
+	return head, packets, nil
+}
+
+// mkvEpoch is the origin of Matroska's DateUTC.
+var mkvEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Matroska element IDs.
+const (
+	mkvEBML               = 0x1A45DFA3
+	mkvEBMLVersion        = 0x4286
+	mkvEBMLReadVersion    = 0x42F7
+	mkvEBMLMaxIDLength    = 0x42F2
+	mkvEBMLMaxSizeLength  = 0x42F3
+	mkvDocType            = 0x4282
+	mkvDocTypeVersion     = 0x4287
+	mkvDocTypeReadVersion = 0x4285
+	mkvSegment            = 0x18538067
+	mkvInfo               = 0x1549A966
+	mkvTimestampScale     = 0x2AD7B1
+	mkvMuxingApp          = 0x4D80
+	mkvWritingApp         = 0x5741
+	mkvDuration           = 0x4489
+	mkvDateUTC            = 0x4461
+	mkvTitle              = 0x7BA9
+	mkvTracks             = 0x1654AE6B
+	mkvTrackEntry         = 0xAE
+	mkvTrackNumber        = 0xD7
+	mkvTrackUID           = 0x73C5
+	mkvTrackType          = 0x83
+	mkvFlagLacing         = 0x9C
+	mkvCodecID            = 0x86
+	mkvCodecPrivate       = 0x63A2
+	mkvCodecDelay         = 0x56AA
+	mkvSeekPreRoll        = 0x56BB
+	mkvVideo              = 0xE0
+	mkvPixelWidth         = 0xB0
+	mkvPixelHeight        = 0xBA
+	mkvAudio              = 0xE1
+	mkvSamplingFrequency  = 0xB5
+	mkvChannels           = 0x9F
+	mkvTags               = 0x1254C367
+	mkvTag                = 0x7373
+	mkvTargets            = 0x63C0
+	mkvSimpleTag          = 0x67C8
+	mkvTagName            = 0x45A3
+	mkvTagString          = 0x4487
+	mkvCluster            = 0x1F43B675
+	mkvClusterTimestamp   = 0xE7
+	mkvSimpleBlock        = 0xA3
+	mkvCues               = 0x1C53BB6B
+	mkvCuePoint           = 0xBB
+	mkvCueTime            = 0xB3
+	mkvCueTrackPositions  = 0xB7
+	mkvCueTrack           = 0xF7
+	mkvCueClusterPosition = 0xF1
+)
+
+// ebml builds EBML elements in memory; clips are small enough that sizes
+// are known before anything is written.
+type ebml struct{ bytes.Buffer }
+
+func (e *ebml) id(id uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], id)
+	n := 4
+	for n > 1 && id>>(8*(n-1)) == 0 {
+		n--
+	}
+	e.Write(b[4-n:])
+}
+
+// size writes n as a variable-length integer.
+func (e *ebml) size(n uint64) {
+	l := 1
+	for l < 8 && n >= 1<<(7*l)-1 {
+		l++
+	}
+	v := n | 1<<(7*l)
+	for i := l - 1; i >= 0; i-- {
+		e.WriteByte(byte(v >> (8 * i)))
+	}
+}
+
+func (e *ebml) bin(id uint32, b []byte) {
+	e.id(id)
+	e.size(uint64(len(b)))
+	e.Write(b)
+}
+
+func (e *ebml) str(id uint32, s string) { e.bin(id, []byte(s)) }
+
+func (e *ebml) uint(id uint32, v uint64) {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	e.bin(id, b)
+}
+
+func (e *ebml) float(id uint32, v float64) {
+	e.bin(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func (e *ebml) master(id uint32, fill func(*ebml)) {
+	var child ebml
+	fill(&child)
+	e.bin(id, child.Bytes())
+}
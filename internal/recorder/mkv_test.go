@@ -0,0 +1,262 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEBMLSize(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want []byte
+	}{
+		{0, []byte{0x80}},
+		{126, []byte{0xfe}},
+		{127, []byte{0x40, 0x7f}}, // all ones is reserved for unknown sizes
+		{16382, []byte{0x7f, 0xfe}},
+		{16383, []byte{0x20, 0x3f, 0xff}},
+		{1 << 28, []byte{0x08, 0x10, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		var e ebml
+		e.size(tt.n)
+		if !bytes.Equal(e.Bytes(), tt.want) {
+			t.Errorf("size(%d) = % x, want % x", tt.n, e.Bytes(), tt.want)
+		}
+	}
+}
+
+func TestEBMLElements(t *testing.T) {
+	tests := []struct {
+		name string
+		fill func(*ebml)
+		want []byte
+	}{
+		{"one-byte id", func(e *ebml) { e.bin(mkvSimpleBlock, []byte{1}) }, []byte{0xa3, 0x81, 1}},
+		{"four-byte id", func(e *ebml) { e.bin(mkvEBML, nil) }, []byte{0x1a, 0x45, 0xdf, 0xa3, 0x80}},
+		{"uint trims zeros", func(e *ebml) { e.uint(mkvTrackNumber, 0x0102) }, []byte{0xd7, 0x82, 1, 2}},
+		{"uint zero", func(e *ebml) { e.uint(mkvTrackNumber, 0) }, []byte{0xd7, 0x81, 0}},
+		{"string", func(e *ebml) { e.str(mkvCodecID, "A_OPUS") }, append([]byte{0x86, 0x86}, "A_OPUS"...)},
+		{"float", func(e *ebml) { e.float(mkvDuration, 1.5) },
+			binary.BigEndian.AppendUint64([]byte{0x44, 0x89, 0x88}, math.Float64bits(1.5))},
+		{"master", func(e *ebml) { e.master(mkvVideo, func(e *ebml) { e.uint(mkvPixelWidth, 1) }) },
+			[]byte{0xe0, 0x83, 0xb0, 0x81, 1}},
+	}
+	for _, tt := range tests {
+		var e ebml
+		tt.fill(&e)
+		if !bytes.Equal(e.Bytes(), tt.want) {
+			t.Errorf("%s: % x, want % x", tt.name, e.Bytes(), tt.want)
+		}
+	}
+}
+
+// element is one parsed EBML element.
+type element struct {
+	id     uint32
+	offset int // of the element's ID within the parent's data
+	data   []byte
+}
+
+// readVint reads an EBML variable-length integer, keeping the length
+// marker if it's an ID.
+func readVint(t *testing.T, b []byte, id bool) (uint64, int) {
+	t.Helper()
+	if len(b) == 0 || b[0] == 0 {
+		t.Fatalf("bad vint at % x", b[:min(len(b), 8)])
+	}
+	l := 1
+	for b[0]&(0x80>>(l-1)) == 0 {
+		l++
+	}
+	v := uint64(b[0])
+	if !id {
+		v &= 0xff >> l
+	}
+	for _, c := range b[1:l] {
+		v = v<<8 | uint64(c)
+	}
+	return v, l
+}
+
+// children parses the elements in data.
+func children(t *testing.T, data []byte) []element {
+	t.Helper()
+	var out []element
+	for pos := 0; pos < len(data); {
+		id, n := readVint(t, data[pos:], true)
+		size, m := readVint(t, data[pos+n:], false)
+		start := pos + n + m
+		if start+int(size) > len(data) {
+			t.Fatalf("element %#x overruns its parent", id)
+		}
+		out = append(out, element{uint32(id), pos, data[start : start+int(size)]})
+		pos = start + int(size)
+	}
+	return out
+}
+
+func find(t *testing.T, els []element, id uint32) element {
+	t.Helper()
+	for _, e := range els {
+		if e.id == id {
+			return e
+		}
+	}
+	t.Fatalf("no element %#x", id)
+	return element{}
+}
+
+func uintOf(e element) uint64 {
+	var v uint64
+	for _, b := range e.data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func TestWriteMKV(t *testing.T) {
+	sps := spsFields{profile: 66, constraints: 0xe0, level: 31, pocType: 2, widthMBs: 20, heightUnits: 15}.encode()
+	pps := []byte{0x68, 0xce, 0x38, 0x80}
+	start := func(nal []byte) []byte { return append([]byte{0, 0, 0, 1}, nal...) }
+	idr := append(append(start(sps), start(pps)...), start([]byte{0x65, 0x88, 0x84})...)
+	p := start([]byte{0x41, 0x9a, 0x02})
+	opusHead := []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	ms := time.Millisecond
+
+	frames := []mkvFrame{
+		// Dropped: before the first keyframe.
+		{data: p, pts: 0},
+		{data: []byte{0xfc}, pts: 50 * ms, audio: true},
+
+		{data: idr, pts: 100 * ms},
+		{data: p, pts: 133 * ms},
+		{data: []byte{0xfc, 1}, pts: 120 * ms, audio: true},
+		{data: idr, pts: 1100 * ms},
+		{data: []byte{0xfc, 2}, pts: 1120 * ms, audio: true},
+	}
+	path := filepath.Join(t.TempDir(), "clip.mkv")
+	if err := writeMKV(path, frames, opusHead, options{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top := children(t, data)
+	if len(top) != 2 || top[0].id != mkvEBML || top[1].id != mkvSegment {
+		t.Fatalf("top level = %v", top)
+	}
+	if doc := find(t, children(t, top[0].data), mkvDocType); string(doc.data) != "matroska" {
+		t.Errorf("DocType = %q", doc.data)
+	}
+	seg := children(t, top[1].data)
+
+	info := children(t, find(t, seg, mkvInfo).data)
+	if d := math.Float64frombits(binary.BigEndian.Uint64(find(t, info, mkvDuration).data)); d != 1020 {
+		t.Errorf("Duration = %v ms, want 1020", d)
+	}
+	tracks := children(t, find(t, seg, mkvTracks).data)
+	if len(tracks) != 2 {
+		t.Fatalf("%d tracks, want 2", len(tracks))
+	}
+	video := children(t, tracks[0].data)
+	if id := find(t, video, mkvCodecID); string(id.data) != "V_MPEG4/ISO/AVC" {
+		t.Errorf("video CodecID = %q", id.data)
+	}
+	if cp := find(t, video, mkvCodecPrivate); !bytes.Equal(cp.data, avcConfig(sps, pps)) {
+		t.Errorf("CodecPrivate = % x", cp.data)
+	}
+	size := children(t, find(t, video, mkvVideo).data)
+	if w, h := uintOf(find(t, size, mkvPixelWidth)), uintOf(find(t, size, mkvPixelHeight)); w != 320 || h != 240 {
+		t.Errorf("video size = %dx%d, want 320x240", w, h)
+	}
+	audio := children(t, tracks[1].data)
+	if delay := uintOf(find(t, audio, mkvCodecDelay)); delay != uint64(312*time.Second/48000) {
+		t.Errorf("CodecDelay = %d", delay)
+	}
+	if ch := uintOf(find(t, children(t, find(t, audio, mkvAudio).data), mkvChannels)); ch != 2 {
+		t.Errorf("Channels = %d, want 2", ch)
+	}
+
+	// A cluster per keyframe, with audio in whichever cluster it falls.
+	type block struct {
+		track byte
+		rel   int16
+		key   bool
+		size  int
+	}
+	want := [][]block{
+		{{0x81, 0, true, len(annexBToAVCC(idr))}, {0x82, 20, true, 2}, {0x81, 33, false, len(annexBToAVCC(p))}},
+		{{0x81, 0, true, len(annexBToAVCC(idr))}, {0x82, 20, true, 2}},
+	}
+	wantTimes := []uint64{0, 1000}
+	var clusters []element
+	for _, e := range seg {
+		if e.id == mkvCluster {
+			clusters = append(clusters, e)
+		}
+	}
+	if len(clusters) != len(want) {
+		t.Fatalf("%d clusters, want %d", len(clusters), len(want))
+	}
+	for i, c := range clusters {
+		els := children(t, c.data)
+		if ts := uintOf(els[0]); els[0].id != mkvClusterTimestamp || ts != wantTimes[i] {
+			t.Errorf("cluster %d timestamp = %d, want %d", i, ts, wantTimes[i])
+		}
+		var got []block
+		for _, e := range els[1:] {
+			got = append(got, block{e.data[0], int16(binary.BigEndian.Uint16(e.data[1:])), e.data[3]&0x80 != 0, len(e.data) - 4})
+		}
+		if len(got) != len(want[i]) {
+			t.Errorf("cluster %d blocks = %v, want %v", i, got, want[i])
+			continue
+		}
+		for j := range got {
+			if got[j] != want[i][j] {
+				t.Errorf("cluster %d block %d = %+v, want %+v", i, j, got[j], want[i][j])
+			}
+		}
+	}
+
+	// Cues point at the clusters, relative to the segment's data.
+	cues := children(t, find(t, seg, mkvCues).data)
+	if len(cues) != len(clusters) {
+		t.Fatalf("%d cue points, want %d", len(cues), len(clusters))
+	}
+	for i, cue := range cues {
+		els := children(t, cue.data)
+		pos := uintOf(find(t, children(t, find(t, els, mkvCueTrackPositions).data), mkvCueClusterPosition))
+		if int(pos) != clusters[i].offset {
+			t.Errorf("cue %d position = %d, want %d", i, pos, clusters[i].offset)
+		}
+		if tm := uintOf(find(t, els, mkvCueTime)); tm != wantTimes[i] {
+			t.Errorf("cue %d time = %d, want %d", i, tm, wantTimes[i])
+		}
+	}
+}
+
+func TestWriteMKVErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []mkvFrame
+		err    string
+	}{
+		{"no keyframe", []mkvFrame{{data: []byte{0, 0, 0, 1, 0x41, 1}}}, "no keyframe"},
+		{"no parameter sets", []mkvFrame{{data: []byte{0, 0, 0, 1, 0x65, 1}}}, "no SPS/PPS"},
+	}
+	for _, tt := range tests {
+		err := writeMKV(filepath.Join(t.TempDir(), "clip.mkv"), tt.frames, nil, options{})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: writeMKV error = %v, want one containing %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package recorder
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hwEncoders are the H264 encoders used when a clip has to be re-encoded,
+// keyed like hwDecodeArgs. Anything else falls back to libx264.
+var hwEncoders = map[string]string{
+	"vaapi":        "h264_vaapi",
+	"videotoolbox": "h264_videotoolbox",
+	"nvenc":        "h264_nvenc",
+	"qsv":          "h264_qsv",
+	"v4l2m2m":      "h264_v4l2m2m",
+}
+
+const vaapiDevice = "/dev/dri/renderD128"
+
+// WithOverlay burns the wall-clock capture time and label (usually the camera
+// name) into snapshots and clips. Clips are re-encoded instead of copied,
+// using the WithHWAccel encoder when one is set.
+func WithOverlay(label string) Option {
+	return func(o *options) {
+		o.overlay = true
+		o.overlayLabel = label
+	}
+}
+
+// overlayFilters returns drawtext filters for the label (top left) and the
+// capture time (top right). Frame timestamps are offset from epoch, the
+// capture time of the input's timestamp zero, so the input needs its real
+// frame timing (see timedInput); when epoch is zero (live piping) the
+// encoder's clock is used instead.
+func (o options) overlayFilters(epoch time.Time) []string {
+	if !o.overlay {
+		return nil
+	}
+	const style = "fontcolor=white:fontsize=h/28:box=1:boxcolor=black@0.5:boxborderw=6"
+
+	clock := "%{localtime:%Y-%m-%d %T}"
+	if !epoch.IsZero() {
+		clock = fmt.Sprintf("%%{pts:localtime:%.3f:%%Y-%%m-%%d %%T}", float64(epoch.UnixMilli())/1000)
+	}
+	filters := []string{fmt.Sprintf("drawtext=text=%s:x=w-tw-10:y=10:%s", escapeOption(clock), style)}
+	if o.overlayLabel != "" {
+		filters = append(filters, fmt.Sprintf("drawtext=expansion=none:text=%s:x=10:y=10:%s", escapeOption(o.overlayLabel), style))
+	}
+	for i, f := range filters {
+		filters[i] = escapeGraph(f)
+	}
+	return filters
+}
+
+// videoArgs returns the input and output video options for muxing a clip:
+// a plain stream copy, or a re-encode when the overlay is on, followed by
+// any metadata tags. epoch is as for overlayFilters.
+func (o options) videoArgs(webm bool, epoch time.Time) (in, out []string) {
+	filters := o.overlayFilters(epoch)
+	if len(filters) == 0 {
+		return nil, append([]string{"-c:v", "copy"}, o.metadataArgs()...)
+	}
+
+	in = o.decodeArgs()
+	if webm {
+		out = []string{"-c:v", "libvpx-vp9", "-deadline", "realtime", "-cpu-used", "8", "-b:v", "0", "-crf", "32"}
+	} else if enc, ok := hwEncoders[o.hwaccel]; ok {
+		out = []string{"-c:v", enc}
+		if o.hwaccel == "vaapi" {
+			in = append(in, "-vaapi_device", vaapiDevice)
+			filters = append(filters, "format=nv12", "hwupload")
+		}
+	} else {
+		out = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23"}
+	}
+	out = append([]string{"-vf", strings.Join(filters, ",")}, out...)
+	return in, append(out, o.metadataArgs()...)
+}
+
+// escapeOption escapes a filter option value (first level of ffmpeg's
+// filtergraph escaping).
+func escapeOption(s string) string {
+	return escapeChars(s, `\':`)
+}
+
+// escapeGraph escapes a filter description for use inside a filtergraph
+// (second level).
+func escapeGraph(s string) string {
+	return escapeChars(s, `\'[],;`)
+}
+
+func escapeChars(s, chars string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
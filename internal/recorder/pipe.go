@@ -0,0 +1,204 @@
+package recorder
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
+)
+
+// defaultPipeBuffer is PipeH264Writer's MaxBuffer when it's 0: several
+// seconds of a camera's video.
+const defaultPipeBuffer = 8 << 20
+
+// PipeH264Writer writes raw H264 Annex B data to an io.Writer. Frames are
+// queued for W so a slow reader (a player, the network) never holds up the
+// RTP read loop, which would overflow the jitter buffer and lose packets.
+type PipeH264Writer struct {
+	W io.Writer
+
+	// MaxLate is how many packets may be held back waiting for a missing
+	// one before it's given up on (see JitterDepth); 0 means 128.
+	MaxLate uint16
+
+	// MaxBuffer bounds the bytes queued for W; 0 means 8 MB. When W falls
+	// that far behind, the oldest frames are discarded and the output
+	// resumes at the next keyframe.
+	MaxBuffer int
+
+	// Log receives status messages; nil means slog's default logger.
+	Log *slog.Logger
+
+	mu        sync.Mutex
+	queue     [][]byte
+	queued    int
+	resync    bool // skip to the next keyframe after discarding
+	frames    int
+	bytes     int64
+	keyframes int
+	dropped   int
+	discarded int
+	blocked   time.Duration
+}
+
+// HandleVideoTrack reads H264 RTP packets and writes Annex B NAL units to
+// the pipe. It returns when ctx is done, the track ends or a write fails.
+func (w *PipeH264Writer) HandleVideoTrack(track nestwebrtc.Track, ctx context.Context) {
+	maxLate := w.MaxLate
+	if maxLate == 0 {
+		maxLate = 128
+	}
+	reader := newPacketReader(track)
+	builder := samplebuilder.New(maxLate, &codecs.H264Packet{}, track.Codec().ClockRate, samplebuilder.WithPacketReleaseHandler(reader.release))
+
+	ready := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	failed := make(chan struct{})
+	go func() {
+		defer close(failed)
+		w.drain(ready, stop)
+	}()
+	defer func() {
+		close(stop)
+		<-failed
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-failed:
+			return
+		default:
+		}
+
+		pkt, err := reader.read()
+		if err != nil {
+			return
+		}
+
+		builder.Push(pkt)
+		for {
+			sample := builder.Pop()
+			if sample == nil {
+				break
+			}
+			w.push(sample.Data, int(sample.PrevDroppedPackets))
+			select {
+			case ready <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (w *PipeH264Writer) logger() *slog.Logger {
+	if w.Log == nil {
+		return slog.Default()
+	}
+	return w.Log
+}
+
+// push queues a frame for W, discarding the oldest ones beyond MaxBuffer.
+func (w *PipeH264Writer) push(data []byte, dropped int) {
+	limit := w.MaxBuffer
+	if limit <= 0 {
+		limit = defaultPipeBuffer
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.frames++
+	w.bytes += int64(len(data))
+	if isKeyframe(data) {
+		w.keyframes++
+	}
+	w.dropped += dropped
+
+	w.queue = append(w.queue, data)
+	w.queued += len(data)
+	for w.queued > limit && len(w.queue) > 1 {
+		w.queued -= len(w.queue[0])
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		w.discarded++
+		w.resync = true
+	}
+}
+
+// pop takes the next frame to write, skipping to a keyframe after frames
+// were discarded; ok is false when the queue is empty.
+func (w *PipeH264Writer) pop() (data []byte, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	skipped := 0
+	for len(w.queue) > 0 {
+		data = w.queue[0]
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		w.queued -= len(data)
+		if w.resync && !isKeyframe(data) {
+			w.discarded++
+			skipped++
+			continue
+		}
+		if w.resync {
+			w.resync = false
+			w.logger().Debug("video output fell behind; resumed at a keyframe", "skipped_frames", skipped)
+		}
+		return data, true
+	}
+	return nil, false
+}
+
+// drain writes queued frames to W as they arrive until stop is closed or a
+// write fails.
+func (w *PipeH264Writer) drain(ready, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ready:
+		}
+		for {
+			data, ok := w.pop()
+			if !ok {
+				break
+			}
+			writeStart := time.Now()
+			_, err := w.W.Write(data)
+			w.mu.Lock()
+			w.blocked += time.Since(writeStart)
+			w.mu.Unlock()
+			if err != nil {
+				return
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// Progress returns frames, bytes and keyframes received, packets dropped,
+// frames discarded because W fell behind and time spent waiting on W, with
+// elapsed time measured from start.
+func (w *PipeH264Writer) Progress(start time.Time) Progress {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Progress{
+		Frames:    w.frames,
+		Bytes:     w.bytes,
+		Keyframes: w.keyframes,
+		Elapsed:   time.Since(start),
+		Dropped:   w.dropped,
+		Discarded: w.discarded,
+		Blocked:   w.blocked,
+	}
+}
@@ -0,0 +1,358 @@
+package recorder
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Player is a media player that live view feeds raw H264 to.
+type Player struct {
+	Path string
+	kind string // "ffplay", "mpv", "vlc", or "" for a custom command
+
+	// A custom command's arguments, expanded with PlayerInput.
+	args       []*template.Template
+	readsInput bool // the command uses {{.Input}}
+}
+
+// PlayerInput describes the video to a custom player command template.
+type PlayerInput struct {
+	Input   string // "-" for stdin, or a tcp:// URL to connect to
+	Format  string // ffmpeg demuxer name of the stream, "h264"
+	FPS     int    // nominal frame rate
+	Title   string // window title
+	Latency string // latency preset: ultra, low or normal
+}
+
+// FindPlayer resolves the live view player. name is ffplay, mpv or vlc, a
+// path to one of them, or a whole command line whose arguments may use
+// PlayerInput's fields as Go templates, e.g. "mpv --profile=low-latency
+// {{.Input}}"; empty means ffplay, at ffplayPath if configured.
+func FindPlayer(name, ffplayPath string) (*Player, error) {
+	if strings.ContainsAny(name, " \t") || strings.Contains(name, "{{") {
+		// A path with spaces ("C:\Program Files\...") is still just a path.
+		if _, err := os.Stat(name); err != nil {
+			return parsePlayerCommand(name)
+		}
+	}
+	if name == "" {
+		path, err := FindTool("ffplay", ffplayPath)
+		if err != nil {
+			return nil, err
+		}
+		return &Player{Path: path, kind: "ffplay"}, nil
+	}
+
+	kind := playerKind(name)
+	if kind == "" {
+		return nil, fmt.Errorf("unsupported player %q (use ffplay, mpv or vlc)", name)
+	}
+	if kind == "ffplay" && !strings.ContainsAny(name, `/\`) {
+		path, err := FindTool("ffplay", ffplayPath)
+		if err != nil {
+			return nil, err
+		}
+		return &Player{Path: path, kind: kind}, nil
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		return &Player{Path: path, kind: kind}, nil
+	}
+	if runtime.GOOS == "windows" && kind == "vlc" && !strings.ContainsAny(name, `/\`) {
+		// The VLC installer doesn't add itself to PATH.
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)"} {
+			if dir := os.Getenv(env); dir != "" {
+				path := filepath.Join(dir, "VideoLAN", "VLC", "vlc.exe")
+				if _, err := os.Stat(path); err == nil {
+					return &Player{Path: path, kind: kind}, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s %w; install it or pass its full path to --player", name, ErrToolNotFound)
+}
+
+// parsePlayerCommand parses a custom player command line. Arguments are
+// separated by spaces and may be quoted with ' or "; backslashes are kept
+// as they are, for Windows paths.
+func parsePlayerCommand(command string) (*Player, error) {
+	words, err := splitCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("player command: %w", err)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("player command is empty")
+	}
+	path, err := exec.LookPath(words[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s %w; install it or give its full path in --player", words[0], ErrToolNotFound)
+	}
+	p := &Player{Path: path}
+	for _, w := range words[1:] {
+		t, err := template.New("player").Option("missingkey=error").Parse(w)
+		if err != nil {
+			return nil, fmt.Errorf("player command argument %q: %w", w, err)
+		}
+		p.args = append(p.args, t)
+		p.readsInput = p.readsInput || strings.Contains(w, ".Input")
+	}
+	return p, nil
+}
+
+// splitCommand splits s into words at unquoted spaces.
+func splitCommand(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// playerKind identifies a player from its binary name.
+func playerKind(name string) string {
+	base := strings.TrimSuffix(strings.ToLower(filepath.Base(name)), ".exe")
+	for _, kind := range []string{"ffplay", "mpv", "vlc"} {
+		if strings.HasPrefix(base, kind) {
+			return kind
+		}
+	}
+	return ""
+}
+
+// Name returns the player's name, e.g. for messages.
+func (p *Player) Name() string {
+	if p.kind == "" {
+		return strings.TrimSuffix(filepath.Base(p.Path), ".exe")
+	}
+	return p.kind
+}
+
+// StdinOnly reports whether the player can only read video from stdin: a
+// custom command that doesn't use {{.Input}}.
+func (p *Player) StdinOnly() bool {
+	return p.kind == "" && !p.readsInput
+}
+
+// Latency presets for live view, from lowest delay to smoothest playback.
+const (
+	LatencyUltra  = "ultra"
+	LatencyLow    = "low"
+	LatencyNormal = "normal"
+)
+
+// JitterDepth returns how many RTP packets live view may hold while waiting
+// for a late or lost one under a latency preset. Deeper buffers ride out
+// bad connections at the cost of delay.
+func JitterDepth(latency string) uint16 {
+	switch latency {
+	case LatencyUltra:
+		return 32
+	case LatencyNormal:
+		return 512
+	}
+	return 128
+}
+
+// PlayerOptions says what a player should show and how.
+type PlayerOptions struct {
+	Input   string   // "-" for stdin, or a URL
+	HWAccel string   // hardware decoding, if set
+	Latency string   // latency preset
+	Extra   []string // ffmpeg_args for live; ffplay only
+	Window  *Window  // window geometry, or nil for the player's default
+}
+
+// Window is a live view window's size, position and style.
+type Window struct {
+	Width, Height int
+	X, Y          int
+	Positioned    bool // X and Y are set
+	OnTop         bool
+	Borderless    bool
+}
+
+// ParsePosition parses a window position "X,Y" in pixels from the top-left
+// corner of the screen.
+func ParsePosition(s string) (x, y int, err error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid position %q (want X,Y)", s)
+	}
+	if x, err = strconv.Atoi(strings.TrimSpace(xs)); err != nil || x < 0 {
+		return 0, 0, fmt.Errorf("invalid position %q (want X,Y)", s)
+	}
+	if y, err = strconv.Atoi(strings.TrimSpace(ys)); err != nil || y < 0 {
+		return 0, 0, fmt.Errorf("invalid position %q (want X,Y)", s)
+	}
+	return x, y, nil
+}
+
+// Args returns the player's arguments to show H264 as opts describe,
+// buffered according to the latency preset. A custom command gets its own
+// arguments, expanded, and must handle window options itself.
+func (p *Player) Args(opts PlayerOptions) ([]string, error) {
+	input, hwaccel, latency := opts.Input, opts.HWAccel, opts.Latency
+	switch p.kind {
+	case "":
+		if opts.Window != nil {
+			return nil, fmt.Errorf("window options aren't supported with a custom player command; add the player's own options to it")
+		}
+		in := PlayerInput{Input: input, Format: "h264", FPS: 30, Title: "gognestcli live", Latency: latency}
+		var args []string
+		for _, t := range p.args {
+			var b strings.Builder
+			if err := t.Execute(&b, in); err != nil {
+				return nil, fmt.Errorf("player command: %w", err)
+			}
+			args = append(args, b.String())
+		}
+		return args, nil
+	case "mpv":
+		args := []string{"--demuxer-lavf-format=h264", "--title=gognestcli live"}
+		switch latency {
+		case LatencyNormal:
+			args = append(args, "--cache=yes", "--cache-secs=1")
+		case LatencyUltra:
+			args = append(args, "--profile=low-latency", "--untimed", "--no-cache", "--video-sync=desync")
+		default:
+			args = append(args, "--profile=low-latency", "--untimed", "--no-cache")
+		}
+		if hwaccel != "" {
+			args = append(args, "--hwdec=auto")
+		}
+		if w := opts.Window; w != nil {
+			geometry := fmt.Sprintf("%dx%d", w.Width, w.Height)
+			if w.Positioned {
+				geometry += fmt.Sprintf("+%d+%d", w.X, w.Y)
+			}
+			args = append(args, "--geometry="+geometry)
+			if w.OnTop {
+				args = append(args, "--ontop")
+			}
+			if w.Borderless {
+				args = append(args, "--no-border")
+			}
+		}
+		return append(args, input), nil
+	case "vlc":
+		caching := "0"
+		if latency == LatencyNormal {
+			caching = "1000"
+		}
+		args := []string{
+			"--demux=h264",
+			"--h264-fps=30",
+			"--network-caching=" + caching,
+			"--file-caching=" + caching,
+			"--meta-title=gognestcli live",
+			"--play-and-exit",
+		}
+		if latency != LatencyNormal {
+			args = append(args, "--clock-jitter=0")
+		}
+		if latency == LatencyUltra {
+			args = append(args, "--clock-synchro=0")
+		}
+		if hwaccel != "" {
+			args = append(args, "--avcodec-hw=any")
+		}
+		if w := opts.Window; w != nil {
+			args = append(args, "--width="+strconv.Itoa(w.Width), "--height="+strconv.Itoa(w.Height), "--no-autoscale")
+			if w.Positioned {
+				args = append(args, "--video-x="+strconv.Itoa(w.X), "--video-y="+strconv.Itoa(w.Y))
+			}
+			if w.OnTop {
+				args = append(args, "--video-on-top")
+			}
+			if w.Borderless {
+				args = append(args, "--no-video-deco", "--no-embedded-video")
+			}
+		}
+		return append(args, input), nil
+	}
+
+	hwArgs, err := PlayerHWAccelArgs(hwaccel)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(hwArgs, opts.Extra, []string{"-f", "h264", "-framerate", "30"},
+		ffplayLatencyArgs(latency), ffplayWindowArgs(opts.Window),
+		[]string{"-window_title", "gognestcli live", input}), nil
+}
+
+// ffplayWindowArgs returns ffplay's window options; -alwaysontop needs
+// FFmpeg 4.3 or newer.
+func ffplayWindowArgs(w *Window) []string {
+	if w == nil {
+		return nil
+	}
+	args := []string{"-x", strconv.Itoa(w.Width), "-y", strconv.Itoa(w.Height)}
+	if w.Positioned {
+		args = append(args, "-left", strconv.Itoa(w.X), "-top", strconv.Itoa(w.Y))
+	}
+	if w.OnTop {
+		args = append(args, "-alwaysontop")
+	}
+	if w.Borderless {
+		args = append(args, "-noborder")
+	}
+	return args
+}
+
+// ffplayLatencyArgs returns ffplay's probing, buffering and sync options for
+// a latency preset. normal lets ffplay probe and buffer as it would for a
+// file, so playback is smooth but a second or so behind.
+func ffplayLatencyArgs(latency string) []string {
+	switch latency {
+	case LatencyNormal:
+		return []string{"-probesize", "500000", "-analyzeduration", "1000000", "-sync", "video"}
+	case LatencyUltra:
+		return []string{
+			"-probesize", "32",
+			"-analyzeduration", "0",
+			"-fflags", "nobuffer",
+			"-flags", "low_delay",
+			"-avioflags", "direct",
+			"-framedrop",
+			"-sync", "ext",
+		}
+	}
+	return []string{
+		"-probesize", "32",
+		"-analyzeduration", "0",
+		"-fflags", "nobuffer",
+		"-flags", "low_delay",
+		"-framedrop",
+	}
+}
@@ -4,24 +4,222 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/brice/gognestcli/internal/proc"
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
 	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media/samplebuilder"
 )
 
+// Progress reports how much of a recording has been captured so far.
+type Progress struct {
+	Frames    int
+	Bytes     int64
+	Keyframes int
+	Elapsed   time.Duration
+
+	// Dropped counts RTP packets lost on the way or given up on while
+	// reassembling frames, i.e. network trouble.
+	Dropped int
+	// Blocked is the total time spent waiting to hand frames to the
+	// destination, e.g. a player or ffmpeg falling behind.
+	Blocked time.Duration
+	// Discarded counts frames a PipeH264Writer threw away because its
+	// destination fell too far behind.
+	Discarded int
+
+	// FPS and Bitrate (bits per second) are averaged over the last few
+	// seconds.
+	FPS     float64
+	Bitrate float64
+}
+
+// rateWindow is how far back FPS and Bitrate are averaged.
+const rateWindow = 3 * time.Second
+
+// ProgressSource is a writer that can report its progress, like H264Writer
+// and PipeH264Writer.
+type ProgressSource interface {
+	Progress(start time.Time) Progress
+}
+
+// WatchProgress calls fn with src's progress, including rolling FPS and
+// bitrate, every interval until the returned stop function is called, which
+// reports once more and waits for the reporter.
+func WatchProgress(src ProgressSource, interval time.Duration, fn func(Progress)) (stop func()) {
+	start := time.Now()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		history := []Progress{{}} // the start, as the first base
+		report := func() {
+			p := src.Progress(start)
+			history = append(history, p)
+			// Keep the newest report at least rateWindow old as the base.
+			for len(history) > 2 && p.Elapsed-history[1].Elapsed >= rateWindow {
+				history = history[1:]
+			}
+			if base := history[0]; p.Elapsed > base.Elapsed {
+				secs := (p.Elapsed - base.Elapsed).Seconds()
+				p.FPS = float64(p.Frames-base.Frames) / secs
+				p.Bitrate = float64(p.Bytes-base.Bytes) * 8 / secs
+			}
+			fn(p)
+		}
+		for {
+			select {
+			case <-done:
+				report()
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// Option configures RecordClip, RecordUntilQuiet and TakeSnapshot.
+type Option func(*options)
+
+type options struct {
+	progress         func(Progress)
+	progressInterval time.Duration
+	ffmpegPath       string
+	ffmpegArgs       []string
+	hwaccel          string
+	quality          int
+	scaleW, scaleH   int
+	crop             *crop
+	overlay          bool
+	overlayLabel     string
+	audio            string    // AudioAAC or AudioCopy; "" for none
+	start            time.Time // when video arrived, for the overlay clock
+	verify           bool
+	doneMarker       bool
+	noReplace        bool
+	metadata         *Metadata
+	firstFrame       func()
+	streamInfo       func(StreamInfo)
+	ctx              context.Context
+	log              *slog.Logger
+	skipToKeyframe   bool // set by the snapshot functions, not an Option
+}
+
+// WithProgress calls fn periodically (every 500 ms) while frames are being
+// captured, and once more when capture stops.
+func WithProgress(fn func(Progress)) Option {
+	return func(o *options) { o.progress = fn }
+}
+
+// WithFirstFrame calls fn once, when the first video frame has been written
+// to disk, e.g. to measure capture latency.
+func WithFirstFrame(fn func()) Option {
+	return func(o *options) { o.firstFrame = fn }
+}
+
+// WithStreamInfo calls fn with the resolution, profile and level of the
+// video once the camera's first SPS arrives, and again if they change
+// mid-capture.
+func WithStreamInfo(fn func(StreamInfo)) Option {
+	return func(o *options) { o.streamInfo = fn }
+}
+
+// WithContext bounds streams and ffmpeg runs by ctx, e.g. to a command's
+// deadline. Cancelling it stops waiting for video and kills ffmpeg.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) { o.ctx = ctx }
+}
+
+// WithLogger sends the recorder's status messages, such as reconnects and
+// lost packets, to log instead of slog's default logger.
+func WithLogger(log *slog.Logger) Option {
+	return func(o *options) { o.log = log }
+}
+
+// logger returns the logger set with WithLogger, or slog's default.
+func (o options) logger() *slog.Logger {
+	if o.log == nil {
+		return slog.Default()
+	}
+	return o.log
+}
+
+// context returns the context set with WithContext, or a background one.
+func (o options) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+func buildOptions(opts []Option) options {
+	o := options{progressInterval: 500 * time.Millisecond}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// startProgress calls o.progress periodically until the returned stop
+// function is called, which reports once more and waits for the reporter.
+func (o options) startProgress(w *H264Writer) (stop func()) {
+	if o.progress == nil {
+		return func() {}
+	}
+	return WatchProgress(w, o.progressInterval, o.progress)
+}
+
 // H264Writer collects raw H264 Annex B data from a WebRTC video track.
 type H264Writer struct {
-	mu       sync.Mutex
-	file     *os.File
-	filename string
-	frames   int
+	mu        sync.Mutex
+	file      io.WriteCloser
+	filename  string
+	frames    int
+	bytes     int64
+	keyframes int
+	dropped   int
+	blocked   time.Duration
+	onFirst   func()
+	stream    StreamInfo
+	onStream  func(StreamInfo)
+	log       *slog.Logger // nil means slog's default
+
+	// err is the first write error; failed is closed when it's set, and no
+	// more is written.
+	err    error
+	failed chan struct{}
+
+	// times records each access unit's size and capture time, for muxing
+	// MKV natively; only file writers keep it. t0 is when the first unit
+	// was written and last the latest capture time.
+	times []frameSpan
+	t0    time.Time
+	last  time.Duration
+
+	// skipToKeyframe drops access units before the first IDR, other than
+	// parameter sets, so the output starts with a decodable frame.
+	skipToKeyframe bool
+}
+
+// frameWriter is implemented by destinations of NewH264WriterTo that want
+// each access unit with its capture time, like CMAFWriter.
+type frameWriter interface {
+	WriteFrame(data []byte, pts time.Duration) (int, error)
 }
 
 // NewH264Writer creates a writer that saves raw H264 Annex B stream.
@@ -30,21 +228,39 @@ func NewH264Writer(filename string) (*H264Writer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &H264Writer{file: f, filename: filename}, nil
+	return &H264Writer{file: f, filename: filename, failed: make(chan struct{})}, nil
+}
+
+// NewH264WriterTo creates a writer that sends the raw H264 Annex B stream to
+// wc instead of a file. Close closes wc.
+func NewH264WriterTo(wc io.WriteCloser) *H264Writer {
+	return &H264Writer{file: wc, failed: make(chan struct{})}
 }
 
 // HandleVideoTrack reads H264 RTP packets and writes Annex B NAL units.
-func (w *H264Writer) HandleVideoTrack(track *webrtc.TrackRemote, ctx context.Context) {
-	builder := samplebuilder.New(128, &codecs.H264Packet{}, track.Codec().ClockRate)
+// Units are timed by their RTP timestamps, from when the track's first one
+// was written, so tracks from successive stream sessions line up. Packets
+// lost before a unit leave a gap in those timestamps, which is logged and
+// kept: the muxers stretch the frame before it. The track is abandoned once a
+// write fails.
+func (w *H264Writer) HandleVideoTrack(track nestwebrtc.Track, ctx context.Context) {
+	clock := track.Codec().ClockRate
+	reader := newPacketReader(track)
+	builder := samplebuilder.New(128, &codecs.H264Packet{}, clock, samplebuilder.WithPacketReleaseHandler(reader.release))
+	var base time.Duration
+	var firstTS, prevTS uint32
+	started := false
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-w.failed:
+			return
 		default:
 		}
 
-		pkt, _, err := track.ReadRTP()
+		pkt, err := reader.read()
 		if err != nil {
 			return
 		}
@@ -55,16 +271,92 @@ func (w *H264Writer) HandleVideoTrack(track *webrtc.TrackRemote, ctx context.Con
 			if sample == nil {
 				break
 			}
+			if sample.PrevDroppedPackets > 0 && started && clock > 0 {
+				w.logger().Debug("video packets lost", "packets", sample.PrevDroppedPackets,
+					"after_rtp", prevTS, "before_rtp", sample.PacketTimestamp,
+					"gap", time.Duration(sample.PacketTimestamp-prevTS)*time.Second/time.Duration(clock))
+			}
+			prevTS = sample.PacketTimestamp
 			w.mu.Lock()
+			w.dropped += int(sample.PrevDroppedPackets)
+			if w.skipToKeyframe && w.keyframes == 0 && !isKeyframe(sample.Data) && !hasParameterSets(sample.Data) {
+				w.mu.Unlock()
+				continue
+			}
 			if w.file != nil {
-				w.file.Write(sample.Data)
+				if !started {
+					started, firstTS = true, sample.PacketTimestamp
+					if w.frames == 0 {
+						w.t0 = time.Now()
+					} else {
+						base = time.Since(w.t0)
+					}
+				}
+				var pts time.Duration
+				if clock > 0 {
+					pts = max(base+time.Duration(sample.PacketTimestamp-firstTS)*time.Second/time.Duration(clock), w.last)
+					w.last = pts
+				}
+				var n int
+				var err error
+				writeStart := time.Now()
+				if fw, ok := w.file.(frameWriter); ok {
+					n, err = fw.WriteFrame(sample.Data, pts)
+				} else {
+					n, err = w.file.Write(sample.Data)
+				}
+				w.blocked += time.Since(writeStart)
+				if err != nil {
+					w.fail(err)
+					w.mu.Unlock()
+					return
+				}
+				if w.filename != "" {
+					w.times = append(w.times, frameSpan{size: n, pts: pts})
+				}
 				w.frames++
+				w.bytes += int64(n)
+				if isKeyframe(sample.Data) {
+					w.keyframes++
+				}
+				if w.frames == 1 && w.onFirst != nil {
+					go w.onFirst()
+				}
+				if info, ok := streamInfo(sample.Data); ok && info != w.stream {
+					w.stream = info
+					if w.onStream != nil {
+						go w.onStream(info)
+					}
+				}
 			}
 			w.mu.Unlock()
 		}
 	}
 }
 
+// fail records the first write error and stops further writes. Callers
+// hold mu.
+func (w *H264Writer) fail(err error) {
+	if w.err == nil {
+		w.err = fmt.Errorf("writing video: %w", err)
+		close(w.failed)
+	}
+}
+
+// writeErr returns the first write error, if any.
+func (w *H264Writer) writeErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *H264Writer) logger() *slog.Logger {
+	if w.log == nil {
+		return slog.Default()
+	}
+	return w.log
+}
+
 // Frames returns the number of frames written so far.
 func (w *H264Writer) Frames() int {
 	w.mu.Lock()
@@ -72,105 +364,86 @@ func (w *H264Writer) Frames() int {
 	return w.frames
 }
 
-// Close closes the file.
-func (w *H264Writer) Close() error {
+// Keyframes returns the number of IDR frames written so far.
+func (w *H264Writer) Keyframes() int {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	if w.file != nil {
-		err := w.file.Close()
-		w.file = nil
-		return err
-	}
-	return nil
+	return w.keyframes
 }
 
-// StdoutH264Writer writes raw H264 Annex B data to stdout.
-type StdoutH264Writer struct{}
-
-// HandleVideoTrack reads H264 RTP packets and writes Annex B NAL units to stdout.
-func (w *StdoutH264Writer) HandleVideoTrack(track *webrtc.TrackRemote, ctx context.Context) {
-	builder := samplebuilder.New(128, &codecs.H264Packet{}, track.Codec().ClockRate)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-
-		pkt, _, err := track.ReadRTP()
-		if err != nil {
-			return
-		}
-
-		builder.Push(pkt)
-		for {
-			sample := builder.Pop()
-			if sample == nil {
-				break
-			}
-			if _, err := os.Stdout.Write(sample.Data); err != nil {
-				return
-			}
-		}
-	}
+// Stream returns the resolution, profile and level from the latest SPS, or
+// a zero StreamInfo before one has arrived.
+func (w *H264Writer) Stream() StreamInfo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stream
 }
 
-// PipeH264Writer writes raw H264 Annex B data to an io.Writer.
-type PipeH264Writer struct {
-	W io.Writer
+// spans returns the size and capture time of each access unit written.
+func (w *H264Writer) spans() []frameSpan {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.times)
 }
 
-// HandleVideoTrack reads H264 RTP packets and writes Annex B NAL units to the pipe.
-func (w *PipeH264Writer) HandleVideoTrack(track *webrtc.TrackRemote, ctx context.Context) {
-	builder := samplebuilder.New(128, &codecs.H264Packet{}, track.Codec().ClockRate)
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-
-		pkt, _, err := track.ReadRTP()
-		if err != nil {
-			return
-		}
+// Progress returns frames, bytes and keyframes written, with elapsed time
+// measured from start.
+func (w *H264Writer) Progress(start time.Time) Progress {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Progress{
+		Frames:    w.frames,
+		Bytes:     w.bytes,
+		Keyframes: w.keyframes,
+		Elapsed:   time.Since(start),
+		Dropped:   w.dropped,
+		Blocked:   w.blocked,
+	}
+}
 
-		builder.Push(pkt)
-		for {
-			sample := builder.Pop()
-			if sample == nil {
-				break
-			}
-			if _, err := w.W.Write(sample.Data); err != nil {
-				return
-			}
-		}
+// Close closes the file.
+func (w *H264Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		err := w.file.Close()
+		w.file = nil
+		return err
 	}
+	return nil
 }
 
 // TakeSnapshot captures a JPEG frame from a WebRTC camera stream.
-// It writes raw H264 to a temp file and uses ffmpeg to extract a frame.
-func TakeSnapshot(outputPath string, startStream func(ctx context.Context, handler func(*webrtc.TrackRemote, *webrtc.RTPReceiver)) error) error {
-	if _, err := exec.LookPath("ffmpeg"); err != nil {
-		return fmt.Errorf("ffmpeg is required for snapshots; install it with: brew install ffmpeg")
+// It writes raw H264 to a temp file, starting at the first IDR frame, and
+// uses ffmpeg to extract that frame as soon as it arrives.
+func TakeSnapshot(outputPath string, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts ...Option) error {
+	o := buildOptions(opts)
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	clip := ext == ".webm" || ext == ".mkv"
+
+	if _, err := FindTool("ffmpeg", o.ffmpegPath); err != nil && o.needsFFmpeg(ext) {
+		return fmt.Errorf("ffmpeg is required for snapshots: %w", err)
 	}
 
 	tmpH264 := outputPath + ".tmp.h264"
-	defer os.Remove(tmpH264)
+	proc.AddTemp(tmpH264)
+	defer proc.RemoveTemp(tmpH264)
 
 	h264w, err := NewH264Writer(tmpH264)
 	if err != nil {
 		return fmt.Errorf("creating temp file: %w", err)
 	}
+	// A WebM or MKV "snapshot" is a short clip, so it keeps every frame.
+	h264w.skipToKeyframe = !clip
+	h264w.onStream = o.streamInfo
+	h264w.log = o.log
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(o.context(), 30*time.Second)
 	defer cancel()
 
 	gotVideo := make(chan struct{}, 1)
 
-	err = startStream(ctx, func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	err = startStream(ctx, func(track nestwebrtc.Track) {
 		if strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264) {
 			select {
 			case gotVideo <- struct{}{}:
@@ -188,95 +461,154 @@ func TakeSnapshot(outputPath string, startStream func(ctx context.Context, handl
 	// Wait for video track, then collect a few seconds of frames
 	select {
 	case <-gotVideo:
-		fmt.Println("Receiving video, capturing frames...")
+		o.start = time.Now()
+		o.logger().Info("Receiving video, capturing frames...")
 	case <-ctx.Done():
 		h264w.Close()
 		return fmt.Errorf("timed out waiting for video track")
 	}
 
-	// Wait until we have some frames, up to 5 seconds
+	stopProgress := o.startProgress(h264w)
+	waitForSnapshot(ctx, h264w, h264w.skipToKeyframe)
+	stopProgress()
+	h264w.Close()
+	if err := h264w.writeErr(); err != nil {
+		return err
+	}
+
+	// Use ffmpeg to extract a JPEG from the raw H264 stream
+	return o.mux(outputPath, func(dst string) error {
+		if clip {
+			return convertClip(o, ext, tmpH264, h264w.spans(), "", dst)
+		}
+		return h264ToJPEG(o, tmpH264, dst)
+	})
+}
+
+// waitForSnapshot waits until w has a frame worth extracting: the first IDR
+// frame when keyframe is set, otherwise about 30 frames. Either way it gives
+// up after 5 seconds and lets ffmpeg try with what arrived, or as soon as
+// ctx is done or a write fails.
+func waitForSnapshot(ctx context.Context, w *H264Writer, keyframe bool) {
 	deadline := time.After(5 * time.Second)
-	ticker := time.NewTicker(200 * time.Millisecond)
+	ticker := time.NewTicker(50 * time.Millisecond)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-deadline:
-			goto extract
+			return
+		case <-ctx.Done():
+			return
+		case <-w.failed:
+			return
 		case <-ticker.C:
-			if h264w.Frames() >= 30 {
-				goto extract
+			if (keyframe && w.Keyframes() > 0) || (!keyframe && w.Frames() >= 30) {
+				return
 			}
 		}
 	}
-
-extract:
-	h264w.Close()
-
-	// Use ffmpeg to extract a JPEG from the raw H264 stream
-	ext := strings.ToLower(filepath.Ext(outputPath))
-	if ext == ".webm" {
-		return h264ToWebM(tmpH264, outputPath)
-	}
-
-	return h264ToJPEG(tmpH264, outputPath)
 }
 
-func h264ToJPEG(h264Path, jpegPath string) error {
-	cmd := exec.Command("ffmpeg",
-		"-y",
-		"-f", "h264",
-		"-i", h264Path,
-		"-frames:v", "1",
-		"-q:v", "2",
-		jpegPath,
-	)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("ffmpeg conversion failed: %w\n%s", err, string(output))
+func h264ToJPEG(o options, h264Path, jpegPath string) error {
+	args := append([]string{"-y", "-f", "h264"}, o.decodeArgs()...)
+	args = append(args, "-i", h264Path, "-frames:v", "1")
+	args = append(append(args, o.imageArgs()...), jpegPath)
+	cmd, err := o.ffmpeg(args...)
+	if err != nil {
+		return err
+	}
+	cmd.Timeout = convertTimeout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg conversion failed: %w", err)
 	}
 	return nil
 }
 
-func h264ToWebM(h264Path, webmPath string) error {
-	cmd := exec.Command("ffmpeg",
-		"-y",
-		"-f", "h264",
-		"-i", h264Path,
-		"-c:v", "copy",
-		webmPath,
-	)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("ffmpeg conversion failed: %w\n%s", err, string(output))
-	}
-	return nil
+// h264ToWebM muxes like h264ToMP4 into a WebM, keeping the audio as Opus.
+func h264ToWebM(o options, h264Path string, spans []frameSpan, audioPath, webmPath string) error {
+	return ffmpegMux(o, h264Path, spans, audioPath, webmPath, true, false)
 }
 
 // RecordClip records a WebRTC stream to a file using ffmpeg for muxing.
 // Duration is how long to record. Output format is determined by file extension.
-func RecordClip(outputPath string, duration time.Duration, startStream func(ctx context.Context, handler func(*webrtc.TrackRemote, *webrtc.RTPReceiver)) error) error {
-	if _, err := exec.LookPath("ffmpeg"); err != nil {
-		return fmt.Errorf("ffmpeg is required for recording; install it with: brew install ffmpeg")
+func RecordClip(outputPath string, duration time.Duration, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts ...Option) error {
+	return recordClip(outputPath, duration, sleepFor(duration), startStream, buildOptions(opts))
+}
+
+// RecordUntilQuiet records like RecordClip, but keeps recording while activity
+// keeps being signalled. Recording stops once no signal has arrived for quiet,
+// or after maxDuration in total.
+func RecordUntilQuiet(outputPath string, quiet, maxDuration time.Duration, activity <-chan struct{}, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts ...Option) error {
+	return recordClip(outputPath, maxDuration, func(ctx context.Context, w *H264Writer) {
+		limit := time.After(maxDuration)
+		timer := time.NewTimer(quiet)
+		defer timer.Stop()
+		for {
+			select {
+			case <-activity:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(quiet)
+			case <-timer.C:
+				return
+			case <-limit:
+				return
+			case <-ctx.Done():
+				return
+			case <-w.failed:
+				return
+			}
+		}
+	}, startStream, buildOptions(opts))
+}
+
+// recordClip starts the stream, calls wait once video arrives, then muxes
+// whatever was captured. maxDuration bounds the overall stream lifetime. wait
+// should return early when ctx is done or the writer fails.
+func recordClip(outputPath string, maxDuration time.Duration, wait func(context.Context, *H264Writer), startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, o options) error {
+	ext := strings.ToLower(filepath.Ext(outputPath))
+	if _, err := FindTool("ffmpeg", o.ffmpegPath); err != nil && o.needsFFmpeg(ext) {
+		return fmt.Errorf("ffmpeg is required for recording: %w", err)
 	}
 
 	tmpH264 := outputPath + ".tmp.h264"
-	defer os.Remove(tmpH264)
+	proc.AddTemp(tmpH264)
+	defer proc.RemoveTemp(tmpH264)
 
 	h264w, err := NewH264Writer(tmpH264)
 	if err != nil {
 		return fmt.Errorf("creating temp file: %w", err)
 	}
+	h264w.onFirst = o.firstFrame
+	h264w.onStream = o.streamInfo
+	h264w.log = o.log
+
+	audio, tmpAudio, err := o.newAudioWriter(outputPath)
+	if err != nil {
+		h264w.Close()
+		return err
+	}
+	if audio != nil {
+		defer proc.RemoveTemp(tmpAudio)
+		defer audio.Close()
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), duration+15*time.Second)
+	ctx, cancel := context.WithTimeout(o.context(), maxDuration+15*time.Second)
 	defer cancel()
 
 	gotVideo := make(chan struct{}, 1)
 
-	err = startStream(ctx, func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		if strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264) {
+	err = startStream(ctx, func(track nestwebrtc.Track) {
+		switch {
+		case strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264):
 			select {
 			case gotVideo <- struct{}{}:
 			default:
 			}
 			h264w.HandleVideoTrack(track, ctx)
+		case audio != nil && strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeOpus):
+			audio.HandleAudioTrack(track, ctx)
 		}
 	})
 	if err != nil {
@@ -287,33 +619,173 @@ func RecordClip(outputPath string, duration time.Duration, startStream func(ctx
 	// Wait for video then record for the requested duration
 	select {
 	case <-gotVideo:
-		fmt.Println("Receiving video, recording...")
+		o.start = time.Now()
+		o.logger().Info("Receiving video, recording...")
 	case <-ctx.Done():
 		h264w.Close()
 		return fmt.Errorf("timed out waiting for video track")
 	}
 
-	time.Sleep(duration)
+	stopProgress := o.startProgress(h264w)
+
+	wait(ctx, h264w)
+	stopProgress()
 	h264w.Close()
+	audioPath := finishAudio(audio, tmpAudio)
+	if err := h264w.writeErr(); err != nil {
+		return err
+	}
 
 	// Mux with ffmpeg
+	return o.mux(outputPath, func(dst string) error {
+		return convertClip(o, ext, tmpH264, h264w.spans(), audioPath, dst)
+	})
+}
+
+// stallTimeout is how long RecordUntil waits without new frames before it
+// treats the stream as dead and reconnects.
+const stallTimeout = 20 * time.Second
+
+// RecordUntil records like RecordClip until stop is closed, with no time
+// limit. When the stream ends or stalls, e.g. because the SDM session could
+// not be extended any further, a new one is started and appended to the same
+// file.
+func RecordUntil(outputPath string, stop <-chan struct{}, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts ...Option) error {
+	o := buildOptions(opts)
 	ext := strings.ToLower(filepath.Ext(outputPath))
-	if ext == ".mp4" {
-		return h264ToMP4(tmpH264, outputPath)
+
+	if _, err := FindTool("ffmpeg", o.ffmpegPath); err != nil && o.needsFFmpeg(ext) {
+		return fmt.Errorf("ffmpeg is required for recording: %w", err)
+	}
+
+	tmpH264 := outputPath + ".tmp.h264"
+	proc.AddTemp(tmpH264)
+	defer proc.RemoveTemp(tmpH264)
+
+	h264w, err := NewH264Writer(tmpH264)
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	h264w.onStream = o.streamInfo
+	h264w.log = o.log
+	audio, tmpAudio, err := o.newAudioWriter(outputPath)
+	if err != nil {
+		h264w.Close()
+		return err
+	}
+	if audio != nil {
+		defer proc.RemoveTemp(tmpAudio)
+		defer audio.Close()
+	}
+
+	// open starts a stream and waits for video. ended fires when its video
+	// track stops; cancel closes the stream.
+	open := func() (cancel context.CancelFunc, ended <-chan struct{}, err error) {
+		ctx, cancel := context.WithCancel(o.context())
+		gotVideo := make(chan struct{}, 1)
+		trackEnded := make(chan struct{}, 1)
+		err = startStream(ctx, func(track nestwebrtc.Track) {
+			switch {
+			case strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264):
+				select {
+				case gotVideo <- struct{}{}:
+				default:
+				}
+				h264w.HandleVideoTrack(track, ctx)
+				trackEnded <- struct{}{}
+			case audio != nil && strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeOpus):
+				audio.HandleAudioTrack(track, ctx)
+			}
+		})
+		if err != nil {
+			cancel()
+			return nil, nil, fmt.Errorf("starting stream: %w", err)
+		}
+		select {
+		case <-gotVideo:
+			return cancel, trackEnded, nil
+		case <-time.After(30 * time.Second):
+			cancel()
+			return nil, nil, fmt.Errorf("timed out waiting for video track")
+		}
 	}
-	return h264ToWebM(tmpH264, outputPath)
+
+	cancel, ended, err := open()
+	if err != nil {
+		h264w.Close()
+		return err
+	}
+	o.start = time.Now()
+	o.logger().Info("Receiving video, recording until stopped...")
+
+	stopProgress := o.startProgress(h264w)
+	stall := time.NewTicker(stallTimeout)
+	defer stall.Stop()
+	frames := 0
+
+record:
+	for {
+		select {
+		case <-stop:
+			break record
+		case <-o.context().Done():
+			break record
+		case <-h264w.failed:
+			break record
+		case <-ended:
+			o.logger().Info("Stream ended, reconnecting...")
+		case <-stall.C:
+			if n := h264w.Frames(); n != frames {
+				frames = n
+				continue
+			}
+			o.logger().Info("Stream stalled, reconnecting...")
+		}
+
+		cancel()
+		for {
+			if cancel, ended, err = open(); err == nil {
+				break
+			}
+			o.logger().Warn("reconnecting failed", "err", err)
+			select {
+			case <-stop:
+				cancel = func() {}
+				break record
+			case <-time.After(5 * time.Second):
+			}
+		}
+		frames = h264w.Frames()
+		stall.Reset(stallTimeout)
+	}
+
+	cancel()
+	stopProgress()
+	h264w.Close()
+	audioPath := finishAudio(audio, tmpAudio)
+	if err := h264w.writeErr(); err != nil {
+		return err
+	}
+
+	return o.mux(outputPath, func(dst string) error {
+		return convertClip(o, ext, tmpH264, h264w.spans(), audioPath, dst)
+	})
 }
 
-func h264ToMP4(h264Path, mp4Path string) error {
-	cmd := exec.Command("ffmpeg",
-		"-y",
-		"-f", "h264",
-		"-i", h264Path,
-		"-c:v", "copy",
-		mp4Path,
-	)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("ffmpeg conversion failed: %w\n%s", err, string(output))
+// convertClip muxes a captured clip by the output's extension ext: MP4, MKV
+// or otherwise WebM.
+func convertClip(o options, ext, h264Path string, spans []frameSpan, audioPath, dst string) error {
+	switch ext {
+	case ".mp4":
+		return h264ToMP4(o, h264Path, spans, audioPath, dst)
+	case ".mkv":
+		return h264ToMKV(o, h264Path, spans, audioPath, dst)
 	}
-	return nil
+	return h264ToWebM(o, h264Path, spans, audioPath, dst)
+}
+
+// h264ToMP4 muxes the raw H264 at h264Path, timed by spans, plus the
+// Ogg/Opus audio at audioPath unless it's "", into an MP4.
+func h264ToMP4(o options, h264Path string, spans []frameSpan, audioPath, mp4Path string) error {
+	return ffmpegMux(o, h264Path, spans, audioPath, mp4Path, false, true)
 }
@@ -0,0 +1,65 @@
+package recorder
+
+import (
+	"sync"
+
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+	"github.com/pion/rtp"
+)
+
+// rtpBufferSize fits any RTP packet on a 1500-byte MTU path.
+const rtpBufferSize = 1500
+
+// packetPool recycles RTP packets and their buffers across tracks, so long
+// recordings from several cameras don't allocate for every packet.
+var packetPool = sync.Pool{
+	New: func() any { return &pooledPacket{buf: make([]byte, rtpBufferSize)} },
+}
+
+type pooledPacket struct {
+	pkt rtp.Packet
+	buf []byte
+}
+
+// packetReader reads a track's RTP packets into pooled buffers. It isn't
+// safe for concurrent use: packets given to a samplebuilder come back
+// through release, its packet release handler, from the same goroutine.
+type packetReader struct {
+	track nestwebrtc.Track
+	held  map[uint16]*pooledPacket
+}
+
+func newPacketReader(track nestwebrtc.Track) *packetReader {
+	return &packetReader{track: track, held: make(map[uint16]*pooledPacket)}
+}
+
+// read returns the next packet, valid until it's released. A retransmitted
+// copy of a packet still held is skipped, as a samplebuilder would only
+// overwrite the original with it.
+func (r *packetReader) read() (*rtp.Packet, error) {
+	for {
+		p := packetPool.Get().(*pooledPacket)
+		n, _, err := r.track.Read(p.buf)
+		if err == nil {
+			err = p.pkt.Unmarshal(p.buf[:n])
+		}
+		if err != nil {
+			packetPool.Put(p)
+			return nil, err
+		}
+		if _, dup := r.held[p.pkt.SequenceNumber]; dup {
+			packetPool.Put(p)
+			continue
+		}
+		r.held[p.pkt.SequenceNumber] = p
+		return &p.pkt, nil
+	}
+}
+
+// release returns a packet from read to the pool.
+func (r *packetReader) release(pkt *rtp.Packet) {
+	if p, ok := r.held[pkt.SequenceNumber]; ok && &p.pkt == pkt {
+		delete(r.held, pkt.SequenceNumber)
+		packetPool.Put(p)
+	}
+}
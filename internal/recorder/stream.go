@@ -0,0 +1,154 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+	"github.com/pion/webrtc/v4"
+)
+
+// Container formats accepted by RecordClipTo.
+const (
+	FormatMP4      = "mp4"      // fragmented MP4, playable while streaming
+	FormatMatroska = "matroska" // MKV
+	FormatH264     = "h264"     // raw Annex B, no ffmpeg needed
+)
+
+// RecordClipTo records like RecordClip but streams the muxed clip to w without
+// touching local disk, e.g. straight into an HTTP or S3 upload body. MP4
+// output is fragmented because w is not seekable.
+func RecordClipTo(w io.Writer, format string, duration time.Duration, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts ...Option) error {
+	o := buildOptions(opts)
+
+	if format == FormatH264 {
+		return captureTo(nopWriteCloser{w}, duration, sleepFor(duration), startStream, o)
+	}
+
+	in, args := o.videoArgs(false, time.Time{})
+	switch format {
+	case FormatMP4:
+		args = append(args, "-movflags", "frag_keyframe+empty_moov+default_base_moof", "-f", "mp4")
+	case FormatMatroska:
+		args = append(args, "-f", "matroska")
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+
+	return pipeThroughFFmpeg(o, w, in, args, func(stdin io.WriteCloser) error {
+		return captureTo(stdin, duration, sleepFor(duration), startStream, o)
+	})
+}
+
+// TakeSnapshotTo captures a single JPEG frame, the first IDR frame, and
+// writes it to w.
+func TakeSnapshotTo(w io.Writer, startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, opts ...Option) error {
+	o := buildOptions(opts)
+	o.skipToKeyframe = true
+
+	args := append([]string{"-frames:v", "1"}, o.imageArgs()...)
+	args = append(args, "-f", "image2", "-c:v", "mjpeg")
+	return pipeThroughFFmpeg(o, w, o.decodeArgs(), args, func(stdin io.WriteCloser) error {
+		return captureTo(stdin, 30*time.Second, func(ctx context.Context, h264w *H264Writer) {
+			waitForSnapshot(ctx, h264w, true)
+		}, startStream, o)
+	})
+}
+
+// pipeThroughFFmpeg runs ffmpeg reading raw H264 on stdin and writing to w
+// with inArgs before the input and outArgs after it, while feed writes the
+// stream into stdin.
+func pipeThroughFFmpeg(o options, w io.Writer, inArgs, outArgs []string, feed func(stdin io.WriteCloser) error) error {
+	args := append([]string{"-hide_banner", "-loglevel", "error", "-f", "h264"}, inArgs...)
+	args = append(append(args, "-i", "pipe:0"), outArgs...)
+	args = append(args, "pipe:1")
+	cmd, err := o.ffmpeg(args...)
+	if err != nil {
+		return fmt.Errorf("ffmpeg is required: %w", err)
+	}
+	cmd.Stdout = w
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	feedErr := feed(stdin)
+	stdin.Close()
+	waitErr := cmd.Wait()
+
+	if feedErr != nil {
+		return feedErr
+	}
+	return waitErr
+}
+
+// captureTo starts the stream, writes Annex B samples to wc until wait
+// returns, then closes wc. It returns the first error writing to wc, or
+// the context's error if the capture was cancelled.
+func captureTo(wc io.WriteCloser, maxDuration time.Duration, wait func(context.Context, *H264Writer), startStream func(ctx context.Context, handler func(nestwebrtc.Track)) error, o options) error {
+	h264w := NewH264WriterTo(wc)
+	h264w.skipToKeyframe = o.skipToKeyframe
+	h264w.onStream = o.streamInfo
+
+	ctx, cancel := context.WithTimeout(o.context(), maxDuration+15*time.Second)
+	defer cancel()
+
+	gotVideo := make(chan struct{}, 1)
+	err := startStream(ctx, func(track nestwebrtc.Track) {
+		if strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeH264) {
+			select {
+			case gotVideo <- struct{}{}:
+			default:
+			}
+			h264w.HandleVideoTrack(track, ctx)
+		}
+	})
+	if err != nil {
+		h264w.Close()
+		return fmt.Errorf("starting stream: %w", err)
+	}
+
+	select {
+	case <-gotVideo:
+	case <-ctx.Done():
+		h264w.Close()
+		return fmt.Errorf("timed out waiting for video track")
+	}
+
+	stopProgress := o.startProgress(h264w)
+	wait(ctx, h264w)
+	stopProgress()
+	closeErr := h264w.Close()
+	if err := h264w.writeErr(); err != nil {
+		return err
+	}
+	if err := o.context().Err(); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// sleepFor returns a wait function that waits for d, or until ctx is done
+// or the writer fails.
+func sleepFor(d time.Duration) func(context.Context, *H264Writer) {
+	return func(ctx context.Context, w *H264Writer) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		case <-w.failed:
+		}
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
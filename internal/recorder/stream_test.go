@@ -0,0 +1,97 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	nestwebrtc "github.com/brice/gognestcli/internal/webrtc"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// fakeTrack is an endless H264 track of single-NAL keyframes, one every
+// few milliseconds.
+type fakeTrack struct {
+	seq uint16
+	ts  uint32
+}
+
+func (t *fakeTrack) Read(b []byte) (int, interceptor.Attributes, error) {
+	time.Sleep(2 * time.Millisecond)
+	t.seq++
+	t.ts += 3000
+	pkt := rtp.Packet{
+		Header:  rtp.Header{Version: 2, Marker: true, PayloadType: 96, SequenceNumber: t.seq, Timestamp: t.ts},
+		Payload: []byte{0x65, 0x88, 0x84, 0x00},
+	}
+	n, err := pkt.MarshalTo(b)
+	return n, nil, err
+}
+
+func (t *fakeTrack) Codec() webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}}
+}
+
+func (t *fakeTrack) Kind() webrtc.RTPCodecType { return webrtc.RTPCodecTypeVideo }
+
+func fakeStream(ctx context.Context, handler func(nestwebrtc.Track)) error {
+	go handler(&fakeTrack{})
+	return nil
+}
+
+// failingWriter accepts a few writes, then fails.
+type failingWriter struct {
+	writes atomic.Int32
+	err    error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.writes.Add(1) > 3 {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+func (w *failingWriter) Close() error { return nil }
+
+func TestCaptureToWriteError(t *testing.T) {
+	errFull := errors.New("no space left on device")
+	w := &failingWriter{err: errFull}
+	start := time.Now()
+	err := captureTo(w, time.Minute, sleepFor(time.Minute), fakeStream, buildOptions(nil))
+	if !errors.Is(err, errFull) {
+		t.Errorf("captureTo error = %v, want the write error", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("captureTo took %s after the writer failed", d)
+	}
+	if n := w.writes.Load(); n != 4 {
+		t.Errorf("%d writes, want 4: none after the failure", n)
+	}
+}
+
+func TestRecordClipToH264WriteError(t *testing.T) {
+	errClosed := io.ErrClosedPipe
+	err := RecordClipTo(&failingWriter{err: errClosed}, FormatH264, time.Minute, fakeStream)
+	if !errors.Is(err, errClosed) {
+		t.Errorf("RecordClipTo error = %v, want the write error", err)
+	}
+}
+
+func TestCaptureToCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err := RecordClipTo(io.Discard, FormatH264, time.Minute, fakeStream, WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RecordClipTo error = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("cancelled capture took %s", d)
+	}
+}
@@ -0,0 +1,174 @@
+package recorder
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/proc"
+)
+
+// ErrCorrupt is returned (wrapped) when a capture fails verification.
+var ErrCorrupt = errors.New("output failed verification")
+
+// WithVerify checks every output after muxing and re-runs the mux once if it
+// looks broken. See Verify.
+func WithVerify() Option {
+	return func(o *options) { o.verify = true }
+}
+
+// mux runs convert into a partial file next to outputPath and renames it into
+// place when done. With WithVerify, the partial file is checked first and the
+// conversion retried once if it fails verification.
+func (o options) mux(outputPath string, convert func(dst string) error) error {
+	tmp := PartialPath(outputPath)
+	proc.AddTemp(tmp)
+	defer proc.RemoveTemp(tmp)
+
+	if err := convert(tmp); err != nil {
+		return err
+	}
+	if o.verify {
+		if err := Verify(tmp, o.ffmpegPath); err != nil {
+			o.logger().Warn("output failed verification, retrying", "err", err)
+			if err := convert(tmp); err != nil {
+				return err
+			}
+			if err := Verify(tmp, o.ffmpegPath); err != nil {
+				return err
+			}
+		}
+	}
+	return commit(tmp, outputPath, o.doneMarker, !o.noReplace)
+}
+
+// Verify checks that a capture is usable. JPEGs must decode; MP4s must contain
+// a moov atom; and when ffprobe is available, clips must have a video stream
+// and a nonzero duration. ffprobe is looked up next to ffmpegPath first.
+func Verify(path, ffmpegPath string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("%w: %s is empty", ErrCorrupt, path)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return verifyJPEG(path)
+	case ".mp4", ".m4v", ".mov":
+		if err := verifyMoov(path); err != nil {
+			return err
+		}
+	}
+
+	ffprobe, err := findFFprobe(ffmpegPath)
+	if err != nil {
+		return nil // structural checks only
+	}
+	return probe(ffprobe, path)
+}
+
+func verifyJPEG(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := jpeg.DecodeConfig(f); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCorrupt, path, err)
+	}
+	return nil
+}
+
+// verifyMoov walks the top-level MP4 boxes looking for moov, which a mux
+// that died halfway never writes.
+func verifyMoov(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var header [16]byte
+	var offset int64
+	for {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("%w: %s has no moov atom", ErrCorrupt, path)
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		if string(header[4:8]) == "moov" {
+			return nil
+		}
+		switch size {
+		case 0: // box runs to end of file
+			return fmt.Errorf("%w: %s has no moov atom", ErrCorrupt, path)
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return fmt.Errorf("%w: %s: truncated box", ErrCorrupt, path)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return fmt.Errorf("%w: %s: invalid box size", ErrCorrupt, path)
+		}
+		offset += size
+	}
+}
+
+func findFFprobe(ffmpegPath string) (string, error) {
+	if ffmpegPath != "" {
+		sibling := filepath.Join(filepath.Dir(ffmpegPath), "ffprobe"+filepath.Ext(ffmpegPath))
+		if _, err := os.Stat(sibling); err == nil {
+			return sibling, nil
+		}
+	}
+	return FindTool("ffprobe", "")
+}
+
+func probe(ffprobe, path string) error {
+	cmd := proc.Command(context.Background(), ffprobe,
+		"-v", "error",
+		"-select_streams", "v",
+		"-show_entries", "stream=codec_type:format=duration",
+		"-of", "json",
+		path,
+	)
+	cmd.Timeout = time.Minute
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCorrupt, path, err)
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fmt.Errorf("parsing ffprobe output: %w", err)
+	}
+	if len(result.Streams) == 0 {
+		return fmt.Errorf("%w: %s has no video stream", ErrCorrupt, path)
+	}
+	if d, err := strconv.ParseFloat(result.Format.Duration, 64); err != nil || d <= 0 {
+		return fmt.Errorf("%w: %s has no duration", ErrCorrupt, path)
+	}
+	return nil
+}
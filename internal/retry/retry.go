@@ -0,0 +1,249 @@
+// Package retry keeps captures that failed on a transient error (network,
+// server or quota) in a persisted queue, so the events command can try them
+// again with backoff, including after a restart.
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/pubsub"
+	"github.com/brice/gognestcli/internal/quota"
+)
+
+// Capture kinds.
+const (
+	KindSnapshot = "snapshot"
+	KindClip     = "clip"
+)
+
+// MaxAttempts is how many times an item is retried before it's dropped.
+const MaxAttempts = 6
+
+// Backoff bounds: the first retry waits minBackoff, doubling up to maxBackoff.
+const (
+	minBackoff = 5 * time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// Item is a failed capture waiting to be retried.
+type Item struct {
+	ID        string        `json:"id"`
+	Kind      string        `json:"kind"`
+	Event     pubsub.Event  `json:"event"`
+	Seq       int64         `json:"seq"`
+	Duration  time.Duration `json:"duration,omitempty"` // clip length; 0 uses the default
+	Attempts  int           `json:"attempts"`           // retries made so far
+	Next      time.Time     `json:"next"`
+	Expires   time.Time     `json:"expires"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+// file is the queue file's contents: items per capture output directory, so
+// several events processes can share one file.
+type file struct {
+	Queues map[string][]Item `json:"queues"`
+}
+
+// Queue is the retry queue for one output directory.
+type Queue struct {
+	mu     sync.Mutex
+	path   string
+	key    string
+	maxAge time.Duration
+	items  []Item
+}
+
+// Open loads the queue for outputDir from path (in memory only when path is
+// empty). Items are kept for at most maxAge after they're first queued.
+func Open(path, outputDir string, maxAge time.Duration) *Queue {
+	key, err := filepath.Abs(outputDir)
+	if err != nil {
+		key = outputDir
+	}
+	q := &Queue{path: path, key: key, maxAge: maxAge}
+	q.items = q.read().Queues[key]
+	return q
+}
+
+// Add queues a failed capture for its first retry.
+func (q *Queue) Add(item Item, cause error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	item.ID = fmt.Sprintf("%d-%s-%d", now.UnixNano(), item.Kind, item.Seq)
+	item.Expires = now.Add(q.maxAge)
+	item.Next = now.Add(delay(0, cause))
+	item.LastError = cause.Error()
+	q.items = append(q.items, item)
+	q.save()
+}
+
+// Due returns the items whose next attempt is at or before now, dropping
+// expired ones.
+func (q *Queue) Due(now time.Time) (due, expired []Item) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.items)
+	q.items = slices.DeleteFunc(q.items, func(it Item) bool {
+		if now.After(it.Expires) {
+			expired = append(expired, it)
+			return true
+		}
+		return false
+	})
+	for _, it := range q.items {
+		if !now.Before(it.Next) {
+			due = append(due, it)
+		}
+	}
+	if len(q.items) != n {
+		q.save()
+	}
+	return due, expired
+}
+
+// Done removes an item after a successful retry.
+func (q *Queue) Done(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.remove(id)
+	q.save()
+}
+
+// Failed records another failed attempt. It reports when the item will be
+// tried again, or false if it was dropped because err isn't transient or it
+// ran out of attempts or time.
+func (q *Queue) Failed(id string, err error) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.save()
+	i := slices.IndexFunc(q.items, func(it Item) bool { return it.ID == id })
+	if i < 0 {
+		return time.Time{}, false
+	}
+	it := &q.items[i]
+	it.Attempts++
+	it.LastError = err.Error()
+	it.Next = time.Now().Add(delay(it.Attempts, err))
+	if !Transient(err) || it.Attempts >= MaxAttempts || it.Next.After(it.Expires) {
+		q.remove(id)
+		return time.Time{}, false
+	}
+	return it.Next, true
+}
+
+// Len returns the number of queued items.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *Queue) remove(id string) {
+	q.items = slices.DeleteFunc(q.items, func(it Item) bool { return it.ID == id })
+}
+
+// delay is the wait before retry number attempt+1: exponential backoff, or
+// the budget's retry time if the call was refused by it.
+func delay(attempt int, err error) time.Duration {
+	d := minBackoff << min(attempt, 10)
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	var qe *quota.ExceededError
+	if errors.As(err, &qe) && qe.RetryAfter > d {
+		d = qe.RetryAfter
+	}
+	return d
+}
+
+// Transient reports whether a capture that failed with err may succeed if
+// tried again: network errors, timeouts, server errors, rate limiting and
+// the local call budget. Other API errors (the event has expired, missing
+// permissions) and local problems are permanent.
+func Transient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var qe *quota.ExceededError
+	if errors.As(err, &qe) {
+		return true
+	}
+	msg := err.Error()
+	for _, code := range []string{"408", "429", "500", "502", "503", "504"} {
+		if strings.Contains(msg, "returned "+code) {
+			return true
+		}
+	}
+	if strings.Contains(msg, "returned 4") {
+		return false
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// Streams that never produced video or couldn't connect (ICE) are
+	// usually a network problem.
+	return strings.Contains(msg, "timed out") || strings.Contains(msg, "starting stream")
+}
+
+// lock takes the queue file's lock for a read-modify-write and returns its
+// release, so processes sharing the file don't drop each other's items. If
+// it can't be had, the save goes ahead unlocked.
+func (q *Queue) lock() (unlock func()) {
+	unlock, err := config.LockFile(q.path + ".lock")
+	if err != nil {
+		return func() {}
+	}
+	return unlock
+}
+
+// read loads the queue file, returning an empty one if it's missing or
+// unreadable.
+func (q *Queue) read() file {
+	f := file{Queues: map[string][]Item{}}
+	if q.path == "" {
+		return f
+	}
+	data, err := os.ReadFile(q.path)
+	if err != nil {
+		return f
+	}
+	if json.Unmarshal(data, &f) != nil || f.Queues == nil {
+		return file{Queues: map[string][]Item{}}
+	}
+	return f
+}
+
+// save writes this directory's items back, keeping other directories'
+// queues. Errors are ignored: the queue still works in memory. Callers hold
+// mu.
+func (q *Queue) save() {
+	if q.path == "" {
+		return
+	}
+	defer q.lock()()
+	f := q.read()
+	if len(q.items) == 0 {
+		delete(f.Queues, q.key)
+	} else {
+		f.Queues[q.key] = q.items
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	config.WriteFileAtomic(q.path, data)
+}
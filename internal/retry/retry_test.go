@@ -0,0 +1,152 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brice/gognestcli/internal/quota"
+)
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"budget", fmt.Errorf("snapshot: %w", &quota.ExceededError{Scope: "project"}), true},
+		{"rate limited", errors.New("GenerateImage returned 429: RESOURCE_EXHAUSTED"), true},
+		{"server error", errors.New("executeCommand returned 503: unavailable"), true},
+		{"request timeout", errors.New("API returned 408: timeout"), true},
+		{"expired event", errors.New("GenerateImage returned 400: event expired"), false},
+		{"forbidden", errors.New("API returned 403: permission denied"), false},
+		{"not found", errors.New("API returned 404: device not found"), false},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"cut short", fmt.Errorf("downloading image: %w", io.ErrUnexpectedEOF), true},
+		{"deadline", fmt.Errorf("waiting: %w", context.DeadlineExceeded), true},
+		{"no video", errors.New("timed out waiting for video"), true},
+		{"ice", errors.New("starting stream: ICE failed"), true},
+		{"disk", &os.PathError{Op: "open", Path: "/x", Err: os.ErrPermission}, false},
+		{"canceled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := Transient(tt.err); got != tt.want {
+			t.Errorf("%s: Transient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	plain := errors.New("returned 503")
+	tests := []struct {
+		attempt int
+		err     error
+		want    time.Duration
+	}{
+		{0, plain, 5 * time.Second},
+		{1, plain, 10 * time.Second},
+		{5, plain, 160 * time.Second},
+		{6, plain, 5 * time.Minute},
+		{60, plain, 5 * time.Minute},
+		{0, &quota.ExceededError{RetryAfter: 40 * time.Second}, 40 * time.Second},
+		{3, &quota.ExceededError{RetryAfter: time.Second}, 40 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := delay(tt.attempt, tt.err); got != tt.want {
+			t.Errorf("delay(%d, %v) = %s, want %s", tt.attempt, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "retry.json")
+	transient := errors.New("API returned 503")
+
+	q := Open(path, filepath.Join(dir, "front"), time.Hour)
+	q.Add(Item{Kind: KindSnapshot, Seq: 1}, transient)
+	q.Add(Item{Kind: KindClip, Seq: 2}, transient)
+	other := Open(path, filepath.Join(dir, "back"), time.Hour)
+	other.Add(Item{Kind: KindSnapshot, Seq: 3}, transient)
+
+	// A restart sees the items, and each directory only its own.
+	q = Open(path, filepath.Join(dir, "front"), time.Hour)
+	if q.Len() != 2 {
+		t.Fatalf("reopened queue has %d items, want 2", q.Len())
+	}
+	if due, _ := q.Due(time.Now()); len(due) != 0 {
+		t.Errorf("%d items due before their backoff", len(due))
+	}
+	due, expired := q.Due(time.Now().Add(minBackoff))
+	if len(due) != 2 || len(expired) != 0 {
+		t.Fatalf("Due after backoff = %d due, %d expired", len(due), len(expired))
+	}
+
+	next, ok := q.Failed(due[0].ID, transient)
+	if !ok || time.Until(next) < minBackoff {
+		t.Errorf("Failed(transient) = %s, %v; want a retry after more backoff", next, ok)
+	}
+	if _, ok := q.Failed(due[1].ID, errors.New("API returned 400: expired")); ok {
+		t.Error("Failed(permanent) kept the item")
+	}
+	q.Done(due[0].ID)
+	if q.Len() != 0 {
+		t.Errorf("queue has %d items after Done, want 0", q.Len())
+	}
+	if n := Open(path, filepath.Join(dir, "back"), time.Hour).Len(); n != 1 {
+		t.Errorf("other directory's queue has %d items, want 1", n)
+	}
+
+	if _, expired := other.Due(time.Now().Add(2 * time.Hour)); len(expired) != 1 {
+		t.Errorf("Due past maxAge expired %d items, want 1", len(expired))
+	}
+}
+
+func TestQueueMaxAttempts(t *testing.T) {
+	q := Open("", t.TempDir(), 24*time.Hour)
+	q.Add(Item{Kind: KindSnapshot}, errors.New("returned 503"))
+	due, _ := q.Due(time.Now().Add(time.Hour))
+	for i := 1; i < MaxAttempts; i++ {
+		if _, ok := q.Failed(due[0].ID, errors.New("returned 503")); !ok {
+			t.Fatalf("dropped after %d attempts, want %d", i, MaxAttempts)
+		}
+	}
+	if _, ok := q.Failed(due[0].ID, errors.New("returned 503")); ok {
+		t.Errorf("kept after %d attempts", MaxAttempts)
+	}
+}
+
+func TestQueueSharedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "retry.json")
+	transient := errors.New("API returned 503")
+
+	// Queues of several directories, as separate processes would have,
+	// saving to the same file at once.
+	const dirs, items = 4, 20
+	var wg sync.WaitGroup
+	for d := range dirs {
+		q := Open(path, filepath.Join(dir, fmt.Sprint(d)), time.Hour)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				q.Add(Item{Kind: KindSnapshot, Seq: int64(i)}, transient)
+			}
+		}()
+	}
+	wg.Wait()
+	for d := range dirs {
+		if n := Open(path, filepath.Join(dir, fmt.Sprint(d)), time.Hour).Len(); n != items {
+			t.Errorf("queue %d has %d items in the file, want %d", d, n, items)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package schedule parses cron expressions and runs jobs on them.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week.
+type Schedule struct {
+	spec                     string
+	minute, hour, dom, month uint64 // bit i set when value i matches
+	dow                      uint64 // 0 = Sunday; 7 is folded into 0
+	domAny, dowAny           bool
+}
+
+var macros = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// Parse parses a cron expression such as "0 8 * * 1-5" or "*/15 * * * *".
+// Fields accept *, lists, ranges and steps; @hourly, @daily, @weekly,
+// @monthly and @yearly are also accepted.
+func Parse(spec string) (*Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if m, ok := macros[expr]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: want 5 fields (minute hour day month weekday)", spec)
+	}
+
+	s := &Schedule{spec: spec}
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: minute: %w", spec, err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: hour: %w", spec, err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of month: %w", spec, err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: month: %w", spec, err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of week: %w", spec, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return s, nil
+}
+
+func parseField(field string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+			step = n
+		}
+
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad range %q", part)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// String returns the expression the schedule was parsed from.
+func (s *Schedule) String() string { return s.spec }
+
+// Matches reports whether the minute containing t is an occurrence. As in
+// cron, when both day of month and day of week are restricted, either may
+// match.
+func (s *Schedule) Matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	domOK := s.dom&(1<<t.Day()) != 0
+	dowOK := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
+
+// Next returns the first occurrence after t, or the zero time if there is
+// none within five years (e.g. "0 0 31 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.Matches(time.Date(t.Year(), t.Month(), t.Day(), firstBit(s.hour), firstBit(s.minute), 0, 0, t.Location())):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Prev returns the latest occurrence at or before t, looking back no further
+// than window. ok is false if there is none.
+func (s *Schedule) Prev(t time.Time, window time.Duration) (time.Time, bool) {
+	start := t.Add(-window)
+	for m := t.Truncate(time.Minute); !m.Before(start); m = m.Add(-time.Minute) {
+		if s.Matches(m) {
+			return m, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func firstBit(bits uint64) int {
+	for i := 0; i < 64; i++ {
+		if bits&(1<<i) != 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// Loop calls run at every occurrence of s until ctx is done, passing how long
+// the job should last. Runs never overlap: occurrences that pass while run is
+// still busy are skipped. With catchUp, a window (occurrence plus length)
+// already open when Loop starts is run for its remainder; otherwise Loop
+// waits for the next occurrence.
+func Loop(ctx context.Context, s *Schedule, length time.Duration, catchUp bool, run func(length time.Duration)) {
+	if catchUp {
+		now := time.Now()
+		if prev, ok := s.Prev(now, length); ok && now.Before(prev.Add(length)) {
+			remaining := prev.Add(length).Sub(now)
+			fmt.Printf("Catching up on %s run from %s (%s left)\n", s, prev.Format("15:04"), remaining.Truncate(time.Second))
+			run(remaining)
+		}
+	}
+
+	for {
+		next := s.Next(time.Now())
+		if next.IsZero() {
+			fmt.Printf("Schedule %q never fires again\n", s)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		run(length)
+	}
+}
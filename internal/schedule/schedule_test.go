@@ -0,0 +1,91 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec, want string
+	}{
+		{"* * * *", "want 5 fields"},
+		{"* * * * * *", "want 5 fields"},
+		{"60 * * * *", "minute"},
+		{"* 24 * * *", "hour"},
+		{"* * 0 * *", "day of month"},
+		{"* * * 13 *", "month"},
+		{"* * * * 8", "day of week"},
+		{"*/0 * * * *", "bad step"},
+		{"5-1 * * * *", "out of range"},
+		{"a * * * *", "bad value"},
+		{"1-x * * * *", "bad range"},
+		{"@often", "want 5 fields"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want one containing %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		spec, from, want string
+	}{
+		{"* * * * *", "2026-03-10 08:00", "2026-03-10 08:01"},
+		{"*/15 * * * *", "2026-03-10 08:07", "2026-03-10 08:15"},
+		{"*/15 * * * *", "2026-03-10 08:59", "2026-03-10 09:00"},
+		{"0 8 * * *", "2026-03-10 08:00", "2026-03-11 08:00"},
+		{"30 8,20 * * *", "2026-03-10 09:00", "2026-03-10 20:30"},
+		{"0 8 * * 1-5", "2026-03-13 09:00", "2026-03-16 08:00"}, // Friday → Monday
+		{"0 0 * * 7", "2026-03-10 00:00", "2026-03-15 00:00"},   // 7 is Sunday
+		{"0 0 1 * *", "2026-12-15 00:00", "2027-01-01 00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"0 12 13 * 5", "2026-03-10 00:00", "2026-03-13 12:00"}, // day of month or Friday
+		{"0 12 1 * 2", "2026-03-02 00:00", "2026-03-03 12:00"},  // the Tuesday comes first
+		{"@hourly", "2026-03-10 08:30", "2026-03-10 09:00"},
+		{"@yearly", "2026-03-10 08:30", "2027-01-01 00:00"},
+		{"0 0 31 2 *", "2026-03-10 00:00", ""},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		got := s.Next(at(tt.from))
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q.Next(%s) = %s, want none", tt.spec, tt.from, got)
+			}
+			continue
+		}
+		if !got.Equal(at(tt.want)) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.spec, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestPrev(t *testing.T) {
+	s, err := Parse("0 8 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 10, 8, 20, 30, 0, time.UTC)
+	if got, ok := s.Prev(now, 30*time.Minute); !ok || !got.Equal(time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Prev within window = %s, %v", got, ok)
+	}
+	if _, ok := s.Prev(now, 10*time.Minute); ok {
+		t.Error("Prev found an occurrence outside the window")
+	}
+}
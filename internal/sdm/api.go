@@ -0,0 +1,28 @@
+package sdm
+
+import "encoding/json"
+
+// DeviceAPI lists, reads and commands devices.
+type DeviceAPI interface {
+	ListDevices() ([]Device, error)
+	GetDevice(name string) (*Device, error)
+	ExecuteCommand(deviceName, command string, params map[string]interface{}) (json.RawMessage, error)
+}
+
+// StreamAPI manages camera WebRTC streams and event images.
+type StreamAPI interface {
+	GenerateWebRTCStream(deviceName, offerSDP string) (*WebRTCStream, error)
+	ExtendWebRTCStream(deviceName, mediaSessionID string) (*WebRTCStream, error)
+	StopWebRTCStream(deviceName, mediaSessionID string) error
+	GenerateEventImage(deviceName, eventID string) (*EventImage, error)
+	DownloadEventImage(img *EventImage, outputPath string) error
+}
+
+// API is the whole SDM surface used by commands. *Client implements it;
+// tests and embedders can substitute fakes.
+type API interface {
+	DeviceAPI
+	StreamAPI
+}
+
+var _ API = (*Client)(nil)
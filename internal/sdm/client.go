@@ -2,31 +2,56 @@ package sdm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"time"
 )
 
-const baseURL = "https://smartdevicemanagement.googleapis.com/v1"
+// DefaultBaseURL is the production SDM API root.
+const DefaultBaseURL = "https://smartdevicemanagement.googleapis.com/v1"
 
 // Client is a lightweight SDM REST API client.
 type Client struct {
 	projectID  string
+	baseURL    string
 	httpClient *http.Client
 	token      func() (string, error)
+	ctx        context.Context
 }
 
 // NewClient creates a new SDM client. tokenFn is called to get a valid access token.
 func NewClient(projectID string, tokenFn func() (string, error)) *Client {
 	return &Client{
 		projectID:  projectID,
+		baseURL:    DefaultBaseURL,
 		httpClient: &http.Client{},
 		token:      tokenFn,
 	}
 }
 
+// SetBaseURL points the client at another SDM API root, such as the
+// in-process fake used by --mock or an httptest server.
+func (c *Client) SetBaseURL(url string) {
+	c.baseURL = url
+}
+
+// SetContext bounds every request made by the client by ctx, e.g. to a
+// command's deadline.
+func (c *Client) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 // Device represents a Nest device from the SDM API.
 type Device struct {
 	Name       string                            `json:"name"`
@@ -35,6 +60,64 @@ type Device struct {
 	ParentRelations []ParentRelation             `json:"parentRelations"`
 }
 
+// LiveStreamTrait is the sdm.devices.traits.CameraLiveStream trait.
+type LiveStreamTrait struct {
+	MaxVideoResolution struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"maxVideoResolution"`
+	VideoCodecs        []string `json:"videoCodecs"`
+	AudioCodecs        []string `json:"audioCodecs"`
+	SupportedProtocols []string `json:"supportedProtocols"`
+}
+
+// SupportsWebRTC reports whether the camera advertises WEB_RTC streaming.
+func (t *LiveStreamTrait) SupportsWebRTC() bool {
+	for _, p := range t.SupportedProtocols {
+		if p == "WEB_RTC" {
+			return true
+		}
+	}
+	return false
+}
+
+// LiveStream returns the device's CameraLiveStream trait, or nil if the device
+// can't stream.
+func (d *Device) LiveStream() *LiveStreamTrait {
+	raw, ok := d.Traits["sdm.devices.traits.CameraLiveStream"]
+	if !ok {
+		return nil
+	}
+	var t LiveStreamTrait
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil
+	}
+	return &t
+}
+
+// CustomName returns the user-assigned name from the Info trait, if any.
+func (d *Device) CustomName() string {
+	var info struct {
+		CustomName string `json:"customName"`
+	}
+	if raw, ok := d.Traits["sdm.devices.traits.Info"]; ok {
+		json.Unmarshal(raw, &info)
+	}
+	return info.CustomName
+}
+
+// Connectivity returns the Connectivity trait status ("ONLINE"/"OFFLINE"),
+// or "" if the device doesn't report it.
+func (d *Device) Connectivity() string {
+	var c struct {
+		Status string `json:"status"`
+	}
+	if raw, ok := d.Traits["sdm.devices.traits.Connectivity"]; ok {
+		json.Unmarshal(raw, &c)
+	}
+	return c.Status
+}
+
 // ParentRelation links a device to its parent structure/room.
 type ParentRelation struct {
 	Parent      string `json:"parent"`
@@ -79,32 +162,48 @@ func (c *Client) ExecuteCommand(deviceName, command string, params map[string]in
 	return result.Results, nil
 }
 
+// WebRTCStream is a camera stream set up by GenerateWebRTCStream or renewed
+// by ExtendWebRTCStream. AnswerSDP is only set by GenerateWebRTCStream.
+type WebRTCStream struct {
+	AnswerSDP      string    `json:"answerSdp"`
+	MediaSessionID string    `json:"mediaSessionId"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+}
+
 // GenerateWebRTCStream initiates a WebRTC stream for a camera device.
-func (c *Client) GenerateWebRTCStream(deviceName, offerSDP string) (answerSDP string, mediaSessionID string, err error) {
+func (c *Client) GenerateWebRTCStream(deviceName, offerSDP string) (*WebRTCStream, error) {
 	params := map[string]interface{}{
 		"offerSdp": offerSDP,
 	}
 	raw, err := c.ExecuteCommand(deviceName, "sdm.devices.commands.CameraLiveStream.GenerateWebRtcStream", params)
 	if err != nil {
-		return "", "", err
-	}
-	var result struct {
-		AnswerSDP      string `json:"answerSdp"`
-		MediaSessionID string `json:"mediaSessionId"`
+		return nil, err
 	}
+	var result WebRTCStream
 	if err := json.Unmarshal(raw, &result); err != nil {
-		return "", "", fmt.Errorf("parsing WebRTC response: %w", err)
+		return nil, fmt.Errorf("parsing WebRTC response: %w", err)
 	}
-	return result.AnswerSDP, result.MediaSessionID, nil
+	return &result, nil
 }
 
-// ExtendWebRTCStream extends an active WebRTC stream session.
-func (c *Client) ExtendWebRTCStream(deviceName, mediaSessionID string) error {
+// ExtendWebRTCStream extends an active WebRTC stream session. The returned
+// stream carries the media session ID to use from now on and its new expiry.
+func (c *Client) ExtendWebRTCStream(deviceName, mediaSessionID string) (*WebRTCStream, error) {
 	params := map[string]interface{}{
 		"mediaSessionId": mediaSessionID,
 	}
-	_, err := c.ExecuteCommand(deviceName, "sdm.devices.commands.CameraLiveStream.ExtendWebRtcStream", params)
-	return err
+	raw, err := c.ExecuteCommand(deviceName, "sdm.devices.commands.CameraLiveStream.ExtendWebRtcStream", params)
+	if err != nil {
+		return nil, err
+	}
+	var result WebRTCStream
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("parsing WebRTC response: %w", err)
+	}
+	if result.MediaSessionID == "" {
+		result.MediaSessionID = mediaSessionID
+	}
+	return &result, nil
 }
 
 // StopWebRTCStream stops an active WebRTC stream session.
@@ -140,7 +239,7 @@ func (c *Client) GenerateEventImage(deviceName, eventID string) (*EventImage, er
 
 // DownloadEventImage downloads the JPEG image from an EventImage to the given path.
 func (c *Client) DownloadEventImage(img *EventImage, outputPath string) error {
-	req, err := http.NewRequest("GET", img.URL, nil)
+	req, err := http.NewRequestWithContext(c.context(), "GET", img.URL, nil)
 	if err != nil {
 		return err
 	}
@@ -173,7 +272,7 @@ func (c *Client) get(path string, out interface{}) error {
 		return fmt.Errorf("getting access token: %w", err)
 	}
 
-	req, err := http.NewRequest("GET", baseURL+path, nil)
+	req, err := http.NewRequestWithContext(c.context(), "GET", c.baseURL+path, nil)
 	if err != nil {
 		return err
 	}
@@ -208,7 +307,7 @@ func (c *Client) post(path string, payload interface{}, out interface{}) error {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", baseURL+path, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(c.context(), "POST", c.baseURL+path, bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
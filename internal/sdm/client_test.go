@@ -0,0 +1,176 @@
+package sdm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSDM serves a project's devices and records the commands it's sent.
+type fakeSDM struct {
+	*httptest.Server
+	commands []map[string]any
+}
+
+func newFakeSDM(t *testing.T) *fakeSDM {
+	f := &fakeSDM{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /enterprises/p/devices", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"devices": [
+			{"name": "enterprises/p/devices/cam", "type": "sdm.devices.types.DOORBELL",
+			 "traits": {"sdm.devices.traits.Info": {"customName": "Front door"}},
+			 "parentRelations": [{"parent": "enterprises/p/structures/s/rooms/r", "displayName": "Porch"}]},
+			{"name": "enterprises/p/devices/thermo", "type": "sdm.devices.types.THERMOSTAT", "traits": {}}
+		]}`))
+	})
+	mux.HandleFunc("GET /enterprises/p/devices/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"code": 404, "message": "Device not found."}}`, http.StatusNotFound)
+	})
+	mux.HandleFunc("POST /enterprises/p/devices/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var cmd map[string]any
+		json.NewDecoder(r.Body).Decode(&cmd)
+		f.commands = append(f.commands, cmd)
+		switch cmd["command"] {
+		case "sdm.devices.commands.CameraLiveStream.GenerateWebRtcStream":
+			w.Write([]byte(`{"results": {"answerSdp": "v=0 answer", "mediaSessionId": "m1", "expiresAt": "2026-01-02T03:04:05Z"}}`))
+		case "sdm.devices.commands.CameraLiveStream.ExtendWebRtcStream":
+			w.Write([]byte(`{"results": {"expiresAt": "2026-01-02T03:09:05Z"}}`))
+		case "sdm.devices.commands.CameraEventImage.GenerateImage":
+			w.Write([]byte(`{"results": {"url": "` + f.URL + `/image", "token": "img-token"}}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	})
+	mux.HandleFunc("GET /image", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic img-token" {
+			http.Error(w, "bad image token", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("jpeg"))
+	})
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/image" && r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		// ServeMux patterns can't contain ':', so route commands on the
+		// device name alone.
+		r.URL.Path = strings.TrimSuffix(r.URL.Path, ":executeCommand")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSDM) client() *Client {
+	c := NewClient("p", func() (string, error) { return "tok", nil })
+	c.SetBaseURL(f.URL)
+	return c
+}
+
+func TestClientDevices(t *testing.T) {
+	c := newFakeSDM(t).client()
+
+	devices, err := c.ListDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("ListDevices returned %d devices, want 2", len(devices))
+	}
+	if got := devices[0].CustomName(); got != "Front door" {
+		t.Errorf("CustomName = %q", got)
+	}
+	if got := devices[0].ParentRelations; len(got) != 1 || got[0].DisplayName != "Porch" {
+		t.Errorf("ParentRelations = %+v", got)
+	}
+
+	_, err = c.GetDevice("enterprises/p/devices/missing")
+	if err == nil || !strings.Contains(err.Error(), "API returned 404") || !strings.Contains(err.Error(), "Device not found") {
+		t.Errorf("GetDevice(missing) = %v, want the API's 404", err)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	f := newFakeSDM(t)
+	c := NewClient("p", func() (string, error) { return "stale", nil })
+	c.SetBaseURL(f.URL)
+	if _, err := c.ListDevices(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("ListDevices with a bad token = %v, want a 401", err)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	f := newFakeSDM(t)
+	c := f.client()
+	const cam = "enterprises/p/devices/cam"
+
+	s, err := c.GenerateWebRTCStream(cam, "v=0 offer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.AnswerSDP != "v=0 answer" || s.MediaSessionID != "m1" || !s.ExpiresAt.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("GenerateWebRTCStream = %+v", s)
+	}
+
+	// Extend's response leaves out the session ID when it doesn't change.
+	s, err = c.ExtendWebRTCStream(cam, "m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MediaSessionID != "m1" || s.ExpiresAt.Minute() != 9 {
+		t.Errorf("ExtendWebRTCStream = %+v", s)
+	}
+
+	if err := c.StopWebRTCStream(cam, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.commands) != 3 {
+		t.Fatalf("sent %d commands, want 3", len(f.commands))
+	}
+	params, _ := f.commands[0]["params"].(map[string]any)
+	if params["offerSdp"] != "v=0 offer" {
+		t.Errorf("GenerateWebRtcStream params = %v", params)
+	}
+	params, _ = f.commands[2]["params"].(map[string]any)
+	if f.commands[2]["command"] != "sdm.devices.commands.CameraLiveStream.StopWebRtcStream" || params["mediaSessionId"] != "m1" {
+		t.Errorf("stop command = %v", f.commands[2])
+	}
+}
+
+func TestClientEventImage(t *testing.T) {
+	c := newFakeSDM(t).client()
+
+	img, err := c.GenerateEventImage("enterprises/p/devices/cam", "ev1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "event.jpg")
+	if err := c.DownloadEventImage(img, out); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "jpeg" {
+		t.Errorf("downloaded %q", data)
+	}
+
+	img.Token = "expired"
+	if err := c.DownloadEventImage(img, out); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("DownloadEventImage with a bad token = %v", err)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	c := newFakeSDM(t).client()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.SetContext(ctx)
+	if _, err := c.ListDevices(); err == nil {
+		t.Error("ListDevices succeeded with a canceled context")
+	}
+}
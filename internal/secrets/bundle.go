@@ -0,0 +1,92 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// bundleFormat identifies a passphrase-encrypted bundle file.
+const bundleFormat = "gognestcli-bundle"
+
+// bundleIterations is the PBKDF2-SHA256 work factor for new bundles.
+const bundleIterations = 600000
+
+// ErrBadPassphrase means a bundle couldn't be decrypted with the passphrase
+// given, or was modified.
+var ErrBadPassphrase = errors.New("wrong passphrase or damaged bundle")
+
+// bundleFile is the on-disk form of a bundle. The key is derived from the
+// passphrase and salt; data is the AES-256-GCM nonce followed by the
+// sealed payload.
+type bundleFile struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Data       []byte `json:"data"`
+}
+
+// SealBundle encrypts payload with a key derived from passphrase, for
+// moving secrets between machines.
+func SealBundle(payload []byte, passphrase string) ([]byte, error) {
+	b := bundleFile{Format: bundleFormat, Version: 1, KDF: "pbkdf2-sha256", Iterations: bundleIterations}
+	b.Salt = make([]byte, 16)
+	if _, err := rand.Read(b.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := bundleCipher(passphrase, b.Salt, b.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	b.Data = aead.Seal(nonce, nonce, payload, []byte(bundleFormat))
+	return json.MarshalIndent(b, "", "  ")
+}
+
+// OpenBundle decrypts a bundle made by SealBundle.
+func OpenBundle(data []byte, passphrase string) ([]byte, error) {
+	var b bundleFile
+	if err := json.Unmarshal(data, &b); err != nil || b.Format != bundleFormat {
+		return nil, errors.New("not a gognestcli bundle")
+	}
+	if b.Version != 1 || b.KDF != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("unsupported bundle version %d (%s); update gognestcli", b.Version, b.KDF)
+	}
+	if b.Iterations < 1 || len(b.Salt) == 0 {
+		return nil, errors.New("malformed bundle")
+	}
+	aead, err := bundleCipher(passphrase, b.Salt, b.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.Data) < aead.NonceSize() {
+		return nil, errors.New("malformed bundle")
+	}
+	payload, err := aead.Open(nil, b.Data[:aead.NonceSize()], b.Data[aead.NonceSize():], []byte(bundleFormat))
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return payload, nil
+}
+
+func bundleCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
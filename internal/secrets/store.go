@@ -1,56 +1,183 @@
 package secrets
 
 import (
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
 
 	"github.com/99designs/keyring"
 )
 
+// ErrNoRefreshToken means gognestcli hasn't been authorized yet.
+var ErrNoRefreshToken = errors.New("no refresh token found (run: gognestcli auth)")
+
+// ErrNoConfigKey means the keyring has no key for encrypted config secrets,
+// e.g. on another machine or after the keyring was reset.
+var ErrNoConfigKey = errors.New("no config encryption key in the keyring (re-enter the secrets in plain text and run: gognestcli config encrypt)")
+
 const (
 	serviceName     = "gognestcli"
 	refreshTokenKey = "refresh_token"
+	configKeyKey    = "config_key"
 )
 
-// Store provides access to the OS keyring for secure token storage.
+// DefaultProfile is the profile used without --profile.
+const DefaultProfile = "default"
+
+// Store provides access to the OS keyring for secure token storage. Items
+// are namespaced by profile, and refresh tokens also by SDM project, so
+// several accounts and projects can be authorized side by side.
 type Store struct {
-	ring keyring.Keyring
+	ring    keyring.Keyring
+	profile string
 }
 
-// NewStore creates a new keyring-backed secret store.
-func NewStore() (*Store, error) {
+// NewStore creates a new keyring-backed secret store for profile (""
+// for DefaultProfile).
+func NewStore(profile string) (*Store, error) {
 	ring, err := keyring.Open(keyring.Config{
 		ServiceName: serviceName,
 		// macOS Keychain is used automatically on Darwin.
 		// On Linux, SecretService or encrypted file fallback.
 		KeychainTrustApplication: true,
+		AllowedBackends:          allowedBackends(),
 	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("opening keyring: %w", err)
+	}
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	return &Store{ring: ring, profile: profile}, nil
+}
+
+// tokenItem is the keyring item holding the refresh token for project.
+func (s *Store) tokenItem(project string) string {
+	return refreshTokenKey + ":" + s.profile + ":" + project
+}
+
+// ConfigKeyItem is the keyring item holding the profile's config encryption
+// key. The default profile keeps the name used before profiles existed.
+func (s *Store) ConfigKeyItem() string {
+	if s.profile == DefaultProfile {
+		return configKeyKey
 	}
-	return &Store{ring: ring}, nil
+	return configKeyKey + ":" + s.profile
 }
 
-// SaveRefreshToken stores the refresh token in the OS keyring.
-func (s *Store) SaveRefreshToken(token string) error {
+// SaveRefreshToken stores the refresh token for project in the OS keyring.
+func (s *Store) SaveRefreshToken(project, token string) error {
 	return s.ring.Set(keyring.Item{
-		Key:  refreshTokenKey,
+		Key:  s.tokenItem(project),
 		Data: []byte(token),
 	})
 }
 
-// LoadRefreshToken retrieves the refresh token from the OS keyring.
-func (s *Store) LoadRefreshToken() (string, error) {
-	item, err := s.ring.Get(refreshTokenKey)
+// LoadRefreshToken retrieves the refresh token for project from the OS
+// keyring. A token stored before items were namespaced is moved to
+// project's item in the default profile.
+func (s *Store) LoadRefreshToken(project string) (string, error) {
+	item, err := s.ring.Get(s.tokenItem(project))
+	if err == nil {
+		return string(item.Data), nil
+	}
+	if !errors.Is(err, keyring.ErrKeyNotFound) {
+		return "", err
+	}
+	if s.profile == DefaultProfile {
+		if legacy, err := s.ring.Get(refreshTokenKey); err == nil {
+			if s.SaveRefreshToken(project, string(legacy.Data)) == nil {
+				s.ring.Remove(refreshTokenKey)
+			}
+			return string(legacy.Data), nil
+		}
+	}
+	return "", ErrNoRefreshToken
+}
+
+// DeleteRefreshToken removes the refresh token for project from the OS
+// keyring.
+func (s *Store) DeleteRefreshToken(project string) error {
+	return s.ring.Remove(s.tokenItem(project))
+}
+
+// Item describes a gognestcli item in the keyring.
+type Item struct {
+	Key     string // keyring item name, as taken by Remove
+	Kind    string // "refresh token" or "config key"
+	Profile string
+	Project string // refresh tokens only; "" for a token stored before namespacing
+}
+
+// Items lists gognestcli's items in the keyring, across all profiles.
+func (s *Store) Items() ([]Item, error) {
+	keys, err := s.ring.Keys()
 	if err != nil {
-		if errors.Is(err, keyring.ErrKeyNotFound) {
-			return "", errors.New("no refresh token found (run: gognestcli auth)")
+		return nil, err
+	}
+	var items []Item
+	for _, k := range keys {
+		parts := strings.SplitN(k, ":", 3)
+		switch {
+		case k == refreshTokenKey:
+			items = append(items, Item{Key: k, Kind: "refresh token", Profile: DefaultProfile})
+		case parts[0] == refreshTokenKey && len(parts) == 3:
+			items = append(items, Item{Key: k, Kind: "refresh token", Profile: parts[1], Project: parts[2]})
+		case k == configKeyKey:
+			items = append(items, Item{Key: k, Kind: "config key", Profile: DefaultProfile})
+		case parts[0] == configKeyKey && len(parts) == 2:
+			items = append(items, Item{Key: k, Kind: "config key", Profile: parts[1]})
 		}
-		return "", err
 	}
-	return string(item.Data), nil
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items, nil
 }
 
-// DeleteRefreshToken removes the refresh token from the OS keyring.
-func (s *Store) DeleteRefreshToken() error {
-	return s.ring.Remove(refreshTokenKey)
+// Remove deletes the keyring item named key (an Item.Key).
+func (s *Store) Remove(key string) error {
+	err := s.ring.Remove(key)
+	if errors.Is(err, keyring.ErrKeyNotFound) {
+		return fmt.Errorf("no keyring item %q", key)
+	}
+	return err
+}
+
+// ConfigKey returns the 256-bit key that encrypts config secrets. With
+// create, a new random key is stored if there is none.
+func (s *Store) ConfigKey(create bool) ([]byte, error) {
+	item, err := s.ring.Get(s.ConfigKeyItem())
+	if err == nil {
+		if len(item.Data) != 32 {
+			return nil, fmt.Errorf("config encryption key in the keyring is %d bytes, want 32", len(item.Data))
+		}
+		return item.Data, nil
+	}
+	if !errors.Is(err, keyring.ErrKeyNotFound) {
+		return nil, err
+	}
+	if !create {
+		return nil, ErrNoConfigKey
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := s.ring.Set(keyring.Item{Key: s.ConfigKeyItem(), Data: key}); err != nil {
+		return nil, fmt.Errorf("saving config encryption key: %w", err)
+	}
+	return key, nil
+}
+
+// allowedBackends pins Windows to Credential Manager (wincred), so a broken
+// credential store is reported instead of silently falling back to the file
+// backend, which has no directory or password configured there. Other
+// platforms keep the library's defaults.
+func allowedBackends() []keyring.BackendType {
+	if runtime.GOOS == "windows" {
+		return []keyring.BackendType{keyring.WinCredBackend}
+	}
+	return nil
 }
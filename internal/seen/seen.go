@@ -0,0 +1,169 @@
+// Package seen remembers which events the events command has handled, for
+// a bounded window and across restarts, so messages Pub/Sub redelivers
+// after a crash or restart aren't captured, uploaded and published twice.
+package seen
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/pubsub"
+)
+
+// MaxKeys bounds the keys kept, oldest dropped first, however short the
+// window.
+const MaxKeys = 10000
+
+// file is the set file's contents: when each key was handled.
+type file struct {
+	Keys map[string]time.Time `json:"keys"`
+}
+
+// Set is the persisted set of handled event keys.
+type Set struct {
+	mu      sync.Mutex
+	path    string
+	window  time.Duration
+	keys    map[string]time.Time
+	pending map[string]bool // claimed by Begin, not yet Done
+}
+
+// Open loads the set from path (in memory only when path is empty),
+// keeping keys handled within window.
+func Open(path string, window time.Duration) *Set {
+	s := &Set{path: path, window: window, pending: map[string]bool{}}
+	s.keys = s.read()
+	s.prune(time.Now())
+	return s
+}
+
+// Key is an event's idempotency key: its event session, type and ID, which
+// a redelivered message repeats. It's "" for events without a session or
+// ID, which can't be told apart from new ones.
+func Key(e pubsub.Event) string {
+	if e.SessionID == "" && e.EventID == "" {
+		return ""
+	}
+	return e.SessionID + "/" + e.EventType + "/" + e.EventID
+}
+
+// Begin claims key for handling. It reports false if key was handled
+// within the window or is being handled now, so the event should be
+// skipped. An empty key is always new.
+//
+// The claim is only kept in memory: key is saved as handled by Done, once
+// its captures are saved, so a message redelivered after a crash in between
+// is handled again.
+func (s *Set) Begin(key string) bool {
+	if key == "" {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.keys[key]; (ok && time.Since(t) < s.window) || s.pending[key] {
+		return false
+	}
+	s.pending[key] = true
+	return true
+}
+
+// Done records a key claimed with Begin as handled now. An error means it
+// couldn't be saved: it's still skipped until a restart.
+func (s *Set) Done(key string) error {
+	if key == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, key)
+	now := time.Now()
+	s.keys[key] = now
+	s.prune(now)
+	return s.save()
+}
+
+// Release drops a claim made with Begin without recording key, so the
+// event is handled again if it's redelivered.
+func (s *Set) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, key)
+}
+
+// Len returns the number of keys in the window.
+func (s *Set) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
+
+// prune drops keys older than the window, then the oldest beyond MaxKeys.
+// Callers hold mu, or own s.
+func (s *Set) prune(now time.Time) {
+	maps.DeleteFunc(s.keys, func(_ string, t time.Time) bool { return now.Sub(t) >= s.window })
+	if len(s.keys) <= MaxKeys {
+		return
+	}
+	keys := slices.SortedFunc(maps.Keys(s.keys), func(a, b string) int { return s.keys[a].Compare(s.keys[b]) })
+	for _, k := range keys[:len(keys)-MaxKeys] {
+		delete(s.keys, k)
+	}
+}
+
+// read loads the set file, returning an empty set if it's missing or
+// unreadable.
+func (s *Set) read() map[string]time.Time {
+	keys := map[string]time.Time{}
+	if s.path == "" {
+		return keys
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return keys
+	}
+	var f file
+	if json.Unmarshal(data, &f) != nil || f.Keys == nil {
+		return keys
+	}
+	return f.Keys
+}
+
+// save writes the set back, merged with keys other events processes added
+// to the file since it was read. Callers hold mu.
+func (s *Set) save() error {
+	if s.path == "" {
+		return nil
+	}
+	defer s.lock()()
+	for k, t := range s.read() {
+		if t.After(s.keys[k]) {
+			s.keys[k] = t
+		}
+	}
+	s.prune(time.Now())
+	data, err := json.Marshal(file{Keys: s.keys})
+	if err != nil {
+		return err
+	}
+	if err := config.WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("saving handled events: %w", err)
+	}
+	return nil
+}
+
+// lock takes the set file's lock for a read-merge-write and returns its
+// release, so processes sharing the file don't drop each other's keys. If
+// it can't be had, the save goes ahead unlocked.
+func (s *Set) lock() (unlock func()) {
+	unlock, err := config.LockFile(s.path + ".lock")
+	if err != nil {
+		return func() {}
+	}
+	return unlock
+}
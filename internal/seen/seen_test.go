@@ -0,0 +1,157 @@
+package seen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brice/gognestcli/internal/pubsub"
+)
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		event pubsub.Event
+		want  string
+	}{
+		{pubsub.Event{SessionID: "s1", EventType: "sdm.devices.events.CameraMotion.Motion", EventID: "e1"},
+			"s1/sdm.devices.events.CameraMotion.Motion/e1"},
+		{pubsub.Event{EventID: "e1", EventType: "t"}, "/t/e1"},
+		{pubsub.Event{SessionID: "s1"}, "s1//"},
+		{pubsub.Event{EventType: "t"}, ""},
+	}
+	for _, tt := range tests {
+		if got := Key(tt.event); got != tt.want {
+			t.Errorf("Key(%+v) = %q, want %q", tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestBeginDoneRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.json")
+	s := Open(path, time.Hour)
+
+	if !s.Begin("") || !s.Begin("") {
+		t.Error("empty key not always new")
+	}
+	if !s.Begin("a") {
+		t.Fatal("Begin(a) on an empty set = false")
+	}
+	if s.Begin("a") {
+		t.Error("Begin(a) while a is being handled = true")
+	}
+	s.Release("a")
+	if !s.Begin("a") {
+		t.Error("Begin(a) after Release = false")
+	}
+	s.Done("a")
+	if s.Begin("a") {
+		t.Error("Begin(a) after Done = true")
+	}
+	if !s.Begin("b") {
+		t.Fatal("Begin(b) = false")
+	}
+
+	// After a restart, only keys that were Done are skipped: b's claim was
+	// in memory only.
+	s = Open(path, time.Hour)
+	if s.Begin("a") {
+		t.Error("Begin(a) after reopening = true")
+	}
+	if !s.Begin("b") {
+		t.Error("Begin(b) after reopening = false; claims shouldn't persist")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.json")
+	now := time.Now()
+	data, _ := json.Marshal(file{Keys: map[string]time.Time{
+		"old":    now.Add(-2 * time.Hour),
+		"recent": now.Add(-time.Minute),
+	}})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := Open(path, time.Hour)
+	if s.Len() != 1 {
+		t.Errorf("Len = %d, want 1 after dropping the old key", s.Len())
+	}
+	if !s.Begin("old") {
+		t.Error("key handled outside the window was skipped")
+	}
+	if s.Begin("recent") {
+		t.Error("key handled within the window wasn't skipped")
+	}
+}
+
+func TestSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.json")
+	a, b := Open(path, time.Hour), Open(path, time.Hour)
+	a.Begin("x")
+	a.Done("x")
+	b.Begin("y")
+	b.Done("y")
+	s := Open(path, time.Hour)
+	if s.Len() != 2 || s.Begin("x") || s.Begin("y") {
+		t.Errorf("the file lost a process's keys: Len = %d", s.Len())
+	}
+}
+
+func TestSharedFileConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.json")
+	const sets, keys = 4, 20
+	var wg sync.WaitGroup
+	for i := range sets {
+		s := Open(path, time.Hour)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range keys {
+				key := fmt.Sprint(i, "/", k)
+				s.Begin(key)
+				if err := s.Done(key); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := Open(path, time.Hour).Len(); n != sets*keys {
+		t.Errorf("file has %d keys, want %d", n, sets*keys)
+	}
+}
+
+func TestDoneSaveError(t *testing.T) {
+	// The set's directory is a file, so it can't be written.
+	dir := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(dir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := Open(filepath.Join(dir, "seen.json"), time.Hour)
+	s.Begin("a")
+	if err := s.Done("a"); err == nil {
+		t.Error("Done = nil for a set that can't be saved")
+	}
+	if s.Begin("a") {
+		t.Error("key not kept in memory after a failed save")
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	s := Open("", time.Hour)
+	for i := range MaxKeys + 5 {
+		key := fmt.Sprint(i)
+		s.Begin(key)
+		s.Done(key)
+	}
+	if s.Len() != MaxKeys {
+		t.Errorf("Len = %d, want %d", s.Len(), MaxKeys)
+	}
+	if s.Begin(fmt.Sprint(MaxKeys + 4)) {
+		t.Error("newest key dropped")
+	}
+}
@@ -0,0 +1,147 @@
+package trigger
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HealthFunc reports whether the events command is healthy, and a status
+// value to return as JSON.
+type HealthFunc func() (healthy bool, status any)
+
+// ServeHTTP serves POST /capture on addr until ctx is done. Parameters come
+// from the query string (?device=driveway&clip=15&snapshot=false) or a JSON
+// body. With a token, requests must send "Authorization: Bearer <token>".
+// GET /healthz answers 200, or 503 when health says it's unhealthy, with
+// the status; it needs no token. Without a token it only listens on
+// loopback addresses, as anyone reaching it could start captures. It
+// returns once the listener is up; serving errors are reported to errf.
+func ServeHTTP(ctx context.Context, addr, token string, handle Handler, health HealthFunc, errf func(error)) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("trigger listener: %w", err)
+	}
+	if ip := ln.Addr().(*net.TCPAddr).IP; token == "" && !ip.IsLoopback() {
+		ln.Close()
+		return fmt.Errorf("trigger listener on %s needs triggers.http_token, or listen on 127.0.0.1: without a token anyone who can reach it can start captures", addr)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/capture", func(w http.ResponseWriter, r *http.Request) {
+		serveCapture(w, r, token, handle)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		serveHealth(w, r, health)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errf(err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	return nil
+}
+
+func serveCapture(w http.ResponseWriter, r *http.Request, token string, handle Handler) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	if token != "" {
+		want := "Bearer " + token
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+	}
+
+	req, err := parseHTTPRequest(r)
+	if err == nil {
+		err = req.Validate()
+	}
+	if err == nil {
+		err = handle(req)
+	}
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusAccepted, "capture started")
+	case errors.Is(err, ErrInvalid):
+		writeJSON(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrUnavailable):
+		writeJSON(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeJSON(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func serveHealth(w http.ResponseWriter, r *http.Request, health HealthFunc) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSON(w, http.StatusMethodNotAllowed, "GET required")
+		return
+	}
+	healthy, status := health()
+	code := http.StatusOK
+	if !healthy {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// parseHTTPRequest reads a request from a JSON body, then lets query
+// parameters override it.
+func parseHTTPRequest(r *http.Request) (Request, error) {
+	req := Request{Source: "http"}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return req, fmt.Errorf("%w: reading body: %v", ErrInvalid, err)
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, fmt.Errorf("%w: body: %v", ErrInvalid, err)
+		}
+	}
+	q := r.URL.Query()
+	if v := q.Get("device"); v != "" {
+		req.Device = v
+	}
+	if v := q.Get("clip"); v != "" {
+		if req.Clip, err = strconv.Atoi(v); err != nil {
+			return req, fmt.Errorf("%w: clip must be a number of seconds", ErrInvalid)
+		}
+	}
+	if v := q.Get("snapshot"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, fmt.Errorf("%w: snapshot must be true or false", ErrInvalid)
+		}
+		req.Snapshot = &b
+	}
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	key := "error"
+	if status < 300 {
+		key = "status"
+	}
+	json.NewEncoder(w).Encode(map[string]string{key: message})
+}
@@ -0,0 +1,337 @@
+package trigger
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// keepAlive is the MQTT keep-alive interval; a PINGREQ is sent every half.
+const keepAlive = 60 * time.Second
+
+// MQTT subscribes to capture requests over MQTT 3.1.1, so no client library
+// is needed. Requests are JSON Request objects; QoS 1 messages are
+// acknowledged once handled.
+type MQTT struct {
+	cfg   config.MQTTConfig
+	addr  string
+	tls   bool
+	host  string
+	topic string
+}
+
+// NewMQTT checks the broker URL; the connection is made by Run.
+func NewMQTT(cfg config.MQTTConfig) (*MQTT, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", cfg.URL)
+	}
+	m := &MQTT{cfg: cfg, host: u.Hostname(), topic: cfg.Topic}
+	port := u.Port()
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if port == "" {
+			port = "1883"
+		}
+	case "mqtts", "ssl", "tls":
+		m.tls = true
+		if port == "" {
+			port = "8883"
+		}
+	default:
+		return nil, fmt.Errorf("url %q must start with mqtt:// or mqtts://", cfg.URL)
+	}
+	if u.User != nil && cfg.Username == "" {
+		m.cfg.Username = u.User.Username()
+		m.cfg.Password, _ = u.User.Password()
+	}
+	m.addr = net.JoinHostPort(m.host, port)
+	if m.topic == "" {
+		m.topic = "gognestcli/capture"
+	}
+	if strings.ContainsAny(m.topic, "+#") {
+		return nil, fmt.Errorf("topic %q must not contain wildcards", m.topic)
+	}
+	if m.cfg.ClientID == "" {
+		// The PID keeps two instances on one host from taking over each
+		// other's session on the broker.
+		host, _ := os.Hostname()
+		m.cfg.ClientID = fmt.Sprintf("gognestcli-%s-%d", host, os.Getpid())
+	}
+	return m, nil
+}
+
+// Topic returns the subscribed topic.
+func (m *MQTT) Topic() string { return m.topic }
+
+// Run connects, subscribes and hands requests to handle until ctx is done,
+// reconnecting with backoff when the connection drops. Errors are reported
+// to errf.
+func (m *MQTT) Run(ctx context.Context, handle Handler, errf func(error)) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := m.session(ctx, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		errf(err)
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// session runs one connection until it fails or ctx is done.
+func (m *MQTT) session(ctx context.Context, handle Handler) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", m.addr, err)
+	}
+	if m.tls {
+		tc := tls.Client(conn, &tls.Config{ServerName: m.host})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return fmt.Errorf("tls handshake with %s: %w", m.addr, err)
+		}
+		conn = tc
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	r := bufio.NewReader(conn)
+	var wmu sync.Mutex
+	write := func(p []byte) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		_, err := conn.Write(p)
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err := write(m.connectPacket()); err != nil {
+		return fmt.Errorf("connecting to %s: %w", m.addr, err)
+	}
+	typ, body, err := readPacket(r)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", m.addr, err)
+	}
+	if typ>>4 != 2 || len(body) < 2 {
+		return fmt.Errorf("%s is not an MQTT broker", m.addr)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("mqtt broker refused connection: %s", connackReason(body[1]))
+	}
+
+	if err := write(subscribePacket(1, m.topic)); err != nil {
+		return err
+	}
+
+	// Keep the connection alive; the broker drops it after 1.5 keep-alives
+	// without traffic.
+	pingDone := make(chan struct{})
+	defer close(pingDone)
+	go func() {
+		ticker := time.NewTicker(keepAlive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pingDone:
+				return
+			case <-ticker.C:
+				if write([]byte{0xC0, 0}) != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		typ, body, err := readPacket(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("mqtt connection lost: %w", err)
+		}
+		switch typ >> 4 {
+		case 9: // SUBACK
+			if len(body) >= 3 && body[2] == 0x80 {
+				return fmt.Errorf("mqtt broker refused subscription to %s", m.topic)
+			}
+		case 3: // PUBLISH
+			qos := (typ >> 1) & 3
+			payload, id, err := parsePublish(body, qos)
+			if err != nil {
+				return err
+			}
+			m.deliver(payload, handle)
+			if qos > 0 {
+				if err := write([]byte{0x40, 2, byte(id >> 8), byte(id)}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// deliver parses and hands on one request; bad ones are reported and
+// dropped.
+func (m *MQTT) deliver(payload []byte, handle Handler) {
+	req := Request{}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		fmt.Printf("Warning: mqtt trigger on %s: invalid JSON: %v\n", m.topic, err)
+		return
+	}
+	req.Source = "mqtt"
+	if err := req.Validate(); err != nil {
+		fmt.Printf("Warning: mqtt trigger on %s: %v\n", m.topic, err)
+		return
+	}
+	if err := handle(req); err != nil {
+		fmt.Printf("Warning: mqtt trigger on %s: %v\n", m.topic, err)
+	}
+}
+
+func (m *MQTT) connectPacket() []byte {
+	var vh []byte
+	vh = appendString(vh, "MQTT")
+	flags := byte(0x02) // clean session
+	if m.cfg.Username != "" {
+		flags |= 0x80
+		if m.cfg.Password != "" {
+			flags |= 0x40
+		}
+	}
+	vh = append(vh, 4, flags, byte(keepAlive/time.Second>>8), byte(keepAlive/time.Second))
+	vh = appendString(vh, m.cfg.ClientID)
+	if m.cfg.Username != "" {
+		vh = appendString(vh, m.cfg.Username)
+		if m.cfg.Password != "" {
+			vh = appendString(vh, m.cfg.Password)
+		}
+	}
+	return packet(0x10, vh)
+}
+
+func subscribePacket(id uint16, topic string) []byte {
+	body := []byte{byte(id >> 8), byte(id)}
+	body = appendString(body, topic)
+	body = append(body, 1) // QoS 1
+	return packet(0x82, body)
+}
+
+// packet frames body with a fixed header.
+func packet(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readPacket reads one packet, returning its first header byte and body.
+// Bodies over 1 MiB are refused.
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed mqtt packet length")
+		}
+		mult *= 128
+	}
+	if n > 1<<20 {
+		return 0, nil, fmt.Errorf("mqtt packet too large (%d bytes)", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return typ, body, nil
+}
+
+// parsePublish returns a PUBLISH packet's payload and packet ID (QoS > 0).
+func parsePublish(body []byte, qos byte) ([]byte, uint16, error) {
+	if len(body) < 2 {
+		return nil, 0, errors.New("malformed mqtt publish")
+	}
+	i := 2 + int(binary.BigEndian.Uint16(body))
+	var id uint16
+	if qos > 0 {
+		if len(body) < i+2 {
+			return nil, 0, errors.New("malformed mqtt publish")
+		}
+		id = binary.BigEndian.Uint16(body[i:])
+		i += 2
+	}
+	if i > len(body) {
+		return nil, 0, errors.New("malformed mqtt publish")
+	}
+	return body[i:], id, nil
+}
+
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client ID rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad username or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", code)
+}
@@ -0,0 +1,102 @@
+package trigger
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 16383, 16384, 1 << 20} {
+		body := bytes.Repeat([]byte{'x'}, n)
+		p := packet(0x30, body)
+		typ, got, err := readPacket(bufio.NewReader(bytes.NewReader(p)))
+		if err != nil {
+			t.Fatalf("readPacket(%d-byte body): %v", n, err)
+		}
+		if typ != 0x30 || !bytes.Equal(got, body) {
+			t.Errorf("readPacket(%d-byte body) = %#x, %d bytes", n, typ, len(got))
+		}
+	}
+}
+
+func TestReadPacket(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		typ  byte
+		body string
+		err  string
+	}{
+		{"connack", []byte{0x20, 2, 0, 0}, 0x20, "\x00\x00", ""},
+		{"two-byte length", append([]byte{0x30, 0x80, 0x01}, bytes.Repeat([]byte{'a'}, 128)...), 0x30, strings.Repeat("a", 128), ""},
+		{"empty", nil, 0, "", io.EOF.Error()},
+		{"no length", []byte{0x20}, 0, "", io.EOF.Error()},
+		{"truncated body", []byte{0x20, 3, 0}, 0, "", io.ErrUnexpectedEOF.Error()},
+		{"five-byte length", []byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}, 0, "", "malformed mqtt packet length"},
+		{"too large", []byte{0x30, 0x81, 0x80, 0x40}, 0, "", "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, body, err := readPacket(bufio.NewReader(bytes.NewReader(tt.in)))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("readPacket error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readPacket: %v", err)
+			}
+			if typ != tt.typ || string(body) != tt.body {
+				t.Errorf("readPacket = %#x, %q; want %#x, %q", typ, body, tt.typ, tt.body)
+			}
+		})
+	}
+}
+
+func TestParsePublish(t *testing.T) {
+	topic := appendString(nil, "nest/trigger")
+	tests := []struct {
+		name    string
+		body    []byte
+		qos     byte
+		payload string
+		id      uint16
+		ok      bool
+	}{
+		{"qos 0", append(append([]byte{}, topic...), `{"device":"x"}`...), 0, `{"device":"x"}`, 0, true},
+		{"qos 1", append(append(append([]byte{}, topic...), 0x12, 0x34), `{}`...), 1, `{}`, 0x1234, true},
+		{"empty payload", append([]byte{}, topic...), 0, "", 0, true},
+		{"no topic length", []byte{0}, 0, "", 0, false},
+		{"topic overruns body", []byte{0, 9, 'a'}, 0, "", 0, false},
+		{"missing packet id", append(append([]byte{}, topic...), 0x12), 1, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, id, err := parsePublish(tt.body, tt.qos)
+			if !tt.ok {
+				if err == nil {
+					t.Errorf("parsePublish = %q, %d; want an error", payload, id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePublish: %v", err)
+			}
+			if string(payload) != tt.payload || id != tt.id {
+				t.Errorf("parsePublish = %q, %#x; want %q, %#x", payload, id, tt.payload, tt.id)
+			}
+		})
+	}
+}
+
+func TestSubscribePacket(t *testing.T) {
+	got := subscribePacket(1, "a/b")
+	want := []byte{0x82, 8, 0, 1, 0, 3, 'a', '/', 'b', 1}
+	if !bytes.Equal(got, want) {
+		t.Errorf("subscribePacket = % x, want % x", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+// Package trigger accepts manual capture requests for the events command
+// over HTTP and MQTT, so external sensors (a gate contact, a button) can
+// have a camera capture on demand.
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors a Handler wraps to tell the caller what went wrong.
+var (
+	ErrInvalid     = errors.New("invalid request")        // bad or unknown device, bad parameters
+	ErrUnavailable = errors.New("not accepting captures") // shutting down
+)
+
+// maxClip bounds the clip length a request may ask for.
+const maxClip = 10 * time.Minute
+
+// Request asks for a capture from one device.
+type Request struct {
+	Device   string `json:"device"`             // device ID or alias
+	Snapshot *bool  `json:"snapshot,omitempty"` // default true
+	Clip     int    `json:"clip,omitempty"`     // clip length in seconds; 0 for none
+	Source   string `json:"-"`                  // "http", "mqtt" or "cli", for logs
+}
+
+// WantSnapshot reports whether a snapshot was asked for; it is unless the
+// request says otherwise.
+func (r Request) WantSnapshot() bool {
+	return r.Snapshot == nil || *r.Snapshot
+}
+
+// ClipDuration returns the requested clip length.
+func (r Request) ClipDuration() time.Duration {
+	return time.Duration(r.Clip) * time.Second
+}
+
+// Validate checks the request's parameters.
+func (r Request) Validate() error {
+	if r.Device == "" {
+		return fmt.Errorf("%w: device is required", ErrInvalid)
+	}
+	if r.Clip < 0 || r.ClipDuration() > maxClip {
+		return fmt.Errorf("%w: clip must be between 0 and %d seconds", ErrInvalid, int(maxClip.Seconds()))
+	}
+	if !r.WantSnapshot() && r.Clip == 0 {
+		return fmt.Errorf("%w: nothing to capture (snapshot is false and no clip)", ErrInvalid)
+	}
+	return nil
+}
+
+// Handler starts the captures for a valid request. It returns once they're
+// under way, not when they finish.
+type Handler func(Request) error
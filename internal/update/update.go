@@ -0,0 +1,279 @@
+// Package update checks GitHub releases for a newer gognestcli and replaces
+// the running binary with it.
+//
+// A release is expected to carry one raw binary per platform, named
+// gognestcli_<GOOS>_<GOARCH> (with .exe on Windows), and a checksums.txt in
+// sha256sum format listing them, and checksums.txt.sig, an Ed25519 signature
+// of checksums.txt by the key in PublicKey. Builds without a key can check
+// for releases but won't install them.
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Repo is the GitHub repository releases are fetched from.
+const Repo = "gdaybrice/gognestcli"
+
+// apiURL is the GitHub REST API root.
+const apiURL = "https://api.github.com"
+
+// PublicKey is the base64 Ed25519 key release checksums are signed with,
+// set at build time with -ldflags "-X .../internal/update.PublicKey=...".
+// The checksums come from the same release as the binary, so they alone
+// don't show who built it: without a key, Apply refuses to update.
+var PublicKey string
+
+// ErrNoPublicKey is returned by Apply in builds without a release key.
+var ErrNoPublicKey = errors.New("this build has no release signing key, so it can't verify updates")
+
+// maxBinarySize bounds downloads so a bad asset can't fill the disk.
+const maxBinarySize = 200 << 20
+
+// Release is a published GitHub release.
+type Release struct {
+	TagName string  `json:"tag_name"`
+	HTMLURL string  `json:"html_url"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+// Latest returns the newest non-prerelease release.
+func Latest(ctx context.Context) (*Release, error) {
+	body, err := get(ctx, fmt.Sprintf("%s/repos/%s/releases/latest", apiURL, Repo), 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	var rel Release
+	if err := json.Unmarshal(body, &rel); err != nil {
+		return nil, fmt.Errorf("parsing release: %w", err)
+	}
+	return &rel, nil
+}
+
+// AssetName is the binary asset for the running platform.
+func AssetName() string {
+	name := fmt.Sprintf("gognestcli_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func (r *Release) asset(name string) (*Asset, error) {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("release %s has no %s", r.TagName, name)
+}
+
+// Newer reports whether release tag is a higher version than current. Dev
+// builds and unparsable versions are never considered current.
+func Newer(tag, current string) bool {
+	t, ok := parseVersion(tag)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return true
+	}
+	for i := range t {
+		if t[i] != c[i] {
+			return t[i] > c[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses "v1.2.3" or "1.2" into major, minor and patch,
+// ignoring any pre-release or build suffix.
+func parseVersion(s string) ([3]int, bool) {
+	var v [3]int
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// Apply downloads the release's binary for this platform, verifies it
+// against checksums.txt and its signature and replaces the executable at exe
+// with it. It returns ErrNoPublicKey if PublicKey isn't set.
+func Apply(ctx context.Context, r *Release, exe string) error {
+	if PublicKey == "" {
+		return ErrNoPublicKey
+	}
+	bin, err := r.asset(AssetName())
+	if err != nil {
+		return err
+	}
+	want, err := r.checksum(ctx, bin.Name)
+	if err != nil {
+		return err
+	}
+
+	// Download next to the executable so the final rename stays on one
+	// filesystem.
+	dir := filepath.Dir(exe)
+	tmp, err := os.CreateTemp(dir, ".gognestcli-update-*")
+	if err != nil {
+		return fmt.Errorf("can't write to %s: %w", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	if err := fetch(ctx, bin.URL, io.MultiWriter(tmp, h), maxBinarySize); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", bin.Name, got, want)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return replace(exe, tmp.Name())
+}
+
+// checksum returns the SHA256 listed for name in the release's
+// checksums.txt, after checking the file's signature.
+func (r *Release) checksum(ctx context.Context, name string) (string, error) {
+	sums, err := r.asset("checksums.txt")
+	if err != nil {
+		return "", err
+	}
+	data, err := get(ctx, sums.URL, 1<<20)
+	if err != nil {
+		return "", err
+	}
+	if err := r.verifySignature(ctx, data); err != nil {
+		return "", err
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		// "<hex>  <name>", with a "*" before binary-mode names.
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no entry for %s", name)
+}
+
+func (r *Release) verifySignature(ctx context.Context, data []byte) error {
+	key, err := base64.StdEncoding.DecodeString(PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid release public key in this build")
+	}
+	sigAsset, err := r.asset("checksums.txt.sig")
+	if err != nil {
+		return err
+	}
+	raw, err := get(ctx, sigAsset.URL, 4096)
+	if err != nil {
+		return err
+	}
+	// Accept the signature raw or base64-encoded.
+	sig := raw
+	if len(sig) != ed25519.SignatureSize {
+		if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw))); err != nil {
+			return errors.New("checksums.txt.sig is not an Ed25519 signature")
+		}
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return errors.New("checksums.txt signature does not match the release key")
+	}
+	return nil
+}
+
+// replace moves the new binary over exe. Windows can't overwrite a running
+// executable, but can rename it, so the old one is moved aside first.
+func replace(exe, newPath string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(newPath, exe)
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(newPath, exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
+
+func get(ctx context.Context, url string, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := fetch(ctx, url, &buf, limit); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func fetch(ctx context.Context, url string, w io.Writer, limit int64) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(url, apiURL) {
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s returned %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return fmt.Errorf("%s is larger than %d bytes", url, limit)
+	}
+	return nil
+}
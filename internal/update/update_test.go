@@ -0,0 +1,147 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		tag, current string
+		want         bool
+	}{
+		{"v1.2.3", "v1.2.2", true},
+		{"v1.10.0", "v1.9.9", true},
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.2.3", "v2.0.0", false},
+		{"v1.3", "1.2.9-rc1", true},
+		{"v1.2.3", "dev", true},
+		{"nightly", "v1.0.0", false},
+		{"v1.2.3.4", "v1.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := Newer(tt.tag, tt.current); got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tt.tag, tt.current, got, tt.want)
+		}
+	}
+}
+
+// release is a fake GitHub release: its assets are served by an httptest
+// server.
+type release struct {
+	binary    []byte // served as the platform's binary
+	checksums string // checksums.txt
+	sig       []byte // checksums.txt.sig
+}
+
+func (r release) serve(t *testing.T) *Release {
+	files := map[string][]byte{
+		AssetName():         r.binary,
+		"checksums.txt":     []byte(r.checksums),
+		"checksums.txt.sig": r.sig,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, ok := files[strings.TrimPrefix(req.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	rel := &Release{TagName: "v9.9.9"}
+	for name := range files {
+		rel.Assets = append(rel.Assets, Asset{Name: name, URL: srv.URL + "/" + name})
+	}
+	return rel
+}
+
+func sha(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestApply(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	defer func(k string) { PublicKey = k }(PublicKey)
+	PublicKey = base64.StdEncoding.EncodeToString(pub)
+
+	binary := []byte("#!/bin/sh\necho new\n")
+	sums := fmt.Sprintf("%s  gognestcli_other_arch\n%s *%s\n", sha([]byte("other")), sha(binary), AssetName())
+	signed := func(key ed25519.PrivateKey, data string) []byte { return ed25519.Sign(key, []byte(data)) }
+
+	tests := []struct {
+		name    string
+		rel     release
+		wantErr string
+	}{
+		{"valid", release{binary, sums, signed(priv, sums)}, ""},
+		{"base64 signature", release{binary, sums, []byte(base64.StdEncoding.EncodeToString(signed(priv, sums)) + "\n")}, ""},
+		{"tampered binary", release{[]byte("#!/bin/sh\necho evil\n"), sums, signed(priv, sums)}, "checksum mismatch"},
+		{"wrong key", release{binary, sums, signed(otherPriv, sums)}, "does not match the release key"},
+		{"tampered checksums", release{binary, sums + "\n", signed(priv, sums)}, "does not match the release key"},
+		{"not a signature", release{binary, sums, []byte("garbage")}, "not an Ed25519 signature"},
+		{"no checksum entry", func() release {
+			s := fmt.Sprintf("%s  gognestcli_other_arch\n", sha([]byte("other")))
+			return release{binary, s, signed(priv, s)}
+		}(), "no entry for " + AssetName()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			exe := filepath.Join(dir, "gognestcli")
+			if err := os.WriteFile(exe, []byte("old"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Apply(context.Background(), tt.rel.serve(t), exe)
+			got, _ := os.ReadFile(exe)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Apply: %v", err)
+				}
+				if string(got) != string(binary) {
+					t.Errorf("executable = %q, want the new binary", got)
+				}
+				if info, _ := os.Stat(exe); info.Mode().Perm()&0o100 == 0 {
+					t.Errorf("new binary isn't executable: %s", info.Mode())
+				}
+			} else {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Apply = %v, want an error containing %q", err, tt.wantErr)
+				}
+				if string(got) != "old" {
+					t.Errorf("executable replaced after a failed update: %q", got)
+				}
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				var names []string
+				for _, e := range entries {
+					names = append(names, e.Name())
+				}
+				t.Errorf("files left next to the executable: %v", names)
+			}
+		})
+	}
+}
+
+func TestApplyWithoutKey(t *testing.T) {
+	defer func(k string) { PublicKey = k }(PublicKey)
+	PublicKey = ""
+	if err := Apply(context.Background(), &Release{}, filepath.Join(t.TempDir(), "gognestcli")); !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("Apply = %v, want ErrNoPublicKey", err)
+	}
+}
@@ -0,0 +1,236 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+const (
+	driveAPIURL     = "https://www.googleapis.com/drive/v3"
+	driveUploadURL  = "https://www.googleapis.com/upload/drive/v3"
+	driveFolderMIME = "application/vnd.google-apps.folder"
+)
+
+// Drive uploads files to Google Drive, creating one folder per path segment of
+// the key (e.g. a folder per device) beneath the configured parent folder.
+// Requires the drive.file scope.
+type Drive struct {
+	parentID   string
+	tokenFn    func() (string, error)
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	folders map[string]string // folder path → Drive file ID
+}
+
+// NewDrive creates a Google Drive uploader. An empty folder_id uploads into
+// the root of "My Drive".
+func NewDrive(cfg config.DriveConfig, tokenFn func() (string, error)) *Drive {
+	parent := cfg.FolderID
+	if parent == "" {
+		parent = "root"
+	}
+	return &Drive{
+		parentID:   parent,
+		tokenFn:    tokenFn,
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+		folders:    make(map[string]string),
+	}
+}
+
+// Name returns the target name used in log messages.
+func (d *Drive) Name() string { return "drive" }
+
+// Upload stores the file at localPath under key, creating intermediate folders.
+func (d *Drive) Upload(ctx context.Context, localPath, key string) error {
+	tok, err := d.tokenFn()
+	if err != nil {
+		return fmt.Errorf("getting access token: %w", err)
+	}
+
+	dir, name := path.Split(key)
+	parent, err := d.ensureFolder(ctx, tok, strings.Trim(dir, "/"))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// A resumable upload, sent in one request: multipart uploads are capped
+	// at 5 MB, which most clips exceed.
+	session, err := d.startUpload(ctx, tok, name, parent, contentType(localPath), info.Size())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", session, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentType(localPath))
+
+	if err := d.do(req, nil); err != nil {
+		return fmt.Errorf("uploading %s: %w", filepath.Base(localPath), err)
+	}
+	return nil
+}
+
+// startUpload starts a resumable upload of a file named name into parent
+// and returns the session URI to send its contents to.
+func (d *Drive) startUpload(ctx context.Context, tok, name, parent, mimeType string, size int64) (string, error) {
+	meta, _ := json.Marshal(map[string]interface{}{
+		"name":    name,
+		"parents": []string{parent},
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", driveUploadURL+"/files?uploadType=resumable", bytes.NewReader(meta))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", mimeType)
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("starting upload: Drive API returned %d: %s", resp.StatusCode, string(body))
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", fmt.Errorf("starting upload: Drive API returned no upload URL")
+	}
+	return session, nil
+}
+
+// ensureFolder returns the ID of the folder at dir (relative to the parent),
+// finding or creating each segment as needed.
+func (d *Drive) ensureFolder(ctx context.Context, tok, dir string) (string, error) {
+	if dir == "" {
+		return d.parentID, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	parent := d.parentID
+	var walked string
+	for _, seg := range strings.Split(dir, "/") {
+		walked = path.Join(walked, seg)
+		if id, ok := d.folders[walked]; ok {
+			parent = id
+			continue
+		}
+
+		id, err := d.findFolder(ctx, tok, parent, seg)
+		if err != nil {
+			return "", err
+		}
+		if id == "" {
+			id, err = d.createFolder(ctx, tok, parent, seg)
+			if err != nil {
+				return "", err
+			}
+		}
+		d.folders[walked] = id
+		parent = id
+	}
+	return parent, nil
+}
+
+func (d *Drive) findFolder(ctx context.Context, tok, parent, name string) (string, error) {
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and mimeType = '%s' and trashed = false",
+		strings.ReplaceAll(name, "'", `\'`), parent, driveFolderMIME)
+	params := url.Values{
+		"q":      {q},
+		"fields": {"files(id)"},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", driveAPIURL+"/files?"+params.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+
+	var result struct {
+		Files []struct {
+			ID string `json:"id"`
+		} `json:"files"`
+	}
+	if err := d.do(req, &result); err != nil {
+		return "", fmt.Errorf("looking up folder %q: %w", name, err)
+	}
+	if len(result.Files) == 0 {
+		return "", nil
+	}
+	return result.Files[0].ID, nil
+}
+
+func (d *Drive) createFolder(ctx context.Context, tok, parent, name string) (string, error) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"name":     name,
+		"mimeType": driveFolderMIME,
+		"parents":  []string{parent},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", driveAPIURL+"/files?fields=id", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Content-Type", "application/json")
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := d.do(req, &result); err != nil {
+		return "", fmt.Errorf("creating folder %q: %w", name, err)
+	}
+	return result.ID, nil
+}
+
+func (d *Drive) do(req *http.Request, out interface{}) error {
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Drive API returned %d: %s", resp.StatusCode, string(body))
+	}
+	if out != nil {
+		return json.Unmarshal(body, out)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// redirect sends every request to srv, keeping its path and query.
+type redirect struct{ srv *httptest.Server }
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(r.srv.URL)
+	req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestDriveUploadResumable(t *testing.T) {
+	const size = 6 << 20 // over the 5 MB multipart limit
+	var got int64
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Query().Get("uploadType") == "resumable":
+			if r.Header.Get("X-Upload-Content-Length") != "6291456" {
+				t.Errorf("X-Upload-Content-Length = %q", r.Header.Get("X-Upload-Content-Length"))
+			}
+			w.Header().Set("Location", srv.URL+"/upload/session/1")
+		case r.Method == "PUT" && r.URL.Path == "/upload/session/1":
+			got, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"id":"f1"}`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDrive(config.DriveConfig{}, func() (string, error) { return "tok", nil })
+	d.httpClient.Transport = redirect{srv}
+	if err := d.Upload(context.Background(), path, "clip.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if got != size {
+		t.Errorf("uploaded %d bytes, want %d", got, size)
+	}
+}
@@ -0,0 +1,101 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+const gcsUploadURL = "https://storage.googleapis.com/upload/storage/v1"
+
+// GCS uploads objects to a Google Cloud Storage bucket using the user's OAuth
+// token (requires the devstorage.read_write scope).
+type GCS struct {
+	bucket     string
+	prefix     string
+	tokenFn    func() (string, error)
+	httpClient *http.Client
+}
+
+// NewGCS creates a Google Cloud Storage uploader.
+func NewGCS(cfg config.GCSConfig, tokenFn func() (string, error)) (*GCS, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	return &GCS{
+		bucket:     cfg.Bucket,
+		prefix:     strings.Trim(cfg.Prefix, "/"),
+		tokenFn:    tokenFn,
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// Name returns the target name used in log messages.
+func (g *GCS) Name() string { return "gcs" }
+
+// Upload stores the file at localPath as gs://bucket/prefix/key.
+func (g *GCS) Upload(ctx context.Context, localPath, key string) error {
+	tok, err := g.tokenFn()
+	if err != nil {
+		return fmt.Errorf("getting access token: %w", err)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	objectName := key
+	if g.prefix != "" {
+		objectName = g.prefix + "/" + key
+	}
+
+	params := url.Values{
+		"uploadType": {"media"},
+		"name":       {objectName},
+	}
+	endpoint := fmt.Sprintf("%s/b/%s/o?%s", gcsUploadURL, url.PathEscape(g.bucket), params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Content-Type", contentType(localPath))
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload returned %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func contentType(path string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
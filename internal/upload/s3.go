@@ -0,0 +1,218 @@
+package upload
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// S3 uploads objects to AWS S3 or any S3-compatible service (MinIO, Backblaze
+// B2, Cloudflare R2) using AWS Signature Version 4.
+type S3 struct {
+	endpoint   *url.URL
+	region     string
+	bucket     string
+	prefix     string
+	accessKey  string
+	secretKey  string
+	pathStyle  bool
+	httpClient *http.Client
+}
+
+// NewS3 creates an S3 uploader. Credentials fall back to the standard
+// AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY environment variables.
+func NewS3(cfg config.S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	accessKey := cfg.AccessKeyID
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	secretKey := cfg.SecretAccessKey
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("access_key_id and secret_access_key are required")
+	}
+
+	return &S3{
+		endpoint:   u,
+		region:     region,
+		bucket:     cfg.Bucket,
+		prefix:     strings.Trim(cfg.Prefix, "/"),
+		accessKey:  accessKey,
+		secretKey:  secretKey,
+		pathStyle:  cfg.PathStyle,
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// Name returns the target name used in log messages.
+func (s *S3) Name() string { return "s3" }
+
+// Upload PUTs the file at localPath to s3://bucket/prefix/key.
+func (s *S3) Upload(ctx context.Context, localPath, key string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// SigV4 requires the payload hash up front, so hash the file then rewind.
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("hashing file: %w", err)
+	}
+	payloadHash := hex.EncodeToString(h.Sum(nil))
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	objectKey := key
+	if s.prefix != "" {
+		objectKey = s.prefix + "/" + key
+	}
+
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = path.Join("/", u.Path, s.bucket, objectKey)
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = path.Join("/", u.Path, objectKey)
+	}
+	// Send the path exactly as it's signed.
+	u.RawPath = uriEncodePath(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentType(localPath))
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("PUT returned %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	var names []string
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncodePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := dateStamp + "/" + s.region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(crHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), dateStamp)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// uriEncodePath percent-encodes each segment of p the way SigV4 canonical
+// requests do: everything but A-Z, a-z, 0-9, '-', '_', '.' and '~', with
+// uppercase hex. url.URL.EscapedPath leaves characters such as '$' and '+'
+// alone, which S3 then rejects as a signature mismatch.
+func uriEncodePath(p string) string {
+	const upperhex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '/',
+			'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(upperhex[c>>4])
+			b.WriteByte(upperhex[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,163 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+	"github.com/brice/gognestcli/internal/proc"
+)
+
+const defaultSFTPRetries = 3
+
+// SFTP uploads files to a remote host using the system sftp client with key
+// authentication. Interrupted transfers are resumed with reput on retry, if
+// the partial file made it to the remote host.
+type SFTP struct {
+	target     string
+	port       int
+	identity   string
+	pathTmpl   *template.Template
+	retries    int
+	retryDelay time.Duration // multiplied by the attempt number
+}
+
+// sftpPathData is the data available to the remote_path template.
+type sftpPathData struct {
+	Key    string // full upload key, e.g. "front-door/20240101-120000_person_001.jpg"
+	Device string // first key segment
+	File   string // base filename
+}
+
+// NewSFTP creates an SFTP uploader.
+func NewSFTP(cfg config.SFTPConfig) (*SFTP, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	if _, err := exec.LookPath("sftp"); err != nil {
+		return nil, errors.New("sftp client not found in PATH")
+	}
+
+	tmplText := cfg.RemotePath
+	if tmplText == "" {
+		tmplText = "{{.Key}}"
+	}
+	tmpl, err := template.New("remote_path").Option("missingkey=error").Parse(tmplText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote_path template: %w", err)
+	}
+
+	target := cfg.Host
+	if cfg.User != "" {
+		target = cfg.User + "@" + cfg.Host
+	}
+
+	retries := cfg.Retries
+	if retries <= 0 {
+		retries = defaultSFTPRetries
+	}
+
+	return &SFTP{
+		target:     target,
+		port:       cfg.Port,
+		identity:   cfg.IdentityFile,
+		pathTmpl:   tmpl,
+		retries:    retries,
+		retryDelay: 5 * time.Second,
+	}, nil
+}
+
+// Name returns the target name used in log messages.
+func (s *SFTP) Name() string { return "sftp" }
+
+// Upload copies localPath to the remote path rendered from key, retrying with
+// resume on transient failures.
+func (s *SFTP) Upload(ctx context.Context, localPath, key string) error {
+	data := sftpPathData{Key: key, File: path.Base(key)}
+	if i := strings.Index(key, "/"); i > 0 {
+		data.Device = key[:i]
+	}
+
+	var buf bytes.Buffer
+	if err := s.pathTmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("rendering remote_path: %w", err)
+	}
+	remotePath := buf.String()
+
+	var err error
+	for attempt := 0; attempt < s.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * s.retryDelay):
+			}
+		}
+		// Retries resume the partial file if the failed attempt got as far
+		// as creating it; reput fails if it doesn't exist.
+		resume := attempt > 0 && s.exists(ctx, remotePath)
+		if err = s.transfer(ctx, localPath, remotePath, resume); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *SFTP) transfer(ctx context.Context, localPath, remotePath string, resume bool) error {
+	var batch strings.Builder
+	// "-" prefixed commands are allowed to fail (directory already exists).
+	var dir string
+	for _, seg := range strings.Split(path.Dir(remotePath), "/") {
+		if seg == "" || seg == "." {
+			if dir == "" && strings.HasPrefix(remotePath, "/") {
+				dir = "/"
+			}
+			continue
+		}
+		dir = path.Join(dir, seg)
+		fmt.Fprintf(&batch, "-mkdir %s\n", quoteSFTP(dir))
+	}
+	verb := "put"
+	if resume {
+		verb = "reput"
+	}
+	fmt.Fprintf(&batch, "%s %s %s\n", verb, quoteSFTP(localPath), quoteSFTP(remotePath))
+	return s.run(ctx, batch.String())
+}
+
+// exists reports whether remotePath exists on the remote host. In batch
+// mode, ls of a missing file fails the whole run.
+func (s *SFTP) exists(ctx context.Context, remotePath string) bool {
+	return s.run(ctx, fmt.Sprintf("ls %s\n", quoteSFTP(remotePath))) == nil
+}
+
+// run runs the sftp client with batch on stdin.
+func (s *SFTP) run(ctx context.Context, batch string) error {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if s.port != 0 {
+		args = append(args, "-P", strconv.Itoa(s.port))
+	}
+	if s.identity != "" {
+		args = append(args, "-i", s.identity)
+	}
+	args = append(args, s.target)
+
+	cmd := proc.Command(ctx, "sftp", args...)
+	cmd.Stdin = strings.NewReader(batch)
+	return cmd.Run()
+}
+
+// quoteSFTP quotes s as one argument of an sftp batch command, which
+// unescapes backslashes as well as quotes.
+func quoteSFTP(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
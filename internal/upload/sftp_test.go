@@ -0,0 +1,97 @@
+package upload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// fakeSFTP is an sftp client that logs each batch, fails the first
+// transfer, and says the remote file exists once a transfer has left a
+// partial one behind.
+const fakeSFTP = `#!/bin/sh
+batch=$(cat)
+printf '%s\n--\n' "$batch" >> "$SFTP_STATE/log"
+case "$batch" in
+"ls "*) [ -e "$SFTP_STATE/exists" ]; exit $? ;;
+esac
+if [ ! -e "$SFTP_STATE/failed" ]; then
+	touch "$SFTP_STATE/failed"
+	[ -n "$SFTP_PARTIAL" ] && touch "$SFTP_STATE/exists"
+	exit 1
+fi
+exit 0
+`
+
+func TestSFTPRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake sftp client is a shell script")
+	}
+	tests := []struct {
+		name    string
+		partial bool
+		verb    string
+	}{
+		{"failed before the file was created", false, "put "},
+		{"failed part way", true, "reput "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin, state := t.TempDir(), t.TempDir()
+			if err := os.WriteFile(filepath.Join(bin, "sftp"), []byte(fakeSFTP), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			t.Setenv("SFTP_STATE", state)
+			partial := ""
+			if tt.partial {
+				partial = "1"
+			}
+			t.Setenv("SFTP_PARTIAL", partial)
+
+			s, err := NewSFTP(config.SFTPConfig{Host: "nas", RemotePath: "cams/{{.Key}}"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.retryDelay = time.Millisecond
+			if err := s.Upload(context.Background(), "/tmp/clip.mp4", "front/clip.mp4"); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+
+			log, _ := os.ReadFile(filepath.Join(state, "log"))
+			batches := strings.Split(strings.TrimSuffix(string(log), "--\n"), "--\n")
+			if len(batches) != 3 {
+				t.Fatalf("ran %d batches, want put, ls and a retry:\n%s", len(batches), log)
+			}
+			if !strings.Contains(batches[0], "\nput ") || !strings.HasPrefix(batches[1], `ls "cams/front/clip.mp4"`) {
+				t.Errorf("first batches:\n%s", log)
+			}
+			last := batches[2][strings.LastIndex(strings.TrimSuffix(batches[2], "\n"), "\n")+1:]
+			if !strings.HasPrefix(last, tt.verb) {
+				t.Errorf("retry ran %q, want %q", last, tt.verb)
+			}
+		})
+	}
+}
+
+func TestQuoteSFTP(t *testing.T) {
+	tests := map[string]string{
+		"plain":       `"plain"`,
+		"with space":  `"with space"`,
+		`say "hi"`:    `"say \"hi\""`,
+		`C:\clips\a`:  `"C:\\clips\\a"`,
+		`trailing\`:   `"trailing\\"`,
+		`both\"mixed`: `"both\\\"mixed"`,
+	}
+	for in, want := range tests {
+		if got := quoteSFTP(in); got != want {
+			t.Errorf("quoteSFTP(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
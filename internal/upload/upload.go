@@ -0,0 +1,92 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/brice/gognestcli/internal/config"
+)
+
+// Uploader pushes a local capture file to remote storage under the given key.
+// Keys always use forward slashes, e.g. "front-door/20240101-120000_person_001.jpg".
+type Uploader interface {
+	Upload(ctx context.Context, localPath, key string) error
+	Name() string
+}
+
+// Manager uploads captures to every configured target.
+type Manager struct {
+	targets     []Uploader
+	deleteLocal bool
+}
+
+// NewManager builds the upload targets described by cfg. tokenFn supplies the
+// Google OAuth token used by the GCS and Drive targets. It returns nil if no
+// target is configured.
+func NewManager(cfg *config.UploadConfig, tokenFn func() (string, error)) (*Manager, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	var targets []Uploader
+	if cfg.S3 != nil {
+		s3, err := NewS3(*cfg.S3)
+		if err != nil {
+			return nil, fmt.Errorf("configuring s3 upload: %w", err)
+		}
+		targets = append(targets, s3)
+	}
+	if cfg.GCS != nil {
+		gcs, err := NewGCS(*cfg.GCS, tokenFn)
+		if err != nil {
+			return nil, fmt.Errorf("configuring gcs upload: %w", err)
+		}
+		targets = append(targets, gcs)
+	}
+	if cfg.Drive != nil {
+		targets = append(targets, NewDrive(*cfg.Drive, tokenFn))
+	}
+	if cfg.SFTP != nil {
+		sftp, err := NewSFTP(*cfg.SFTP)
+		if err != nil {
+			return nil, fmt.Errorf("configuring sftp upload: %w", err)
+		}
+		targets = append(targets, sftp)
+	}
+
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	return &Manager{targets: targets, deleteLocal: cfg.DeleteLocal}, nil
+}
+
+// Upload sends localPath to every target. The local file is removed afterwards
+// only if delete_local is set and every target succeeded.
+func (m *Manager) Upload(ctx context.Context, localPath, key string) error {
+	if key == "" {
+		key = filepath.Base(localPath)
+	}
+	key = path.Clean(filepath.ToSlash(key))
+
+	var firstErr error
+	for _, t := range m.targets {
+		if err := t.Upload(ctx, localPath, key); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s upload: %w", t.Name(), err)
+			}
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if m.deleteLocal {
+		if err := os.Remove(localPath); err != nil {
+			return fmt.Errorf("removing local file: %w", err)
+		}
+	}
+	return nil
+}
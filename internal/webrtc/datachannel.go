@@ -0,0 +1,117 @@
+package webrtc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// dataKeepalive is how often a keepalive goes out on the data channel once
+// the camera has shown it uses the channel, if nothing else was sent.
+const dataKeepalive = 15 * time.Second
+
+// DataMessage is a message a camera sent on a data channel.
+type DataMessage struct {
+	Channel  string // label of the channel, e.g. "dataSendChannel"
+	Data     []byte
+	IsString bool
+}
+
+// dataChannels tracks the session's data channels: the dataSendChannel Nest
+// requires in the offer, and any the camera opens itself.
+type dataChannels struct {
+	send *webrtc.DataChannel
+	log  *slog.Logger
+
+	mu        sync.Mutex
+	onMessage func(DataMessage)
+	received  int
+	lastIn    time.Time
+	lastOut   time.Time
+}
+
+// watch handles a data channel's messages.
+func (d *dataChannels) watch(dc *webrtc.DataChannel) {
+	label := dc.Label()
+	dc.OnOpen(func() { d.log.Debug("data channel open", "label", label) })
+	dc.OnClose(func() { d.log.Debug("data channel closed", "label", label) })
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		d.mu.Lock()
+		d.received++
+		d.lastIn = time.Now()
+		fn := d.onMessage
+		d.mu.Unlock()
+
+		preview := fmt.Sprintf("%d bytes", len(msg.Data))
+		if msg.IsString {
+			preview = string(msg.Data)
+			if len(preview) > 200 {
+				preview = preview[:200] + "..."
+			}
+		}
+		d.log.Debug("data channel message", "label", label, "data", preview)
+		if fn != nil {
+			fn(DataMessage{Channel: label, Data: msg.Data, IsString: msg.IsString})
+		}
+	})
+}
+
+// OnDataMessage calls fn with each message the camera sends on a data
+// channel. Some cameras send status or keepalive messages; most send none.
+func (s *Session) OnDataMessage(fn func(DataMessage)) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	s.data.onMessage = fn
+}
+
+// DataReceived returns how many data channel messages the camera has sent
+// and when the last one arrived.
+func (s *Session) DataReceived() (n int, last time.Time) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	return s.data.received, s.data.lastIn
+}
+
+// SendData sends a binary message on the dataSendChannel.
+func (s *Session) SendData(data []byte) error {
+	if s.data.send.ReadyState() != webrtc.DataChannelStateOpen {
+		return fmt.Errorf("data channel is %s", s.data.send.ReadyState())
+	}
+	if err := s.data.send.Send(data); err != nil {
+		return err
+	}
+	s.data.mu.Lock()
+	s.data.lastOut = time.Now()
+	s.data.mu.Unlock()
+	return nil
+}
+
+// keepaliveLoop sends an empty message on the dataSendChannel every
+// dataKeepalive once the camera has sent something on a data channel,
+// which shows it's watching the channel and may close an idle one. Cameras
+// that never send anything aren't sent anything either.
+func (s *Session) keepaliveLoop(ctx context.Context) {
+	ticker := time.NewTicker(dataKeepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.data.mu.Lock()
+		due := s.data.received > 0 && time.Since(s.data.lastOut) >= dataKeepalive
+		s.data.mu.Unlock()
+		if !due {
+			continue
+		}
+		if err := s.SendData(nil); err != nil {
+			s.log.Debug("data channel keepalive failed", "err", err)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+// Track is a remote media track as readers consume it: a *webrtc.TrackRemote
+// straight from a Session, or a StreamHub subscriber's copy of one.
+type Track interface {
+	Read(b []byte) (n int, attributes interceptor.Attributes, err error)
+	Codec() webrtc.RTPCodecParameters
+	Kind() webrtc.RTPCodecType
+}
+
+// Opener opens a camera stream for a StreamHub. It calls handler with each
+// of the stream's tracks until ctx is done, expiring once the stream can't
+// be kept alive (see Session.OnExpiringSoon), and closed once it has ended
+// (see Session.OnClosed).
+type Opener func(ctx context.Context, handler func(Track), expiring, closed func()) error
+
+const (
+	// hubTrackDepth is how many packets a subscriber may fall behind
+	// before its copy starts losing them, rather than holding up the
+	// others.
+	hubTrackDepth = 512
+
+	// renewGrace is how long an expiring stream is kept once its
+	// replacement's video has taken over, for its other tracks to follow.
+	renewGrace = 10 * time.Second
+
+	// hubPacketSize fits any RTP packet on a 1500-byte MTU path.
+	hubPacketSize = 1500
+)
+
+// errStreamEnded is returned to subscribers waiting on a stream that was
+// closed before it finished opening.
+var errStreamEnded = errors.New("stream ended while opening")
+
+// StreamHub shares one camera stream among any number of readers, so a
+// recorder, a pre-roll buffer and a snapshot never need a Nest session
+// each. The stream is opened when the first reader subscribes and closed
+// when the last one leaves or it ends; the next subscriber then opens a new
+// one. A stream that can't be extended is replaced before it expires, and
+// the new one is spliced into the readers' tracks without ending them.
+type StreamHub struct {
+	open Opener
+	log  *slog.Logger
+
+	mu   sync.Mutex
+	subs map[*hubSub]struct{}
+	up   *hubUpstream // the open stream, nil when none
+}
+
+type hubSub struct {
+	handler func(Track)
+}
+
+type hubUpstream struct {
+	cancel   context.CancelFunc
+	sources  []*hubSource
+	renewing bool
+	replaces *hubUpstream // the expiring stream whose sources this one takes over
+	open     func() error
+
+	ready  chan struct{} // closed once open has returned, with err set
+	err    error
+	opened bool // open succeeded
+	closed bool // the session has ended
+}
+
+// hubSource is one of the stream's tracks with its subscribers' copies. It
+// outlives the upstream track feeding it when a replacement takes over.
+type hubSource struct {
+	codec  webrtc.RTPCodecParameters
+	kind   webrtc.RTPCodecType
+	copies map[*hubSub]*hubTrack
+	feeder *hubUpstream
+
+	// The last sequence number and timestamp sent, and when, so a new feed
+	// carries on from them; seqOff and tsOff map the feed's onto them.
+	seq    uint16
+	ts     uint32
+	at     time.Time
+	resync bool
+	seqOff uint16
+	tsOff  uint32
+}
+
+// NewStreamHub returns a hub opening its stream with open. Of opts, only
+// WithLogger applies; open configures the sessions themselves.
+func NewStreamHub(open Opener, opts ...Option) *StreamHub {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &StreamHub{open: open, log: o.logger(), subs: map[*hubSub]struct{}{}}
+}
+
+// Subscribe calls handler, in a goroutine of its own, with a copy of each of
+// the stream's tracks, those already received and any that arrive later,
+// opening the stream if needed. The copies end when ctx is done or the
+// stream ends. It can stand in for a single-reader stream starter.
+func (h *StreamHub) Subscribe(ctx context.Context, handler func(Track)) error {
+	h.mu.Lock()
+	// While a stream is being renewed, the expiring one's tracks are there
+	// to subscribe to. Otherwise the stream has to be open first; other
+	// subscribers wait on ready rather than the lock while it's signaled.
+	if up := h.up; up == nil || up.replaces == nil {
+		if up == nil {
+			up = h.newUpstream(nil)
+			h.up = up
+			h.mu.Unlock()
+			h.start(up)
+		} else {
+			h.mu.Unlock()
+		}
+		if err := up.wait(ctx); err != nil {
+			return err
+		}
+		h.mu.Lock()
+		if h.up == nil {
+			h.mu.Unlock()
+			return errStreamEnded
+		}
+	}
+	defer h.mu.Unlock()
+
+	sub := &hubSub{handler: handler}
+	h.subs[sub] = struct{}{}
+	for _, src := range h.sources() {
+		go handler(src.add(sub))
+	}
+	go func() {
+		<-ctx.Done()
+		h.unsubscribe(sub)
+	}()
+	return nil
+}
+
+// newUpstream returns a stream yet to be opened with start, replacing old
+// if it isn't nil.
+func (h *StreamHub) newUpstream(old *hubUpstream) *hubUpstream {
+	ctx, cancel := context.WithCancel(context.Background())
+	up := &hubUpstream{cancel: cancel, replaces: old, ready: make(chan struct{})}
+	up.open = func() error {
+		return h.open(ctx, func(track Track) { h.forward(up, track) }, func() { h.renew(up) }, func() { h.ended(up) })
+	}
+	return up
+}
+
+// start opens up, which is already h.up so its tracks are forwarded as soon
+// as they arrive, and closes its ready channel. Callers don't hold mu.
+func (h *StreamHub) start(up *hubUpstream) {
+	err := up.open()
+	h.mu.Lock()
+	if err != nil {
+		up.cancel()
+		if h.up == up {
+			h.up = up.replaces
+		}
+	} else {
+		up.opened = true
+		if up.closed {
+			h.retire(up)
+		}
+	}
+	up.err = err
+	close(up.ready)
+	h.mu.Unlock()
+}
+
+// wait returns once up has been opened, with open's error, or ctx is done.
+func (up *hubUpstream) wait(ctx context.Context) error {
+	select {
+	case <-up.ready:
+		return up.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ended is called once up's session has closed. A stream that ended
+// without tracks is retired here; otherwise forward does it as they end.
+func (h *StreamHub) ended(up *hubUpstream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	up.closed = true
+	if up.opened {
+		h.retire(up)
+	}
+}
+
+// retire forgets up once it has no tracks left: the next subscriber opens
+// a new stream, or the stream replacing it stops waiting for its tracks.
+// Callers hold mu.
+func (h *StreamHub) retire(up *hubUpstream) {
+	switch {
+	case len(up.sources) > 0:
+	case h.up == up:
+		h.stop()
+	case h.up != nil && h.up.replaces == up:
+		h.up.replaces = nil
+		up.cancel()
+	}
+}
+
+// sources returns the tracks subscribers get copies of, including those
+// still fed by a stream being replaced. Callers hold mu.
+func (h *StreamHub) sources() []*hubSource {
+	if h.up == nil {
+		return nil
+	}
+	srcs := h.up.sources
+	if h.up.replaces != nil {
+		srcs = append(srcs[:len(srcs):len(srcs)], h.up.replaces.sources...)
+	}
+	return srcs
+}
+
+// stop closes the stream and the one it replaces, if any. Callers hold mu.
+func (h *StreamHub) stop() {
+	if h.up == nil {
+		return
+	}
+	h.up.cancel()
+	if h.up.replaces != nil {
+		h.up.replaces.cancel()
+	}
+	h.up = nil
+}
+
+// unsubscribe ends sub's copies, closing the stream if it was the last
+// subscriber.
+func (h *StreamHub) unsubscribe(sub *hubSub) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	var ended []*hubTrack
+	for _, src := range h.sources() {
+		if t := src.copies[sub]; t != nil {
+			delete(src.copies, sub)
+			ended = append(ended, t)
+		}
+	}
+	if len(h.subs) == 0 {
+		h.stop()
+	}
+	h.mu.Unlock()
+	for _, t := range ended {
+		t.end()
+	}
+}
+
+// renew opens a replacement for the expiring stream up. Its tracks take
+// over up's as they arrive; if it can't be opened, up runs until it expires
+// and its subscribers' tracks end as usual.
+func (h *StreamHub) renew(up *hubUpstream) {
+	h.mu.Lock()
+	if h.up != up || up.renewing {
+		h.mu.Unlock()
+		return
+	}
+	up.renewing = true
+	h.mu.Unlock()
+
+	h.log.Info("Stream expiring, opening a new one...")
+	next := h.newUpstream(up)
+	// Tracks of next may arrive before open returns, so it's h.up first.
+	h.mu.Lock()
+	if h.up != up {
+		h.mu.Unlock()
+		next.cancel()
+		return
+	}
+	h.up = next
+	h.mu.Unlock()
+
+	h.start(next)
+	if next.err != nil {
+		h.log.Warn("replacing expiring stream failed", "err", next.err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.up != next {
+		// Everyone left while it opened.
+		next.cancel()
+	}
+}
+
+// forward copies a track of up to its subscribers until it ends or a
+// replacement stream takes it over. When the hub's stream has no tracks
+// left it's closed, so the next subscriber opens a new one.
+func (h *StreamHub) forward(up *hubUpstream, track Track) {
+	h.mu.Lock()
+	if h.up != up {
+		h.mu.Unlock()
+		return
+	}
+	src := h.takeOver(up, track.Kind())
+	if src == nil {
+		src = &hubSource{codec: track.Codec(), kind: track.Kind(), copies: map[*hubSub]*hubTrack{}, feeder: up}
+		up.sources = append(up.sources, src)
+		for sub := range h.subs {
+			go sub.handler(src.add(sub))
+		}
+	}
+	h.mu.Unlock()
+
+	clock := track.Codec().ClockRate
+	for {
+		// One copy shared by every subscriber; they only read it, and the
+		// last one to do so returns it to the pool.
+		pkt := hubPacketPool.Get().(*hubPacket)
+		n, _, err := track.Read(pkt.buf)
+		if err != nil {
+			hubPacketPool.Put(pkt)
+			break
+		}
+		pkt.n = n
+		pkt.refs.Store(1)
+		h.mu.Lock()
+		if src.feeder != up {
+			h.mu.Unlock()
+			pkt.release()
+			return
+		}
+		src.splice(pkt.buf[:n], clock)
+		for _, t := range src.copies {
+			t.send(pkt)
+		}
+		h.mu.Unlock()
+		pkt.release()
+	}
+
+	h.mu.Lock()
+	if src.feeder != up {
+		h.mu.Unlock()
+		return
+	}
+	var ended []*hubTrack
+	for _, t := range src.copies {
+		ended = append(ended, t)
+	}
+	src.copies = map[*hubSub]*hubTrack{}
+	up.remove(src)
+	h.retire(up)
+	h.mu.Unlock()
+	for _, t := range ended {
+		t.end()
+	}
+}
+
+// takeOver hands a source of the stream up replaces, of the given kind, to
+// up, or returns nil if there's none. Callers hold mu.
+func (h *StreamHub) takeOver(up *hubUpstream, kind webrtc.RTPCodecType) *hubSource {
+	old := up.replaces
+	if old == nil {
+		return nil
+	}
+	for _, src := range old.sources {
+		if src.kind != kind {
+			continue
+		}
+		old.remove(src)
+		src.feeder = up
+		src.resync = true
+		up.sources = append(up.sources, src)
+		if len(old.sources) == 0 {
+			old.cancel()
+			up.replaces = nil
+		} else if kind == webrtc.RTPCodecTypeVideo {
+			time.AfterFunc(renewGrace, old.cancel)
+		}
+		return src
+	}
+	return nil
+}
+
+func (up *hubUpstream) remove(src *hubSource) {
+	for i, s := range up.sources {
+		if s == src {
+			up.sources = append(up.sources[:i], up.sources[i+1:]...)
+			return
+		}
+	}
+}
+
+// Close ends every subscriber's copies and closes the stream.
+func (h *StreamHub) Close() {
+	h.mu.Lock()
+	var ended []*hubTrack
+	for _, src := range h.sources() {
+		for _, t := range src.copies {
+			ended = append(ended, t)
+		}
+		src.copies = map[*hubSub]*hubTrack{}
+	}
+	h.stop()
+	h.mu.Unlock()
+	for _, t := range ended {
+		t.end()
+	}
+}
+
+// add creates sub's copy of src. Callers hold the hub's mu.
+func (src *hubSource) add(sub *hubSub) *hubTrack {
+	t := &hubTrack{codec: src.codec, kind: src.kind, packets: make(chan *hubPacket, hubTrackDepth), done: make(chan struct{})}
+	src.copies[sub] = t
+	return t
+}
+
+// splice rewrites an RTP packet's sequence number and timestamp so a new
+// feed carries on where the last one stopped, its timestamps advanced by
+// the time in between. Callers hold the hub's mu.
+func (src *hubSource) splice(pkt []byte, clock uint32) {
+	if len(pkt) < 12 {
+		return
+	}
+	seq := binary.BigEndian.Uint16(pkt[2:])
+	ts := binary.BigEndian.Uint32(pkt[4:])
+	if src.resync {
+		src.resync = false
+		src.seqOff = src.seq + 1 - seq
+		src.tsOff = src.ts + uint32(time.Since(src.at).Seconds()*float64(clock)) - ts
+	}
+	seq += src.seqOff
+	ts += src.tsOff
+	binary.BigEndian.PutUint16(pkt[2:], seq)
+	binary.BigEndian.PutUint32(pkt[4:], ts)
+	if src.at.IsZero() || int16(seq-src.seq) > 0 {
+		src.seq = seq
+	}
+	if src.at.IsZero() || int32(ts-src.ts) > 0 {
+		src.ts = ts
+	}
+	src.at = time.Now()
+}
+
+// hubPacketPool recycles the packets forward reads, so a shared stream
+// doesn't allocate for every packet.
+var hubPacketPool = sync.Pool{
+	New: func() any { return &hubPacket{buf: make([]byte, hubPacketSize)} },
+}
+
+// hubPacket is a packet queued for any number of subscribers' copies. It
+// goes back to the pool once each of them has read or dropped it.
+type hubPacket struct {
+	buf  []byte
+	n    int
+	refs atomic.Int32
+}
+
+func (p *hubPacket) release() {
+	if p.refs.Add(-1) == 0 {
+		hubPacketPool.Put(p)
+	}
+}
+
+// hubTrack is a subscriber's copy of a track.
+type hubTrack struct {
+	codec   webrtc.RTPCodecParameters
+	kind    webrtc.RTPCodecType
+	packets chan *hubPacket
+	done    chan struct{}
+	once    sync.Once
+}
+
+// send queues a packet, dropping it if the reader is too far behind; its
+// samplebuilder sees that as packet loss.
+func (t *hubTrack) send(pkt *hubPacket) {
+	pkt.refs.Add(1)
+	select {
+	case t.packets <- pkt:
+	default:
+		pkt.release()
+	}
+}
+
+func (t *hubTrack) end() {
+	t.once.Do(func() { close(t.done) })
+}
+
+// Read copies the next packet into b, returning io.EOF once the copy has
+// ended.
+func (t *hubTrack) Read(b []byte) (int, interceptor.Attributes, error) {
+	select {
+	case pkt := <-t.packets:
+		defer pkt.release()
+		if pkt.n > len(b) {
+			return 0, nil, io.ErrShortBuffer
+		}
+		return copy(b, pkt.buf[:pkt.n]), nil, nil
+	case <-t.done:
+		return 0, nil, io.EOF
+	}
+}
+
+func (t *hubTrack) Codec() webrtc.RTPCodecParameters { return t.codec }
+
+func (t *hubTrack) Kind() webrtc.RTPCodecType { return t.kind }
@@ -0,0 +1,410 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+// chanTrack is a track whose packets are sent on a channel; closing it ends
+// the track.
+type chanTrack struct {
+	kind    webrtc.RTPCodecType
+	packets chan []byte
+}
+
+func (t *chanTrack) Read(b []byte) (int, interceptor.Attributes, error) {
+	pkt, ok := <-t.packets
+	if !ok {
+		return 0, nil, io.EOF
+	}
+	return copy(b, pkt), nil, nil
+}
+
+func (t *chanTrack) Codec() webrtc.RTPCodecParameters {
+	return webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}}
+}
+
+func (t *chanTrack) Kind() webrtc.RTPCodecType { return t.kind }
+
+// rtpPacket returns a minimal RTP packet with a one-byte payload.
+func rtpPacket(seq uint16, ts uint32, payload byte) []byte {
+	pkt := make([]byte, 13)
+	pkt[0] = 0x80
+	binary.BigEndian.PutUint16(pkt[2:], seq)
+	binary.BigEndian.PutUint32(pkt[4:], ts)
+	pkt[12] = payload
+	return pkt
+}
+
+// fakeStream is one stream opened by a fakeOpener.
+type fakeStream struct {
+	ctx              context.Context
+	handler          func(Track)
+	expiring, closed func()
+}
+
+// addTrack delivers a new track of the stream, as pion would from a
+// goroutine of its own.
+func (s *fakeStream) addTrack(kind webrtc.RTPCodecType) *chanTrack {
+	t := &chanTrack{kind: kind, packets: make(chan []byte)}
+	go s.handler(t)
+	return t
+}
+
+// fakeOpener records the streams a hub opens. open, if set, is called for
+// each one and its error returned.
+type fakeOpener struct {
+	mu      sync.Mutex
+	streams []*fakeStream
+	open    func(s *fakeStream) error
+}
+
+func (f *fakeOpener) Open(ctx context.Context, handler func(Track), expiring, closed func()) error {
+	s := &fakeStream{ctx: ctx, handler: handler, expiring: expiring, closed: closed}
+	f.mu.Lock()
+	f.streams = append(f.streams, s)
+	open := f.open
+	f.mu.Unlock()
+	if open != nil {
+		return open(s)
+	}
+	return nil
+}
+
+func (f *fakeOpener) stream(i int) *fakeStream {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.streams[i]
+}
+
+func (f *fakeOpener) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.streams)
+}
+
+// subscribe subscribes to h and returns a channel of the tracks handed to
+// the subscriber.
+func subscribe(t *testing.T, ctx context.Context, h *StreamHub) <-chan Track {
+	t.Helper()
+	tracks := make(chan Track, 8)
+	if err := h.Subscribe(ctx, func(tr Track) { tracks <- tr }); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return tracks
+}
+
+func recvTrack(t *testing.T, tracks <-chan Track) Track {
+	t.Helper()
+	select {
+	case tr := <-tracks:
+		return tr
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a track")
+		return nil
+	}
+}
+
+// readPacket reads a packet from tr and returns its sequence number,
+// timestamp and payload byte.
+func readPacket(t *testing.T, tr Track) (uint16, uint32, byte) {
+	t.Helper()
+	type result struct {
+		pkt []byte
+		err error
+	}
+	got := make(chan result, 1)
+	go func() {
+		b := make([]byte, 1500)
+		n, _, err := tr.Read(b)
+		got <- result{b[:n], err}
+	}()
+	select {
+	case r := <-got:
+		if r.err != nil || len(r.pkt) != 13 {
+			t.Fatalf("Read = %d bytes, %v", len(r.pkt), r.err)
+		}
+		return binary.BigEndian.Uint16(r.pkt[2:]), binary.BigEndian.Uint32(r.pkt[4:]), r.pkt[12]
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a packet")
+		return 0, 0, 0
+	}
+}
+
+func waitDone(t *testing.T, ctx context.Context, what string) {
+	t.Helper()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s wasn't closed", what)
+	}
+}
+
+func TestHubFanOut(t *testing.T) {
+	f := &fakeOpener{}
+	h := NewStreamHub(f.Open)
+	ctx, cancel := context.WithCancel(context.Background())
+	a, b := subscribe(t, ctx, h), subscribe(t, ctx, h)
+	if n := f.count(); n != 1 {
+		t.Fatalf("opened %d streams for two subscribers", n)
+	}
+
+	track := f.stream(0).addTrack(webrtc.RTPCodecTypeVideo)
+	ta, tb := recvTrack(t, a), recvTrack(t, b)
+	for i := range 3 {
+		track.packets <- rtpPacket(uint16(10+i), uint32(i*3000), byte(i))
+	}
+	for _, tr := range []Track{ta, tb} {
+		for i := range 3 {
+			if seq, _, p := readPacket(t, tr); seq != uint16(10+i) || p != byte(i) {
+				t.Errorf("packet %d: seq %d, payload %d", i, seq, p)
+			}
+		}
+	}
+
+	// The last subscriber leaving closes the stream.
+	cancel()
+	waitDone(t, f.stream(0).ctx, "stream")
+}
+
+func TestHubOpensWithoutLock(t *testing.T) {
+	release := make(chan struct{})
+	opening := make(chan struct{})
+	var track *chanTrack
+	f := &fakeOpener{open: func(s *fakeStream) error {
+		// A track may arrive before signaling is done.
+		track = s.addTrack(webrtc.RTPCodecTypeVideo)
+		close(opening)
+		<-release
+		return nil
+	}}
+	h := NewStreamHub(f.Open)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := make(chan (<-chan Track), 1)
+	go func() { first <- subscribe(t, ctx, h) }()
+	<-opening
+	if !h.mu.TryLock() {
+		t.Fatal("hub locked while the stream is being opened")
+	}
+	h.mu.Unlock()
+
+	second := make(chan (<-chan Track), 1)
+	go func() { second <- subscribe(t, ctx, h) }()
+	select {
+	case <-second:
+		t.Fatal("second subscriber didn't wait for the stream to open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	a, b := recvTrack(t, <-first), recvTrack(t, <-second)
+	track.packets <- rtpPacket(1, 0, 7)
+	for _, tr := range []Track{a, b} {
+		if _, _, p := readPacket(t, tr); p != 7 {
+			t.Errorf("payload %d, want 7", p)
+		}
+	}
+	if n := f.count(); n != 1 {
+		t.Errorf("opened %d streams, want 1", n)
+	}
+}
+
+func TestHubOpenError(t *testing.T) {
+	errOpen := errors.New("signaling failed")
+	f := &fakeOpener{open: func(*fakeStream) error { return errOpen }}
+	h := NewStreamHub(f.Open)
+	for range 2 {
+		if err := h.Subscribe(context.Background(), func(Track) {}); !errors.Is(err, errOpen) {
+			t.Fatalf("Subscribe = %v, want the open error", err)
+		}
+	}
+	if n := f.count(); n != 2 {
+		t.Errorf("opened %d streams, want a new one per subscriber after a failure", n)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	f := &fakeOpener{}
+	h := NewStreamHub(f.Open)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fast, slow := subscribe(t, ctx, h), subscribe(t, ctx, h)
+	track := f.stream(0).addTrack(webrtc.RTPCodecTypeVideo)
+	tf, ts := recvTrack(t, fast), recvTrack(t, slow)
+
+	// The slow subscriber reads nothing until the fast one has read more
+	// packets than its queue holds; the packets it kept mustn't have been
+	// reused for later ones.
+	total := hubTrackDepth + 50
+	for i := range total {
+		track.packets <- rtpPacket(uint16(i), uint32(i), byte(i))
+		if seq, _, p := readPacket(t, tf); seq != uint16(i) || p != byte(i) {
+			t.Fatalf("fast subscriber packet %d: seq %d, payload %d", i, seq, p)
+		}
+	}
+	for i := range hubTrackDepth {
+		if seq, _, p := readPacket(t, ts); seq != uint16(i) || p != byte(i) {
+			t.Fatalf("slow subscriber packet %d: seq %d, payload %d", i, seq, p)
+		}
+	}
+}
+
+func TestHubEndedWithoutTracks(t *testing.T) {
+	tests := []struct {
+		name      string
+		close     string // when the first stream ends: "opening", "after" or ""
+		wantErr   error  // from the first Subscribe
+		wantOpens int    // after a second Subscribe
+	}{
+		{"closed while opening", "opening", errStreamEnded, 2},
+		{"closed after opening", "after", nil, 2},
+		{"still open", "", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeOpener{}
+			f.open = func(s *fakeStream) error {
+				if tt.close == "opening" && f.count() == 1 {
+					s.closed()
+				}
+				return nil
+			}
+			h := NewStreamHub(f.Open)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := h.Subscribe(ctx, func(Track) {}); err != tt.wantErr {
+				t.Fatalf("Subscribe = %v, want %v", err, tt.wantErr)
+			}
+			if tt.close == "after" {
+				f.stream(0).closed()
+			}
+			if tt.close != "" {
+				waitDone(t, f.stream(0).ctx, "dead stream")
+			}
+
+			subscribe(t, ctx, h)
+			if n := f.count(); n != tt.wantOpens {
+				t.Errorf("opened %d streams, want %d", n, tt.wantOpens)
+			}
+		})
+	}
+}
+
+func TestHubRenew(t *testing.T) {
+	f := &fakeOpener{}
+	h := NewStreamHub(f.Open)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracks := subscribe(t, ctx, h)
+	first := f.stream(0)
+	old := first.addTrack(webrtc.RTPCodecTypeVideo)
+	tr := recvTrack(t, tracks)
+	for i := range 3 {
+		old.packets <- rtpPacket(uint16(100+i), uint32(9000+i*3000), byte(i))
+		readPacket(t, tr)
+	}
+	lastTS := uint32(9000 + 2*3000)
+
+	// The replacement's track carries on from the old one's sequence
+	// numbers and timestamps, in the subscriber's existing copy.
+	first.expiring()
+	if n := f.count(); n != 2 {
+		t.Fatalf("opened %d streams, want a replacement", n)
+	}
+	time.Sleep(100 * time.Millisecond)
+	next := f.stream(1).addTrack(webrtc.RTPCodecTypeVideo)
+	next.packets <- rtpPacket(7, 500, 10)
+	next.packets <- rtpPacket(8, 3500, 11)
+
+	seq, ts, p := readPacket(t, tr)
+	if seq != 103 || p != 10 {
+		t.Errorf("first spliced packet: seq %d, payload %d; want 103, 10", seq, p)
+	}
+	// 100ms went by between the feeds: 9000 ticks at 90 kHz.
+	if gap := ts - lastTS; gap < 9000 || gap > 90000 {
+		t.Errorf("first spliced packet %d ticks after the last, want about 9000", gap)
+	}
+	seq2, ts2, _ := readPacket(t, tr)
+	if seq2 != 104 || ts2-ts != 3000 {
+		t.Errorf("second spliced packet: seq %d, ts +%d; want 104, +3000", seq2, ts2-ts)
+	}
+	select {
+	case extra := <-tracks:
+		t.Errorf("subscriber got a new track %v instead of a spliced one", extra)
+	default:
+	}
+
+	// With its only track taken over, the expiring stream is closed.
+	waitDone(t, first.ctx, "expiring stream")
+}
+
+func TestSplice(t *testing.T) {
+	tests := []struct {
+		name     string
+		seq      uint16 // last sent before the new feed
+		ts       uint32
+		gap      time.Duration // since it was sent
+		in       []uint16      // the new feed's sequence numbers
+		inTS     uint32        // and timestamp
+		wantSeq  []uint16
+		wantTS   uint32 // of the first, give or take the time the test takes
+		wantLast uint16
+	}{
+		{
+			name: "gap", seq: 500, ts: 90000, gap: time.Second,
+			in: []uint16{1, 2}, inTS: 42,
+			wantSeq: []uint16{501, 502}, wantTS: 180000,
+			wantLast: 502,
+		},
+		{
+			name: "wraps", seq: 65535, ts: 4294967000, gap: 0,
+			in: []uint16{10, 11}, inTS: 0,
+			wantSeq: []uint16{0, 1}, wantTS: 4294967000,
+			wantLast: 1,
+		},
+		{
+			name: "late packet", seq: 10, ts: 0, gap: 0,
+			in: []uint16{5, 4}, inTS: 0,
+			wantSeq: []uint16{11, 10}, wantTS: 0,
+			wantLast: 11,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &hubSource{seq: tt.seq, ts: tt.ts, at: time.Now().Add(-tt.gap), resync: true}
+			for i, in := range tt.in {
+				pkt := rtpPacket(in, tt.inTS, 0)
+				src.splice(pkt, 90000)
+				seq := binary.BigEndian.Uint16(pkt[2:])
+				if seq != tt.wantSeq[i] {
+					t.Errorf("packet %d: seq %d, want %d", i, seq, tt.wantSeq[i])
+				}
+				if i == 0 {
+					ts := binary.BigEndian.Uint32(pkt[4:])
+					if ts-tt.wantTS > 9000 {
+						t.Errorf("ts %d, want %d", ts, tt.wantTS)
+					}
+				}
+			}
+			if src.seq != tt.wantLast {
+				t.Errorf("last seq %d, want %d", src.seq, tt.wantLast)
+			}
+		})
+	}
+
+	short := []byte{1, 2, 3}
+	(&hubSource{resync: true}).splice(short, 90000)
+	if short[2] != 3 {
+		t.Error("splice modified a packet too short for an RTP header")
+	}
+}
@@ -0,0 +1,99 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// WithInterfaces limits ICE to network interfaces matching one of names,
+// which may be shell patterns like "en*". On a multi-homed host (a VPN next
+// to the LAN, Docker bridges) this keeps the camera from being offered
+// addresses it can't reach, which otherwise stalls connecting for 20
+// seconds or more. No names means every interface.
+func WithInterfaces(names []string) Option {
+	return func(o *options) { o.interfaces = names }
+}
+
+// WithIPFamily limits ICE to "ipv4" or "ipv6" addresses; "" allows both.
+func WithIPFamily(family string) Option {
+	return func(o *options) { o.family = family }
+}
+
+// WithUDPPorts binds ICE's UDP sockets to ports in a range like
+// "50000-50100" instead of random ones, so a firewall can allow them.
+// Each session takes a port per interface it gathers on, so the range
+// needs room for every stream open at once. "" means random ports.
+func WithUDPPorts(portRange string) Option {
+	return func(o *options) { o.udpPorts = portRange }
+}
+
+// ParsePortRange parses a "min-max" port range, or a single port.
+func ParsePortRange(s string) (min, max uint16, err error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		hi = lo
+	}
+	a, err1 := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	b, err2 := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if err1 != nil || err2 != nil || a == 0 || a > b {
+		return 0, 0, fmt.Errorf("invalid port range %q (want e.g. 50000-50100)", s)
+	}
+	return uint16(a), uint16(b), nil
+}
+
+// applyNetwork sets the interface, IP family and port restrictions on se.
+func (o options) applyNetwork(se *webrtc.SettingEngine) error {
+	if o.udpPorts != "" {
+		lo, hi, err := ParsePortRange(o.udpPorts)
+		if err != nil {
+			return err
+		}
+		if err := se.SetEphemeralUDPPortRange(lo, hi); err != nil {
+			return fmt.Errorf("setting UDP port range: %w", err)
+		}
+	}
+
+	switch o.family {
+	case "":
+	case "ipv4":
+		se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeTCP4})
+	case "ipv6":
+		se.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP6, webrtc.NetworkTypeTCP6})
+	default:
+		return fmt.Errorf("unknown IP family %q (ipv4 or ipv6)", o.family)
+	}
+
+	if len(o.interfaces) == 0 {
+		return nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("listing network interfaces: %w", err)
+	}
+	found := false
+	for _, iface := range ifaces {
+		if o.interfaceAllowed(iface.Name) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no network interface matches %s", strings.Join(o.interfaces, ", "))
+	}
+	se.SetInterfaceFilter(o.interfaceAllowed)
+	return nil
+}
+
+func (o options) interfaceAllowed(name string) bool {
+	for _, pattern := range o.interfaces {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// stunServerAlt is a second STUN server for ProbeNAT to compare mappings
+// against.
+const stunServerAlt = "stun1.l.google.com:19302"
+
+// ProbeUDP sends a STUN binding request to the session's STUN server and
+// returns the public address it reports. An error means outbound UDP, which
+// WebRTC media needs, is blocked or filtered.
+func ProbeUDP(timeout time.Duration) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return stunBinding(conn, stunServer, timeout)
+}
+
+// NATMapping is what ProbeNAT found: the public address each STUN server
+// saw for the same local port.
+type NATMapping struct {
+	Servers []string
+	Mapped  []string // by server; "" where it didn't answer
+}
+
+// EndpointIndependent reports whether every server that answered saw the
+// same public address. If not, the NAT maps each destination to a new port
+// ("symmetric" NAT), which defeats direct connections to the camera, and
+// only a TURN relay works reliably.
+func (m NATMapping) EndpointIndependent() bool {
+	seen := ""
+	for _, addr := range m.Mapped {
+		switch {
+		case addr == "":
+		case seen == "":
+			seen = addr
+		case addr != seen:
+			return false
+		}
+	}
+	return true
+}
+
+// ProbeNAT sends STUN binding requests to two servers from one local port
+// and reports the public addresses they saw. It fails only if neither
+// answers.
+func ProbeNAT(timeout time.Duration) (NATMapping, error) {
+	m := NATMapping{Servers: []string{stunServer, stunServerAlt}}
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return m, err
+	}
+	defer conn.Close()
+
+	var firstErr error
+	for _, server := range m.Servers {
+		addr, err := stunBinding(conn, server, timeout)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		m.Mapped = append(m.Mapped, addr)
+	}
+	if m.Mapped[0] == "" && m.Mapped[1] == "" {
+		return m, firstErr
+	}
+	return m, nil
+}
+
+// stunBinding asks server, over conn, for the public address conn is seen
+// from.
+func stunBinding(conn net.PacketConn, server string, timeout time.Duration) (string, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return "", err
+	}
+	req := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.WriteTo(req.Raw, raddr); err != nil {
+		return "", err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no STUN response from %s: %w", server, err)
+		}
+		res := &stun.Message{Raw: buf[:n]}
+		if err := res.Decode(); err != nil {
+			return "", fmt.Errorf("decoding STUN response: %w", err)
+		}
+		if res.TransactionID != req.TransactionID || from.String() != raddr.String() {
+			continue // a late answer to an earlier request
+		}
+		var addr stun.XORMappedAddress
+		if err := addr.GetFrom(res); err != nil {
+			return "", fmt.Errorf("STUN response without mapped address: %w", err)
+		}
+		return addr.String(), nil
+	}
+}
+
+// GatherCandidates gathers the local ICE candidates a session with opts
+// would offer the camera, without contacting one. Only WithTURN,
+// WithInterfaces and WithIPFamily matter among opts. It returns what was
+// gathered within timeout.
+func GatherCandidates(timeout time.Duration, opts ...Option) ([]Candidate, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	config, se, err := o.ice()
+	if err != nil {
+		return nil, err
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithSettingEngine(se)).NewPeerConnection(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating peer connection: %w", err)
+	}
+	defer pc.Close()
+
+	// Something to negotiate, so the offer starts gathering.
+	if _, err := pc.CreateDataChannel("probe", nil); err != nil {
+		return nil, fmt.Errorf("creating data channel: %w", err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating offer: %w", err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return nil, fmt.Errorf("setting local description: %w", err)
+	}
+	select {
+	case <-gatherComplete:
+	case <-time.After(timeout):
+	}
+
+	var out []Candidate
+	for _, stat := range pc.GetStats() {
+		if st, ok := stat.(webrtc.ICECandidateStats); ok && st.Type == webrtc.StatsTypeLocalCandidate {
+			out = append(out, candidateFromStats(st))
+		}
+	}
+	return out, nil
+}
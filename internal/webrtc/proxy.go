@@ -0,0 +1,100 @@
+package webrtc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TURNServer is a relay for networks where direct UDP to the camera is
+// blocked. Use a "turn:host:port?transport=tcp" or "turns:host:443" URL to
+// relay over TCP or TLS.
+type TURNServer struct {
+	URL        string
+	Username   string
+	Credential string
+}
+
+// WithTURN adds TURN relays to the ICE servers. TCP and TLS relays are
+// reached through HTTPS_PROXY (honoring NO_PROXY) when it is set. With
+// relayOnly, only relayed candidates are used, so no direct UDP is attempted.
+func WithTURN(servers []TURNServer, relayOnly bool) Option {
+	return func(o *options) {
+		o.turn = servers
+		o.relayOnly = relayOnly && len(servers) > 0
+	}
+}
+
+// proxyDialer dials TURN servers over TCP, tunnelling through the
+// environment's HTTP(S) proxy with CONNECT when one applies.
+type proxyDialer struct{}
+
+const proxyTimeout = 15 * time.Second
+
+func (proxyDialer) Dial(network, addr string) (net.Conn, error) {
+	proxyURL, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return net.DialTimeout(network, addr, proxyTimeout)
+	}
+
+	var conn net.Conn
+	switch proxyURL.Scheme {
+	case "http":
+		conn, err = net.DialTimeout("tcp", proxyURL.Host, proxyTimeout)
+	case "https":
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: proxyTimeout}, "tcp", proxyURL.Host, nil)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q for TURN", proxyURL.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to proxy %s: %w", proxyURL.Host, err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u := proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)))
+	}
+	conn.SetDeadline(time.Now().Add(proxyTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s: %s", addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// bufferedConn reads through the bufio.Reader used for the CONNECT response
+// so no tunnelled bytes it buffered are lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
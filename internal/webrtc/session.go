@@ -2,60 +2,194 @@ package webrtc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
+	"github.com/pion/interceptor"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
 )
 
 const (
-	extendInterval = 4 * time.Minute
+	extendLead     = time.Minute     // extend this long before the stream expires
+	extendRetry    = 5 * time.Second // first retry of a failed extension, then doubling
+	extendRetryMax = time.Minute
+	renewLead      = 30 * time.Second // replace a stream this close to expiry
+	streamLifetime = 5 * time.Minute  // assumed when the API doesn't say when a stream expires
 	pliInterval    = 2 * time.Second
 )
 
 // TrackHandler is called when a remote track is received.
 type TrackHandler func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
 
+// Option configures a Session.
+type Option func(*options)
+
+type options struct {
+	talkback   bool
+	h264       []string // fmtp lines in preference order
+	turn       []TURNServer
+	relayOnly  bool
+	interfaces []string // name patterns; nil for all
+	family     string   // "ipv4", "ipv6" or "" for both
+	udpPorts   string   // "min-max", or "" for random ports
+	log        *slog.Logger
+}
+
+// WithLogger sends the session's status messages, such as ICE state changes
+// and failed extensions, to log instead of slog's default logger. For a
+// StreamHub it's the only option that applies.
+func WithLogger(log *slog.Logger) Option {
+	return func(o *options) { o.log = log }
+}
+
+// logger returns the logger set with WithLogger, or slog's default.
+func (o options) logger() *slog.Logger {
+	if o.log == nil {
+		return slog.Default()
+	}
+	return o.log
+}
+
+// ErrExtendRejected marks an extension failure that retrying won't fix,
+// e.g. the API no longer knows the media session. The extend function
+// given to SetAnswer wraps such errors with it.
+var ErrExtendRejected = errors.New("stream extension rejected")
+
+// ErrStreamExpired is passed to the OnClosed function when the session was
+// closed because its stream expired.
+var ErrStreamExpired = errors.New("stream expired")
+
+// ExtendFunc extends the stream of a media session, returning the media
+// session ID to use from now on and when the stream now expires. A zero
+// expiry means the API didn't say.
+type ExtendFunc func(mediaSessionID string) (newID string, expiresAt time.Time, err error)
+
+// H264 fmtp lines for the profiles accepted by WithVideoProfile.
+var h264Profiles = map[string]string{
+	"baseline": "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+	"main":     "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=4d001f",
+	"high":     "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032",
+}
+
+// WithVideoProfile selects the H264 profile offered to the camera: "baseline"
+// (Constrained Baseline 42e01f, the default), "main", "high", or "any" to offer
+// all three and let the camera pick.
+func WithVideoProfile(profile string) Option {
+	return func(o *options) {
+		switch profile {
+		case "", "baseline", "main", "high":
+			if profile != "" {
+				o.h264 = []string{h264Profiles[profile]}
+			}
+		case "any":
+			o.h264 = []string{h264Profiles["high"], h264Profiles["main"], h264Profiles["baseline"]}
+		}
+	}
+}
+
+// WithH264Fmtp offers a custom H264 fmtp line, e.g. to request a specific
+// profile-level-id. It overrides WithVideoProfile.
+func WithH264Fmtp(line string) Option {
+	return func(o *options) {
+		if line != "" {
+			o.h264 = []string{line}
+		}
+	}
+}
+
+// WithTalkback negotiates the audio transceiver as sendrecv so audio written
+// with WriteAudio is played on the camera or doorbell speaker.
+func WithTalkback() Option {
+	return func(o *options) { o.talkback = true }
+}
+
 // Session manages a WebRTC connection to a Nest camera.
 type Session struct {
-	pc             *webrtc.PeerConnection
-	mediaSessionID string
+	pc *webrtc.PeerConnection
 
-	extendFn func(mediaSessionID string) error
+	extendFn ExtendFunc
 	stopFn   func(mediaSessionID string) error
 
 	// Connected is closed when the ICE connection reaches the connected state.
 	Connected chan struct{}
 
-	mu     sync.Mutex
-	closed bool
-	cancel context.CancelFunc
+	audioOut *webrtc.TrackLocalStaticSample
+	log      *slog.Logger
+	data     *dataChannels
+
+	mu             sync.Mutex
+	mediaSessionID string
+	expiresAt      time.Time
+	onExpiring     func()
+	onClosed       func(err error)
+	onReconnected  func()
+	closed         bool
+	cancel         context.CancelFunc
 }
 
-// NewSession creates a WebRTC PeerConnection configured for Nest camera streaming.
-// It returns the SDP offer to send to the SDM API.
-func NewSession(onTrack TrackHandler) (*Session, string, error) {
+// stunServer is used to discover the public address for ICE candidates.
+const stunServer = "stun.l.google.com:19302"
+
+// ice returns the ICE servers and settings for a peer connection: Google's
+// STUN server plus any TURN relays, reached through the proxy dialer, on
+// the allowed interfaces and IP family.
+func (o options) ice() (webrtc.Configuration, webrtc.SettingEngine, error) {
 	config := webrtc.Configuration{
 		ICEServers: []webrtc.ICEServer{
-			{URLs: []string{"stun:stun.l.google.com:19302"}},
+			{URLs: []string{"stun:" + stunServer}},
 		},
 		BundlePolicy: webrtc.BundlePolicyMaxBundle,
 	}
+	for _, t := range o.turn {
+		config.ICEServers = append(config.ICEServers, webrtc.ICEServer{
+			URLs:       []string{t.URL},
+			Username:   t.Username,
+			Credential: t.Credential,
+		})
+	}
+	if o.relayOnly {
+		config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
 
+	se := webrtc.SettingEngine{}
+	if len(o.turn) > 0 {
+		se.SetICEProxyDialer(proxyDialer{})
+	}
+	err := o.applyNetwork(&se)
+	return config, se, err
+}
+
+// NewSession creates a WebRTC PeerConnection configured for Nest camera streaming.
+// It returns the SDP offer to send to the SDM API.
+func NewSession(onTrack TrackHandler, opts ...Option) (*Session, string, error) {
+	o := options{h264: []string{h264Profiles["baseline"]}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	config, se, err := o.ice()
+	if err != nil {
+		return nil, "", err
+	}
 	m := &webrtc.MediaEngine{}
 
-	// H264 video codec (profile 42e01f = Constrained Baseline)
-	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{
-			MimeType:    webrtc.MimeTypeH264,
-			ClockRate:   90000,
-			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
-		},
-		PayloadType: 96,
-	}, webrtc.RTPCodecTypeVideo); err != nil {
-		return nil, "", fmt.Errorf("registering H264 codec: %w", err)
+	// H264 video codecs in preference order (default 42e01f = Constrained Baseline)
+	for i, fmtp := range o.h264 {
+		if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:    webrtc.MimeTypeH264,
+				ClockRate:   90000,
+				SDPFmtpLine: fmtp,
+			},
+			PayloadType: webrtc.PayloadType(96 + i),
+		}, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, "", fmt.Errorf("registering H264 codec: %w", err)
+		}
 	}
 
 	// Opus audio codec
@@ -70,15 +204,39 @@ func NewSession(onTrack TrackHandler) (*Session, string, error) {
 		return nil, "", fmt.Errorf("registering Opus codec: %w", err)
 	}
 
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(m))
+	// Default interceptors add NACK generation, RTCP receiver reports and
+	// TWCC feedback so the camera can adapt its bitrate on congested links.
+	ir := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, ir); err != nil {
+		return nil, "", fmt.Errorf("registering interceptors: %w", err)
+	}
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(ir), webrtc.WithSettingEngine(se))
 
 	pc, err := api.NewPeerConnection(config)
 	if err != nil {
 		return nil, "", fmt.Errorf("creating peer connection: %w", err)
 	}
 
-	// Add transceivers in the required order: audio recvonly, video recvonly, then data channel.
-	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+	// Add transceivers in the required order: audio, video recvonly, then data channel.
+	var audioOut *webrtc.TrackLocalStaticSample
+	if o.talkback {
+		audioOut, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeOpus,
+			ClockRate: 48000,
+			Channels:  2,
+		}, "audio", "gognestcli")
+		if err != nil {
+			pc.Close()
+			return nil, "", fmt.Errorf("creating talkback track: %w", err)
+		}
+		if _, err := pc.AddTransceiverFromTrack(audioOut, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionSendrecv,
+		}); err != nil {
+			pc.Close()
+			return nil, "", fmt.Errorf("adding audio transceiver: %w", err)
+		}
+	} else if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
 		Direction: webrtc.RTPTransceiverDirectionRecvonly,
 	}); err != nil {
 		pc.Close()
@@ -93,7 +251,8 @@ func NewSession(onTrack TrackHandler) (*Session, string, error) {
 	}
 
 	// Data channel is required for Nest WebRTC
-	if _, err := pc.CreateDataChannel("dataSendChannel", nil); err != nil {
+	dc, err := pc.CreateDataChannel("dataSendChannel", nil)
+	if err != nil {
 		pc.Close()
 		return nil, "", fmt.Errorf("creating data channel: %w", err)
 	}
@@ -101,21 +260,50 @@ func NewSession(onTrack TrackHandler) (*Session, string, error) {
 	sess := &Session{
 		pc:        pc,
 		Connected: make(chan struct{}),
+		audioOut:  audioOut,
+		log:       o.logger(),
+		data:      &dataChannels{send: dc, log: o.logger()},
 	}
+	sess.data.watch(dc)
+	pc.OnDataChannel(sess.data.watch)
 
 	connectedOnce := sync.Once{}
+	lost := false // ICE dropped after connecting; only touched by pion's callback
 	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
-		fmt.Printf("ICE connection state: %s\n", state.String())
-		if state == webrtc.ICEConnectionStateConnected {
+		sess.log.Info("ICE connection", "state", state.String())
+		switch state {
+		case webrtc.ICEConnectionStateConnected:
 			connectedOnce.Do(func() { close(sess.Connected) })
+			// Off pion's callback: collecting stats waits on the ICE agent.
+			go sess.logPair()
+			if lost {
+				lost = false
+				sess.mu.Lock()
+				fn := sess.onReconnected
+				sess.mu.Unlock()
+				if fn != nil {
+					go fn()
+				}
+			}
+		case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateFailed:
+			select {
+			case <-sess.Connected:
+				lost = true
+			default:
+			}
 		}
 		if state == webrtc.ICEConnectionStateFailed {
-			fmt.Println("ICE connection failed — check network/firewall settings")
+			sess.log.Warn("ICE connection failed — check network/firewall settings")
 		}
 	})
 
 	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		fmt.Printf("Track received: %s (%s)\n", track.Kind().String(), track.Codec().MimeType)
+		sess.log.Info("Track received", "kind", track.Kind().String(), "codec", track.Codec().MimeType)
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			// Ask for a keyframe right away rather than at the first PLI
+			// tick, so decoding can start sooner.
+			sess.RequestKeyframe()
+		}
 		if onTrack != nil {
 			onTrack(track, receiver)
 		}
@@ -139,9 +327,17 @@ func NewSession(onTrack TrackHandler) (*Session, string, error) {
 	return sess, pc.LocalDescription().SDP, nil
 }
 
-// SetAnswer sets the remote SDP answer and starts background tasks.
-func (s *Session) SetAnswer(answerSDP, mediaSessionID string, extendFn func(string) error, stopFn func(string) error) error {
+// SetAnswer sets the remote SDP answer and starts background tasks: the
+// stream is extended ahead of expiresAt (streamLifetime from now if zero)
+// with extendFn, and stopped with stopFn when the session closes.
+func (s *Session) SetAnswer(answerSDP, mediaSessionID string, expiresAt time.Time, extendFn ExtendFunc, stopFn func(string) error) error {
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(streamLifetime)
+	}
+	s.mu.Lock()
 	s.mediaSessionID = mediaSessionID
+	s.expiresAt = expiresAt
+	s.mu.Unlock()
 	s.extendFn = extendFn
 	s.stopFn = stopFn
 
@@ -158,16 +354,71 @@ func (s *Session) SetAnswer(answerSDP, mediaSessionID string, extendFn func(stri
 
 	go s.pliLoop(ctx)
 	go s.extendLoop(ctx)
+	go s.keepaliveLoop(ctx)
 
 	return nil
 }
 
-// Close terminates the WebRTC session.
-func (s *Session) Close() error {
+// WriteAudio sends an Opus sample to the device speaker. The session must
+// have been created with WithTalkback.
+func (s *Session) WriteAudio(sample media.Sample) error {
+	if s.audioOut == nil {
+		return fmt.Errorf("session was not created with talkback enabled")
+	}
+	return s.audioOut.WriteSample(sample)
+}
+
+// MediaSessionID returns the SDM media session of the stream, which may
+// change when it's extended. It's empty until SetAnswer.
+func (s *Session) MediaSessionID() string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.mediaSessionID
+}
 
+// ExpiresAt returns when the stream expires unless extended again. It's
+// zero until SetAnswer.
+func (s *Session) ExpiresAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expiresAt
+}
+
+// OnExpiringSoon calls fn once the stream can't be kept alive: an extension
+// was rejected, or extensions are still failing as it nears expiry. The
+// session runs on until it expires, leaving time to start a replacement.
+func (s *Session) OnExpiringSoon(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onExpiring = fn
+}
+
+// OnClosed calls fn once the session has closed, with ErrStreamExpired if
+// its stream expired and nil if Close was called.
+func (s *Session) OnClosed(fn func(err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onClosed = fn
+}
+
+// OnReconnected calls fn each time the ICE connection recovers after
+// dropping, e.g. on a network change. The stream itself carries on.
+func (s *Session) OnReconnected(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReconnected = fn
+}
+
+// Close terminates the WebRTC session.
+func (s *Session) Close() error {
+	return s.close(nil)
+}
+
+// close terminates the session, reporting why to the OnClosed function.
+func (s *Session) close(reason error) error {
+	s.mu.Lock()
 	if s.closed {
+		s.mu.Unlock()
 		return nil
 	}
 	s.closed = true
@@ -180,7 +431,26 @@ func (s *Session) Close() error {
 		_ = s.stopFn(s.mediaSessionID)
 	}
 
-	return s.pc.Close()
+	err := s.pc.Close()
+	onClosed := s.onClosed
+	s.mu.Unlock()
+	if onClosed != nil {
+		onClosed(reason)
+	}
+	return err
+}
+
+// RequestKeyframe sends a Picture Loss Indication for each video track,
+// asking the camera for an IDR frame now.
+func (s *Session) RequestKeyframe() {
+	for _, receiver := range s.pc.GetReceivers() {
+		track := receiver.Track()
+		if track != nil && track.Kind() == webrtc.RTPCodecTypeVideo {
+			_ = s.pc.WriteRTCP([]rtcp.Packet{
+				&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())},
+			})
+		}
+	}
 }
 
 func (s *Session) pliLoop(ctx context.Context) {
@@ -192,32 +462,72 @@ func (s *Session) pliLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			for _, receiver := range s.pc.GetReceivers() {
-				track := receiver.Track()
-				if track != nil && track.Kind() == webrtc.RTPCodecTypeVideo {
-					_ = s.pc.WriteRTCP([]rtcp.Packet{
-						&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())},
-					})
-				}
-			}
+			s.RequestKeyframe()
 		}
 	}
 }
 
+// extendLoop extends the stream extendLead before it expires, retrying
+// failed extensions with backoff. When the stream can't be kept it calls
+// the OnExpiringSoon function; once it has expired the session is closed,
+// which ends its tracks so callers can start a new one.
 func (s *Session) extendLoop(ctx context.Context) {
-	ticker := time.NewTicker(extendInterval)
-	defer ticker.Stop()
+	s.mu.Lock()
+	id, expires := s.mediaSessionID, s.expiresAt
+	s.mu.Unlock()
+	if s.extendFn == nil || id == "" {
+		return
+	}
+	timer := time.NewTimer(time.Until(expires) - extendLead)
+	defer timer.Stop()
+	retry := extendRetry
+	rejected := false
+	var expiring sync.Once
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			if s.extendFn != nil && s.mediaSessionID != "" {
-				if err := s.extendFn(s.mediaSessionID); err != nil {
-					fmt.Printf("Warning: failed to extend stream: %v\n", err)
+		case <-timer.C:
+		}
+		left := time.Until(expires)
+		if left <= 0 {
+			s.log.Info("Stream expired, closing session")
+			s.close(ErrStreamExpired)
+			return
+		}
+		if !rejected {
+			newID, newExpires, err := s.extendFn(id)
+			if err == nil {
+				if newID != "" {
+					id = newID
 				}
+				expires = newExpires
+				if expires.IsZero() {
+					expires = time.Now().Add(streamLifetime)
+				}
+				s.mu.Lock()
+				s.mediaSessionID, s.expiresAt = id, expires
+				s.mu.Unlock()
+				retry = extendRetry
+				timer.Reset(time.Until(expires) - extendLead)
+				continue
 			}
+			s.log.Warn("failed to extend stream", "err", err)
+			rejected = errors.Is(err, ErrExtendRejected)
+		}
+		s.mu.Lock()
+		onExpiring := s.onExpiring
+		s.mu.Unlock()
+		if (rejected || left < renewLead) && onExpiring != nil {
+			expiring.Do(func() { go onExpiring() })
+		}
+		if rejected {
+			// Retrying is pointless; just close the session on time.
+			timer.Reset(left)
+			continue
 		}
+		timer.Reset(min(retry, left))
+		retry = min(2*retry, extendRetryMax)
 	}
 }
@@ -0,0 +1,152 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// StreamStats summarizes reception quality for one inbound RTP stream.
+type StreamStats struct {
+	Kind            string // "audio" or "video"
+	PacketsReceived uint32
+	PacketsLost     int32
+	BytesReceived   uint64
+	Jitter          float64 // seconds
+	NACKCount       uint32
+	PLICount        uint32
+}
+
+// LossRatio returns the fraction of packets lost, in [0, 1].
+func (s StreamStats) LossRatio() float64 {
+	total := float64(s.PacketsReceived) + float64(s.PacketsLost)
+	if total <= 0 || s.PacketsLost <= 0 {
+		return 0
+	}
+	return float64(s.PacketsLost) / total
+}
+
+// Stats is a point-in-time view of the session's transport and streams.
+type Stats struct {
+	Streams []StreamStats
+
+	// RoundTripTime and AvailableIncomingBitrate (bits/s) come from the
+	// nominated ICE candidate pair; zero when not yet known.
+	RoundTripTime            float64
+	AvailableIncomingBitrate float64
+
+	// Pair is the candidate pair carrying the media; zero until ICE has
+	// connected.
+	Pair CandidatePair
+}
+
+// CandidatePair describes the route media takes: how each end was reached
+// and at which address.
+type CandidatePair struct {
+	Local, Remote Candidate
+	RoundTripTime float64 // seconds; zero when not yet known
+}
+
+// Candidate is an ICE candidate, local or remote.
+type Candidate struct {
+	Type     string // "host", "srflx" (public address via STUN), "prflx" or "relay" (via TURN)
+	Protocol string // "udp" or "tcp"
+	Address  string // host:port
+
+	// RelayProtocol is how a local relay candidate reaches its TURN
+	// server: "udp", "tcp" or "tls".
+	RelayProtocol string
+}
+
+// String returns e.g. "srflx udp 203.0.113.7:50123".
+func (c Candidate) String() string {
+	return c.Type + " " + c.Protocol + " " + c.Address
+}
+
+// Relayed reports whether media goes through a TURN relay on either end.
+func (p CandidatePair) Relayed() bool {
+	return p.Local.Type == "relay" || p.Remote.Type == "relay"
+}
+
+func (p CandidatePair) rtt() time.Duration {
+	return time.Duration(p.RoundTripTime * float64(time.Second)).Round(100 * time.Microsecond)
+}
+
+// String returns e.g. "srflx udp 203.0.113.7:50123 ↔ host udp 198.51.100.2:19305, rtt 23ms".
+func (p CandidatePair) String() string {
+	if p.Local.Type == "" {
+		return "not connected"
+	}
+	s := fmt.Sprintf("%s ↔ %s", p.Local, p.Remote)
+	if p.Local.RelayProtocol != "" {
+		s += " (relayed over " + p.Local.RelayProtocol + ")"
+	}
+	if p.RoundTripTime > 0 {
+		s += fmt.Sprintf(", rtt %s", p.rtt())
+	}
+	return s
+}
+
+// Stats collects loss, jitter and bandwidth figures. Receiver reports and
+// NACKs are produced by pion's default interceptors registered in NewSession.
+func (s *Session) Stats() Stats {
+	var out Stats
+	var localID, remoteID string
+	candidates := map[string]Candidate{}
+	for _, stat := range s.pc.GetStats() {
+		switch st := stat.(type) {
+		case webrtc.InboundRTPStreamStats:
+			out.Streams = append(out.Streams, StreamStats{
+				Kind:            st.Kind,
+				PacketsReceived: st.PacketsReceived,
+				PacketsLost:     st.PacketsLost,
+				BytesReceived:   st.BytesReceived,
+				Jitter:          st.Jitter,
+				NACKCount:       st.NACKCount,
+				PLICount:        st.PLICount,
+			})
+		case webrtc.ICECandidatePairStats:
+			if st.Nominated {
+				out.RoundTripTime = st.CurrentRoundTripTime
+				out.AvailableIncomingBitrate = st.AvailableIncomingBitrate
+				localID, remoteID = st.LocalCandidateID, st.RemoteCandidateID
+			}
+		case webrtc.ICECandidateStats:
+			candidates[st.ID] = candidateFromStats(st)
+		}
+	}
+	if local, ok := candidates[localID]; ok {
+		out.Pair = CandidatePair{Local: local, Remote: candidates[remoteID], RoundTripTime: out.RoundTripTime}
+	}
+	return out
+}
+
+func candidateFromStats(st webrtc.ICECandidateStats) Candidate {
+	return Candidate{
+		Type:          st.CandidateType.String(),
+		Protocol:      st.Protocol,
+		Address:       net.JoinHostPort(st.IP, strconv.Itoa(int(st.Port))),
+		RelayProtocol: st.RelayProtocol,
+	}
+}
+
+// logPair logs the route ICE picked, which tells whether a TURN relay is in
+// use when a stream is slow or fails on some networks.
+func (s *Session) logPair() {
+	p := s.Stats().Pair
+	if p.Local.Type == "" {
+		return
+	}
+	args := []any{"local", p.Local.Type + ":" + p.Local.Protocol + ":" + p.Local.Address,
+		"remote", p.Remote.Type + ":" + p.Remote.Protocol + ":" + p.Remote.Address}
+	if p.Local.RelayProtocol != "" {
+		args = append(args, "relay", p.Local.RelayProtocol)
+	}
+	if p.RoundTripTime > 0 {
+		args = append(args, "rtt", p.rtt())
+	}
+	s.log.Info("ICE route", args...)
+}